@@ -21,9 +21,13 @@ import (
 	"github.com/swaggo/swag"
 
 	"github.com/safanaj/go-f2lb/pkg/api"
+	api_v0 "github.com/safanaj/go-f2lb/pkg/api/v0"
 	api_v1 "github.com/safanaj/go-f2lb/pkg/api/v1"
 	api_v2 "github.com/safanaj/go-f2lb/pkg/api/v2"
 	"github.com/safanaj/go-f2lb/pkg/caches/blockfrostutils"
+	"github.com/safanaj/go-f2lb/pkg/caches/cachestore"
+	"github.com/safanaj/go-f2lb/pkg/caches/chainprovider"
+	"github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
 	"github.com/safanaj/go-f2lb/pkg/ccli"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_gsheet"
 	"github.com/safanaj/go-f2lb/pkg/logging"
@@ -61,8 +65,12 @@ func addFlags() {
 	logging.AddFlags(flag.CommandLine)
 	ccli.AddFlags(flag.CommandLine)
 	blockfrostutils.AddFlags(flag.CommandLine)
+	cachestore.AddFlags(flag.CommandLine)
+	chainprovider.AddFlags(flag.CommandLine)
+	koiosutils.AddFlags(flag.CommandLine)
 	txbuilder.AddFlags(flag.CommandLine)
 	pinger.AddFlags(flag.CommandLine)
+	api_v0.AddFlags(flag.CommandLine)
 }
 
 func main() {
@@ -75,37 +83,66 @@ func main() {
 	flag.BoolVar(&useGinAsRootHandler, "use-gin-as-root-handler", useGinAsRootHandler, "")
 	flag.StringVar(&certPath, "tls-cert", "", "")
 	flag.StringVar(&keyPath, "tls-key", "", "")
+	simulatedClock := flag.Bool("debug-simulated-clock", false, "Use a clock that admins can fast-forward, only for sandbox deployments")
 	addFlags()
 	flag.Parse()
 	if *showVersion {
 		fmt.Printf("%s %s\n", progname, version)
 		os.Exit(0)
 	}
+	utils.CheckErr(api_v0.LoadRefreshWebhookSecret())
 
 	if !(*exposeGrpc) {
 		listenGrpcAddr = ""
 	}
 
 	log := logging.GetLogger().WithName(progname)
+	if *simulatedClock {
+		log.Info("Using a simulated clock, it can be advanced by the admins")
+		utils.SetClock(utils.NewSimulatedClock(time.Now()))
+	}
 	mainCtx, mainCtxCancel := context.WithCancel(context.Background())
 	childCtx, childCtxCancel := context.WithCancel(mainCtx)
 	webCtx := utils.SetupShutdownSignals(childCtx)
 	f2lbCtrl := f2lb_gsheet.NewController(childCtx, log.WithName("f2lbController"))
-	payer, err := txbuilder.NewPayer(childCtx, log.WithName("payer"))
+	payer, err := txbuilder.NewPayer(childCtx, log.WithName("payer"), f2lbCtrl.GetChainProvider(), f2lbCtrl.GetEventBus())
 	utils.CheckErr(err)
+	if payer != nil {
+		utils.CheckErr(payer.RegisterJobs(f2lbCtrl.GetScheduler()))
+	}
 	if !*pingerDisabled {
 		pinger.NewPinger(log.WithName("pinger")).SetController(f2lbCtrl)
 	}
 
+	// other communities share the koios client, the caches and the pinger of the main controller
+	communities, err := f2lb_gsheet.GetCommunities()
+	utils.CheckErr(err)
+	communityCtrls := make([]f2lb_gsheet.Controller, 0, len(communities))
+	communityByHost := make(map[string]string)
+	for _, cm := range communities {
+		ctrl, err := f2lb_gsheet.NewCommunityController(childCtx, log.WithName("f2lbController"), cm, f2lbCtrl)
+		utils.CheckErr(err)
+		communityCtrls = append(communityCtrls, ctrl)
+		for _, h := range cm.Hosts {
+			communityByHost[h] = cm.Name
+		}
+	}
+
+	ginEngine := gin.Default()
 	webSrvOpts := webserver.Options{
 		Addr:                listenAddr,
+		GinEngine:           ginEngine,
 		SessionsStoreDir:    f2lbCtrl.GetCachesStoreDirPath(),
 		UseGinAsRootHandler: useGinAsRootHandler,
 		GrpcAddr:            listenGrpcAddr,
 		CertPath:            certPath,
 		KeyPath:             keyPath,
 	}
+	if len(communityByHost) > 0 {
+		webSrvOpts.HandlerWrapper = func(h http.Handler) http.Handler { return api.CommunityHostsHandler(h, communityByHost) }
+	}
 	webSrv := webserver.New(webSrvOpts)
+	utils.CheckErr(webSrv.GetSessionManager().RegisterJobs(f2lbCtrl.GetScheduler()))
 
 	startControllerAt := time.Now()
 	log.V(1).Info("Starting controller", "at", startControllerAt.Format(time.RFC850))
@@ -118,35 +155,31 @@ func main() {
 
 	apiV1Opts := api.ApiV1Options{
 		ControlMsgServiceServer: api_v1.NewControlServiceServer(f2lbCtrl, strings.Split(adminPoolsStr, ","), payer, webSrv.GetSessionManager()),
-		MainQueueServiceServer:  api_v1.NewMainQueueServiceServer(f2lbCtrl.GetMainQueue(), f2lbCtrl.GetStakePoolSet()),
-		AddonQueueServiceServer: api_v1.NewAddonQueueServiceServer(f2lbCtrl.GetAddonQueue(), f2lbCtrl.GetStakePoolSet()),
+		MainQueueServiceServer:  api_v1.NewMainQueueServiceServer(f2lbCtrl),
+		AddonQueueServiceServer: api_v1.NewAddonQueueServiceServer(f2lbCtrl),
 		SupporterServiceServer:  api_v1.NewSupporterServiceServer(f2lbCtrl.GetSupporters()),
-		MemberServiceServer:     api_v1.NewMemberServiceServer(f2lbCtrl.GetDelegationCycle(), f2lbCtrl.GetStakePoolSet()),
+		MemberServiceServer:     api_v1.NewMemberServiceServer(f2lbCtrl),
 	}
 
-	apiV1Opts.ControlMsgServiceServer.(api_v1.ControlServiceRefresher).SetRefresherChannel(make(chan string))
-	f2lbCtrl.SetRefresherChannel(apiV1Opts.ControlMsgServiceServer.(api_v1.ControlServiceRefresher).GetRefresherChannel())
 	apiV1Opts.ControlMsgServiceServer.(api_v1.ControlServiceRefresher).StartRefresher(webCtx)
 
 	api.RegisterApiV1(webCtx, webSrv.GetGinEngine().Group("/api/v1/*gw"), webSrv.GetGrpcServer(), webSrv.GetGrpcGw(), apiV1Opts)
 
-	apiV2Opts := api.ApiV2Options{
-		ControlMsgServiceHandler: api_v2.NewControlServiceHandler(webCtx, f2lbCtrl, strings.Split(adminPoolsStr, ","), payer, webSrv.GetSessionManager()),
-		MainQueueServiceHandler:  api_v2.NewMainQueueServiceServer(f2lbCtrl.GetMainQueue(), f2lbCtrl.GetStakePoolSet()),
-		AddonQueueServiceHandler: api_v2.NewAddonQueueServiceServer(f2lbCtrl.GetAddonQueue(), f2lbCtrl.GetStakePoolSet()),
-		SupporterServiceHandler:  api_v2.NewSupporterServiceServer(f2lbCtrl.GetSupporters()),
-		MemberServiceHandler:     api_v2.NewMemberServiceServer(f2lbCtrl.GetDelegationCycle(), f2lbCtrl.GetStakePoolSet()),
-		KoiosHandler:             api_v2.NewKoiosService(f2lbCtrl.GetKoiosClient(), webSrv.GetSessionManager()),
-		AccountCacheHandler:      api_v2.NewAccountCacheService(f2lbCtrl.GetAccountCache(), webSrv.GetSessionManager()),
-		PoolCacheHandler:         api_v2.NewPoolCacheService(f2lbCtrl.GetPoolCache(), webSrv.GetSessionManager()),
-	}
-
-	apiV2Opts.ControlMsgServiceHandler.(api_v2.ControlServiceRefresher).SetRefresherChannel(make(chan string))
-	f2lbCtrl.SetRefresherChannelV2(apiV2Opts.ControlMsgServiceHandler.(api_v2.ControlServiceRefresher).GetRefresherChannel())
-	apiV2Opts.ControlMsgServiceHandler.(api_v2.ControlServiceRefresher).StartRefresher(webCtx)
-
+	apiV2Opts := newApiV2Options(webCtx, f2lbCtrl, strings.Split(adminPoolsStr, ","), payer, webSrv.GetSessionManager())
 	api.RegisterApiV2(webCtx, webSrv.GetGinEngine(), webSrv.GetGinEngine().Group("/api/v2/*connect"), apiV2Opts)
 
+	// the communities have the v0 and v2 apis under /c/<name>, v1 is served only for the main one
+	for i, cm := range communities {
+		ctrl := communityCtrls[i]
+		api.RegisterCommunityApiV0(webSrv.GetGinEngine(), cm.Name, ctrl)
+		api.RegisterCommunityApiV2(webCtx, webSrv.GetGinEngine(), cm.Name,
+			newApiV2Options(webCtx, ctrl, cm.AdminPools, payer, webSrv.GetSessionManager()))
+		log.V(1).Info("Starting community controller", "community", cm.Name)
+		if err := ctrl.Start(); err != nil {
+			utils.CheckErr(err)
+		}
+	}
+
 	if useGinAsRootHandler {
 		// this is an ugly solution to use Gin as root handler, and just middlweare to manage GrpcWeb stuff,
 		// if we reach this handler means that the middleware did not aborted the the handlers chain,
@@ -221,6 +254,9 @@ func main() {
 
 	go func() {
 		<-webCtx.Done()
+		for _, ctrl := range communityCtrls {
+			ctrl.Stop()
+		}
 		f2lbCtrl.Stop()
 		stopWebCtx, stopWebCtxDone := context.WithTimeout(childCtx, 5*time.Second)
 		defer stopWebCtxDone()
@@ -239,6 +275,23 @@ func main() {
 	<-mainCtx.Done()
 }
 
+func newApiV2Options(ctx context.Context, ctrl f2lb_gsheet.Controller, adminPools []string, payer *txbuilder.Payer, sm webserver.SessionManager) api.ApiV2Options {
+	opts := api.ApiV2Options{
+		ControlMsgServiceHandler: api_v2.NewControlServiceHandler(ctx, ctrl, adminPools, payer, sm),
+		MainQueueServiceHandler:  api_v2.NewMainQueueServiceServer(ctrl),
+		AddonQueueServiceHandler: api_v2.NewAddonQueueServiceServer(ctrl),
+		SupporterServiceHandler:  api_v2.NewSupporterServiceServer(ctrl.GetSupporters()),
+		MemberServiceHandler:     api_v2.NewMemberServiceServer(ctrl, ctrl.GetDelegationCycle(), ctrl.GetMainQueue(), ctrl.GetAddonQueue()),
+		KoiosHandler:             api_v2.NewKoiosService(ctrl.GetKoiosClient(), sm),
+		AccountCacheHandler:      api_v2.NewAccountCacheService(ctrl.GetAccountCache(), sm),
+		PoolCacheHandler:         api_v2.NewPoolCacheService(ctrl.GetPoolCache(), sm),
+		HistoryServiceHandler:    api_v2.NewHistoryServiceServer(ctrl.GetHistory()),
+	}
+
+	opts.ControlMsgServiceHandler.(api_v2.ControlServiceRefresher).StartRefresher(ctx)
+	return opts
+}
+
 var tmplElements = template.Must(template.New("openapi-ui").Parse(`<!doctype html>
 <html lang="en">
 	<head>
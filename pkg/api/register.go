@@ -2,8 +2,10 @@ package api
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"slices"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
@@ -19,6 +21,7 @@ import (
 	v1 "github.com/safanaj/go-f2lb/pkg/api/v1"
 	v2 "github.com/safanaj/go-f2lb/pkg/api/v2"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_gsheet"
+	"github.com/safanaj/go-f2lb/pkg/utils"
 )
 
 func RegisterApiV0(rg *gin.RouterGroup, ctrl f2lb_gsheet.Controller) {
@@ -57,6 +60,7 @@ type ApiV2Options struct {
 	v2.KoiosHandler
 	v2.AccountCacheHandler
 	v2.PoolCacheHandler
+	v2.HistoryServiceHandler
 }
 
 type regWrapper struct {
@@ -103,14 +107,17 @@ func v2HandlerDetailFor(name string, opts ApiV2Options, copts []connect.HandlerO
 	case v2.PoolCacheName:
 		pcPath, pcHandler := v2.NewPoolCacheHandler(opts.PoolCacheHandler, copts...)
 		return v2HandlerDetail{v2.PoolCacheName, pcPath, pcHandler}
+	case v2.HistoryServiceName:
+		historyPath, historyHandler := v2.NewHistoryServiceHandler(opts.HistoryServiceHandler, copts...)
+		return v2HandlerDetail{v2.HistoryServiceName, historyPath, historyHandler}
 	}
 	return v2HandlerDetail{}
 }
 
-func RegisterApiV2(ctx context.Context, engine *gin.Engine, rg *gin.RouterGroup, opts ApiV2Options) {
+func apiV2Details(opts ApiV2Options) []v2HandlerDetail {
 	copts := append([]connect.HandlerOption{}, connect.WithCompressMinBytes(1024))
 
-	details := slices.Collect(func(yield func(v2HandlerDetail) bool) {
+	return slices.Collect(func(yield func(v2HandlerDetail) bool) {
 		for _, svcName := range []string{
 			v2.ControlMsgServiceName,
 			v2.MainQueueServiceName,
@@ -120,6 +127,7 @@ func RegisterApiV2(ctx context.Context, engine *gin.Engine, rg *gin.RouterGroup,
 			v2.KoiosName,
 			v2.AccountCacheName,
 			v2.PoolCacheName,
+			v2.HistoryServiceName,
 		} {
 			detail := v2HandlerDetailFor(svcName, opts, copts)
 			if detail.name == "" {
@@ -130,6 +138,68 @@ func RegisterApiV2(ctx context.Context, engine *gin.Engine, rg *gin.RouterGroup,
 			}
 		}
 	})
+}
+
+func apiV2Transcoder(details []v2HandlerDetail) http.Handler {
+	transcoder, _ := vanguard.NewTranscoder(slices.Collect(func(yield func(*vanguard.Service) bool) {
+		for _, detail := range details {
+			if !yield(vanguard.NewService(detail.name, detail.handler)) {
+				return
+			}
+		}
+	}))
+	return notModifiedHandler(transcoder)
+}
+
+// notModifiedHandler answers 304 to the GET requests whose If-None-Match matches the ETag set by the handler,
+// the REST paths are the cacheable ones
+func notModifiedHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch := r.Header.Get("If-None-Match")
+		if ifNoneMatch == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&notModifiedWriter{ResponseWriter: w, ifNoneMatch: ifNoneMatch}, r)
+	})
+}
+
+type notModifiedWriter struct {
+	http.ResponseWriter
+	ifNoneMatch string
+	wroteHeader bool
+	notModified bool
+}
+
+func (w *notModifiedWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code == http.StatusOK && utils.ETagMatches(w.ifNoneMatch, w.Header().Get("ETag")) {
+		w.notModified = true
+		for _, h := range []string{"Content-Length", "Content-Type", "Content-Encoding"} {
+			w.Header().Del(h)
+		}
+		code = http.StatusNotModified
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *notModifiedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *notModifiedWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func RegisterApiV2(ctx context.Context, engine *gin.Engine, rg *gin.RouterGroup, opts ApiV2Options) {
+	details := apiV2Details(opts)
 	rw := regWrapper{engine, http.MethodPost}
 	for _, detail := range details {
 		rw.wrapV2(detail.path, detail.handler)
@@ -147,13 +217,7 @@ func RegisterApiV2(ctx context.Context, engine *gin.Engine, rg *gin.RouterGroup,
 	rw.wrapV2(grpcreflect.NewHandlerV1(grpcreflect.NewStaticReflector(names...)))
 	rw.wrapV2(grpcreflect.NewHandlerV1Alpha(grpcreflect.NewStaticReflector(names...)))
 
-	transcoder, _ := vanguard.NewTranscoder(slices.Collect(func(yield func(*vanguard.Service) bool) {
-		for _, detail := range details {
-			if !yield(vanguard.NewService(detail.name, detail.handler)) {
-				return
-			}
-		}
-	}))
+	transcoder := apiV2Transcoder(details)
 	rg.Any("", gin.WrapH(transcoder))
 	// rg.Any("", func(c *gin.Context) {
 	// 	//if strings.HasSuffix(c.Request.Header.Get("referer"), "/openapi-doc") || strings.HasSuffix(c.Request.Header.Get("referer"), "/openapi-explorer") {
@@ -196,3 +260,50 @@ func RegisterApiV2(ctx context.Context, engine *gin.Engine, rg *gin.RouterGroup,
 	// 	transcoder.ServeHTTP(c.Writer, c.Request)
 	// })
 }
+
+// CommunityPathPrefix is where the apis of a community are served, i.e. /c/<name>/api/v2
+func CommunityPathPrefix(name string) string { return "/c/" + name }
+
+// RegisterCommunityApiV0 serves the v0 api of a community controller under the community prefix
+func RegisterCommunityApiV0(engine *gin.Engine, name string, ctrl f2lb_gsheet.Controller) {
+	v0.RegisterApiV0(engine.Group(CommunityPathPrefix(name)+"/api/v0"), ctrl)
+}
+
+// RegisterCommunityApiV2 serves the v2 api of a community under the community prefix,
+// both the connect paths and the transcoded REST ones. Health and reflection are served only by the main one.
+func RegisterCommunityApiV2(ctx context.Context, engine *gin.Engine, name string, opts ApiV2Options) {
+	prefix := CommunityPathPrefix(name)
+	details := apiV2Details(opts)
+	rw := regWrapper{engine, http.MethodPost}
+	for _, detail := range details {
+		rw.wrapV2(prefix+detail.path, http.StripPrefix(prefix, detail.handler))
+	}
+	engine.Group(prefix+"/api/v2/*connect").Any("", gin.WrapH(http.StripPrefix(prefix, apiV2Transcoder(details))))
+}
+
+// the paths routed to a community when the request is for one of its hosts
+var communityRoutedPaths = []string{"/api/v0/", "/api/v2/", "/v2."}
+
+// CommunityHostsHandler returns a handler that serves the requests for the hosts of the communities
+// as if they were for the community prefix, so the apis are reachable also without the prefix.
+// It has to wrap the gin engine, so the rewritten path is the one used for the routing.
+func CommunityHostsHandler(next http.Handler, communityByHost map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if name, ok := communityByHost[host]; ok {
+			for _, p := range communityRoutedPaths {
+				if strings.HasPrefix(r.URL.Path, p) {
+					r2 := r.Clone(r.Context())
+					r2.URL.Path = CommunityPathPrefix(name) + r.URL.Path
+					r2.URL.RawPath = ""
+					next.ServeHTTP(w, r2)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	v2 "github.com/safanaj/go-f2lb/pkg/api/v2"
+)
+
+type testMainQueueService struct {
+	v2.UnimplementedMainQueueServiceHandler
+	ticker string
+}
+
+func (s testMainQueueService) Served(context.Context, *connect.Request[emptypb.Empty]) (*connect.Response[v2.Member], error) {
+	res := connect.NewResponse(&v2.Member{Ticker: s.ticker})
+	res.Header().Set("ETag", `W/"`+s.ticker+`"`)
+	return res, nil
+}
+
+func testApiV2Options(ticker string) ApiV2Options {
+	return ApiV2Options{
+		ControlMsgServiceHandler: v2.UnimplementedControlMsgServiceHandler{},
+		MainQueueServiceHandler:  testMainQueueService{ticker: ticker},
+		AddonQueueServiceHandler: v2.UnimplementedAddonQueueServiceHandler{},
+		SupporterServiceHandler:  v2.UnimplementedSupporterServiceHandler{},
+		MemberServiceHandler:     v2.UnimplementedMemberServiceHandler{},
+		KoiosHandler:             v2.UnimplementedKoiosHandler{},
+		AccountCacheHandler:      v2.UnimplementedAccountCacheHandler{},
+		PoolCacheHandler:         v2.UnimplementedPoolCacheHandler{},
+		HistoryServiceHandler:    v2.UnimplementedHistoryServiceHandler{},
+	}
+}
+
+func newTestCommunitiesHandler(t *testing.T) http.Handler {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	ctx := context.Background()
+	RegisterApiV2(ctx, engine, engine.Group("/api/v2/*connect"), testApiV2Options("MAIN"))
+	RegisterCommunityApiV2(ctx, engine, "cm", testApiV2Options("CM"))
+	engine.GET("/about", func(c *gin.Context) { c.String(http.StatusOK, "about") })
+	return CommunityHostsHandler(engine, map[string]string{"cm.example.com": "cm"})
+}
+
+func serveTestCommunitiesRequest(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestCommunityHostsHandler(t *testing.T) {
+	h := newTestCommunitiesHandler(t)
+
+	for _, tc := range []struct {
+		name, host, path string
+		code             int
+		want             string
+	}{
+		{"main host", "f2lb.example.com", "/api/v2/main-queue/served", http.StatusOK, `"MAIN"`},
+		{"community host", "cm.example.com", "/api/v2/main-queue/served", http.StatusOK, `"CM"`},
+		{"community host with port", "cm.example.com:8080", "/api/v2/main-queue/served", http.StatusOK, `"CM"`},
+		{"community prefix", "f2lb.example.com", "/c/cm/api/v2/main-queue/served", http.StatusOK, `"CM"`},
+		{"unknown community prefix", "f2lb.example.com", "/c/other/api/v2/main-queue/served", http.StatusNotFound, ""},
+		{"community host not routed path", "cm.example.com", "/about", http.StatusOK, "about"},
+	} {
+		w := serveTestCommunitiesRequest(h, httptest.NewRequest(http.MethodGet, "http://"+tc.host+tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, tc.code)
+			continue
+		}
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: got body %q, want it to contain %q", tc.name, w.Body.String(), tc.want)
+		}
+	}
+}
+
+func TestCommunityHostsHandlerConnect(t *testing.T) {
+	h := newTestCommunitiesHandler(t)
+
+	// the connect paths are served under the prefix too, stripped before reaching the service
+	for _, tc := range []struct {
+		name, host, path, want string
+	}{
+		{"main host", "f2lb.example.com", v2.MainQueueServiceServedProcedure, `"MAIN"`},
+		{"community host", "cm.example.com", v2.MainQueueServiceServedProcedure, `"CM"`},
+		{"community prefix", "f2lb.example.com", CommunityPathPrefix("cm") + v2.MainQueueServiceServedProcedure, `"CM"`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://"+tc.host+tc.path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		w := serveTestCommunitiesRequest(h, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", tc.name, w.Code, w.Body.String())
+			continue
+		}
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: got body %q, want it to contain %q", tc.name, w.Body.String(), tc.want)
+		}
+	}
+}
+
+func TestCommunityHostsHandlerKeepsRequest(t *testing.T) {
+	var got string
+	h := CommunityHostsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r.URL.Path }),
+		map[string]string{"cm.example.com": "cm"})
+
+	req := httptest.NewRequest(http.MethodGet, "http://cm.example.com/api/v0/main-queue.json", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "/c/cm/api/v0/main-queue.json" {
+		t.Errorf("got path %q", got)
+	}
+	if req.URL.Path != "/api/v0/main-queue.json" {
+		t.Errorf("the original request was modified: %q", req.URL.Path)
+	}
+}
+
+func TestNotModifiedHandler(t *testing.T) {
+	h := newTestCommunitiesHandler(t)
+
+	for _, tc := range []struct {
+		name, method, ifNoneMatch string
+		code                      int
+	}{
+		{"no If-None-Match", http.MethodGet, "", http.StatusOK},
+		{"matching", http.MethodGet, `W/"MAIN"`, http.StatusNotModified},
+		{"matching in a list", http.MethodGet, `"other", "MAIN"`, http.StatusNotModified},
+		{"not matching", http.MethodGet, `W/"CM"`, http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, "http://f2lb.example.com/api/v2/main-queue/served", nil)
+		if tc.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+		}
+		w := serveTestCommunitiesRequest(h, req)
+		if w.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, tc.code)
+			continue
+		}
+		if w.Code == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s: unexpected body %q", tc.name, w.Body.String())
+		}
+		if etag := w.Header().Get("ETag"); etag != `W/"MAIN"` {
+			t.Errorf("%s: unexpected ETag %q", tc.name, etag)
+		}
+	}
+}
@@ -8,7 +8,6 @@ import (
 
 	"golang.org/x/crypto/blake2b"
 
-	koios "github.com/cardano-community/koios-go-client/v4"
 	"github.com/gin-gonic/gin"
 
 	"github.com/safanaj/go-f2lb/pkg/ccli"
@@ -22,18 +21,27 @@ const slotInEpochForNextNonce = 302400
 func RegisterApiV0(rg *gin.RouterGroup, ctrl f2lb_gsheet.Controller) {
 	// queues
 	rg.GET("/main-queue.json", func(c *gin.Context) {
-		c.IndentedJSON(http.StatusOK,
-			getMemberByTickersForApi(ctrl.GetStakePoolSet(), ctrl.GetMainQueue().GetOrdered()))
+		st := ctrl.GetState()
+		if notModifiedForApi(c, st) {
+			return
+		}
+		c.IndentedJSON(http.StatusOK, getMemberByTickersForApi(st, st.MainQueue))
 	})
 
 	rg.GET("/addon-queue.json", func(c *gin.Context) {
-		c.IndentedJSON(http.StatusOK,
-			getMemberByTickersForApi(ctrl.GetStakePoolSet(), ctrl.GetAddonQueue().GetOrdered()))
+		st := ctrl.GetState()
+		if notModifiedForApi(c, st) {
+			return
+		}
+		c.IndentedJSON(http.StatusOK, getMemberByTickersForApi(st, st.AddonQueue))
 	})
 
 	rg.GET("/main-queue.csv", func(c *gin.Context) {
-		res, err := getMembersAsCSVRecordsForApi(
-			getMemberByTickersForApi(ctrl.GetStakePoolSet(), ctrl.GetMainQueue().GetOrdered()))
+		st := ctrl.GetState()
+		if notModifiedForApi(c, st) {
+			return
+		}
+		res, err := getMembersAsCSVRecordsForApi(getMemberByTickersForApi(st, st.MainQueue))
 		if err != nil {
 			c.Status(http.StatusInternalServerError)
 			return
@@ -42,8 +50,11 @@ func RegisterApiV0(rg *gin.RouterGroup, ctrl f2lb_gsheet.Controller) {
 	})
 
 	rg.GET("/addon-queue.csv", func(c *gin.Context) {
-		res, err := getMembersAsCSVRecordsForApi(
-			getMemberByTickersForApi(ctrl.GetStakePoolSet(), ctrl.GetAddonQueue().GetOrdered()))
+		st := ctrl.GetState()
+		if notModifiedForApi(c, st) {
+			return
+		}
+		res, err := getMembersAsCSVRecordsForApi(getMemberByTickersForApi(st, st.AddonQueue))
 		if err != nil {
 			c.Status(http.StatusInternalServerError)
 			return
@@ -51,21 +62,77 @@ func RegisterApiV0(rg *gin.RouterGroup, ctrl f2lb_gsheet.Controller) {
 		c.Data(http.StatusOK, "application/csv", []byte(res))
 	})
 
+	// health, degraded means the sheets are not reachable and the last good data is served
+	rg.GET("/health", func(c *gin.Context) {
+		ss := ctrl.GetSheetsStatus()
+		status, code := "ok", http.StatusOK
+		if !ss.HasData() {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		} else if ss.IsStale() {
+			status = "degraded"
+		}
+		res := gin.H{
+			"status":        status,
+			"caches_ready":  ctrl.GetAccountCache().Ready() && ctrl.GetPoolCache().Ready(),
+			"from_snapshot": ss.FromSnapshot,
+			// delivered and dropped events per subscriber
+			"event_bus": ctrl.GetEventBus().Stats(),
+		}
+		if ss.HasData() {
+			res["data_time"] = ss.DataTime.Format(time.RFC3339)
+			res["data_age_seconds"] = int64(ss.DataAge().Seconds())
+		}
+		if ss.IsStale() {
+			res["stale_since"] = ss.StaleSince.Format(time.RFC3339)
+			res["last_error"] = ss.LastError
+		}
+		c.IndentedJSON(code, res)
+	})
+
+	// delegation schedule forecast, optionally filtered by ticker
+	rg.GET("/forecast.json", func(c *gin.Context) {
+		f := ctrl.GetDelegationForecast()
+		if ticker := c.Query("ticker"); ticker != "" {
+			c.IndentedJSON(http.StatusOK, f.ForTicker(ticker))
+			return
+		}
+		c.IndentedJSON(http.StatusOK, f)
+	})
+
+	// where a prospective member would land, i.e. /what-if.json?ada=5000&join_epoch=500&addon=true
+	rg.GET("/what-if.json", func(c *gin.Context) {
+		q := struct {
+			Ada       uint32 `form:"ada" binding:"required"`
+			JoinEpoch uint32 `form:"join_epoch"`
+			Addon     bool   `form:"addon"`
+		}{}
+		if err := c.BindQuery(&q); err != nil {
+			return
+		}
+		res, err := ctrl.SimulateDeclaration(f2lb_gsheet.WhatIfDeclaration{
+			AdaDeclared: q.Ada,
+			JoinEpoch:   utils.Epoch(q.JoinEpoch),
+			Addon:       q.Addon,
+		})
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, res)
+	})
+
 	// nonce
-	kc := ctrl.GetKoiosClient().GetKoiosClient()
+	kc := ctrl.GetKoiosClient().Cached()
 	ctx := ctrl.GetKoiosClient().GetContext()
 
 	getNonceFromKoiosHandlerForEpoch := func(delta int) func(c *gin.Context) {
 		return func(c *gin.Context) {
-			epoch := koios.EpochNo(int(utils.CurrentEpoch()) + delta)
-			opts := kc.NewRequestOptions()
-			opts.QuerySet("select", "nonce")
-			r, err := kc.GetEpochParams(ctx, epoch, opts)
+			nonce, err := kc.GetEpochNonce(utils.Epoch(int(utils.CurrentEpoch()) + delta))
 			if err != nil {
 				c.String(http.StatusServiceUnavailable, "Error getting info from koios: %v\n", err)
 				return
 			}
-			c.String(http.StatusOK, "%s\n", r.Data[0].Nonce)
+			c.String(http.StatusOK, "%s\n", nonce)
 			return
 		}
 	}
@@ -129,6 +196,9 @@ func RegisterApiV0(rg *gin.RouterGroup, ctrl f2lb_gsheet.Controller) {
 	// block height, check signature and store reported block height by members
 	rg.POST("/report/tip", getReportTipHandler(ctrl))
 
+	// signed notification of sheet edits, i.e. from an Apps Script trigger, to refresh without waiting
+	registerRefreshWebhook(rg, ctrl)
+
 	rg.GET("/dump.pinger", func(c *gin.Context) {
 		pinger := ctrl.GetPinger()
 		if pinger == nil {
@@ -3,11 +3,16 @@ package v0
 import (
 	"encoding/csv"
 	"fmt"
+	"net/http"
 	"reflect"
 	"strings"
 	"time"
 
+	"github.com/gin-gonic/gin"
+
+	"github.com/safanaj/go-f2lb/pkg/f2lb_gsheet"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
+	"github.com/safanaj/go-f2lb/pkg/utils"
 )
 
 type member struct {
@@ -32,9 +37,19 @@ type member struct {
 	ActiveStake               uint32   `json:"active_stake"`
 	LiveStake                 uint32   `json:"live_stake"`
 	LiveDelegators            uint32   `json:"live_delegators"`
+	// the amounts above are in ADA, these are the precise ones
+	AdaDeclaredLovelace  uint64 `json:"ada_declared_lovelace"`
+	AdaDelegatedLovelace uint64 `json:"ada_delegated_lovelace"`
+	ActiveStakeLovelace  uint64 `json:"active_stake_lovelace"`
+	LiveStakeLovelace    uint64 `json:"live_stake_lovelace"`
+	// all the stake addresses of the member, the fields above are about the main one
+	// only the stake delegated to the pool of the member
+	TotalAdaDelegatedLovelace uint64                          `json:"total_ada_delegated_lovelace"`
+	DelegationStatus          string                          `json:"delegation_status"`
+	StakeAddressesInfo        []f2lb_members.StakeAddressInfo `json:"stake_addresses_info" csv:"-"`
 }
 
-func newMemeberFromStakePool(sp f2lb_members.StakePool) member {
+func newMemeberFromStakePool(sp f2lb_members.StakePoolView) member {
 	return member{
 		DiscordId:                 sp.DiscordName(),
 		Ticker:                    sp.Ticker(),
@@ -42,8 +57,8 @@ func newMemeberFromStakePool(sp f2lb_members.StakePool) member {
 		StakeAddr:                 sp.MainStakeAddress(),
 		StakeKeys:                 sp.StakeKeys(),
 		StakeAddrs:                sp.StakeAddrs(),
-		AdaDeclared:               uint32(sp.AdaDeclared()),
-		AdaDelegated:              uint32(sp.AdaDelegated()),
+		AdaDeclared:               sp.AdaDeclared().AdaUint32(),
+		AdaDelegated:              sp.AdaDelegated().AdaUint32(),
 		EpochGranted:              uint32(sp.EpochGranted()),
 		PoolIdHex:                 sp.PoolIdHex(),
 		PoolIdBech32:              sp.PoolIdBech32(),
@@ -54,21 +69,37 @@ func newMemeberFromStakePool(sp f2lb_members.StakePool) member {
 		EpochGrantedOnAddonQueue:  uint32(sp.EpochGrantedOnAddonQueue()),
 		StartingEpochOnAddonQueue: uint32(sp.StartingEpochOnAddonQueue()),
 		StartingTimeOnAddonQueue:  sp.StartingTimeOnAddonQueue().Format(time.RFC850),
-		ActiveStake:               sp.ActiveStake(),
-		LiveStake:                 sp.LiveStake(),
+		ActiveStake:               sp.ActiveStake().AdaUint32(),
+		LiveStake:                 sp.LiveStake().AdaUint32(),
 		LiveDelegators:            sp.LiveDelegators(),
+		AdaDeclaredLovelace:       uint64(sp.AdaDeclared()),
+		AdaDelegatedLovelace:      uint64(sp.AdaDelegated()),
+		ActiveStakeLovelace:       uint64(sp.ActiveStake()),
+		LiveStakeLovelace:         uint64(sp.LiveStake()),
+		TotalAdaDelegatedLovelace: uint64(sp.TotalAdaDelegated()),
+		DelegationStatus:          sp.DelegationStatus(),
+		StakeAddressesInfo:        sp.StakeAddressesInfo(),
 	}
 }
 
-func getMemberByTickersForApi(sps f2lb_members.StakePoolSet, tickers []string) (members []member) {
-	for _, t := range tickers {
-		if sp := sps.Get(t); sp != nil {
-			members = append(members, newMemeberFromStakePool(sp))
-		}
+func getMemberByTickersForApi(st *f2lb_gsheet.State, tickers []string) (members []member) {
+	for _, sp := range st.MembersOf(tickers) {
+		members = append(members, newMemeberFromStakePool(sp))
 	}
 	return members
 }
 
+// notModifiedForApi sets the ETag of the state and answers 304 if the client already has this generation
+func notModifiedForApi(c *gin.Context, st *f2lb_gsheet.State) bool {
+	etag := st.ETag()
+	c.Header("ETag", etag)
+	if utils.ETagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
 func getMembersAsCSVRecordsForApi(members []member) (string, error) {
 	sb := strings.Builder{}
 	w := csv.NewWriter(&sb)
@@ -77,6 +108,9 @@ func getMembersAsCSVRecordsForApi(members []member) (string, error) {
 	hl := []string{}
 	for i := 0; i < fieldNum; i++ {
 		f := typ.Field(i)
+		if f.Tag.Get("csv") == "-" {
+			continue
+		}
 		hl = append(hl, f.Tag.Get("json"))
 	}
 	m2r := func(m member) []string {
@@ -98,6 +132,12 @@ func getMembersAsCSVRecordsForApi(members []member) (string, error) {
 		r = append(r, fmt.Sprintf("%d", m.ActiveStake))
 		r = append(r, fmt.Sprintf("%d", m.LiveStake))
 		r = append(r, fmt.Sprintf("%d", m.LiveDelegators))
+		r = append(r, fmt.Sprintf("%d", m.AdaDeclaredLovelace))
+		r = append(r, fmt.Sprintf("%d", m.AdaDelegatedLovelace))
+		r = append(r, fmt.Sprintf("%d", m.ActiveStakeLovelace))
+		r = append(r, fmt.Sprintf("%d", m.LiveStakeLovelace))
+		r = append(r, fmt.Sprintf("%d", m.TotalAdaDelegatedLovelace))
+		r = append(r, m.DelegationStatus)
 		return r
 	}
 
@@ -0,0 +1,154 @@
+package v0
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	flag "github.com/spf13/pflag"
+
+	"github.com/safanaj/go-f2lb/pkg/f2lb_gsheet"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+const (
+	refreshWebhookTimestampHeader = "X-F2LB-Timestamp"
+	refreshWebhookSignatureHeader = "X-F2LB-Signature"
+	refreshWebhookSignaturePrefix = "sha256="
+	refreshWebhookMaxBodySize     = 64 * 1024
+)
+
+var (
+	refreshWebhookSecretPath string
+	refreshWebhookMaxSkew    = 5 * time.Minute
+
+	// loaded by LoadRefreshWebhookSecret
+	refreshWebhookSecret []byte
+)
+
+func AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&refreshWebhookSecretPath, "refresh-webhook-secret-file", refreshWebhookSecretPath,
+		"File with the shared secret to verify the refresh webhook signatures, the webhook is disabled if not set")
+	fs.DurationVar(&refreshWebhookMaxSkew, "refresh-webhook-max-skew", refreshWebhookMaxSkew,
+		"Maximum difference between the refresh webhook timestamp and the local time")
+}
+
+type refreshWebhookPayload struct {
+	// touched ranges in A1 notation, i.e. "MainQueue!A5:O5", empty means everything
+	Ranges []string `json:"ranges"`
+}
+
+func signRefreshWebhook(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// getRefreshWebhookHandler verifies an HMAC-SHA256 signature of "<timestamp>.<body>", where timestamp
+// is the unix time in seconds sent in the X-F2LB-Timestamp header and the signature is sent hex encoded
+// in the X-F2LB-Signature header prefixed by "sha256=". To test it locally:
+//
+//	ts=$(date +%s); body='{"ranges":["MainQueue!A5:O5"]}'
+//	sig=$(printf '%s.%s' "$ts" "$body" | openssl dgst -sha256 -hmac "$(cat secret)" -hex | cut -d' ' -f2)
+//	curl -H "X-F2LB-Timestamp: $ts" -H "X-F2LB-Signature: sha256=$sig" -d "$body" http://localhost:8080/api/v0/webhook/refresh
+//
+// A signature is accepted only once, the ones seen are kept until their timestamp is out of range.
+func getRefreshWebhookHandler(ctrl f2lb_gsheet.Controller, secret []byte) func(*gin.Context) {
+	var (
+		seenMu sync.Mutex
+		seen   = make(map[string]time.Time)
+	)
+	return func(c *gin.Context) {
+		tsStr := c.GetHeader(refreshWebhookTimestampHeader)
+		ts, err := strconv.ParseInt(tsStr, 10, 64)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, map[string]string{"error": "invalid timestamp"})
+			return
+		}
+		if skew := utils.Now().Sub(time.Unix(ts, 0)); skew > refreshWebhookMaxSkew || skew < -refreshWebhookMaxSkew {
+			c.IndentedJSON(http.StatusUnauthorized, map[string]string{"error": "timestamp out of range"})
+			return
+		}
+
+		sig, err := hex.DecodeString(strings.TrimPrefix(c.GetHeader(refreshWebhookSignatureHeader), refreshWebhookSignaturePrefix))
+		if err != nil || len(sig) == 0 {
+			c.IndentedJSON(http.StatusUnauthorized, map[string]string{"error": "invalid signature"})
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, refreshWebhookMaxBodySize))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, map[string]string{"error": "invalid payload"})
+			return
+		}
+		if !hmac.Equal(sig, signRefreshWebhook(secret, tsStr, body)) {
+			c.IndentedJSON(http.StatusUnauthorized, map[string]string{"error": "invalid signature"})
+			return
+		}
+
+		seenMu.Lock()
+		now := utils.Now()
+		for s, t := range seen {
+			if now.Sub(t) > refreshWebhookMaxSkew {
+				delete(seen, s)
+			}
+		}
+		_, replayed := seen[string(sig)]
+		if !replayed {
+			seen[string(sig)] = time.Unix(ts, 0)
+		}
+		seenMu.Unlock()
+		if replayed {
+			c.IndentedJSON(http.StatusUnauthorized, map[string]string{"error": "replayed request"})
+			return
+		}
+
+		payload := refreshWebhookPayload{}
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &payload); err != nil {
+				c.IndentedJSON(http.StatusBadRequest, map[string]string{"error": "invalid payload"})
+				return
+			}
+		}
+
+		ctrl.RequestRefresh(payload.Ranges...)
+		c.IndentedJSON(http.StatusAccepted, map[string]string{"status": "scheduled"})
+	}
+}
+
+// LoadRefreshWebhookSecret reads the shared secret of the refresh webhook, it has to be called once after
+// the flags are parsed and before the routes are registered, the webhook is not registered without a secret
+func LoadRefreshWebhookSecret() error {
+	if refreshWebhookSecretPath == "" {
+		return nil
+	}
+	secret, err := os.ReadFile(refreshWebhookSecretPath)
+	if err != nil {
+		return err
+	}
+	secret = []byte(strings.TrimSpace(string(secret)))
+	if len(secret) == 0 {
+		return fmt.Errorf("refresh webhook secret file %s is empty", refreshWebhookSecretPath)
+	}
+	refreshWebhookSecret = secret
+	return nil
+}
+
+func registerRefreshWebhook(rg *gin.RouterGroup, ctrl f2lb_gsheet.Controller) {
+	if len(refreshWebhookSecret) == 0 {
+		return
+	}
+	rg.POST("/webhook/refresh", getRefreshWebhookHandler(ctrl, refreshWebhookSecret))
+}
@@ -0,0 +1,153 @@
+package v0
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/safanaj/go-f2lb/pkg/f2lb_gsheet"
+)
+
+type refreshRequestRecorder struct {
+	f2lb_gsheet.Controller
+	requests [][]string
+}
+
+func (r *refreshRequestRecorder) RequestRefresh(ranges ...string) {
+	r.requests = append(r.requests, ranges)
+}
+
+var testRefreshWebhookSecret = []byte("test secret")
+
+func newTestRefreshWebhookRouter(ctrl f2lb_gsheet.Controller) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/refresh", getRefreshWebhookHandler(ctrl, testRefreshWebhookSecret))
+	return r
+}
+
+func newTestRefreshWebhookRequest(ts time.Time, body string, sign func(ts string, body []byte) string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/webhook/refresh", strings.NewReader(body))
+	tsStr := strconv.FormatInt(ts.Unix(), 10)
+	req.Header.Set(refreshWebhookTimestampHeader, tsStr)
+	if sign != nil {
+		req.Header.Set(refreshWebhookSignatureHeader, sign(tsStr, []byte(body)))
+	}
+	return req
+}
+
+func signWith(secret []byte) func(string, []byte) string {
+	return func(ts string, body []byte) string {
+		return refreshWebhookSignaturePrefix + hex.EncodeToString(signRefreshWebhook(secret, ts, body))
+	}
+}
+
+func serveTestRefreshWebhook(r *gin.Engine, req *http.Request) int {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRefreshWebhookValidSignature(t *testing.T) {
+	ctrl := &refreshRequestRecorder{}
+	r := newTestRefreshWebhookRouter(ctrl)
+
+	body := `{"ranges":["MainQueue!A5:O5"]}`
+	if code := serveTestRefreshWebhook(r, newTestRefreshWebhookRequest(time.Now(), body, signWith(testRefreshWebhookSecret))); code != http.StatusAccepted {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if want := [][]string{{"MainQueue!A5:O5"}}; !reflect.DeepEqual(ctrl.requests, want) {
+		t.Fatalf("got refresh requests %v, want %v", ctrl.requests, want)
+	}
+
+	// an empty body is a full refresh
+	if code := serveTestRefreshWebhook(r, newTestRefreshWebhookRequest(time.Now(), "", signWith(testRefreshWebhookSecret))); code != http.StatusAccepted {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if len(ctrl.requests) != 2 || len(ctrl.requests[1]) != 0 {
+		t.Fatalf("unexpected refresh requests %v", ctrl.requests)
+	}
+}
+
+func TestRefreshWebhookRejected(t *testing.T) {
+	body := `{"ranges":["MainQueue!A5:O5"]}`
+	for _, tc := range []struct {
+		name string
+		req  *http.Request
+		code int
+	}{
+		{"bad signature", newTestRefreshWebhookRequest(time.Now(), body, signWith([]byte("other secret"))), http.StatusUnauthorized},
+		{"tampered body", func() *http.Request {
+			req := newTestRefreshWebhookRequest(time.Now(), `{"ranges":[]}`, nil)
+			req.Header.Set(refreshWebhookSignatureHeader, signWith(testRefreshWebhookSecret)(req.Header.Get(refreshWebhookTimestampHeader), []byte(body)))
+			return req
+		}(), http.StatusUnauthorized},
+		{"missing signature", newTestRefreshWebhookRequest(time.Now(), body, nil), http.StatusUnauthorized},
+		{"not hex signature", newTestRefreshWebhookRequest(time.Now(), body, func(string, []byte) string { return "sha256=zz" }), http.StatusUnauthorized},
+		{"missing timestamp", func() *http.Request {
+			req := newTestRefreshWebhookRequest(time.Now(), body, signWith(testRefreshWebhookSecret))
+			req.Header.Del(refreshWebhookTimestampHeader)
+			return req
+		}(), http.StatusBadRequest},
+		{"expired timestamp", newTestRefreshWebhookRequest(time.Now().Add(-refreshWebhookMaxSkew-time.Minute), body, signWith(testRefreshWebhookSecret)), http.StatusUnauthorized},
+		{"future timestamp", newTestRefreshWebhookRequest(time.Now().Add(refreshWebhookMaxSkew+time.Minute), body, signWith(testRefreshWebhookSecret)), http.StatusUnauthorized},
+	} {
+		ctrl := &refreshRequestRecorder{}
+		if code := serveTestRefreshWebhook(newTestRefreshWebhookRouter(ctrl), tc.req); code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.name, code, tc.code)
+		}
+		if len(ctrl.requests) != 0 {
+			t.Errorf("%s: refresh requested", tc.name)
+		}
+	}
+}
+
+func TestRefreshWebhookReplayed(t *testing.T) {
+	ctrl := &refreshRequestRecorder{}
+	r := newTestRefreshWebhookRouter(ctrl)
+
+	ts, body := time.Now(), `{"ranges":["AddonQ!A3:N3"]}`
+	if code := serveTestRefreshWebhook(r, newTestRefreshWebhookRequest(ts, body, signWith(testRefreshWebhookSecret))); code != http.StatusAccepted {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if code := serveTestRefreshWebhook(r, newTestRefreshWebhookRequest(ts, body, signWith(testRefreshWebhookSecret))); code != http.StatusUnauthorized {
+		t.Fatalf("replayed request: unexpected status %d", code)
+	}
+	if len(ctrl.requests) != 1 {
+		t.Fatalf("unexpected refresh requests %v", ctrl.requests)
+	}
+}
+
+func TestLoadRefreshWebhookSecret(t *testing.T) {
+	defer func(path string) { refreshWebhookSecretPath, refreshWebhookSecret = path, nil }(refreshWebhookSecretPath)
+
+	dir := t.TempDir()
+	refreshWebhookSecretPath = filepath.Join(dir, "secret")
+	if err := LoadRefreshWebhookSecret(); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+	if err := os.WriteFile(refreshWebhookSecretPath, []byte(" \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadRefreshWebhookSecret(); err == nil {
+		t.Errorf("expected an error for an empty secret")
+	}
+	if err := os.WriteFile(refreshWebhookSecretPath, []byte("test secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadRefreshWebhookSecret(); err != nil {
+		t.Fatal(err)
+	}
+	if string(refreshWebhookSecret) != "test secret" {
+		t.Errorf("unexpected secret %q", refreshWebhookSecret)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/safanaj/cardano-go/crypto"
 
 	// "github.com/safanaj/cardano-go/libsodium"
+	"github.com/safanaj/go-f2lb/pkg/eventbus"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_gsheet"
 	"github.com/safanaj/go-f2lb/pkg/libsodium"
 )
@@ -347,6 +348,8 @@ func getReportTipHandler(ctrl f2lb_gsheet.Controller) func(*gin.Context) {
 
 		// here everything is verified, we trust the data so update the cache
 		pool.SetBlockHeight(tip)
+		eventbus.Publish(ctrl.GetEventBus(), eventbus.TipReported,
+			eventbus.TipReport{Ticker: pool.Ticker(), PoolId: pool.IdBech32(), BlockHeight: tip})
 		c.Status(http.StatusOK)
 	}
 }
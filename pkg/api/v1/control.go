@@ -18,33 +18,32 @@ import (
 
 	"github.com/hako/durafmt"
 
-	koios "github.com/cardano-community/koios-go-client/v4"
-
 	"github.com/safanaj/cardano-go"
 	"github.com/safanaj/cardano-go/bech32/prefixes"
 	"github.com/safanaj/cardano-go/cose"
 	"github.com/safanaj/cardano-go/crypto"
 
+	"github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
 	"github.com/safanaj/go-f2lb/pkg/ccli"
+	"github.com/safanaj/go-f2lb/pkg/eventbus"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_gsheet"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
 	"github.com/safanaj/go-f2lb/pkg/libsodium"
+	"github.com/safanaj/go-f2lb/pkg/scheduler"
 	"github.com/safanaj/go-f2lb/pkg/txbuilder"
 	"github.com/safanaj/go-f2lb/pkg/utils"
 	"github.com/safanaj/go-f2lb/pkg/webserver"
 )
 
+// ControlServiceRefresher sends the control messages to the clients on the events of the controller bus
 type ControlServiceRefresher interface {
-	SetRefresherChannel(chan string)
-	GetRefresherChannel() chan string
 	StartRefresher(context.Context)
 }
 
 type controlServiceServer struct {
 	UnimplementedControlMsgServiceServer
 
-	ctrl      f2lb_gsheet.Controller
-	refreshCh chan string
+	ctrl f2lb_gsheet.Controller
 
 	uuid2stream map[string]ControlMsgService_ControlServer
 	// uuid2verifiedAuthn map[string]bool
@@ -70,16 +69,9 @@ func NewControlServiceServer(
 	return cs
 }
 
-func (c *controlServiceServer) SetRefresherChannel(ch chan string) {
-	c.refreshCh = ch
-}
-func (c *controlServiceServer) GetRefresherChannel() chan string {
-	return c.refreshCh
-}
-
 func (c *controlServiceServer) Refresh(ctx context.Context, unused *emptypb.Empty) (*emptypb.Empty, error) {
 	c.sm.UpdateExpirationByContext(ctx)
-	c.ctrl.Refresh()
+	c.ctrl.ForceRefresh()
 	if c.payer != nil {
 		c.payer.Refresh()
 	}
@@ -88,14 +80,17 @@ func (c *controlServiceServer) Refresh(ctx context.Context, unused *emptypb.Empt
 
 func (s *controlServiceServer) RefreshMember(ctx context.Context, member *StakeAddr) (*MemberOrEmpty, error) {
 	s.sm.UpdateExpirationByContext(ctx)
-	if sp := s.ctrl.GetStakePoolSet().Get(member.GetStakeAddress()); sp != nil {
+	if sp := s.ctrl.GetState().Member(member.GetStakeAddress()); sp != nil {
 		s.ctrl.GetAccountCache().Add(sp.MainStakeAddress())
 		s.ctrl.GetPoolCache().Add(sp.PoolIdBech32())
 
 		if err := s.ctrl.GetAccountCache().RefreshMember(sp.MainStakeAddress()); err != nil {
 			return nil, err
 		}
-		sp = s.ctrl.GetStakePoolSet().Get(member.GetStakeAddress())
+		// the refreshed values, the state is republished only later
+		if live := s.ctrl.GetStakePoolSet().Get(member.GetStakeAddress()); live != nil {
+			sp = f2lb_members.Snapshot(live)
+		}
 		return &MemberOrEmpty{MemberOrEmpty: &MemberOrEmpty_Member{Member: newMemeberFromStakePool(sp)}}, nil
 	}
 	return &MemberOrEmpty{MemberOrEmpty: &MemberOrEmpty_Empty{}}, nil
@@ -112,25 +107,44 @@ func (s *controlServiceServer) RefreshAllMembers(ctx context.Context, unused *em
 	return &emptypb.Empty{}, nil
 }
 
+// name and interval of the job pinging the clients connected to the control stream
+const (
+	ControlKeepaliveJobName  = "api-v1-control-keepalive"
+	controlKeepaliveInterval = 120 * time.Second
+)
+
 func (s *controlServiceServer) StartRefresher(ctx context.Context) {
 	s.uuid2stream = make(map[string]ControlMsgService_ControlServer)
-	ticker := time.NewTicker(120 * time.Second)
+	// the clients are pinged by a scheduled job, the messages are sent only by the loop below
+	keepalive := make(chan struct{}, 1)
+	if err := s.ctrl.GetScheduler().Register(ControlKeepaliveJobName, scheduler.Every(controlKeepaliveInterval),
+		func(context.Context) error {
+			select {
+			case keepalive <- struct{}{}:
+			default:
+			}
+			return nil
+		}); err != nil {
+		fmt.Printf("Unable to schedule the control keepalive: %v\n", err)
+	}
+	bus := s.ctrl.GetEventBus()
+	refreshes := eventbus.Subscribe(bus, f2lb_gsheet.RefreshCompletedTopic, "api/v1/control", 0)
+	sessions := eventbus.Subscribe(bus, eventbus.SessionVerified, "api/v1/control", 0)
 
 	go func() {
+		defer refreshes.Close()
+		defer sessions.Close()
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case t := <-ticker.C:
-				s.sendControlMsgToAll(t, ControlMsg_NONE)
-			case ruuid, ok := <-s.refreshCh:
-				if !ok {
-					return
-				}
-				if ruuid == "ALL" {
-					s.sendControlMsgToAll(time.Now(), ControlMsg_REFRESH)
-				} else if stream, ok := s.uuid2stream[ruuid]; ok {
-					if err := s.sendControlMsg(stream, time.Now(), ControlMsg_NONE, nil); err != nil {
+			case <-keepalive:
+				s.sendControlMsgToAll(utils.Now(), ControlMsg_NONE, nil)
+			case diff := <-refreshes.C:
+				s.sendControlMsgToAll(utils.Now(), ControlMsg_REFRESH, diff)
+			case ruuid := <-sessions.C:
+				if stream, ok := s.uuid2stream[ruuid]; ok {
+					if err := s.sendControlMsg(stream, utils.Now(), ControlMsg_NONE, nil); err != nil {
 						delete(s.uuid2stream, ruuid)
 					}
 				} else {
@@ -141,38 +155,54 @@ func (s *controlServiceServer) StartRefresher(ctx context.Context) {
 	}()
 }
 
-func (s *controlServiceServer) getDataBytesForControlMsg(t time.Time) []byte {
+func (s *controlServiceServer) getDataBytesForControlMsg(t time.Time, diff *f2lb_gsheet.RefreshDiff) []byte {
 	tips := map[string]uint32{}
-	for _, sp := range s.ctrl.GetStakePoolSet().StakePools() {
+	for _, sp := range s.ctrl.GetState().Members {
 		if sp.BlockHeight() == 0 {
 			continue
 		}
 		tips[sp.Ticker()] = sp.BlockHeight()
 	}
 
-	dataBytes, _ := json.Marshal(map[string]any{
+	pcStats, acStats := s.ctrl.GetPoolCache().Stats(), s.ctrl.GetAccountCache().Stats()
+	data := map[string]any{
 		"cache_ready":              s.ctrl.GetAccountCache().Ready() && s.ctrl.GetPoolCache().Ready(),
 		"last_refresh_time":        s.ctrl.GetLastRefreshTime().Format(time.RFC850),
 		"epoch_remaining_duration": durafmt.Parse(time.Duration(utils.EpochLength-utils.TimeToSlot(t)) * time.Second).String(),
 		"koios_tip_block_height":   s.ctrl.GetKoiosTipBlockHeight(),
 		"notes": map[string]string{
-			"poolcache_pending":    fmt.Sprintf("%d", s.ctrl.GetPoolCache().Pending()),
-			"poolcache_len":        fmt.Sprintf("%d", s.ctrl.GetPoolCache().Len()),
-			"poolcache_added":      fmt.Sprintf("%d", s.ctrl.GetPoolCache().AddedItems()),
-			"accountcache_pending": fmt.Sprintf("%d", s.ctrl.GetAccountCache().Pending()),
-			"accountcache_len":     fmt.Sprintf("%d", s.ctrl.GetAccountCache().Len()),
-			"accountcache_added":   fmt.Sprintf("%d", s.ctrl.GetAccountCache().AddedItems()),
-			"missing_pools":        strings.Join(s.ctrl.GetPoolCache().GetMissingPoolInfos(), ", "),
-			"payer_available":      fmt.Sprintf("%t", s.payer != nil),
+			"poolcache_pending":         fmt.Sprintf("%d", s.ctrl.GetPoolCache().Pending()),
+			"poolcache_len":             fmt.Sprintf("%d", s.ctrl.GetPoolCache().Len()),
+			"poolcache_added":           fmt.Sprintf("%d", s.ctrl.GetPoolCache().AddedItems()),
+			"accountcache_pending":      fmt.Sprintf("%d", s.ctrl.GetAccountCache().Pending()),
+			"accountcache_len":          fmt.Sprintf("%d", s.ctrl.GetAccountCache().Len()),
+			"accountcache_added":        fmt.Sprintf("%d", s.ctrl.GetAccountCache().AddedItems()),
+			"poolcache_stale":           fmt.Sprintf("%d", pcStats.Stale),
+			"poolcache_fetch_errors":    fmt.Sprintf("%d", pcStats.FetchErrors),
+			"accountcache_stale":        fmt.Sprintf("%d", acStats.Stale),
+			"accountcache_fetch_errors": fmt.Sprintf("%d", acStats.FetchErrors),
+			"missing_pools":             strings.Join(s.ctrl.GetPoolCache().GetMissingPoolInfos(), ", "),
+			"payer_available":           fmt.Sprintf("%t", s.payer != nil),
 		},
 		"tips": tips,
-	})
+	}
+	if ss := s.ctrl.GetSheetsStatus(); ss.HasData() {
+		data["data_age"] = durafmt.Parse(ss.DataAge().Truncate(time.Second)).String()
+		if ss.IsStale() {
+			data["stale_since"] = ss.StaleSince.Format(time.RFC850)
+		}
+	}
+	if diff != nil {
+		data["changes"] = diff.Events
+		data["changes_reason"] = diff.Reason
+	}
+	dataBytes, _ := json.Marshal(data)
 	return dataBytes
 }
 
 func (s *controlServiceServer) sendControlMsg(stream ControlMsgService_ControlServer, t time.Time, cmsgType ControlMsg_Type, data []byte) error {
 	if data == nil {
-		data = s.getDataBytesForControlMsg(t)
+		data = s.getDataBytesForControlMsg(t, nil)
 	}
 
 	cmsg := &ControlMsg{
@@ -185,9 +215,9 @@ func (s *controlServiceServer) sendControlMsg(stream ControlMsgService_ControlSe
 	return stream.Send(cmsg)
 }
 
-func (s *controlServiceServer) sendControlMsgToAll(t time.Time, cmsgType ControlMsg_Type) {
+func (s *controlServiceServer) sendControlMsgToAll(t time.Time, cmsgType ControlMsg_Type, diff *f2lb_gsheet.RefreshDiff) {
 	toDel := []string{}
-	dataBytes := s.getDataBytesForControlMsg(t)
+	dataBytes := s.getDataBytesForControlMsg(t, diff)
 	for ruuid, stream := range s.uuid2stream {
 		if err := s.sendControlMsg(stream, t, cmsgType, dataBytes); err != nil {
 			toDel = append(toDel, ruuid)
@@ -202,7 +232,7 @@ func (s *controlServiceServer) sendControlMsgToAll(t time.Time, cmsgType Control
 }
 
 func (s *controlServiceServer) Control(stream ControlMsgService_ControlServer) error {
-	s.sendControlMsg(stream, time.Now(), ControlMsg_REFRESH, nil)
+	s.sendControlMsg(stream, utils.Now(), ControlMsg_REFRESH, nil)
 	ruuid, isOk := stream.Context().Value(webserver.IdCtxKey).(string)
 	if isOk {
 		s.uuid2stream[ruuid] = stream
@@ -234,7 +264,7 @@ func (s *controlServiceServer) Auth(ctx context.Context, saddr *StakeAddr) (*Use
 		}
 	}
 
-	if sp := s.ctrl.GetStakePoolSet().Get(saddr_); sp != nil && sp.Ticker() != "" {
+	if sp := s.ctrl.GetState().Member(saddr_); sp != nil && sp.Ticker() != "" {
 		// check admin permission
 		_, isAdmin := s.adminPools[sp.Ticker()]
 
@@ -249,7 +279,7 @@ func (s *controlServiceServer) Auth(ctx context.Context, saddr *StakeAddr) (*Use
 		return user, nil
 	}
 
-	delegPool, _, _ := s.ctrl.GetKoiosClient().GetStakeAddressInfo(saddr_)
+	delegPool, _, _ := s.ctrl.GetChainProvider().GetStakeAddressInfo(saddr_)
 
 	supporter := (*f2lb_gsheet.Supporter)(nil)
 	for _, r := range s.ctrl.GetSupportersRecords() {
@@ -287,7 +317,7 @@ func (s *controlServiceServer) BuildDelegationTx(ctx context.Context, deleg *Del
 
 	s.payer.Info("Control.BuildDelegationTx", "deleg", deleg)
 	memberTicker := ""
-	if sp := s.ctrl.GetStakePoolSet().Get(deleg.GetStakeAddress()); sp != nil && sp.Ticker() != "" {
+	if sp := s.ctrl.GetState().Member(deleg.GetStakeAddress()); sp != nil && sp.Ticker() != "" {
 		memberTicker = sp.Ticker()
 	}
 	if txHex := s.payer.BuildDelegationTx(
@@ -374,7 +404,7 @@ func (s *controlServiceServer) Authn(ctx context.Context, asig *AuthnSignature)
 	s.sm.UpdateExpiration(ruuid)
 	s.sm.UpdateVerifiedAccount(ruuid, stakeAddr.Bech32())
 
-	s.refreshCh <- ruuid
+	eventbus.Publish(s.ctrl.GetEventBus(), eventbus.SessionVerified, ruuid)
 	return wrapperspb.Bool(true), nil
 }
 
@@ -393,7 +423,7 @@ func (s *controlServiceServer) CheckAllPools(ctx context.Context, unused *emptyp
 		return unused, fmt.Errorf("Not verified")
 	}
 
-	if sp := s.ctrl.GetStakePoolSet().Get(sd.VerifiedAccount); sp != nil && sp.Ticker() != "" {
+	if sp := s.ctrl.GetState().Member(sd.VerifiedAccount); sp != nil && sp.Ticker() != "" {
 		if _, isAdmin := s.adminPools[sp.Ticker()]; !isAdmin {
 			return unused, fmt.Errorf("Not an admin, not allowed")
 		}
@@ -526,7 +556,7 @@ func (s *controlServiceServer) WhoAmI(ctx context.Context, unused *emptypb.Empty
 			if sd.MemberAccount == "" {
 				return user, nil
 			}
-			if sp := s.ctrl.GetStakePoolSet().Get(sd.MemberAccount); sp != nil && sp.Ticker() != "" {
+			if sp := s.ctrl.GetState().Member(sd.MemberAccount); sp != nil && sp.Ticker() != "" {
 				// check admin permission
 				_, isAdmin := s.adminPools[sp.Ticker()]
 				user.Type = User_SPO
@@ -541,19 +571,17 @@ func (s *controlServiceServer) WhoAmI(ctx context.Context, unused *emptypb.Empty
 	return user, nil
 }
 
-func getNonce(ctx context.Context, kc *koios.Client, delta int) (string, error) {
-	epoch := koios.EpochNo(int(utils.CurrentEpoch()) - 1)
-	opts := kc.NewRequestOptions()
-	opts.QuerySet("select", "nonce")
-	r, err := kc.GetEpochParams(ctx, epoch, opts)
+// getNonce always returns the nonce of the previous epoch, delta is ignored as it has always been
+func getNonce(kc *koiosutils.CachedClient, delta int) (string, error) {
+	nonce, err := kc.GetEpochNonce(utils.CurrentEpoch() - 1)
 	if err != nil {
 		return "", fmt.Errorf("Error getting info from koios: %w\n", err)
 	}
-	return r.Data[0].Nonce, nil
+	return nonce, nil
 }
 
 func (s *controlServiceServer) NoncePrev(ctx context.Context, unused *emptypb.Empty) (*wrapperspb.StringValue, error) {
-	nonce, err := getNonce(ctx, s.ctrl.GetKoiosClient().GetKoiosClient(), -1)
+	nonce, err := getNonce(s.ctrl.GetKoiosClient().Cached(), -1)
 	if err != nil {
 		return nil, err
 	}
@@ -561,20 +589,18 @@ func (s *controlServiceServer) NoncePrev(ctx context.Context, unused *emptypb.Em
 }
 
 func (s *controlServiceServer) NonceCurrent(ctx context.Context, unused *emptypb.Empty) (*wrapperspb.StringValue, error) {
-	nonce, err := getNonce(ctx, s.ctrl.GetKoiosClient().GetKoiosClient(), 0)
+	nonce, err := getNonce(s.ctrl.GetKoiosClient().Cached(), 0)
 	if err != nil {
 		return nil, err
 	}
 	return wrapperspb.String(nonce), nil
 }
 
-const slotInEpochForNextNonce = 302400
-
 func (s *controlServiceServer) NonceNext(ctx context.Context, unused *emptypb.Empty) (*wrapperspb.StringValue, error) {
 	curSlotInEpoch := int(utils.CurrentSlotInEpoch())
-	if curSlotInEpoch < slotInEpochForNextNonce {
+	if curSlotInEpoch < int(scheduler.NonceStabilitySlot) {
 		return nil, fmt.Errorf("New epoch nonce not yet computable, will be available in %v",
-			time.Duration(time.Second*time.Duration(slotInEpochForNextNonce-curSlotInEpoch)))
+			time.Duration(time.Second*time.Duration(int(scheduler.NonceStabilitySlot)-curSlotInEpoch)))
 	}
 	out, err := ccli.DoCommand(ctx, "query protocol-state --mainnet")
 	if err != nil {
@@ -813,7 +839,7 @@ func (s *controlServiceServer) ReportTip(ctx context.Context, tp *TipPayload) (*
 		}
 		vkhHex := hex.EncodeToString(vkh)
 		// we need this to verify stake key hash
-		sp := s.ctrl.GetStakePoolSet().Get(pool.Ticker())
+		sp := s.ctrl.GetState().Member(pool.Ticker())
 		if sp.MainStakeKey() != vkhHex {
 			return nil, fmt.Errorf("provided public key is not matching member stake key, details: " +
 				fmt.Sprintf("%s != %s (stake key hash)", vkhHex, sp.MainStakeKey()))
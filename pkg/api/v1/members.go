@@ -10,14 +10,14 @@ import (
 	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
 )
 
-func newMemeberFromStakePool(sp f2lb_members.StakePool) *Member {
+func newMemeberFromStakePool(sp f2lb_members.StakePoolView) *Member {
 	return &Member{
 		DiscordId:                 sp.DiscordName(),
 		Ticker:                    sp.Ticker(),
 		StakeKey:                  sp.MainStakeKey(),
 		StakeAddr:                 sp.MainStakeAddress(),
-		AdaDeclared:               uint32(sp.AdaDeclared()),
-		AdaDelegated:              uint32(sp.AdaDelegated()),
+		AdaDeclared:               sp.AdaDeclared().AdaUint32(),
+		AdaDelegated:              sp.AdaDelegated().AdaUint32(),
 		EpochGranted:              uint32(sp.EpochGranted()),
 		PoolIdHex:                 sp.PoolIdHex(),
 		PoolIdBech32:              sp.PoolIdBech32(),
@@ -29,41 +29,50 @@ func newMemeberFromStakePool(sp f2lb_members.StakePool) *Member {
 		EpochGrantedOnAddonQueue:  uint32(sp.EpochGrantedOnAddonQueue()),
 		StartingEpochOnAddonQueue: uint32(sp.StartingEpochOnAddonQueue()),
 		StartingTimeOnAddonQueue:  sp.StartingTimeOnAddonQueue().Format(time.RFC850),
-		ActiveStake:               sp.ActiveStake(),
-		LiveStake:                 sp.LiveStake(),
+		ActiveStake:               sp.ActiveStake().AdaUint32(),
+		LiveStake:                 sp.LiveStake().AdaUint32(),
 		LiveDelegators:            sp.LiveDelegators(),
 		BlockHeight:               sp.BlockHeight(),
+		AdaDeclaredLovelace:       uint64(sp.AdaDeclared()),
+		AdaDelegatedLovelace:      uint64(sp.AdaDelegated()),
+		ActiveStakeLovelace:       uint64(sp.ActiveStake()),
+		LiveStakeLovelace:         uint64(sp.LiveStake()),
 	}
 }
 
+func newMembersFromStakePools(sps []f2lb_members.StakePoolView) []*Member {
+	members := make([]*Member, 0, len(sps))
+	for _, sp := range sps {
+		members = append(members, newMemeberFromStakePool(sp))
+	}
+	return members
+}
+
 type memberServiceServer struct {
 	UnimplementedMemberServiceServer
-	delegCycle *f2lb_gsheet.DelegationCycle
-	sps        f2lb_members.StakePoolSet
+	sg f2lb_gsheet.StateGetter
 }
 
-func NewMemberServiceServer(d *f2lb_gsheet.DelegationCycle, sps f2lb_members.StakePoolSet) MemberServiceServer {
-	return &memberServiceServer{delegCycle: d, sps: sps}
+func NewMemberServiceServer(sg f2lb_gsheet.StateGetter) MemberServiceServer {
+	return &memberServiceServer{sg: sg}
 }
 
 func (a *memberServiceServer) Active(context.Context, *emptypb.Empty) (*Member, error) {
-	if sp := a.sps.Get(a.delegCycle.GetActiveTicker()); sp != nil {
+	st := a.sg.GetState()
+	if sp := st.Member(st.DelegationCycle.ActiveTicker); sp != nil {
 		return newMemeberFromStakePool(sp), nil
 	}
 	return &Member{}, nil
 }
 
 func (a *memberServiceServer) Top(context.Context, *emptypb.Empty) (*Member, error) {
-	if sp := a.sps.Get(a.delegCycle.GetTopTicker()); sp != nil {
+	st := a.sg.GetState()
+	if sp := st.Member(st.DelegationCycle.TopTicker); sp != nil {
 		return newMemeberFromStakePool(sp), nil
 	}
 	return &Member{}, nil
 }
 
 func (a *memberServiceServer) List(context.Context, *emptypb.Empty) (*Members, error) {
-	members := []*Member{}
-	for _, sp := range a.sps.StakePools() {
-		members = append(members, newMemeberFromStakePool(sp))
-	}
-	return &Members{Members: members}, nil
+	return &Members{Members: newMembersFromStakePools(a.sg.GetState().Members)}, nil
 }
@@ -37,12 +37,12 @@ func checkForVerifiedUser(ctx context.Context, sm webserver.SessionManager) erro
 type koiosServiceHandler struct {
 	UnimplementedKoiosHandler
 
-	kc *koiosutils.KoiosClient
+	kc *koiosutils.CachedClient
 	sm webserver.SessionManager
 }
 
 func NewKoiosService(kc *koiosutils.KoiosClient, sm webserver.SessionManager) KoiosHandler {
-	return &koiosServiceHandler{kc: kc, sm: sm}
+	return &koiosServiceHandler{kc: kc.Cached(), sm: sm}
 }
 
 func (h *koiosServiceHandler) checkForVerifiedUser(ctx context.Context) error {
@@ -252,7 +252,8 @@ func (h *accountCacheServiceHandler) Get(ctx context.Context, req *connect.Reque
 	res, err := structpb.NewStruct(map[string]any{
 		"StakeAddress":  info.StakeAddress(),
 		"DelegatedPool": info.DelegatedPool(),
-		"AdaAmount":     info.AdaAmount(),
+		"AdaAmount":     info.AdaAmount().Ada(),
+		"Lovelace":      uint64(info.AdaAmount()),
 		"Status":        info.Status(),
 	})
 
@@ -383,16 +384,18 @@ func (h *poolCacheServiceHandler) Get(ctx context.Context, req *connect.Request[
 		return nil, connect.NewError(connect.CodeNotFound, nil)
 	}
 	res, err := structpb.NewStruct(map[string]any{
-		"Ticker":         info.Ticker(),
-		"IdBech32":       info.IdBech32(),
-		"IdHex":          info.IdHex(),
-		"VrfKeyHash":     info.VrfKeyHash(),
-		"ActiveStake":    info.ActiveStake(),
-		"LiveStake":      info.LiveStake(),
-		"LiveDelegators": info.LiveDelegators(),
-		"BlockHeight":    info.BlockHeight(),
-		"IsRetired":      info.IsRetired(),
-		"Margin":         info.Margin(),
+		"Ticker":              info.Ticker(),
+		"IdBech32":            info.IdBech32(),
+		"IdHex":               info.IdHex(),
+		"VrfKeyHash":          info.VrfKeyHash(),
+		"ActiveStake":         info.ActiveStake().Ada(),
+		"LiveStake":           info.LiveStake().Ada(),
+		"ActiveStakeLovelace": uint64(info.ActiveStake()),
+		"LiveStakeLovelace":   uint64(info.LiveStake()),
+		"LiveDelegators":      info.LiveDelegators(),
+		"BlockHeight":         info.BlockHeight(),
+		"IsRetired":           info.IsRetired(),
+		"Margin":              info.Margin(),
 	})
 	return connect.NewResponse(res), err
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"maps"
 	reflect "reflect"
@@ -20,18 +21,19 @@ import (
 
 	"github.com/hako/durafmt"
 
-	koios "github.com/cardano-community/koios-go-client/v4"
-
 	"github.com/safanaj/cardano-go"
 	"github.com/safanaj/cardano-go/bech32/prefixes"
 	"github.com/safanaj/cardano-go/cose"
 	"github.com/safanaj/cardano-go/crypto"
 
+	"github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
 	"github.com/safanaj/go-f2lb/pkg/ccli"
+	"github.com/safanaj/go-f2lb/pkg/eventbus"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_gsheet"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
 	"github.com/safanaj/go-f2lb/pkg/libsodium"
 	"github.com/safanaj/go-f2lb/pkg/logging"
+	"github.com/safanaj/go-f2lb/pkg/scheduler"
 	"github.com/safanaj/go-f2lb/pkg/txbuilder"
 	"github.com/safanaj/go-f2lb/pkg/utils"
 	"github.com/safanaj/go-f2lb/pkg/webserver"
@@ -39,9 +41,8 @@ import (
 	connect "connectrpc.com/connect"
 )
 
+// ControlServiceRefresher sends the control messages to the clients on the events of the controller bus
 type ControlServiceRefresher interface {
-	SetRefresherChannel(chan string)
-	GetRefresherChannel() chan string
 	StartRefresher(context.Context)
 }
 
@@ -107,9 +108,8 @@ func (m *streamMap) DeleteStream(key string, value *connect.ServerStream[Control
 type controlServiceServer struct {
 	UnimplementedControlMsgServiceHandler
 
-	webCtx    context.Context
-	ctrl      f2lb_gsheet.Controller
-	refreshCh chan string
+	webCtx context.Context
+	ctrl   f2lb_gsheet.Controller
 
 	uuid2stream *streamMap
 	// uuid2stream map[string]*connect.ServerStream[ControlMsg]
@@ -120,6 +120,10 @@ type controlServiceServer struct {
 	payer *txbuilder.Payer
 
 	sm webserver.SessionManager
+
+	nextNonceMu    sync.Mutex
+	nextNonceEpoch utils.Epoch
+	nextNonce      string
 }
 
 var (
@@ -147,17 +151,9 @@ func NewControlServiceHandler(
 	}
 }
 
-func (c *controlServiceServer) SetRefresherChannel(ch chan string) {
-	c.refreshCh = ch
-}
-
-func (c *controlServiceServer) GetRefresherChannel() chan string {
-	return c.refreshCh
-}
-
 func (c *controlServiceServer) Refresh(ctx context.Context, unused *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
 	c.sm.UpdateExpirationByContext(ctx)
-	c.ctrl.Refresh()
+	c.ctrl.ForceRefresh()
 	if c.payer != nil {
 		c.payer.Refresh()
 	}
@@ -167,14 +163,17 @@ func (c *controlServiceServer) Refresh(ctx context.Context, unused *connect.Requ
 func (s *controlServiceServer) RefreshMember(ctx context.Context, req *connect.Request[StakeAddr]) (*connect.Response[MemberOrEmpty], error) {
 	member := req.Msg
 	s.sm.UpdateExpirationByContext(ctx)
-	if sp := s.ctrl.GetStakePoolSet().Get(member.GetStakeAddress()); sp != nil {
+	if sp := s.ctrl.GetState().Member(member.GetStakeAddress()); sp != nil {
 		s.ctrl.GetAccountCache().Add(sp.MainStakeAddress())
 		s.ctrl.GetPoolCache().Add(sp.PoolIdBech32())
 
 		if err := s.ctrl.GetAccountCache().RefreshMember(sp.MainStakeAddress()); err != nil {
 			return nil, err
 		}
-		sp = s.ctrl.GetStakePoolSet().Get(member.GetStakeAddress())
+		// the refreshed values, the state is republished only later
+		if live := s.ctrl.GetStakePoolSet().Get(member.GetStakeAddress()); live != nil {
+			sp = f2lb_members.Snapshot(live)
+		}
 		return connect.NewResponse(&MemberOrEmpty{MemberOrEmpty: &MemberOrEmpty_Member{Member: newMemeberFromStakePool(sp)}}), nil
 	}
 	return connect.NewResponse(&MemberOrEmpty{MemberOrEmpty: &MemberOrEmpty_Empty{}}), nil
@@ -191,26 +190,52 @@ func (s *controlServiceServer) RefreshAllMembers(ctx context.Context, unused *co
 	return connect.NewResponse(&emptypb.Empty{}), nil
 }
 
+// name and interval of the job pinging the clients connected to the control stream
+const (
+	ControlKeepaliveJobName  = "api-v2-control-keepalive"
+	controlKeepaliveInterval = 120 * time.Second
+)
+
 func (s *controlServiceServer) StartRefresher(ctx context.Context) {
 	s.uuid2stream = &streamMap{Map: new(sync.Map)}
-	ticker := time.NewTicker(120 * time.Second)
+	// the clients are pinged by a scheduled job, the messages are sent only by the loop below
+	keepalive := make(chan struct{}, 1)
+	if err := s.ctrl.GetScheduler().Register(ControlKeepaliveJobName, scheduler.Every(controlKeepaliveInterval),
+		func(context.Context) error {
+			select {
+			case keepalive <- struct{}{}:
+			default:
+			}
+			return nil
+		}); err != nil {
+		fmt.Printf("Unable to schedule the control keepalive: %v\n", err)
+	}
+	if err := s.ctrl.GetScheduler().Register(NextNonceJobName, scheduler.AtSlotInEpoch(scheduler.NonceStabilitySlot),
+		func(ctx context.Context) error {
+			_, err := s.getNextNonce(ctx)
+			return err
+		}); err != nil {
+		fmt.Printf("Unable to schedule the next nonce: %v\n", err)
+	}
+	bus := s.ctrl.GetEventBus()
+	refreshes := eventbus.Subscribe(bus, f2lb_gsheet.RefreshCompletedTopic, "api/v2/control", 0)
+	sessions := eventbus.Subscribe(bus, eventbus.SessionVerified, "api/v2/control", 0)
 
 	go func() {
+		defer refreshes.Close()
+		defer sessions.Close()
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case t := <-ticker.C:
-				s.sendControlMsgToAll(t, ControlMsg_NONE)
-			case ruuid, ok := <-s.refreshCh:
-				if !ok {
-					return
-				}
-				if ruuid == "ALL" {
-					s.sendControlMsgToAll(time.Now(), ControlMsg_REFRESH)
-				} else if streams, ok := s.uuid2stream.LoadStreams(ruuid); ok {
+			case <-keepalive:
+				s.sendControlMsgToAll(utils.Now(), ControlMsg_NONE, nil)
+			case diff := <-refreshes.C:
+				s.sendControlMsgToAll(utils.Now(), ControlMsg_REFRESH, diff)
+			case ruuid := <-sessions.C:
+				if streams, ok := s.uuid2stream.LoadStreams(ruuid); ok {
 					for _, stream := range streams {
-						if err := s.sendControlMsg(stream, time.Now(), ControlMsg_NONE, nil); err != nil {
+						if err := s.sendControlMsg(stream, utils.Now(), ControlMsg_NONE, nil); err != nil {
 							s.uuid2stream.DeleteStream(ruuid, stream)
 						}
 					}
@@ -222,38 +247,54 @@ func (s *controlServiceServer) StartRefresher(ctx context.Context) {
 	}()
 }
 
-func (s *controlServiceServer) getDataBytesForControlMsg(t time.Time) []byte {
+func (s *controlServiceServer) getDataBytesForControlMsg(t time.Time, diff *f2lb_gsheet.RefreshDiff) []byte {
 	tips := map[string]uint32{}
-	for _, sp := range s.ctrl.GetStakePoolSet().StakePools() {
+	for _, sp := range s.ctrl.GetState().Members {
 		if sp.BlockHeight() == 0 {
 			continue
 		}
 		tips[sp.Ticker()] = sp.BlockHeight()
 	}
 
-	dataBytes, _ := json.Marshal(map[string]any{
+	pcStats, acStats := s.ctrl.GetPoolCache().Stats(), s.ctrl.GetAccountCache().Stats()
+	data := map[string]any{
 		"cache_ready":              s.ctrl.GetAccountCache().Ready() && s.ctrl.GetPoolCache().Ready(),
 		"last_refresh_time":        s.ctrl.GetLastRefreshTime().Format(time.RFC850),
 		"epoch_remaining_duration": durafmt.Parse(time.Duration(utils.EpochLength-utils.TimeToSlot(t)) * time.Second).String(),
 		"koios_tip_block_height":   s.ctrl.GetKoiosTipBlockHeight(),
 		"notes": map[string]string{
-			"poolcache_pending":    fmt.Sprintf("%d", s.ctrl.GetPoolCache().Pending()),
-			"poolcache_len":        fmt.Sprintf("%d", s.ctrl.GetPoolCache().Len()),
-			"poolcache_added":      fmt.Sprintf("%d", s.ctrl.GetPoolCache().AddedItems()),
-			"accountcache_pending": fmt.Sprintf("%d", s.ctrl.GetAccountCache().Pending()),
-			"accountcache_len":     fmt.Sprintf("%d", s.ctrl.GetAccountCache().Len()),
-			"accountcache_added":   fmt.Sprintf("%d", s.ctrl.GetAccountCache().AddedItems()),
-			"missing_pools":        strings.Join(s.ctrl.GetPoolCache().GetMissingPoolInfos(), ", "),
-			"payer_available":      fmt.Sprintf("%t", s.payer != nil),
+			"poolcache_pending":         fmt.Sprintf("%d", s.ctrl.GetPoolCache().Pending()),
+			"poolcache_len":             fmt.Sprintf("%d", s.ctrl.GetPoolCache().Len()),
+			"poolcache_added":           fmt.Sprintf("%d", s.ctrl.GetPoolCache().AddedItems()),
+			"accountcache_pending":      fmt.Sprintf("%d", s.ctrl.GetAccountCache().Pending()),
+			"accountcache_len":          fmt.Sprintf("%d", s.ctrl.GetAccountCache().Len()),
+			"accountcache_added":        fmt.Sprintf("%d", s.ctrl.GetAccountCache().AddedItems()),
+			"poolcache_stale":           fmt.Sprintf("%d", pcStats.Stale),
+			"poolcache_fetch_errors":    fmt.Sprintf("%d", pcStats.FetchErrors),
+			"accountcache_stale":        fmt.Sprintf("%d", acStats.Stale),
+			"accountcache_fetch_errors": fmt.Sprintf("%d", acStats.FetchErrors),
+			"missing_pools":             strings.Join(s.ctrl.GetPoolCache().GetMissingPoolInfos(), ", "),
+			"payer_available":           fmt.Sprintf("%t", s.payer != nil),
 		},
 		"tips": tips,
-	})
+	}
+	if ss := s.ctrl.GetSheetsStatus(); ss.HasData() {
+		data["data_age"] = durafmt.Parse(ss.DataAge().Truncate(time.Second)).String()
+		if ss.IsStale() {
+			data["stale_since"] = ss.StaleSince.Format(time.RFC850)
+		}
+	}
+	if diff != nil {
+		data["changes"] = diff.Events
+		data["changes_reason"] = diff.Reason
+	}
+	dataBytes, _ := json.Marshal(data)
 	return dataBytes
 }
 
 func (s *controlServiceServer) sendControlMsg(stream *connect.ServerStream[ControlMsg], t time.Time, cmsgType ControlMsg_Type, data []byte) error {
 	if data == nil {
-		data = s.getDataBytesForControlMsg(t)
+		data = s.getDataBytesForControlMsg(t, nil)
 	}
 
 	cmsg := &ControlMsg{
@@ -267,13 +308,13 @@ func (s *controlServiceServer) sendControlMsg(stream *connect.ServerStream[Contr
 	return stream.Send(cmsg)
 }
 
-func (s *controlServiceServer) sendControlMsgToAll(t time.Time, cmsgType ControlMsg_Type) {
+func (s *controlServiceServer) sendControlMsgToAll(t time.Time, cmsgType ControlMsg_Type, diff *f2lb_gsheet.RefreshDiff) {
 	type tuple struct {
 		string
 		*connect.ServerStream[ControlMsg]
 	}
 	toDel := []tuple{}
-	dataBytes := s.getDataBytesForControlMsg(t)
+	dataBytes := s.getDataBytesForControlMsg(t, diff)
 
 	defer func() {
 		if x := recover(); x != nil {
@@ -304,7 +345,7 @@ func (s *controlServiceServer) sendControlMsgToAll(t time.Time, cmsgType Control
 }
 
 func (s *controlServiceServer) Control(ctx context.Context, _ *connect.Request[emptypb.Empty], stream *connect.ServerStream[ControlMsg]) error {
-	s.sendControlMsg(stream, time.Now(), ControlMsg_REFRESH, nil)
+	s.sendControlMsg(stream, utils.Now(), ControlMsg_REFRESH, nil)
 	ruuid, isOk := ctx.Value(webserver.IdCtxKey).(string)
 	if isOk {
 		s.uuid2stream.AddStream(ruuid, stream)
@@ -346,7 +387,7 @@ func (s *controlServiceServer) Auth(ctx context.Context, req *connect.Request[St
 		}
 	}
 
-	if sp := s.ctrl.GetStakePoolSet().Get(saddr_); sp != nil && sp.Ticker() != "" {
+	if sp := s.ctrl.GetState().Member(saddr_); sp != nil && sp.Ticker() != "" {
 		// check admin permission
 		_, isAdmin := s.adminPools[sp.Ticker()]
 
@@ -362,7 +403,7 @@ func (s *controlServiceServer) Auth(ctx context.Context, req *connect.Request[St
 		return connect.NewResponse(user), nil
 	}
 
-	delegPool, _, _ := s.ctrl.GetKoiosClient().GetStakeAddressInfo(saddr_)
+	delegPool, _, _ := s.ctrl.GetChainProvider().GetStakeAddressInfo(saddr_)
 
 	supporter := (*f2lb_gsheet.Supporter)(nil)
 	for _, r := range s.ctrl.GetSupportersRecords() {
@@ -402,7 +443,7 @@ func (s *controlServiceServer) BuildDelegationTx(ctx context.Context, req *conne
 	deleg := req.Msg
 	s.payer.Info("Control.BuildDelegationTx", "deleg", deleg)
 	memberTicker := ""
-	if sp := s.ctrl.GetStakePoolSet().Get(deleg.GetStakeAddress()); sp != nil && sp.Ticker() != "" {
+	if sp := s.ctrl.GetState().Member(deleg.GetStakeAddress()); sp != nil && sp.Ticker() != "" {
 		memberTicker = sp.Ticker()
 	}
 	if txHex := s.payer.BuildDelegationTx(
@@ -492,7 +533,7 @@ func (s *controlServiceServer) Authn(ctx context.Context, req *connect.Request[A
 	s.sm.UpdateExpiration(ruuid)
 	s.sm.UpdateVerifiedAccount(ruuid, stakeAddr.Bech32())
 
-	s.refreshCh <- ruuid
+	eventbus.Publish(s.ctrl.GetEventBus(), eventbus.SessionVerified, ruuid)
 	return connect.NewResponse(wrapperspb.Bool(true)), nil
 }
 
@@ -501,23 +542,31 @@ func (s *controlServiceServer) Logout(ctx context.Context, _ *connect.Request[em
 	return connect.NewResponse(&emptypb.Empty{}), nil
 }
 
-func (s *controlServiceServer) CheckAllPools(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
-	unused := connect.NewResponse(req.Msg)
+// checkForAdmin verifies that the session belongs to a verified member of an admin pool
+func (s *controlServiceServer) checkForAdmin(ctx context.Context) error {
 	sd, ok := s.sm.GetByContext(ctx)
 	if !ok {
-		return unused, fmt.Errorf("No session")
+		return fmt.Errorf("No session")
 	}
 	s.sm.UpdateExpirationByContext(ctx)
 	if sd.VerifiedAccount == "" {
-		return unused, fmt.Errorf("Not verified")
+		return fmt.Errorf("Not verified")
 	}
 
-	if sp := s.ctrl.GetStakePoolSet().Get(sd.VerifiedAccount); sp != nil && sp.Ticker() != "" {
+	if sp := s.ctrl.GetState().Member(sd.VerifiedAccount); sp != nil && sp.Ticker() != "" {
 		if _, isAdmin := s.adminPools[sp.Ticker()]; !isAdmin {
-			return unused, fmt.Errorf("Not an admin, not allowed")
+			return fmt.Errorf("Not an admin, not allowed")
 		}
 	} else {
-		return unused, fmt.Errorf("Not a member, not allowed")
+		return fmt.Errorf("Not a member, not allowed")
+	}
+	return nil
+}
+
+func (s *controlServiceServer) CheckAllPools(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
+	unused := connect.NewResponse(req.Msg)
+	if err := s.checkForAdmin(ctx); err != nil {
+		return unused, err
 	}
 
 	p := s.ctrl.GetPinger()
@@ -534,6 +583,293 @@ func (s *controlServiceServer) CheckAllPools(ctx context.Context, req *connect.R
 	return unused, nil
 }
 
+func (s *controlServiceServer) GetSheetDataQuality(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[SheetDataQualityReport], error) {
+	if err := s.checkForAdmin(ctx); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	report := s.ctrl.GetDataQualityReport()
+	res := &SheetDataQualityReport{
+		GeneratedAt: report.GeneratedAt.Format(time.RFC850),
+		Sheets:      make([]*SheetDataQuality, 0, len(report.Sheets)),
+	}
+	for _, sq := range report.Sheets {
+		sdq := &SheetDataQuality{
+			Sheet:          sq.Sheet,
+			UnmappedFields: sq.UnmappedFields,
+			Warnings:       sq.Warnings,
+			RowErrors:      make([]*SheetRowError, 0, len(sq.RowErrors)),
+			Discrepancies:  make([]*SheetRuleDiscrepancy, 0, len(sq.Discrepancies)),
+		}
+		for _, re := range sq.RowErrors {
+			sdq.RowErrors = append(sdq.RowErrors, &SheetRowError{
+				Sheet:   re.Sheet,
+				Row:     uint32(re.Row),
+				Column:  re.Column,
+				Field:   re.Field,
+				Ticker:  re.Ticker,
+				Value:   re.Value,
+				Message: re.Message,
+			})
+		}
+		for _, d := range sq.Discrepancies {
+			sdq.Discrepancies = append(sdq.Discrepancies, &SheetRuleDiscrepancy{
+				Sheet:       d.Sheet,
+				Row:         uint32(d.Row),
+				Field:       d.Field,
+				Ticker:      d.Ticker,
+				SheetValue:  d.SheetValue,
+				Computed:    d.Computed,
+				Explanation: d.Explanation,
+			})
+		}
+		res.Sheets = append(res.Sheets, sdq)
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (s *controlServiceServer) GetPendingSheetChanges(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[SheetCellChanges], error) {
+	if err := s.checkForAdmin(ctx); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	changes := s.ctrl.GetPendingSheetChanges()
+	res := &SheetCellChanges{Changes: make([]*SheetCellChange, 0, len(changes))}
+	for _, cc := range changes {
+		res.Changes = append(res.Changes, &SheetCellChange{
+			Sheet:  cc.Sheet,
+			Cell:   cc.Cell,
+			Field:  cc.Field,
+			Ticker: cc.Ticker,
+			Old:    cc.Old,
+			New:    cc.New,
+		})
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (s *controlServiceServer) GetComplianceReport(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[ComplianceReport], error) {
+	if err := s.checkForAdmin(ctx); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	report := s.ctrl.GetComplianceReport()
+	res := &ComplianceReport{
+		Epoch:    uint32(report.Epoch),
+		Findings: make([]*ComplianceFinding, 0, len(report.Findings)),
+	}
+	if !report.CheckedAt.IsZero() {
+		res.CheckedAt = report.CheckedAt.Format(time.RFC3339)
+	}
+	for _, f := range report.Findings {
+		cf := &ComplianceFinding{
+			Rule:         string(f.Rule),
+			Severity:     string(f.Severity),
+			Ticker:       f.Ticker,
+			StakeAddress: f.StakeAddr,
+			Expected:     f.Expected,
+			Actual:       f.Actual,
+			Message:      f.Message,
+			FirstSeen:    f.FirstSeen.Format(time.RFC3339),
+			LastSeen:     f.LastSeen.Format(time.RFC3339),
+			Epochs:       make([]uint32, 0, len(f.Epochs)),
+		}
+		for _, e := range f.Epochs {
+			cf.Epochs = append(cf.Epochs, uint32(e))
+		}
+		res.Findings = append(res.Findings, cf)
+	}
+	return connect.NewResponse(res), nil
+}
+
+func newWhatIfQueueEntry(e *f2lb_gsheet.ForecastEntry) *WhatIfQueueEntry {
+	return &WhatIfQueueEntry{
+		Queue:        e.Queue,
+		Position:     uint32(e.Position),
+		EpochGranted: uint32(e.EpochGranted),
+		StartEpoch:   uint32(e.StartEpoch),
+		EndEpoch:     uint32(e.EndEpoch),
+		StartTime:    e.StartTime.Format(time.RFC3339),
+		EndTime:      e.EndTime.Format(time.RFC3339),
+	}
+}
+
+func (s *controlServiceServer) SimulateDeclaration(ctx context.Context, req *connect.Request[WhatIfDeclaration]) (*connect.Response[WhatIfResult], error) {
+	r, err := s.ctrl.SimulateDeclaration(f2lb_gsheet.WhatIfDeclaration{
+		AdaDeclared: req.Msg.GetAdaDeclared(),
+		JoinEpoch:   utils.Epoch(req.Msg.GetJoinEpoch()),
+		Addon:       req.Msg.GetAddon(),
+	})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	res := &WhatIfResult{
+		EpochGranted: uint32(r.EpochGranted),
+		MainQueue:    newWhatIfQueueEntry(&r.MainQueue),
+	}
+	if r.AddonQueue != nil {
+		res.AddonQueue = newWhatIfQueueEntry(r.AddonQueue)
+	}
+	return connect.NewResponse(res), nil
+}
+
+func newJob(info scheduler.JobInfo) *Job {
+	job := &Job{
+		Name:     info.Name,
+		Schedule: info.Schedule,
+		Running:  info.Running,
+		History:  make([]*JobRun, 0, len(info.History)),
+	}
+	if !info.NextRun.IsZero() {
+		job.NextRun = info.NextRun.Format(time.RFC3339)
+	}
+	for _, r := range info.History {
+		job.History = append(job.History, &JobRun{
+			StartedAt:  r.StartedAt.Format(time.RFC3339),
+			DurationMs: uint64(r.Duration.Milliseconds()),
+			Epoch:      uint32(r.Epoch),
+			Slot:       uint32(r.Slot),
+			Manual:     r.Manual,
+			Error:      r.Error,
+		})
+	}
+	return job
+}
+
+func (s *controlServiceServer) ListJobs(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[Jobs], error) {
+	if err := s.checkForAdmin(ctx); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	infos := s.ctrl.GetScheduler().Jobs()
+	res := &Jobs{Jobs: make([]*Job, 0, len(infos))}
+	for _, info := range infos {
+		res.Jobs = append(res.Jobs, newJob(info))
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (s *controlServiceServer) TriggerJob(ctx context.Context, req *connect.Request[JobName]) (*connect.Response[Job], error) {
+	if err := s.checkForAdmin(ctx); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	info, err := s.ctrl.GetScheduler().Trigger(req.Msg.GetName())
+	if errors.Is(err, scheduler.JobNotFoundErr) {
+		return nil, connect.NewError(connect.CodeNotFound, err)
+	} else if err != nil {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, err)
+	}
+	return connect.NewResponse(newJob(info)), nil
+}
+
+func (s *controlServiceServer) GetKoiosStats(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[KoiosStats], error) {
+	if err := s.checkForAdmin(ctx); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	stats := s.ctrl.GetKoiosClient().Stats()
+	res := &KoiosStats{
+		Circuit:             stats.Circuit,
+		ConsecutiveFailures: stats.ConsecutiveFailures,
+		RateLimit:           stats.RateLimit,
+		Authenticated:       stats.Authenticated,
+		Tier:                stats.Tier,
+		PoolsChunkSize:      uint32(stats.PoolsChunkSize),
+		AccountsChunkSize:   uint32(stats.AccountsChunkSize),
+		Endpoints:           make([]*KoiosEndpointStats, 0, len(stats.Endpoints)),
+	}
+	if !stats.CircuitOpenedAt.IsZero() {
+		res.CircuitOpenedAt = stats.CircuitOpenedAt.Format(time.RFC3339)
+	}
+	for _, es := range stats.Endpoints {
+		kes := &KoiosEndpointStats{
+			Endpoint:     es.Endpoint,
+			Requests:     es.Requests,
+			Retries:      es.Retries,
+			Failures:     es.Failures,
+			RateLimited:  es.RateLimited,
+			LastStatus:   int32(es.LastStatus),
+			LastError:    es.LastError,
+			AvgLatencyMs: uint64(es.AvgLatency.Milliseconds()),
+		}
+		if !es.LastRequestAt.IsZero() {
+			kes.LastRequestAt = es.LastRequestAt.Format(time.RFC3339)
+		}
+		res.Endpoints = append(res.Endpoints, kes)
+	}
+	for _, cs := range stats.Cache {
+		res.Cache = append(res.Cache, &KoiosCacheStats{
+			Endpoint:  cs.Endpoint,
+			Hits:      cs.Hits,
+			Misses:    cs.Misses,
+			Coalesced: cs.Coalesced,
+			Entries:   uint32(cs.Entries),
+		})
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (s *controlServiceServer) AdvanceClock(ctx context.Context, req *connect.Request[ClockAdvance]) (*connect.Response[ClockStatus], error) {
+	if err := s.checkForAdmin(ctx); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	sc, ok := utils.GetSimulatedClock()
+	if !ok {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("the clock is not simulated"))
+	}
+	var err error
+	if e := req.Msg.GetToEpoch(); e > 0 {
+		_, err = sc.AdvanceToEpoch(utils.Epoch(e))
+	} else {
+		_, err = sc.Advance(time.Duration(req.Msg.GetSeconds()) * time.Second)
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	now := utils.Now()
+	return connect.NewResponse(&ClockStatus{
+		Now:       now.Format(time.RFC3339),
+		Epoch:     uint32(utils.TimeToEpoch(now)),
+		Slot:      uint32(utils.TimeToSlot(now)),
+		Simulated: true,
+	}), nil
+}
+
+func (s *controlServiceServer) getPoolHints() *PoolHints {
+	hints := s.ctrl.GetPoolsHints()
+	res := &PoolHints{Hints: make([]*PoolHint, 0, len(hints)), Errors: s.ctrl.GetPoolsHintsErrors()}
+	for _, h := range hints {
+		res.Hints = append(res.Hints, &PoolHint{
+			Ticker:       h.Ticker,
+			PoolIdBech32: h.PoolIdBech32,
+			Conflict:     h.Conflict,
+		})
+	}
+	return res
+}
+
+func (s *controlServiceServer) ListPoolHints(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[PoolHints], error) {
+	if err := s.checkForAdmin(ctx); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	return connect.NewResponse(s.getPoolHints()), nil
+}
+
+func (s *controlServiceServer) SetPoolHint(ctx context.Context, req *connect.Request[PoolHint]) (*connect.Response[PoolHints], error) {
+	if err := s.checkForAdmin(ctx); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	if err := s.ctrl.SetPoolHint(req.Msg.GetTicker(), req.Msg.GetPoolIdBech32()); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(s.getPoolHints()), nil
+}
+
+func (s *controlServiceServer) DeletePoolHint(ctx context.Context, req *connect.Request[PoolTicker]) (*connect.Response[PoolHints], error) {
+	if err := s.checkForAdmin(ctx); err != nil {
+		return nil, connect.NewError(connect.CodePermissionDenied, err)
+	}
+	if err := s.ctrl.DeletePoolHint(req.Msg.GetTicker()); err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, err)
+	}
+	return connect.NewResponse(s.getPoolHints()), nil
+}
+
 func (s *controlServiceServer) GetPoolStats(ctx context.Context, req *connect.Request[PoolTicker]) (*connect.Response[PoolStats], error) {
 	pt := req.Msg
 	s.sm.UpdateExpirationByContext(ctx)
@@ -647,7 +983,7 @@ func (s *controlServiceServer) WhoAmI(ctx context.Context, _ *connect.Request[em
 			if sd.MemberAccount == "" {
 				return connect.NewResponse(user), nil
 			}
-			if sp := s.ctrl.GetStakePoolSet().Get(sd.MemberAccount); sp != nil && sp.Ticker() != "" {
+			if sp := s.ctrl.GetState().Member(sd.MemberAccount); sp != nil && sp.Ticker() != "" {
 				// check admin permission
 				_, isAdmin := s.adminPools[sp.Ticker()]
 				user.Type = User_SPO
@@ -662,19 +998,17 @@ func (s *controlServiceServer) WhoAmI(ctx context.Context, _ *connect.Request[em
 	return connect.NewResponse(user), nil
 }
 
-func getNonce(ctx context.Context, kc *koios.Client, delta int) (string, error) {
-	epoch := koios.EpochNo(int(utils.CurrentEpoch()) - 1)
-	opts := kc.NewRequestOptions()
-	opts.QuerySet("select", "nonce")
-	r, err := kc.GetEpochParams(ctx, epoch, opts)
+// getNonce always returns the nonce of the previous epoch, delta is ignored as it has always been
+func getNonce(kc *koiosutils.CachedClient, delta int) (string, error) {
+	nonce, err := kc.GetEpochNonce(utils.CurrentEpoch() - 1)
 	if err != nil {
 		return "", fmt.Errorf("Error getting info from koios: %w\n", err)
 	}
-	return r.Data[0].Nonce, nil
+	return nonce, nil
 }
 
 func (s *controlServiceServer) NoncePrev(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[wrapperspb.StringValue], error) {
-	nonce, err := getNonce(ctx, s.ctrl.GetKoiosClient().GetKoiosClient(), -1)
+	nonce, err := getNonce(s.ctrl.GetKoiosClient().Cached(), -1)
 	if err != nil {
 		return nil, err
 	}
@@ -682,45 +1016,70 @@ func (s *controlServiceServer) NoncePrev(ctx context.Context, _ *connect.Request
 }
 
 func (s *controlServiceServer) NonceCurrent(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[wrapperspb.StringValue], error) {
-	nonce, err := getNonce(ctx, s.ctrl.GetKoiosClient().GetKoiosClient(), 0)
+	nonce, err := getNonce(s.ctrl.GetKoiosClient().Cached(), 0)
 	if err != nil {
 		return nil, err
 	}
 	return connect.NewResponse(wrapperspb.String(nonce)), nil
 }
 
-const slotInEpochForNextNonce = 302400
-
 func (s *controlServiceServer) NonceNext(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[wrapperspb.StringValue], error) {
 	curSlotInEpoch := int(utils.CurrentSlotInEpoch())
-	if curSlotInEpoch < slotInEpochForNextNonce {
+	if curSlotInEpoch < int(scheduler.NonceStabilitySlot) {
 		return nil, fmt.Errorf("New epoch nonce not yet computable, will be available in %v",
-			time.Duration(time.Second*time.Duration(slotInEpochForNextNonce-curSlotInEpoch)))
+			time.Duration(time.Second*time.Duration(int(scheduler.NonceStabilitySlot)-curSlotInEpoch)))
 	}
+	nonce, err := s.getNextNonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(wrapperspb.String(nonce)), nil
+}
+
+// NextNonceJobName is the job computing the nonce of the next epoch as soon as it is stable
+const NextNonceJobName = "api-v2-next-nonce"
+
+// getNextNonce computes the nonce of the next epoch once per epoch, it has to be called after the stability slot
+func (s *controlServiceServer) getNextNonce(ctx context.Context) (string, error) {
+	epoch := utils.CurrentEpoch()
+	s.nextNonceMu.Lock()
+	defer s.nextNonceMu.Unlock()
+	if s.nextNonceEpoch == epoch && s.nextNonce != "" {
+		return s.nextNonce, nil
+	}
+	nonce, err := computeNextNonce(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.nextNonceEpoch, s.nextNonce = epoch, nonce
+	return nonce, nil
+}
+
+func computeNextNonce(ctx context.Context) (string, error) {
 	out, err := ccli.DoCommand(ctx, "query protocol-state --mainnet")
 	if err != nil {
-		return nil, fmt.Errorf("Error getting info from cardano-node: %w", err)
+		return "", fmt.Errorf("Error getting info from cardano-node: %w", err)
 	}
 	res := map[string]any{}
 	err = json.Unmarshal([]byte(out), &res)
 	if err != nil {
-		return nil, fmt.Errorf("Error decoding info from cardano-node: %w", err)
+		return "", fmt.Errorf("Error decoding info from cardano-node: %w", err)
 	}
 	cn := res["candidateNonce"].(map[string]any)["contents"].(string)
 	lebn := res["lastEpochBlockNonce"].(map[string]any)["contents"].(string)
 	eta0_, err := hex.DecodeString(cn + lebn)
 	if err != nil {
-		return nil, fmt.Errorf("Error decoding info from cardano-node: %w", err)
+		return "", fmt.Errorf("Error decoding info from cardano-node: %w", err)
 	}
 	hash, err := blake2b.New(32, nil)
 	if err != nil {
-		return nil, fmt.Errorf("Error computing next nonce: %w", err)
+		return "", fmt.Errorf("Error computing next nonce: %w", err)
 	}
 	_, err = hash.Write(eta0_)
 	if err != nil {
-		return nil, fmt.Errorf("Error computing next nonce: %w", err)
+		return "", fmt.Errorf("Error computing next nonce: %w", err)
 	}
-	return connect.NewResponse(wrapperspb.String(hex.EncodeToString(hash.Sum(nil)))), nil
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 type sigType string
@@ -934,7 +1293,7 @@ func (s *controlServiceServer) ReportTip(ctx context.Context, req *connect.Reque
 		}
 		vkhHex := hex.EncodeToString(vkh)
 		// we need this to verify stake key hash
-		sp := s.ctrl.GetStakePoolSet().Get(pool.Ticker())
+		sp := s.ctrl.GetState().Member(pool.Ticker())
 		if sp.MainStakeKey() != vkhHex {
 			return nil, fmt.Errorf("provided public key is not matching member stake key, details: " +
 				fmt.Sprintf("%s != %s (stake key hash)", vkhHex, sp.MainStakeKey()))
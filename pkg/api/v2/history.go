@@ -0,0 +1,90 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"connectrpc.com/connect"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/safanaj/go-f2lb/pkg/f2lb_gsheet"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+var ErrHistoryDisabled = errors.New("History is not available, caches store path is not set")
+
+type historyServiceServer struct {
+	UnimplementedHistoryServiceHandler
+	history *f2lb_gsheet.History
+}
+
+func NewHistoryServiceServer(h *f2lb_gsheet.History) HistoryServiceHandler {
+	return &historyServiceServer{history: h}
+}
+
+func newHistoryQueueEntries(entries []f2lb_gsheet.HistoryQueueEntry) []*HistoryQueueEntry {
+	res := make([]*HistoryQueueEntry, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, &HistoryQueueEntry{
+			Ticker:       e.Ticker,
+			PoolIdBech32: e.PoolIdBech32,
+			StakeAddr:    e.StakeAddr,
+			AdaDeclared:  uint32(e.AD),
+			EpochGranted: uint32(e.EG),
+			Qpp:          uint32(e.QPP),
+		})
+	}
+	return res
+}
+
+func (s *historyServiceServer) Epochs(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[HistoryEpochs], error) {
+	if s.history == nil {
+		return nil, connect.NewError(connect.CodeUnavailable, ErrHistoryDisabled)
+	}
+	epochs := []uint32{}
+	for _, e := range s.history.Epochs() {
+		epochs = append(epochs, uint32(e))
+	}
+	return connect.NewResponse(&HistoryEpochs{Epochs: epochs}), nil
+}
+
+func (s *historyServiceServer) QueuesAsOfEpoch(ctx context.Context, req *connect.Request[HistoryEpoch]) (*connect.Response[EpochSnapshot], error) {
+	if s.history == nil {
+		return nil, connect.NewError(connect.CodeUnavailable, ErrHistoryDisabled)
+	}
+	snap, err := s.history.AsOfEpoch(utils.Epoch(req.Msg.GetEpoch()))
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, err)
+	}
+	return connect.NewResponse(&EpochSnapshot{
+		Epoch:                        uint32(snap.Epoch),
+		Time:                         snap.Time.Format(time.RFC850),
+		MainQueue:                    newHistoryQueueEntries(snap.MainQueue),
+		AddonQueue:                   newHistoryQueueEntries(snap.AddonQueue),
+		MainQueueServed:              snap.MainQueueServed,
+		AddonQueueServed:             snap.AddonQueueServed,
+		DelegCycleEpoch:              snap.DelegCycleEpoch,
+		ActiveTicker:                 snap.ActiveTicker,
+		TopTicker:                    snap.TopTicker,
+		TopRemainingEpochs:           snap.TopRemainingEpochs,
+		AddonQueueTopTicker:          snap.AddonQueueTopTicker,
+		AddonQueueTopRemainingEpochs: snap.AddonQueueTopRemainingEpochs,
+	}), nil
+}
+
+func (s *historyServiceServer) ServedEpochs(ctx context.Context, req *connect.Request[HistoryTicker]) (*connect.Response[TickerServedEpochs], error) {
+	if s.history == nil {
+		return nil, connect.NewError(connect.CodeUnavailable, ErrHistoryDisabled)
+	}
+	served := &TickerServedEpochs{Ticker: req.Msg.GetTicker(), Epochs: []*ServedEpoch{}}
+	for _, se := range s.history.ServedEpochs(req.Msg.GetTicker()) {
+		served.Epochs = append(served.Epochs, &ServedEpoch{
+			Epoch:            uint32(se.Epoch),
+			Active:           se.Active,
+			MainQueueServed:  se.MainQueueServed,
+			AddonQueueServed: se.AddonQueueServed,
+		})
+	}
+	return connect.NewResponse(served), nil
+}
@@ -2,65 +2,44 @@ package v2
 
 import (
 	"context"
-	"slices"
 
 	connect "connectrpc.com/connect"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/safanaj/go-f2lb/pkg/f2lb_gsheet"
-	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
 )
 
 type mainQueueServiceServer struct {
 	UnimplementedMainQueueServiceHandler
-	queue *f2lb_gsheet.MainQueue
-	sps   f2lb_members.StakePoolSet
+	sg f2lb_gsheet.StateGetter
 }
 
-func NewMainQueueServiceServer(q *f2lb_gsheet.MainQueue, sps f2lb_members.StakePoolSet) MainQueueServiceHandler {
-	return &mainQueueServiceServer{queue: q, sps: sps}
+func NewMainQueueServiceServer(sg f2lb_gsheet.StateGetter) MainQueueServiceHandler {
+	return &mainQueueServiceServer{sg: sg}
 }
 
 func (a *mainQueueServiceServer) Records(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[Members], error) {
-	var err error
-	return connect.NewResponse(&Members{
-		Members: slices.Collect(func(yield func(*Member) bool) {
-			for _, sp := range a.sps.StakePools() {
-				if !yield(newMemeberFromStakePool(sp)) {
-					err = ErrOutOfMemoryWTF
-					return
-				}
-			}
-		}),
-	}), err
+	st := a.sg.GetState()
+	res := connect.NewResponse(&Members{Members: newMembersFromStakePools(st.Members)})
+	res.Header().Set("ETag", st.ETag())
+	return res, nil
 }
 
 func (a *mainQueueServiceServer) ListQueue(ctx context.Context, _ *connect.Request[emptypb.Empty]) (*connect.Response[Members], error) {
-	var err error
-
-	members := slices.Collect(func(yield func(*Member) bool) {
-		for _, t := range a.queue.GetOrdered() {
-			if sp := a.sps.Get(t); sp != nil {
-				if !yield(newMemeberFromStakePool(sp)) {
-					err = ErrOutOfMemoryWTF
-					return
-				}
-			}
-		}
-	})
-
-	return connect.NewResponse(&Members{
-		Members: members,
-	}), err
+	st := a.sg.GetState()
+	res := connect.NewResponse(&Members{Members: newMembersFromStakePools(st.MembersOf(st.MainQueue))})
+	res.Header().Set("ETag", st.ETag())
+	return res, nil
 }
 
 func (a *mainQueueServiceServer) Served(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[Member], error) {
-	for _, t := range a.queue.GetOrdered() {
-		if sp := a.sps.Get(t); sp != nil {
-			return connect.NewResponse(newMemeberFromStakePool(sp)), nil
+	st := a.sg.GetState()
+	res := connect.NewResponse(&Member{})
+	if len(st.MainQueue) > 0 {
+		if sp := st.Member(st.MainQueue[0]); sp != nil {
+			res = connect.NewResponse(newMemeberFromStakePool(sp))
 		}
-		break
 	}
-
-	return connect.NewResponse(&Member{}), nil
+	res.Header().Set("ETag", st.ETag())
+	return res, nil
 }
@@ -2,7 +2,6 @@ package v2
 
 import (
 	"context"
-	"slices"
 	"time"
 
 	connect "connectrpc.com/connect"
@@ -12,14 +11,14 @@ import (
 	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
 )
 
-func newMemeberFromStakePool(sp f2lb_members.StakePool) *Member {
+func newMemeberFromStakePool(sp f2lb_members.StakePoolView) *Member {
 	return &Member{
 		DiscordId:                 sp.DiscordName(),
 		Ticker:                    sp.Ticker(),
 		StakeKey:                  sp.MainStakeKey(),
 		StakeAddr:                 sp.MainStakeAddress(),
-		AdaDeclared:               uint32(sp.AdaDeclared()),
-		AdaDelegated:              uint32(sp.AdaDelegated()),
+		AdaDeclared:               sp.AdaDeclared().AdaUint32(),
+		AdaDelegated:              sp.AdaDelegated().AdaUint32(),
 		EpochGranted:              uint32(sp.EpochGranted()),
 		PoolIdHex:                 sp.PoolIdHex(),
 		PoolIdBech32:              sp.PoolIdBech32(),
@@ -31,47 +30,115 @@ func newMemeberFromStakePool(sp f2lb_members.StakePool) *Member {
 		EpochGrantedOnAddonQueue:  uint32(sp.EpochGrantedOnAddonQueue()),
 		StartingEpochOnAddonQueue: uint32(sp.StartingEpochOnAddonQueue()),
 		StartingTimeOnAddonQueue:  sp.StartingTimeOnAddonQueue().Format(time.RFC850),
-		ActiveStake:               sp.ActiveStake(),
-		LiveStake:                 sp.LiveStake(),
+		ActiveStake:               sp.ActiveStake().AdaUint32(),
+		LiveStake:                 sp.LiveStake().AdaUint32(),
 		LiveDelegators:            sp.LiveDelegators(),
 		BlockHeight:               sp.BlockHeight(),
+		AdaDeclaredLovelace:       uint64(sp.AdaDeclared()),
+		AdaDelegatedLovelace:      uint64(sp.AdaDelegated()),
+		ActiveStakeLovelace:       uint64(sp.ActiveStake()),
+		LiveStakeLovelace:         uint64(sp.LiveStake()),
+		TotalAdaDelegatedLovelace: uint64(sp.TotalAdaDelegated()),
+		DelegationStatus:          sp.DelegationStatus(),
+		StakeAddresses:            newMemberStakeAddresses(sp.StakeAddressesInfo()),
 	}
 }
 
+func newMembersFromStakePools(sps []f2lb_members.StakePoolView) []*Member {
+	res := make([]*Member, 0, len(sps))
+	for _, sp := range sps {
+		res = append(res, newMemeberFromStakePool(sp))
+	}
+	return res
+}
+
+func newMemberStakeAddresses(infos []f2lb_members.StakeAddressInfo) []*MemberStakeAddress {
+	res := make([]*MemberStakeAddress, 0, len(infos))
+	for _, i := range infos {
+		res = append(res, &MemberStakeAddress{
+			StakeAddress:   i.StakeAddress,
+			DelegatedPool:  i.DelegatedPool,
+			AmountLovelace: uint64(i.Amount),
+			Status:         i.Status,
+		})
+	}
+	return res
+}
+
+func newForecastEntries(entries []f2lb_gsheet.ForecastEntry) []*ForecastEntry {
+	res := make([]*ForecastEntry, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, &ForecastEntry{
+			Queue:        e.Queue,
+			Position:     uint32(e.Position),
+			Ticker:       e.Ticker,
+			StakeAddr:    e.StakeAddr,
+			PoolIdBech32: e.PoolIdBech32,
+			EpochGranted: uint32(e.EpochGranted),
+			StartEpoch:   uint32(e.StartEpoch),
+			EndEpoch:     uint32(e.EndEpoch),
+			StartTime:    e.StartTime.Format(time.RFC850),
+			EndTime:      e.EndTime.Format(time.RFC850),
+		})
+	}
+	return res
+}
+
 type memberServiceServer struct {
 	UnimplementedMemberServiceHandler
+	sg         f2lb_gsheet.StateGetter
 	delegCycle *f2lb_gsheet.DelegationCycle
-	sps        f2lb_members.StakePoolSet
+	mainQueue  *f2lb_gsheet.MainQueue
+	addonQueue *f2lb_gsheet.AddonQueue
 }
 
-func NewMemberServiceServer(d *f2lb_gsheet.DelegationCycle, sps f2lb_members.StakePoolSet) MemberServiceHandler {
-	return &memberServiceServer{delegCycle: d, sps: sps}
+func NewMemberServiceServer(sg f2lb_gsheet.StateGetter, d *f2lb_gsheet.DelegationCycle, mq *f2lb_gsheet.MainQueue, aq *f2lb_gsheet.AddonQueue) MemberServiceHandler {
+	return &memberServiceServer{sg: sg, delegCycle: d, mainQueue: mq, addonQueue: aq}
 }
 
 func (a *memberServiceServer) Active(context.Context, *connect.Request[emptypb.Empty]) (*connect.Response[Member], error) {
-	if sp := a.sps.Get(a.delegCycle.GetActiveTicker()); sp != nil {
-		return connect.NewResponse(newMemeberFromStakePool(sp)), nil
+	st := a.sg.GetState()
+	res := connect.NewResponse(&Member{})
+	if sp := st.Member(st.DelegationCycle.ActiveTicker); sp != nil {
+		res = connect.NewResponse(newMemeberFromStakePool(sp))
 	}
-	return connect.NewResponse(&Member{}), nil
+	res.Header().Set("ETag", st.ETag())
+	return res, nil
 }
 
 func (a *memberServiceServer) Top(context.Context, *connect.Request[emptypb.Empty]) (*connect.Response[Member], error) {
-	if sp := a.sps.Get(a.delegCycle.GetTopTicker()); sp != nil {
-		return connect.NewResponse(newMemeberFromStakePool(sp)), nil
+	st := a.sg.GetState()
+	res := connect.NewResponse(&Member{})
+	if sp := st.Member(st.DelegationCycle.TopTicker); sp != nil {
+		res = connect.NewResponse(newMemeberFromStakePool(sp))
 	}
-	return connect.NewResponse(&Member{}), nil
+	res.Header().Set("ETag", st.ETag())
+	return res, nil
 }
 
 func (a *memberServiceServer) List(context.Context, *connect.Request[emptypb.Empty]) (*connect.Response[Members], error) {
-	var err error
-	return connect.NewResponse(&Members{
-		Members: slices.Collect(func(yield func(*Member) bool) {
-			for _, sp := range a.sps.StakePools() {
-				if !yield(newMemeberFromStakePool(sp)) {
-					err = ErrOutOfMemoryWTF
-					return
-				}
+	st := a.sg.GetState()
+	res := connect.NewResponse(&Members{Members: newMembersFromStakePools(st.Members)})
+	res.Header().Set("ETag", st.ETag())
+	return res, nil
+}
+
+func (a *memberServiceServer) Forecast(ctx context.Context, req *connect.Request[ForecastRequest]) (*connect.Response[DelegationForecast], error) {
+	f := f2lb_gsheet.NewDelegationForecast(a.mainQueue, a.addonQueue, a.delegCycle)
+	if ticker := req.Msg.GetTicker(); ticker != "" {
+		res := &DelegationForecast{Epoch: uint32(f.Epoch), MainQueue: []*ForecastEntry{}, AddonQueue: []*ForecastEntry{}}
+		for _, e := range newForecastEntries(f.ForTicker(ticker)) {
+			if e.Queue == f2lb_gsheet.AddonQueueName {
+				res.AddonQueue = append(res.AddonQueue, e)
+			} else {
+				res.MainQueue = append(res.MainQueue, e)
 			}
-		}),
-	}), err
+		}
+		return connect.NewResponse(res), nil
+	}
+	return connect.NewResponse(&DelegationForecast{
+		Epoch:      uint32(f.Epoch),
+		MainQueue:  newForecastEntries(f.MainQueue),
+		AddonQueue: newForecastEntries(f.AddonQueue),
+	}), nil
 }
@@ -0,0 +1,38 @@
+package accountcache
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+func TestMigrateStoreV1(t *testing.T) {
+	// the lines of the gob encoder: the amounts in ADA, an undelegated account has an empty pool
+	legacy := "stake1delegated pool1abc 1500\n" +
+		"stake1undelegated  20\n" +
+		"malformed line here\n" +
+		"\n"
+	dat, err := migrateStoreV1(logr.Discard())([]byte(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(dat), []byte{'\n'})
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 accounts, the malformed line skipped, got %q", dat)
+	}
+	for i, want := range []accountInfo{
+		{stakeAddress: "stake1delegated", delegatedPoolIdBech32: "pool1abc", adaAmount: utils.AdaToLovelace(1500)},
+		{stakeAddress: "stake1undelegated", adaAmount: utils.AdaToLovelace(20)},
+	} {
+		ai, err := decodeAccountInfo(lines[i])
+		if err != nil {
+			t.Fatalf("line %q: %v", lines[i], err)
+		}
+		if *ai != want {
+			t.Errorf("got %+v, want %+v", *ai, want)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 	// "time"
 
 	"github.com/blockfrost/blockfrost-go"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
 )
 
 type BlockFrostClient struct {
@@ -38,7 +40,7 @@ func New(ctx context.Context) *BlockFrostClient {
 func (c *BlockFrostClient) GetBlockFrostClient() blockfrost.APIClient { return c.api }
 func (c *BlockFrostClient) GetContext() context.Context               { return c.ctx }
 
-func (c *BlockFrostClient) GetStakeAddressInfo(stakeAddr string) (delegatedPool string, totalAda uint32, err error) {
+func (c *BlockFrostClient) GetStakeAddressInfo(stakeAddr string) (delegatedPool string, totalBalance utils.Lovelace, err error) {
 	account, err := c.api.Account(c.ctx, stakeAddr)
 	if err != nil {
 		return "", 0, err
@@ -48,6 +50,6 @@ func (c *BlockFrostClient) GetStakeAddressInfo(stakeAddr string) (delegatedPool
 		return "", 0, err
 	}
 	delegatedPool = account.PoolID
-	totalAda = uint32(amount / 1e6)
+	totalBalance = utils.Lovelace(amount)
 	return
 }
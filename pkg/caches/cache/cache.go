@@ -0,0 +1,655 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/caches/cachestore"
+	"github.com/safanaj/go-f2lb/pkg/logging"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// NotFoundErr is returned by FetchNow for the keys unknown to the backend
+var NotFoundErr = errors.New("not found")
+
+// Value is what the cache stores, it is stamped when stored and persisted in its binary form
+type Value interface {
+	encoding.BinaryMarshaler
+	LastUpdated() time.Time
+	SetLastUpdated(time.Time)
+}
+
+// Fetcher gets the values of a batch of keys, the keys missing from the returned map are unknown to the backend
+type Fetcher[K comparable, V Value] func(keys []K) (map[K]V, error)
+
+type Options[K comparable, V Value] struct {
+	Context context.Context
+	Fetch   Fetcher[K, V]
+	// KeyOf is the key of the value in the cache, the fetcher can return it for another key (like an alias)
+	KeyOf func(V) K
+	// Aliases are the other keys to get the value with
+	Aliases func(V) []K
+	// Merge combines the fetched value with the cached one, to keep the fields the backend did not return
+	Merge func(old, new V) V
+
+	Workers uint32
+	// BatchSize is the maximum number of keys in a fetch, a smaller batch is fetched
+	// once no key was queued for WorkersInterval
+	BatchSize       uint32
+	WorkersInterval time.Duration
+	// RefreshInterval is the age of the entries to fetch again, 0 disables the refresh of the stale entries
+	RefreshInterval time.Duration
+
+	Store  *cachestore.Store
+	Decode func([]byte) (V, error)
+}
+
+// Stats are the counters of a cache, PriorityFetches are the fetches of the on-demand keys
+type Stats struct {
+	Entries         int           `json:"entries"`
+	Pending         int           `json:"pending"`
+	Missing         int           `json:"missing"`
+	Stale           int           `json:"stale"`
+	Fetches         uint64        `json:"fetches"`
+	PriorityFetches uint64        `json:"priority_fetches"`
+	FetchErrors     uint64        `json:"fetch_errors"`
+	FetchedKeys     uint64        `json:"fetched_keys"`
+	LastFetchAt     time.Time     `json:"last_fetch_at"`
+	LastError       string        `json:"last_error,omitempty"`
+	AvgFetchLatency time.Duration `json:"avg_fetch_latency"`
+
+	totalLatency time.Duration
+}
+
+type Cache[K comparable, V Value] struct {
+	logging.Logger
+	opts Options[K, V]
+
+	mu      sync.RWMutex
+	running bool
+	entries map[K]V
+	aliases map[K]K
+	// added and not fetched yet
+	pending map[K]struct{}
+	// unknown to the backend at the last fetch, with the time of that fetch
+	missing map[K]time.Time
+	// in the queues or being fetched, true for the priority ones
+	queued      map[K]bool
+	queue       []K
+	prio        []K
+	lastQueueAt time.Time
+	waiters     []chan struct{}
+	stats       Stats
+
+	wakeCh            chan struct{}
+	workersWg         sync.WaitGroup
+	ctxCancel         func()
+	refresherFinished chan struct{}
+}
+
+func New[K comparable, V Value](logger logging.Logger, opts Options[K, V]) *Cache[K, V] {
+	c, _ := (&Cache[K, V]{Logger: logger}).WithOptions(opts)
+	return c
+}
+
+// WithOptions returns a cache with the entries of this one and the ones loaded from the store
+func (c *Cache[K, V]) WithOptions(opts Options[K, V]) (*Cache[K, V], error) {
+	if c.IsRunning() {
+		return nil, fmt.Errorf("Cache is running")
+	}
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+	if opts.Workers == 0 {
+		opts.Workers = 1
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 1
+	}
+	if opts.WorkersInterval <= 0 {
+		opts.WorkersInterval = time.Second
+	}
+	newCache := &Cache[K, V]{
+		Logger:  c.Logger,
+		opts:    opts,
+		entries: make(map[K]V),
+		aliases: make(map[K]K),
+		pending: make(map[K]struct{}),
+		missing: make(map[K]time.Time),
+		queued:  make(map[K]bool),
+	}
+	c.mu.RLock()
+	for _, v := range c.entries {
+		newCache.set(v)
+	}
+	c.mu.RUnlock()
+	newCache.maybeLoadFromDisk()
+	return newCache, nil
+}
+
+// lookup finds the entry by key or alias, the lock is held by the caller
+func (c *Cache[K, V]) lookup(k K) (V, bool) {
+	if v, ok := c.entries[k]; ok {
+		return v, true
+	}
+	if key, ok := c.aliases[k]; ok {
+		v, ok := c.entries[key]
+		return v, ok
+	}
+	var zero V
+	return zero, false
+}
+
+func (c *Cache[K, V]) set(v V) {
+	k := c.opts.KeyOf(v)
+	if old, ok := c.entries[k]; ok {
+		c.unset(k, old)
+	}
+	c.entries[k] = v
+	if c.opts.Aliases != nil {
+		for _, a := range c.opts.Aliases(v) {
+			if a != k {
+				c.aliases[a] = k
+			}
+		}
+	}
+}
+
+func (c *Cache[K, V]) unset(k K, v V) {
+	delete(c.entries, k)
+	if c.opts.Aliases != nil {
+		for _, a := range c.opts.Aliases(v) {
+			if key, ok := c.aliases[a]; ok && key == k {
+				delete(c.aliases, a)
+			}
+		}
+	}
+}
+
+// notifyWaiters wakes up WaitReady once nothing is pending, the lock is held by the caller
+func (c *Cache[K, V]) notifyWaiters() {
+	if len(c.pending) > 0 {
+		return
+	}
+	for _, ch := range c.waiters {
+		close(ch)
+	}
+	c.waiters = nil
+}
+
+// enqueue adds the key to the queue of the workers, a key already queued is only moved
+// to the priority queue when asked with priority. The lock is held by the caller
+func (c *Cache[K, V]) enqueue(k K, priority bool) {
+	prio, ok := c.queued[k]
+	if ok && (prio || !priority) {
+		return
+	}
+	if ok {
+		// it is not in the batch queue anymore if a worker is fetching it
+		c.queue = slices.DeleteFunc(c.queue, func(q K) bool { return q == k })
+	}
+	c.queued[k] = priority
+	if priority {
+		c.prio = append(c.prio, k)
+		return
+	}
+	c.queue = append(c.queue, k)
+	c.lastQueueAt = time.Now()
+}
+
+func (c *Cache[K, V]) wake() {
+	select {
+	case c.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Cache[K, V]) add(priority bool, keys []K) {
+	c.mu.Lock()
+	if !c.running {
+		c.mu.Unlock()
+		return
+	}
+	for _, k := range keys {
+		if _, ok := c.lookup(k); !ok {
+			c.pending[k] = struct{}{}
+		}
+		c.enqueue(k, priority)
+	}
+	c.mu.Unlock()
+	c.wake()
+}
+
+// Add queues the keys to be fetched in a batch, the ones already cached are fetched again
+func (c *Cache[K, V]) Add(keys ...K) { c.add(false, keys) }
+
+// AddNow queues the keys to be fetched by the first free worker, without waiting for a batch
+func (c *Cache[K, V]) AddNow(keys ...K) { c.add(true, keys) }
+
+// FetchNow fetches the keys and stores the values before returning, NotFoundErr is returned
+// for the keys unknown to the backend
+func (c *Cache[K, V]) FetchNow(keys ...K) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("Cache is not running")
+	}
+	start := time.Now()
+	values, err := c.opts.Fetch(keys)
+	c.apply(keys, values, err, true, time.Since(start))
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, ok := values[k]; !ok {
+			return fmt.Errorf("%v %w", k, NotFoundErr)
+		}
+	}
+	return nil
+}
+
+func (c *Cache[K, V]) Del(keys ...K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running {
+		return
+	}
+	for _, k := range keys {
+		if v, ok := c.lookup(k); ok {
+			c.unset(c.opts.KeyOf(v), v)
+		}
+		delete(c.pending, k)
+		delete(c.missing, k)
+	}
+	c.notifyWaiters()
+}
+
+func (c *Cache[K, V]) Get(k K) (V, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lookup(k)
+}
+
+// Range calls f on a snapshot of the entries until it returns false
+func (c *Cache[K, V]) Range(f func(K, V) bool) {
+	c.mu.RLock()
+	entries := make(map[K]V, len(c.entries))
+	for k, v := range c.entries {
+		entries[k] = v
+	}
+	c.mu.RUnlock()
+	for k, v := range entries {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// Missing are the keys unknown to the backend at their last fetch
+func (c *Cache[K, V]) Missing() []K {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	missing := make([]K, 0, len(c.missing))
+	for k := range c.missing {
+		missing = append(missing, k)
+	}
+	return missing
+}
+
+func (c *Cache[K, V]) IsMissing(k K) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.missing[k]
+	return ok
+}
+
+func (c *Cache[K, V]) Len() uint32 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return uint32(len(c.entries))
+}
+
+func (c *Cache[K, V]) Pending() uint32 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return uint32(len(c.pending))
+}
+
+func (c *Cache[K, V]) AddedItems() uint32 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return uint32(len(c.entries) + len(c.pending))
+}
+
+func (c *Cache[K, V]) IsRunning() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.running
+}
+
+// ResetCounts forgets the pending keys, they are still fetched if queued
+func (c *Cache[K, V]) ResetCounts() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = make(map[K]struct{})
+	c.notifyWaiters()
+}
+
+func (c *Cache[K, V]) Ready() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.V(5).Info("Cache.Status", "ready", len(c.pending) == 0, "nitems", len(c.entries), "pending", len(c.pending))
+	return len(c.pending) == 0
+}
+
+func (c *Cache[K, V]) WaitReady(d time.Duration) bool {
+	c.mu.Lock()
+	if !c.running {
+		c.mu.Unlock()
+		return false
+	}
+	if len(c.pending) == 0 {
+		c.mu.Unlock()
+		return true
+	}
+	ch := make(chan struct{})
+	c.waiters = append(c.waiters, ch)
+	c.mu.Unlock()
+
+	select {
+	case <-ch:
+	case <-time.After(d):
+	}
+	return c.Ready()
+}
+
+// Refresh queues all the entries to be fetched again
+func (c *Cache[K, V]) Refresh() {
+	c.mu.Lock()
+	if !c.running {
+		c.mu.Unlock()
+		return
+	}
+	for k := range c.entries {
+		c.enqueue(k, false)
+	}
+	c.mu.Unlock()
+	c.wake()
+}
+
+// refreshStale queues the entries older than the refresh interval and the pending keys
+// whose fetch failed, the keys missing at the last fetch wait for the refresh interval too
+func (c *Cache[K, V]) refreshStale() {
+	now := utils.Now()
+	c.mu.Lock()
+	if c.opts.RefreshInterval > 0 {
+		for k, v := range c.entries {
+			if now.Sub(v.LastUpdated()) < c.opts.RefreshInterval {
+				continue
+			}
+			if at, ok := c.missing[k]; ok && now.Sub(at) < c.opts.RefreshInterval {
+				continue
+			}
+			c.enqueue(k, false)
+		}
+	}
+	for k := range c.pending {
+		c.enqueue(k, false)
+	}
+	c.mu.Unlock()
+	c.wake()
+}
+
+// nextBatch takes the priority keys first, then a full batch or the queued keys
+// once the queue did not grow for the workers interval
+func (c *Cache[K, V]) nextBatch() ([]K, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := int(c.opts.BatchSize)
+	take := func(q *[]K) []K {
+		if n > len(*q) {
+			n = len(*q)
+		}
+		keys := append([]K(nil), (*q)[:n]...)
+		*q = (*q)[n:]
+		return keys
+	}
+	if len(c.prio) > 0 {
+		return take(&c.prio), true
+	}
+	if len(c.queue) >= n || (len(c.queue) > 0 && time.Since(c.lastQueueAt) >= c.opts.WorkersInterval) {
+		return take(&c.queue), false
+	}
+	return nil, false
+}
+
+func (c *Cache[K, V]) worker(end context.Context) {
+	defer c.workersWg.Done()
+	t := time.NewTicker(c.opts.WorkersInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-end.Done():
+			return
+		case <-c.wakeCh:
+		case <-t.C:
+		}
+		for {
+			keys, priority := c.nextBatch()
+			if len(keys) == 0 {
+				break
+			}
+			c.V(3).Info("worker fetching", "keys", len(keys), "priority", priority)
+			start := time.Now()
+			values, err := c.opts.Fetch(keys)
+			if err != nil {
+				c.Error(err, "fetch failed", "keys", len(keys))
+			}
+			c.apply(keys, values, err, priority, time.Since(start))
+			if utils.IsContextDone(end) {
+				return
+			}
+		}
+	}
+}
+
+// apply stores the fetched values, on failure the keys stay pending and they are retried by the refresher
+func (c *Cache[K, V]) apply(keys []K, values map[K]V, err error, priority bool, latency time.Duration) {
+	now := utils.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Fetches++
+	if priority {
+		c.stats.PriorityFetches++
+	}
+	c.stats.LastFetchAt = now
+	c.stats.totalLatency += latency
+	c.stats.AvgFetchLatency = c.stats.totalLatency / time.Duration(c.stats.Fetches)
+	for _, k := range keys {
+		delete(c.queued, k)
+	}
+	if err != nil {
+		c.stats.FetchErrors++
+		c.stats.LastError = err.Error()
+		return
+	}
+	c.stats.LastError = ""
+	c.stats.FetchedKeys += uint64(len(values))
+
+	for k, v := range values {
+		key := c.opts.KeyOf(v)
+		if old, ok := c.entries[k]; ok && k != key {
+			// the value was cached with the key it was fetched for
+			c.unset(k, old)
+		}
+		if old, ok := c.entries[key]; ok && c.opts.Merge != nil {
+			v = c.opts.Merge(old, v)
+		}
+		v.SetLastUpdated(now)
+		c.set(v)
+		delete(c.missing, k)
+	}
+	for _, k := range keys {
+		if _, ok := values[k]; !ok {
+			c.missing[k] = now
+			c.V(4).Info("missing from the fetched values", "key", k)
+		}
+		delete(c.pending, k)
+	}
+	c.V(2).Info("stored fetched values", "keys", len(keys), "values", len(values), "now len", len(c.entries))
+	c.notifyWaiters()
+}
+
+func (c *Cache[K, V]) Stats() Stats {
+	now := utils.Now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := c.stats
+	s.Entries = len(c.entries)
+	s.Pending = len(c.pending)
+	s.Missing = len(c.missing)
+	if c.opts.RefreshInterval > 0 {
+		for _, v := range c.entries {
+			if now.Sub(v.LastUpdated()) >= c.opts.RefreshInterval {
+				s.Stale++
+			}
+		}
+	}
+	return s
+}
+
+// the stale entries are looked for a few times in a refresh interval
+func refreshCheckInterval(d time.Duration) time.Duration {
+	d = d / 10
+	if d < time.Second {
+		d = time.Second
+	}
+	if d > time.Minute {
+		d = time.Minute
+	}
+	return d
+}
+
+func (c *Cache[K, V]) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		return
+	}
+	ctx, ctxCancel := context.WithCancel(c.opts.Context)
+	c.ctxCancel = ctxCancel
+	c.wakeCh = make(chan struct{}, 1)
+	c.refresherFinished = make(chan struct{})
+	c.running = true
+
+	c.workersWg.Add(int(c.opts.Workers))
+	for i := 0; i < int(c.opts.Workers); i++ {
+		go c.worker(ctx)
+	}
+
+	refresherTick := time.NewTicker(refreshCheckInterval(c.opts.RefreshInterval))
+	var storeTick *time.Ticker
+	var storeTickC <-chan time.Time
+	if c.opts.Store != nil && cachestore.Interval() > 0 {
+		storeTick = time.NewTicker(cachestore.Interval())
+		storeTickC = storeTick.C
+	}
+	go func(end context.Context) {
+		defer close(c.refresherFinished)
+		defer refresherTick.Stop()
+		if storeTick != nil {
+			defer storeTick.Stop()
+		}
+		for {
+			select {
+			case <-end.Done():
+				return
+			case <-refresherTick.C:
+				c.V(3).Info("Refresh stale entries", "is ready", c.Ready())
+				c.refreshStale()
+			case <-storeTickC:
+				c.maybeStoreToDisk()
+			}
+		}
+	}(ctx)
+}
+
+func (c *Cache[K, V]) Stop() {
+	c.mu.Lock()
+	if !c.running {
+		c.mu.Unlock()
+		return
+	}
+	c.running = false
+	c.mu.Unlock()
+
+	c.V(2).Info("Stopping Cache")
+	c.ctxCancel()
+	<-c.refresherFinished
+	c.V(2).Info("Cache refresher stopped")
+	c.workersWg.Wait()
+	c.V(2).Info("Cache workers stopped")
+
+	// the keys still queued are fetched at the next start
+	c.maybeStoreToDisk()
+	c.V(2).Info("Cache stopped")
+}
+
+func (c *Cache[K, V]) maybeLoadFromDisk() {
+	if c.opts.Store == nil || c.opts.Decode == nil {
+		return
+	}
+	dat, err := c.opts.Store.Load()
+	if err != nil {
+		c.Error(err, "loading from the store failed")
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, elt := range bytes.Split(dat, []byte{'\n'}) {
+		if len(bytes.TrimSpace(elt)) == 0 {
+			continue
+		}
+		v, err := c.opts.Decode(elt)
+		if err != nil {
+			c.Error(err, "decoding a stored entry failed")
+			continue
+		}
+		c.set(v)
+	}
+	c.V(2).Info("maybeLoadFromDisk", "nitems", len(c.entries))
+}
+
+// maybeStoreToDisk persists the entries, it is safe also while running as the values
+// are replaced and not modified once stored
+func (c *Cache[K, V]) maybeStoreToDisk() {
+	if c.opts.Store == nil {
+		return
+	}
+	var buf bytes.Buffer
+	c.mu.RLock()
+	nitems := len(c.entries)
+	for _, v := range c.entries {
+		dat, err := v.MarshalBinary()
+		if err != nil {
+			c.mu.RUnlock()
+			c.Error(err, "encoding the entries failed")
+			return
+		}
+		buf.Write(dat)
+	}
+	c.mu.RUnlock()
+	if nitems == 0 {
+		return
+	}
+	if err := c.opts.Store.Save(buf.Bytes()); err != nil {
+		c.Error(err, "saving to the store failed")
+		return
+	}
+	c.V(3).Info("maybeStoreToDisk", "nitems", nitems)
+}
@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	flag "github.com/spf13/pflag"
+
+	"github.com/safanaj/go-f2lb/pkg/caches/cachestore"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+type testValue struct {
+	key, alias, data, extra string
+	lastUpdated             time.Time
+}
+
+func (v *testValue) LastUpdated() time.Time     { return v.lastUpdated }
+func (v *testValue) SetLastUpdated(t time.Time) { v.lastUpdated = t }
+func (v *testValue) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := fmt.Fprintf(&buf, "%q %q %q %q %d\n", v.key, v.alias, v.data, v.extra, v.lastUpdated.Unix())
+	return buf.Bytes(), err
+}
+
+func decodeTestValue(data []byte) (*testValue, error) {
+	v := &testValue{}
+	var lastUpdated int64
+	if _, err := fmt.Sscanf(string(data), "%q %q %q %q %d", &v.key, &v.alias, &v.data, &v.extra, &lastUpdated); err != nil {
+		return nil, err
+	}
+	v.lastUpdated = time.Unix(lastUpdated, 0)
+	return v, nil
+}
+
+// testBackend serves the values by key or alias, the values are copied as the cache stamps them
+type testBackend struct {
+	mu     sync.Mutex
+	values []testValue
+}
+
+func (b *testBackend) set(values ...testValue) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.values = values
+}
+
+func (b *testBackend) fetch(keys []string) (map[string]*testValue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := make(map[string]*testValue)
+	for _, k := range keys {
+		for _, v := range b.values {
+			if v.key == k || v.alias == k {
+				v := v
+				res[k] = &v
+			}
+		}
+	}
+	return res, nil
+}
+
+func newTestCache(t *testing.T, b *testBackend, store *cachestore.Store) *Cache[string, *testValue] {
+	t.Helper()
+	return New(logr.Discard(), Options[string, *testValue]{
+		Fetch: b.fetch,
+		KeyOf: func(v *testValue) string { return v.key },
+		Aliases: func(v *testValue) []string {
+			if v.alias == "" {
+				return nil
+			}
+			return []string{v.alias}
+		},
+		Merge: func(old, new *testValue) *testValue {
+			if new.extra == "" {
+				new.extra = old.extra
+			}
+			return new
+		},
+		RefreshInterval: time.Hour,
+		Store:           store,
+		Decode:          decodeTestValue,
+	})
+}
+
+func setTestClock(t *testing.T, start time.Time) *utils.SimulatedClock {
+	t.Helper()
+	prev := utils.GetClock()
+	sc := utils.NewSimulatedClock(start)
+	utils.SetClock(sc)
+	t.Cleanup(func() { utils.SetClock(prev) })
+	return sc
+}
+
+func TestCacheEnqueue(t *testing.T) {
+	c := newTestCache(t, &testBackend{}, nil)
+	enqueue := func(priority bool, keys ...string) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, k := range keys {
+			c.enqueue(k, priority)
+		}
+	}
+	queues := func() ([]string, []string) {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return append([]string{}, c.prio...), append([]string{}, c.queue...)
+	}
+
+	enqueue(false, "a", "b", "a")
+	enqueue(true, "a", "c", "a", "c")
+	enqueue(false, "c")
+	if prio, queue := queues(); !reflect.DeepEqual(prio, []string{"a", "c"}) || !reflect.DeepEqual(queue, []string{"b"}) {
+		t.Fatalf("got priority queue %v and batch queue %v", prio, queue)
+	}
+
+	if keys, priority := c.nextBatch(); !priority || !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Fatalf("expected the priority key first, got %v (priority %v)", keys, priority)
+	}
+	// a key being fetched is not queued again
+	enqueue(true, "a")
+	if prio, _ := queues(); !reflect.DeepEqual(prio, []string{"c"}) {
+		t.Fatalf("got priority queue %v", prio)
+	}
+}
+
+func TestCacheAliasesAndMerge(t *testing.T) {
+	b := &testBackend{}
+	b.set(testValue{key: "a", alias: "x", data: "1", extra: "kept"})
+	c := newTestCache(t, b, nil)
+	c.Start()
+	defer c.Stop()
+
+	// fetched by the alias, cached by the key
+	if err := c.FetchNow("x"); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "x"} {
+		if v, ok := c.Get(k); !ok || v.data != "1" {
+			t.Fatalf("Get(%q) = %+v, %v", k, v, ok)
+		}
+	}
+	if c.Len() != 1 {
+		t.Fatalf("expected 1 entry, got %d", c.Len())
+	}
+
+	// the fields not returned by the backend are merged, the old alias is dropped
+	b.set(testValue{key: "a", alias: "y", data: "2"})
+	if err := c.FetchNow("a"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := c.Get("y"); !ok || v.data != "2" || v.extra != "kept" {
+		t.Fatalf("Get(%q) = %+v, %v", "y", v, ok)
+	}
+	if _, ok := c.Get("x"); ok {
+		t.Fatalf("the old alias is still there")
+	}
+
+	if err := c.FetchNow("unknown"); err == nil || !c.IsMissing("unknown") {
+		t.Fatalf("expected the unknown key missing, got %v", err)
+	}
+}
+
+func TestCacheRefreshStale(t *testing.T) {
+	sc := setTestClock(t, utils.EpochStartTime(400))
+	c := newTestCache(t, &testBackend{}, nil)
+	values := func(keys ...string) map[string]*testValue {
+		res := make(map[string]*testValue)
+		for _, k := range keys {
+			res[k] = &testValue{key: k}
+		}
+		return res
+	}
+
+	c.apply([]string{"a", "b", "c"}, values("a", "b", "c"), nil, false, 0)
+	sc.Advance(30 * time.Minute)
+	c.apply([]string{"b"}, values("b"), nil, false, 0)
+	sc.Advance(20 * time.Minute)
+	// c is not known anymore, it waits for the refresh interval before the next try
+	c.apply([]string{"c"}, nil, nil, false, 0)
+	c.mu.Lock()
+	c.pending["p"] = struct{}{}
+	c.mu.Unlock()
+	sc.Advance(20 * time.Minute)
+
+	c.refreshStale()
+	c.mu.RLock()
+	queue := slices.Sorted(slices.Values(c.queue))
+	c.mu.RUnlock()
+	if want := []string{"a", "p"}; !reflect.DeepEqual(queue, want) {
+		t.Fatalf("queued %v, want %v", queue, want)
+	}
+	if s := c.Stats(); s.Stale != 2 || s.Missing != 1 || s.Pending != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestCacheStore(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cachestore.AddFlags(fs)
+	prev := cachestore.Interval()
+	if err := fs.Parse([]string{"--caches-store-interval=10ms"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Set("caches-store-interval", prev.String()) })
+
+	store := cachestore.New(logr.Discard(), t.TempDir(), "test.store", 1)
+	b := &testBackend{}
+	b.set(testValue{key: "a", alias: "x", data: "1"}, testValue{key: "b", data: "2"})
+	c := newTestCache(t, b, store)
+	c.Start()
+	if err := c.FetchNow("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// stored while running
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if payload, err := store.Load(); err == nil && bytes.Count(payload, []byte{'\n'}) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the cache was not stored while running")
+		}
+	}
+	c.Stop()
+
+	loaded := newTestCache(t, &testBackend{}, store)
+	if loaded.Len() != 2 {
+		t.Fatalf("expected 2 entries loaded, got %d", loaded.Len())
+	}
+	if v, ok := loaded.Get("x"); !ok || v.key != "a" || v.data != "1" {
+		t.Fatalf("Get(%q) = %+v, %v", "x", v, ok)
+	}
+}
@@ -0,0 +1,4 @@
+// Package cache is a generic cache of the values fetched in batches from the chain data backends: the keys added are queued
+// and fetched by a pool of workers, the entries older than the refresh interval are fetched again and
+// the cache is persisted in a cachestore. A new cache only needs the function fetching a batch of keys.
+package cache
@@ -0,0 +1,5 @@
+// Package cachestore is the on disk persistence of the caches: a store file has a header with a magic, the schema version
+// of the payload and its checksum, it is written to a temporary file and renamed over the previous one
+// that is kept as backup. Corrupted files are moved aside and the backup is used instead, the payloads
+// of older schema versions are upgraded by the migrations registered by the caches.
+package cachestore
@@ -0,0 +1,239 @@
+package cachestore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/safanaj/go-f2lb/pkg/logging"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+const (
+	magic      = "F2LBCACH"
+	headerSize = len(magic) + 4 + 8 + sha256.Size
+
+	backupSuffix  = ".bak"
+	corruptSuffix = ".corrupt"
+)
+
+var storeInterval = 5 * time.Minute
+
+func AddFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&storeInterval, "caches-store-interval", storeInterval,
+		"How often the running caches are persisted in the caches store path, 0 stores them only at shutdown")
+}
+
+// Interval is how often the running caches should be persisted, 0 means only when they are stopped
+func Interval() time.Duration { return storeInterval }
+
+// Migration upgrades a payload to the next schema version
+type Migration func([]byte) ([]byte, error)
+
+// Store persists the payload of a cache in a file of a directory, the payload is opaque
+// to the store that only checks its integrity and brings it to the current schema version
+type Store struct {
+	logging.Logger
+
+	mu         sync.Mutex
+	dir        string
+	name       string
+	version    uint32
+	migrations map[uint32]Migration
+
+	legacyFileName string
+	legacyVersion  uint32
+}
+
+// New returns a store for the file name in dir, the payloads saved are of the schema version
+func New(logger logging.Logger, dir, name string, version uint32) *Store {
+	return &Store{
+		Logger:     logger,
+		dir:        dir,
+		name:       name,
+		version:    version,
+		migrations: make(map[uint32]Migration),
+	}
+}
+
+// WithMigration registers the upgrade of the payloads of the schema version from to the next one
+func (s *Store) WithMigration(from uint32, m Migration) *Store {
+	s.migrations[from] = m
+	return s
+}
+
+// WithLegacyGobFile makes Load read the payload from a file written by the gob encoder of the cache
+// before the store existed, it is used only when there is no store file and removed by the next Save
+func (s *Store) WithLegacyGobFile(name string, version uint32) *Store {
+	s.legacyFileName = name
+	s.legacyVersion = version
+	return s
+}
+
+func (s *Store) path() string { return filepath.Join(s.dir, s.name) }
+
+// the directory must be private to the process user, like the old store files
+func (s *Store) checkDir(create bool) error {
+	fi, err := os.Stat(s.dir)
+	if err != nil {
+		if create && os.IsNotExist(err) {
+			return os.MkdirAll(s.dir, 0700)
+		}
+		return err
+	}
+	if !(fi.Mode().IsDir() && ((fi.Mode().Perm() & 0700) == 0700)) {
+		return fmt.Errorf("%s is not a directory or has bad permissions", s.dir)
+	}
+	return nil
+}
+
+// Save writes the payload atomically over the store file,
+// the previous store file becomes the backup
+func (s *Store) Save(payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkDir(true); err != nil {
+		return err
+	}
+
+	var header [headerSize]byte
+	copy(header[:], magic)
+	binary.BigEndian.PutUint32(header[len(magic):], s.version)
+	binary.BigEndian.PutUint64(header[len(magic)+4:], uint64(len(payload)))
+	sum := sha256.Sum256(payload)
+	copy(header[len(magic)+12:], sum[:])
+
+	// the backup is a link to the previous store file, so the store file is always there
+	fn := s.path()
+	if err := os.Remove(fn + backupSuffix); err != nil && !os.IsNotExist(err) {
+		s.Error(err, "removing the old backup failed", "file", fn)
+	}
+	if err := os.Link(fn, fn+backupSuffix); err != nil && !os.IsNotExist(err) {
+		s.Error(err, "keeping the backup failed", "file", fn)
+	}
+	if err := utils.WriteFileAtomic(fn, append(header[:], payload...)); err != nil {
+		return err
+	}
+
+	if s.legacyFileName != "" {
+		if err := os.Remove(filepath.Join(s.dir, s.legacyFileName)); err == nil {
+			s.V(2).Info("removed legacy store file", "file", s.legacyFileName)
+		}
+	}
+	return nil
+}
+
+// Load returns the stored payload at the current schema version, nil if nothing was stored.
+// A corrupted store file is renamed with the .corrupt suffix and the backup is tried instead
+func (s *Store) Load() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkDir(false); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	fn := s.path()
+	for _, fn := range []string{fn, fn + backupSuffix} {
+		version, payload, err := s.readFile(fn)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			s.Error(err, "store file is corrupted, moving it aside", "file", fn)
+			if err := os.Rename(fn, fn+corruptSuffix); err != nil {
+				s.Error(err, "moving aside the corrupted store file failed", "file", fn)
+			}
+			continue
+		}
+		if payload, err = s.migrate(version, payload); err != nil {
+			s.Error(err, "store file migration failed", "file", fn)
+			continue
+		}
+		s.V(2).Info("loaded store file", "file", fn, "version", version, "size", len(payload))
+		return payload, nil
+	}
+
+	if s.legacyFileName == "" {
+		return nil, nil
+	}
+	payload, err := s.readLegacyFile(filepath.Join(s.dir, s.legacyFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	s.V(2).Info("loaded legacy store file", "file", s.legacyFileName, "size", len(payload))
+	return s.migrate(s.legacyVersion, payload)
+}
+
+func (s *Store) readFile(fn string) (uint32, []byte, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < headerSize || string(data[:len(magic)]) != magic {
+		return 0, nil, fmt.Errorf("bad header")
+	}
+	version := binary.BigEndian.Uint32(data[len(magic):])
+	size := binary.BigEndian.Uint64(data[len(magic)+4:])
+	payload := data[headerSize:]
+	if uint64(len(payload)) != size {
+		return 0, nil, fmt.Errorf("truncated payload: %d bytes of %d", len(payload), size)
+	}
+	if sum := sha256.Sum256(payload); !bytes.Equal(sum[:], data[len(magic)+12:headerSize]) {
+		return 0, nil, fmt.Errorf("checksum mismatch")
+	}
+	return version, payload, nil
+}
+
+func (s *Store) migrate(version uint32, payload []byte) ([]byte, error) {
+	if version > s.version {
+		return nil, fmt.Errorf("schema version %d is newer than %d", version, s.version)
+	}
+	for ; version < s.version; version++ {
+		m, ok := s.migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration from schema version %d", version)
+		}
+		var err error
+		if payload, err = m(payload); err != nil {
+			return nil, fmt.Errorf("migrating from schema version %d: %w", version, err)
+		}
+	}
+	return payload, nil
+}
+
+// legacyPayload receives the bytes produced by the GobEncode of the caches
+type legacyPayload []byte
+
+func (p *legacyPayload) GobDecode(dat []byte) error {
+	*p = append((*p)[:0], dat...)
+	return nil
+}
+
+func (s *Store) readLegacyFile(fn string) ([]byte, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var p legacyPayload
+	if err := gob.NewDecoder(f).Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,146 @@
+package cachestore
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func newTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "caches")
+	return New(logr.Discard(), dir, "test.store", 2).
+		WithMigration(1, func(p []byte) ([]byte, error) { return []byte(strings.ToUpper(string(p))), nil }), dir
+}
+
+func mustSave(t *testing.T, s *Store, payload string) {
+	t.Helper()
+	if err := s.Save([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustLoad(t *testing.T, s *Store) string {
+	t.Helper()
+	payload, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(payload)
+}
+
+func TestStoreSaveLoad(t *testing.T) {
+	s, dir := newTestStore(t)
+	if got := mustLoad(t, s); got != "" {
+		t.Fatalf("expected nothing from a missing directory, got %q", got)
+	}
+	mustSave(t, s, "first")
+	mustSave(t, s, "second")
+	if got := mustLoad(t, s); got != "second" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test.store"+backupSuffix)); err != nil {
+		t.Fatalf("the previous file was not kept as backup: %v", err)
+	}
+}
+
+func TestStoreChecksumMismatch(t *testing.T) {
+	s, dir := newTestStore(t)
+	mustSave(t, s, "first")
+	mustSave(t, s, "second")
+
+	fn := filepath.Join(dir, "test.store")
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(fn, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the backup is used and the corrupted file moved aside
+	if got := mustLoad(t, s); got != "first" {
+		t.Fatalf("expected the backup payload, got %q", got)
+	}
+	if _, err := os.Stat(fn + corruptSuffix); err != nil {
+		t.Fatalf("the corrupted file was not moved aside: %v", err)
+	}
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Fatalf("the corrupted file is still in place: %v", err)
+	}
+
+	// the next save is loaded again
+	mustSave(t, s, "third")
+	if got := mustLoad(t, s); got != "third" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestStoreCorruptedWithoutBackup(t *testing.T) {
+	s, dir := newTestStore(t)
+	mustSave(t, s, "first")
+	fn := filepath.Join(dir, "test.store")
+	if err := os.WriteFile(fn, []byte("F2LBCACH truncated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustLoad(t, s); got != "" {
+		t.Fatalf("expected nothing, got %q", got)
+	}
+	if _, err := os.Stat(fn + corruptSuffix); err != nil {
+		t.Fatalf("the corrupted file was not moved aside: %v", err)
+	}
+}
+
+func TestStoreMigration(t *testing.T) {
+	s, dir := newTestStore(t)
+	old := New(logr.Discard(), dir, "test.store", 1)
+	mustSave(t, old, "v1 payload")
+	if got := mustLoad(t, s); got != "V1 PAYLOAD" {
+		t.Fatalf("expected the migrated payload, got %q", got)
+	}
+
+	// a newer schema can not be read, the backup is used
+	newer := New(logr.Discard(), dir, "test.store", 3)
+	mustSave(t, newer, "v3 payload")
+	if got := mustLoad(t, s); got != "V1 PAYLOAD" {
+		t.Fatalf("expected the backup payload, got %q", got)
+	}
+}
+
+type testLegacyCache []byte
+
+func (c testLegacyCache) GobEncode() ([]byte, error) { return c, nil }
+
+func TestStoreLegacyGobFile(t *testing.T) {
+	s, dir := newTestStore(t)
+	s.WithLegacyGobFile("test.gob", 1)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	legacy := filepath.Join(dir, "test.gob")
+	f, err := os.Create(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(f).Encode(testLegacyCache("legacy line\n")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if got := mustLoad(t, s); got != "LEGACY LINE\n" {
+		t.Fatalf("expected the migrated legacy payload, got %q", got)
+	}
+	// the legacy file is removed once the store file is written
+	mustSave(t, s, "saved")
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatalf("the legacy file was not removed: %v", err)
+	}
+	if got := mustLoad(t, s); got != "saved" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,172 @@
+package chainprovider
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/blockfrost/blockfrost-go"
+
+	bfu "github.com/safanaj/go-f2lb/pkg/caches/blockfrostutils"
+	ku "github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+const BlockfrostProviderName = "blockfrost"
+
+// blockfrostProvider serves all the calls but the ticker lookup, the API has no search by ticker.
+// There are no bulk endpoints so it is one request per item, it is meant as a fallback
+type blockfrostProvider struct {
+	*bfu.BlockFrostClient
+}
+
+var _ ChainProvider = (*blockfrostProvider)(nil)
+
+func NewBlockfrostProvider(bfc *bfu.BlockFrostClient) ChainProvider {
+	return &blockfrostProvider{BlockFrostClient: bfc}
+}
+
+func (p *blockfrostProvider) Name() string { return BlockfrostProviderName }
+
+func (p *blockfrostProvider) api() blockfrost.APIClient { return p.GetBlockFrostClient() }
+
+func (p *blockfrostProvider) GetTip() (epoch, block, slot int, err error) {
+	b, err := p.api().BlockLatest(p.GetContext())
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return b.Epoch, b.Height, b.Slot, nil
+}
+
+func (p *blockfrostProvider) GetStakeAddressesInfos(stakeAddrs ...string) (map[string]*AccountInfo, error) {
+	if len(stakeAddrs) == 0 {
+		return nil, nil
+	}
+	res := make(map[string]*AccountInfo)
+	for _, saddr := range stakeAddrs {
+		account, err := p.api().Account(p.GetContext(), saddr)
+		if err != nil {
+			return res, err
+		}
+		amount, err := strconv.ParseUint(account.ControlledAmount, 10, 64)
+		if err != nil {
+			return res, err
+		}
+		// same values of the koios status
+		status := "not registered"
+		if account.Active {
+			status = "registered"
+		}
+		res[account.StakeAddress] = &AccountInfo{
+			Bech32:        account.StakeAddress,
+			DelegatedPool: account.PoolID,
+			Status:        status,
+			TotalBalance:  utils.Lovelace(amount),
+		}
+	}
+	return res, nil
+}
+
+func (p *blockfrostProvider) GetPoolsInfos(bech32PoolIds ...string) (map[string]*PoolInfo, error) {
+	if len(bech32PoolIds) == 0 {
+		return nil, nil
+	}
+	res := make(map[string]*PoolInfo)
+	for _, pid := range bech32PoolIds {
+		pool, err := p.api().Pool(p.GetContext(), pid)
+		if err != nil {
+			return res, err
+		}
+		md, err := p.api().PoolMetadata(p.GetContext(), pid)
+		if err != nil {
+			return res, err
+		}
+		if md.Ticker == "" {
+			// as koios, the pools without a ticker are skipped
+			continue
+		}
+		relays, err := p.api().PoolRelays(p.GetContext(), pid)
+		if err != nil {
+			return res, err
+		}
+		activeStake, _ := strconv.ParseUint(pool.ActiveStake, 10, 64)
+		liveStake, _ := strconv.ParseUint(pool.LiveStake, 10, 64)
+		pi := &PoolInfo{
+			Bech32:         pool.PoolID,
+			Ticker:         md.Ticker,
+			ActiveStake:    utils.Lovelace(activeStake),
+			LiveStake:      utils.Lovelace(liveStake),
+			LiveDelegators: uint32(pool.LiveDelegators),
+			VrfKeyHash:     pool.VrfKey,
+			Margin:         float32(pool.MarginCost),
+			Status:         "registered",
+		}
+		// the retirement certificates are listed without their epoch, a pool with one is considered retired
+		if len(pool.Retirement) > 0 {
+			pi.IsRetired = true
+			pi.Status = "retired"
+		}
+		for _, r := range relays {
+			pi.Relays = append(pi.Relays, ku.Relay{DNS: r.DNS, Ipv4: r.Ipv4, Ipv6: r.Ipv6, Port: uint16(r.Port), Srv: r.DNSSrv})
+		}
+		res[pi.Bech32] = pi
+	}
+	return res, nil
+}
+
+func (p *blockfrostProvider) GetTickerToPoolIdMapFor(...string) (map[string]string, error) {
+	return nil, NotSupportedErr
+}
+
+func (p *blockfrostProvider) GetLastDelegationTx(stakeAddr string) (string, error) {
+	hist, err := p.api().AccountDelegationHistory(p.GetContext(), stakeAddr, blockfrost.APIQueryParams{Order: "desc", Count: 1})
+	if err != nil || len(hist) == 0 {
+		return "", err
+	}
+	return hist[0].TXHash, nil
+}
+
+func (p *blockfrostProvider) GetTxsTimes(txs []string) (map[string]time.Time, error) {
+	res := make(map[string]time.Time)
+	for _, tx := range txs {
+		tc, err := p.api().Transaction(p.GetContext(), tx)
+		if err != nil {
+			return res, err
+		}
+		b, err := p.api().Block(p.GetContext(), tc.Block)
+		if err != nil {
+			return res, err
+		}
+		res[tx] = time.Unix(int64(b.Time), 0)
+	}
+	return res, nil
+}
+
+func (p *blockfrostProvider) GetTxsMetadata(txs []string) (map[string]string, error) {
+	if len(txs) == 0 {
+		return nil, nil
+	}
+	res := make(map[string]string)
+	for _, tx := range txs {
+		mds, err := p.api().TransactionMetadata(p.GetContext(), tx)
+		if err != nil {
+			return res, err
+		}
+		// as koios, the msg of the CIP-20 label or an empty string
+		msg := ""
+		for _, md := range mds {
+			if md.Label != "674" {
+				continue
+			}
+			metadata := map[string][]string{}
+			if raw, err := json.Marshal(md.JsonMetadata); err == nil {
+				json.Unmarshal(raw, &metadata)
+			}
+			if s, ok := metadata["msg"]; ok && len(s) == 1 {
+				msg = s[0]
+			}
+		}
+		res[tx] = msg
+	}
+	return res, nil
+}
@@ -0,0 +1,188 @@
+package chainprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	bfu "github.com/safanaj/go-f2lb/pkg/caches/blockfrostutils"
+	ku "github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
+	"github.com/safanaj/go-f2lb/pkg/logging"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+const ChainProviderName = "chain"
+
+var (
+	defaultOrder = []string{KoiosProviderName, BlockfrostProviderName, NodeProviderName}
+	callOrders   []string
+)
+
+func AddFlags(fs *flag.FlagSet) {
+	fs.StringSliceVar(&defaultOrder, "chain-providers", defaultOrder,
+		"Chain data backends tried in order, any of koios, blockfrost and node (node only serves the tip)")
+	fs.StringArrayVar(&callOrders, "chain-provider-order", nil,
+		"Order of the backends for a call type as call=backend,backend, can be repeated. "+
+			"The call types are tip, account-info, pool-info, ticker-lookup, tx-times and tx-metadata")
+}
+
+// chain tries the backends in the order configured for the call type, the next one is used
+// when a backend fails or does not support the call
+type chain struct {
+	logging.Logger
+	ctx   context.Context
+	order map[CallType][]ChainProvider
+}
+
+var _ ChainProvider = (*chain)(nil)
+
+// New returns the chain of the backends configured by the flags, blockfrost is skipped when bfc is nil
+func New(ctx context.Context, kc *ku.KoiosClient, bfc *bfu.BlockFrostClient, logger logging.Logger) (ChainProvider, error) {
+	providers := map[string]ChainProvider{
+		KoiosProviderName: NewKoiosProvider(kc),
+		NodeProviderName:  NewNodeProvider(ctx),
+	}
+	if bfc != nil {
+		providers[BlockfrostProviderName] = NewBlockfrostProvider(bfc)
+	}
+	orders := make(map[CallType][]string)
+	for _, ct := range CallTypes {
+		for _, name := range defaultOrder {
+			// the node is not used for the calls it does not serve
+			if strings.TrimSpace(name) == NodeProviderName && !slices.Contains(nodeCallTypes, ct) {
+				continue
+			}
+			orders[ct] = append(orders[ct], name)
+		}
+	}
+	for _, co := range callOrders {
+		ct, names, found := strings.Cut(co, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid chain provider order %q, expected call=backend,backend", co)
+		}
+		if _, ok := orders[CallType(ct)]; !ok {
+			return nil, fmt.Errorf("unknown call type %q in chain provider order", ct)
+		}
+		orders[CallType(ct)] = strings.Split(names, ",")
+	}
+	return NewChain(ctx, logger, providers, orders)
+}
+
+// NewChain returns a ChainProvider using the providers by name in the given order per call type,
+// the unknown names and the node for a call it does not serve are an error while the known ones missing
+// in providers are skipped
+func NewChain(ctx context.Context, logger logging.Logger, providers map[string]ChainProvider, orders map[CallType][]string) (ChainProvider, error) {
+	c := &chain{Logger: logger, ctx: ctx, order: make(map[CallType][]ChainProvider)}
+	for ct, names := range orders {
+		for _, name := range names {
+			switch name = strings.TrimSpace(name); name {
+			case KoiosProviderName, BlockfrostProviderName:
+			case NodeProviderName:
+				if !slices.Contains(nodeCallTypes, ct) {
+					return nil, fmt.Errorf("chain provider %q does not serve %s", name, ct)
+				}
+			default:
+				return nil, fmt.Errorf("unknown chain provider %q for %s", name, ct)
+			}
+			if p, ok := providers[name]; ok {
+				c.order[ct] = append(c.order[ct], p)
+			}
+		}
+	}
+	for ct, ps := range c.order {
+		names := make([]string, 0, len(ps))
+		for _, p := range ps {
+			names = append(names, p.Name())
+		}
+		c.V(2).Info("Chain provider order", "call", ct, "providers", names)
+	}
+	return c, nil
+}
+
+func (c *chain) Name() string                { return ChainProviderName }
+func (c *chain) GetContext() context.Context { return c.ctx }
+
+// call returns the result of the first backend succeeding, or the last error
+func call[T any](c *chain, ct CallType, f func(ChainProvider) (T, error)) (T, error) {
+	var (
+		res T
+		err error
+	)
+	for _, p := range c.order[ct] {
+		r, perr := f(p)
+		if perr == nil {
+			return r, nil
+		}
+		if errors.Is(perr, NotSupportedErr) {
+			continue
+		}
+		c.Error(perr, "Chain provider failed, trying the next one", "provider", p.Name(), "call", ct)
+		res, err = r, perr
+	}
+	if err == nil {
+		err = fmt.Errorf("no chain provider available for %s", ct)
+	}
+	return res, err
+}
+
+func (c *chain) GetTip() (epoch, block, slot int, err error) {
+	type tip struct{ epoch, block, slot int }
+	t, err := call(c, TipCall, func(p ChainProvider) (tip, error) {
+		e, b, s, err := p.GetTip()
+		return tip{e, b, s}, err
+	})
+	return t.epoch, t.block, t.slot, err
+}
+
+func (c *chain) GetStakeAddressesInfos(stakeAddrs ...string) (map[string]*AccountInfo, error) {
+	return call(c, AccountInfoCall, func(p ChainProvider) (map[string]*AccountInfo, error) {
+		return p.GetStakeAddressesInfos(stakeAddrs...)
+	})
+}
+
+func (c *chain) GetStakeAddressInfo(stakeAddr string) (string, utils.Lovelace, error) {
+	type info struct {
+		pool   string
+		amount utils.Lovelace
+	}
+	i, err := call(c, AccountInfoCall, func(p ChainProvider) (info, error) {
+		dp, amount, err := p.GetStakeAddressInfo(stakeAddr)
+		return info{dp, amount}, err
+	})
+	return i.pool, i.amount, err
+}
+
+func (c *chain) GetPoolsInfos(bech32PoolIds ...string) (map[string]*PoolInfo, error) {
+	return call(c, PoolInfoCall, func(p ChainProvider) (map[string]*PoolInfo, error) {
+		return p.GetPoolsInfos(bech32PoolIds...)
+	})
+}
+
+func (c *chain) GetTickerToPoolIdMapFor(tickers ...string) (map[string]string, error) {
+	return call(c, TickerLookupCall, func(p ChainProvider) (map[string]string, error) {
+		return p.GetTickerToPoolIdMapFor(tickers...)
+	})
+}
+
+func (c *chain) GetLastDelegationTx(stakeAddr string) (string, error) {
+	return call(c, TxTimesCall, func(p ChainProvider) (string, error) {
+		return p.GetLastDelegationTx(stakeAddr)
+	})
+}
+
+func (c *chain) GetTxsTimes(txs []string) (map[string]time.Time, error) {
+	return call(c, TxTimesCall, func(p ChainProvider) (map[string]time.Time, error) {
+		return p.GetTxsTimes(txs)
+	})
+}
+
+func (c *chain) GetTxsMetadata(txs []string) (map[string]string, error) {
+	return call(c, TxMetadataCall, func(p ChainProvider) (map[string]string, error) {
+		return p.GetTxsMetadata(txs)
+	})
+}
@@ -0,0 +1,4 @@
+// Package chainprovider gives the chain data used by the caches and the controller, the calls are served by a chain of
+// backends (koios, blockfrost.io and a local cardano-node) tried in a configurable order per call type,
+// so the outage of one of them does not take down the dynamic data. The local cardano-node only serves the tip.
+package chainprovider
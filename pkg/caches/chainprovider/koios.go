@@ -0,0 +1,45 @@
+package chainprovider
+
+import (
+	"time"
+
+	koios "github.com/cardano-community/koios-go-client/v4"
+
+	ku "github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
+)
+
+const KoiosProviderName = "koios"
+
+// koiosProvider serves all the calls
+type koiosProvider struct {
+	*ku.KoiosClient
+}
+
+var _ ChainProvider = (*koiosProvider)(nil)
+
+func NewKoiosProvider(kc *ku.KoiosClient) ChainProvider { return &koiosProvider{KoiosClient: kc} }
+
+func (p *koiosProvider) Name() string { return KoiosProviderName }
+
+func (p *koiosProvider) GetTickerToPoolIdMapFor(tickers ...string) (map[string]string, error) {
+	t2p, err, _ := p.KoiosClient.GetTickerToPoolIdMapFor(tickers...)
+	return t2p, err
+}
+
+func (p *koiosProvider) GetLastDelegationTx(stakeAddr string) (string, error) {
+	tx, err := p.KoiosClient.GetLastDelegationTx(stakeAddr)
+	return string(tx), err
+}
+
+func (p *koiosProvider) GetTxsTimes(txs []string) (map[string]time.Time, error) {
+	txhs := make([]koios.TxHash, 0, len(txs))
+	for _, tx := range txs {
+		txhs = append(txhs, koios.TxHash(tx))
+	}
+	tx2time, err := p.KoiosClient.GetTxsTimes(txhs)
+	res := make(map[string]time.Time, len(tx2time))
+	for tx, t := range tx2time {
+		res[string(tx)] = t
+	}
+	return res, err
+}
@@ -0,0 +1,57 @@
+package chainprovider
+
+import (
+	"context"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/ccli"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+const NodeProviderName = "node"
+
+// nodeCallTypes are the calls served by the node provider
+var nodeCallTypes = []CallType{TipCall}
+
+// nodeProvider queries a local cardano-node via cardano-cli, only the tip is served:
+// the node does not know the pool tickers nor the tx times and metadata, and the stake address info
+// from the ledger state has the rewards but not the total balance of the account.
+// The chain does not use it for the other call types, see nodeCallTypes
+type nodeProvider struct {
+	ctx context.Context
+}
+
+var _ ChainProvider = (*nodeProvider)(nil)
+
+func NewNodeProvider(ctx context.Context) ChainProvider { return &nodeProvider{ctx: ctx} }
+
+func (p *nodeProvider) Name() string                { return NodeProviderName }
+func (p *nodeProvider) GetContext() context.Context { return p.ctx }
+
+func (p *nodeProvider) GetTip() (epoch, block, slot int, err error) {
+	tip, err := ccli.GetNodeTip(p.ctx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return int(tip.Epoch), int(tip.Block), int(tip.Slot), nil
+}
+
+func (p *nodeProvider) GetStakeAddressesInfos(...string) (map[string]*AccountInfo, error) {
+	return nil, NotSupportedErr
+}
+func (p *nodeProvider) GetStakeAddressInfo(string) (string, utils.Lovelace, error) {
+	return "", 0, NotSupportedErr
+}
+func (p *nodeProvider) GetPoolsInfos(...string) (map[string]*PoolInfo, error) {
+	return nil, NotSupportedErr
+}
+func (p *nodeProvider) GetTickerToPoolIdMapFor(...string) (map[string]string, error) {
+	return nil, NotSupportedErr
+}
+func (p *nodeProvider) GetLastDelegationTx(string) (string, error) { return "", NotSupportedErr }
+func (p *nodeProvider) GetTxsTimes([]string) (map[string]time.Time, error) {
+	return nil, NotSupportedErr
+}
+func (p *nodeProvider) GetTxsMetadata([]string) (map[string]string, error) {
+	return nil, NotSupportedErr
+}
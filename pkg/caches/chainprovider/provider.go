@@ -0,0 +1,50 @@
+package chainprovider
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	ku "github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+type (
+	AccountInfo = ku.AccountInfo
+	PoolInfo    = ku.PoolInfo
+)
+
+// NotSupportedErr is returned by a backend for the calls it is not able to serve,
+// the chain skips it without counting it as a failure
+var NotSupportedErr = errors.New("not supported by the chain provider")
+
+// ChainProvider gives the chain data needed by f2lb, the tx hashes are hex strings
+type ChainProvider interface {
+	Name() string
+	GetContext() context.Context
+
+	GetTip() (epoch, block, slot int, err error)
+	GetStakeAddressesInfos(stakeAddrs ...string) (map[string]*AccountInfo, error)
+	GetStakeAddressInfo(stakeAddr string) (delegatedPool string, totalBalance utils.Lovelace, err error)
+	GetPoolsInfos(bech32PoolIds ...string) (map[string]*PoolInfo, error)
+	// as koios, in case tickers is zero-length all the pools with a ticker are returned
+	GetTickerToPoolIdMapFor(tickers ...string) (map[string]string, error)
+	GetLastDelegationTx(stakeAddr string) (string, error)
+	GetTxsTimes(txs []string) (map[string]time.Time, error)
+	GetTxsMetadata(txs []string) (map[string]string, error)
+}
+
+// CallType groups the calls sharing the same order of backends
+type CallType string
+
+const (
+	TipCall          CallType = "tip"
+	AccountInfoCall  CallType = "account-info"
+	PoolInfoCall     CallType = "pool-info"
+	TickerLookupCall CallType = "ticker-lookup"
+	// also the lookup of the last delegation tx, it is used only to get its time
+	TxTimesCall    CallType = "tx-times"
+	TxMetadataCall CallType = "tx-metadata"
+)
+
+var CallTypes = []CallType{TipCall, AccountInfoCall, PoolInfoCall, TickerLookupCall, TxTimesCall, TxMetadataCall}
@@ -0,0 +1,263 @@
+package koiosutils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	koios "github.com/cardano-community/koios-go-client/v4"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// names of the cached endpoints in CacheStats
+const (
+	PoolInfoCacheName     = "pool_info"
+	AccountInfoCacheName  = "account_info"
+	TxMetadataCacheName   = "tx_metadata"
+	DelegationTxCacheName = "delegation_tx"
+	EpochNonceCacheName   = "epoch_nonce"
+)
+
+var (
+	poolInfoTTL     = 5 * time.Minute
+	accountInfoTTL  = 2 * time.Minute
+	txMetadataTTL   = 24 * time.Hour
+	delegationTxTTL = 10 * time.Minute
+)
+
+// CacheStats are the counters of a cached endpoint, Coalesced are the misses served by a request already in flight
+type CacheStats struct {
+	Endpoint  string `json:"endpoint"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Coalesced uint64 `json:"coalesced"`
+	Entries   int    `json:"entries"`
+}
+
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+// responseCache keeps the responses by endpoint and key until they expire, the identical requests
+// in flight are sent once. The expiration follows utils.Now so the epoch scoped entries work with a simulated clock
+type responseCache struct {
+	mu        sync.Mutex
+	entries   map[string]map[string]cacheEntry
+	stats     map[string]*CacheStats
+	lastSweep time.Time
+	group     singleflight.Group
+}
+
+var sharedCache = &responseCache{
+	entries: make(map[string]map[string]cacheEntry),
+	stats:   make(map[string]*CacheStats),
+}
+
+func (rc *responseCache) endpointStats(endpoint string) *CacheStats {
+	cs, ok := rc.stats[endpoint]
+	if !ok {
+		cs = &CacheStats{Endpoint: endpoint}
+		rc.stats[endpoint] = cs
+	}
+	return cs
+}
+
+// lookup returns the values found for the keys and the missing ones, counting hits and misses
+func (rc *responseCache) lookup(endpoint string, keys []string) (map[string]any, []string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := utils.Now()
+	found := make(map[string]any)
+	missing := []string{}
+	cs := rc.endpointStats(endpoint)
+	for _, k := range keys {
+		if e, ok := rc.entries[endpoint][k]; ok && now.Before(e.expires) {
+			found[k] = e.value
+			cs.Hits++
+			continue
+		}
+		missing = append(missing, k)
+		cs.Misses++
+	}
+	return found, missing
+}
+
+func (rc *responseCache) store(endpoint string, values map[string]any, expires time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := utils.Now()
+	if !now.Before(expires) {
+		return
+	}
+	if now.Sub(rc.lastSweep) > time.Minute {
+		for _, entries := range rc.entries {
+			for k, e := range entries {
+				if !now.Before(e.expires) {
+					delete(entries, k)
+				}
+			}
+		}
+		rc.lastSweep = now
+	}
+	entries, ok := rc.entries[endpoint]
+	if !ok {
+		entries = make(map[string]cacheEntry)
+		rc.entries[endpoint] = entries
+	}
+	for k, v := range values {
+		entries[k] = cacheEntry{value: v, expires: expires}
+	}
+}
+
+func (rc *responseCache) countCoalesced(endpoint string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.endpointStats(endpoint).Coalesced++
+}
+
+func (rc *responseCache) Stats() []CacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := utils.Now()
+	res := make([]CacheStats, 0, len(rc.stats))
+	for endpoint, cs := range rc.stats {
+		s := *cs
+		for _, e := range rc.entries[endpoint] {
+			if now.Before(e.expires) {
+				s.Entries++
+			}
+		}
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Endpoint < res[j].Endpoint })
+	return res
+}
+
+// cachedMany serves the keys from the cache and fetches only the missing ones, the same set of missing keys
+// is fetched once by the concurrent callers. The keys not returned by fetch are not cached
+func cachedMany[V any](rc *responseCache, endpoint string, ttl time.Duration, keys []string,
+	fetch func([]string) (map[string]V, error)) (map[string]V, error) {
+	found, missing := rc.lookup(endpoint, keys)
+	res := make(map[string]V, len(keys))
+	for k, v := range found {
+		res[k] = v.(V)
+	}
+	if len(missing) == 0 {
+		return res, nil
+	}
+	sort.Strings(missing)
+	leader := false
+	fetched, err, _ := rc.group.Do(endpoint+":"+strings.Join(missing, ","), func() (any, error) {
+		leader = true
+		values, err := fetch(missing)
+		if err == nil && ttl > 0 {
+			toStore := make(map[string]any, len(values))
+			for k, v := range values {
+				toStore[k] = v
+			}
+			rc.store(endpoint, toStore, utils.Now().Add(ttl))
+		}
+		return values, err
+	})
+	if !leader {
+		rc.countCoalesced(endpoint)
+	}
+	if values, ok := fetched.(map[string]V); ok {
+		for k, v := range values {
+			res[k] = v
+		}
+	}
+	return res, err
+}
+
+// cachedOne is cachedMany for a single key, expiring at the given time
+func cachedOne[V any](rc *responseCache, endpoint string, expires func() time.Time, key string,
+	fetch func() (V, error)) (V, error) {
+	var zero V
+	if found, _ := rc.lookup(endpoint, []string{key}); len(found) == 1 {
+		return found[key].(V), nil
+	}
+	leader := false
+	v, err, _ := rc.group.Do(endpoint+":"+key, func() (any, error) {
+		leader = true
+		v, err := fetch()
+		if err == nil {
+			rc.store(endpoint, map[string]any{key: v}, expires())
+		}
+		return v, err
+	})
+	if !leader {
+		rc.countCoalesced(endpoint)
+	}
+	if err != nil {
+		return zero, err
+	}
+	return v.(V), nil
+}
+
+func expiresIn(ttl time.Duration) func() time.Time {
+	return func() time.Time { return utils.Now().Add(ttl) }
+}
+
+// the epoch params do not change within an epoch
+func expiresAtEpochEnd() time.Time { return utils.EpochEndTime(utils.CurrentEpoch()) }
+
+// CachedClient serves the calls from the response cache shared by all the clients,
+// it is for the data shown to the visitors where a few minutes old data is fine
+type CachedClient struct {
+	*KoiosClient
+}
+
+func (kc *KoiosClient) Cached() *CachedClient { return &CachedClient{KoiosClient: kc} }
+
+func (cc *CachedClient) GetPoolsInfos(bech32PoolIds ...string) (map[string]*PoolInfo, error) {
+	if len(bech32PoolIds) == 0 {
+		return nil, nil
+	}
+	return cachedMany(sharedCache, PoolInfoCacheName, poolInfoTTL, bech32PoolIds, func(ids []string) (map[string]*PoolInfo, error) {
+		return cc.KoiosClient.GetPoolsInfos(ids...)
+	})
+}
+
+func (cc *CachedClient) GetStakeAddressesInfos(stakeAddrs ...string) (map[string]*AccountInfo, error) {
+	if len(stakeAddrs) == 0 {
+		return nil, nil
+	}
+	return cachedMany(sharedCache, AccountInfoCacheName, accountInfoTTL, stakeAddrs, func(saddrs []string) (map[string]*AccountInfo, error) {
+		return cc.KoiosClient.GetStakeAddressesInfos(saddrs...)
+	})
+}
+
+func (cc *CachedClient) GetTxsMetadata(txs []string) (map[string]string, error) {
+	if len(txs) == 0 {
+		return nil, nil
+	}
+	return cachedMany(sharedCache, TxMetadataCacheName, txMetadataTTL, txs, cc.KoiosClient.GetTxsMetadata)
+}
+
+func (cc *CachedClient) GetLastDelegationTx(stakeAddr string) (koios.TxHash, error) {
+	return cachedOne(sharedCache, DelegationTxCacheName, expiresIn(delegationTxTTL), stakeAddr, func() (koios.TxHash, error) {
+		return cc.KoiosClient.GetLastDelegationTx(stakeAddr)
+	})
+}
+
+// GetEpochNonce returns the nonce of the epoch from the epoch params, cached until the end of the current epoch
+func (cc *CachedClient) GetEpochNonce(epoch utils.Epoch) (string, error) {
+	return cachedOne(sharedCache, EpochNonceCacheName, expiresAtEpochEnd, fmt.Sprintf("%d", epoch), func() (string, error) {
+		opts := cc.k.NewRequestOptions()
+		opts.QuerySet("select", "nonce")
+		r, err := cc.k.GetEpochParams(cc.ctx, koios.EpochNo(int(epoch)), opts)
+		if err != nil {
+			return "", err
+		}
+		if len(r.Data) != 1 {
+			return "", fmt.Errorf("Epoch params for %d are not one: len %d", epoch, len(r.Data))
+		}
+		return r.Data[0].Nonce, nil
+	})
+}
@@ -0,0 +1,72 @@
+package koiosutils
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// chunkSizer adapts the number of items sent in a bulk request: it is halved by a failed request
+// and slowly grown back to the maximum by the successful ones
+type chunkSizer struct {
+	mu   sync.Mutex
+	size int
+	max  int
+}
+
+func newChunkSizer(max int) *chunkSizer {
+	if max < 1 {
+		max = 1
+	}
+	return &chunkSizer{size: max, max: max}
+}
+
+func (cs *chunkSizer) Size() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.size
+}
+
+// shrink halves the size below the one of the failed chunk, false if it was already a single item
+func (cs *chunkSizer) shrink(failed int) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if failed <= 1 {
+		return false
+	}
+	if half := failed / 2; half < cs.size {
+		cs.size = half
+	}
+	return true
+}
+
+func (cs *chunkSizer) grow() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.size < cs.max {
+		cs.size += cs.size/10 + 1
+		if cs.size > cs.max {
+			cs.size = cs.max
+		}
+	}
+}
+
+// inChunks calls f on consecutive chunks of items, a failed chunk is tried again smaller until it is
+// a single item. The errors not depending on the size, like the open circuit, are returned at once
+func inChunks[T any](ctx context.Context, cs *chunkSizer, items []T, f func([]T) error) error {
+	for len(items) > 0 {
+		n := cs.Size()
+		if n > len(items) {
+			n = len(items)
+		}
+		if err := f(items[:n]); err != nil {
+			if ctx.Err() != nil || errors.Is(err, CircuitOpenErr) || !cs.shrink(n) {
+				return err
+			}
+			continue
+		}
+		cs.grow()
+		items = items[n:]
+	}
+	return nil
+}
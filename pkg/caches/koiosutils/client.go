@@ -4,16 +4,61 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	koios "github.com/cardano-community/koios-go-client/v4"
+	flag "github.com/spf13/pflag"
+
 	"github.com/safanaj/go-f2lb/pkg/utils"
 )
 
 var useKoiosCachedInfo = true
 
+var (
+	authToken        string
+	requestTimeout   = 2 * time.Minute
+	rateLimit        = 10.0
+	rateBurst        = 10
+	maxRetries       = 3
+	retryBackoff     = 500 * time.Millisecond
+	retryBackoffMax  = 30 * time.Second
+	breakerThreshold = 5
+	breakerCooldown  = time.Minute
+	maxChunkSize     = 50
+)
+
+func AddFlags(fs *flag.FlagSet) {
+	koiosFlagSet := flag.NewFlagSet("koios", flag.ExitOnError)
+	koiosFlagSet.StringVar(&authToken, "koios-token", "", "Bearer token of a registered Koios tier")
+	koiosFlagSet.DurationVar(&requestTimeout, "koios-request-timeout", requestTimeout, "Timeout of a request including the retries")
+	koiosFlagSet.Float64Var(&rateLimit, "koios-rate-limit", rateLimit, "Requests per second sent to Koios by all the clients")
+	koiosFlagSet.IntVar(&rateBurst, "koios-rate-burst", rateBurst, "")
+	koiosFlagSet.IntVar(&maxRetries, "koios-max-retries", maxRetries, "Retries of the requests failed by 429, 5xx or the network")
+	koiosFlagSet.DurationVar(&retryBackoff, "koios-retry-backoff", retryBackoff, "")
+	koiosFlagSet.DurationVar(&retryBackoffMax, "koios-retry-backoff-max", retryBackoffMax, "")
+	koiosFlagSet.IntVar(&breakerThreshold, "koios-circuit-breaker-threshold", breakerThreshold,
+		"Consecutive failed requests opening the circuit breaker, 0 to disable it")
+	koiosFlagSet.DurationVar(&breakerCooldown, "koios-circuit-breaker-cooldown", breakerCooldown, "")
+	koiosFlagSet.IntVar(&maxChunkSize, "koios-max-chunk-size", maxChunkSize, "Maximum number of pools or accounts in a bulk request")
+	koiosFlagSet.DurationVar(&poolInfoTTL, "koios-cache-pool-info-ttl", poolInfoTTL, "")
+	koiosFlagSet.DurationVar(&accountInfoTTL, "koios-cache-account-info-ttl", accountInfoTTL, "")
+	koiosFlagSet.DurationVar(&txMetadataTTL, "koios-cache-tx-metadata-ttl", txMetadataTTL, "")
+	koiosFlagSet.DurationVar(&delegationTxTTL, "koios-cache-delegation-tx-ttl", delegationTxTTL, "")
+	fs.AddFlagSet(koiosFlagSet)
+}
+
+// all the clients share the rate limit, the circuit breaker and the chunk sizes, they are created on the first New
+var (
+	sharedOnce         sync.Once
+	sharedTransport    *resilientTransport
+	poolsChunkSizer    *chunkSizer
+	accountsChunkSizer *chunkSizer
+)
+
 type Relay = koios.Relay
 
 type KoiosClient struct {
@@ -22,10 +67,42 @@ type KoiosClient struct {
 }
 
 func New(ctx context.Context) *KoiosClient {
-	k, _ := koios.New()
+	sharedOnce.Do(func() {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConns = 100
+		t.MaxConnsPerHost = 100
+		t.MaxIdleConnsPerHost = 100
+		sharedTransport = newResilientTransport(t)
+		poolsChunkSizer = newChunkSizer(maxChunkSize)
+		accountsChunkSizer = newChunkSizer(maxChunkSize)
+	})
+	// the limiter of the library refills one request per second, the shared transport does the rate limiting
+	k, err := koios.New(
+		koios.HTTPClient(&http.Client{Transport: sharedTransport, Timeout: requestTimeout}),
+		koios.RateLimit(255),
+	)
+	utils.CheckErr(err)
+	if authToken != "" {
+		utils.CheckErr(k.SetAuth(authToken))
+	}
 	return &KoiosClient{ctx: ctx, k: k}
 }
 
+// Stats returns the counters of the requests and the state of the circuit breaker, shared by all the clients
+func (kc *KoiosClient) Stats() Stats {
+	s := sharedTransport.stats()
+	s.PoolsChunkSize = poolsChunkSizer.Size()
+	s.AccountsChunkSize = accountsChunkSizer.Size()
+	s.Cache = sharedCache.Stats()
+	if authToken != "" {
+		if ai, err := koios.GetTokenAuthInfo(authToken); err == nil {
+			s.Authenticated = true
+			s.Tier = ai.Tier.String()
+		}
+	}
+	return s
+}
+
 func (kc *KoiosClient) GetKoiosClient() *koios.Client { return kc.k }
 func (kc *KoiosClient) GetContext() context.Context   { return kc.ctx }
 
@@ -105,8 +182,8 @@ func (kc *KoiosClient) GetTickerToPoolIdMapFor(tickers ...string) (map[string]st
 type PoolInfo struct {
 	Bech32         string
 	Ticker         string
-	ActiveStake    uint32
-	LiveStake      uint32
+	ActiveStake    utils.Lovelace
+	LiveStake      utils.Lovelace
 	LiveDelegators uint32
 	VrfKeyHash     string
 	IsRetired      bool
@@ -119,13 +196,18 @@ func (kc *KoiosClient) GetPoolsInfos(bech32PoolIds ...string) (map[string]*PoolI
 	if len(bech32PoolIds) == 0 {
 		return nil, nil
 	}
-	var err error
 	res := make(map[string]*PoolInfo)
 	pids := make([]koios.PoolID, 0, len(bech32PoolIds))
 	for _, pid := range bech32PoolIds {
 		pids = append(pids, koios.PoolID(pid))
 	}
+	err := inChunks(kc.ctx, poolsChunkSizer, pids, func(chunk []koios.PoolID) error {
+		return kc.getPoolsInfos(chunk, res)
+	})
+	return res, err
+}
 
+func (kc *KoiosClient) getPoolsInfos(pids []koios.PoolID, res map[string]*PoolInfo) error {
 	page := uint(1)
 	opts_ := kc.k.NewRequestOptions()
 
@@ -139,10 +221,9 @@ func (kc *KoiosClient) GetPoolsInfos(bech32PoolIds ...string) (map[string]*PoolI
 		opts := opts_.Clone()
 		opts.SetCurrentPage(page)
 		page = page + 1
-		pools, ierr := kc.k.GetPoolInfos(kc.ctx, pids, opts)
-		if ierr != nil || len(pools.Data) == 0 {
-			err = ierr
-			break
+		pools, err := kc.k.GetPoolInfos(kc.ctx, pids, opts)
+		if err != nil || len(pools.Data) == 0 {
+			return err
 		}
 
 		for _, p := range pools.Data {
@@ -152,8 +233,8 @@ func (kc *KoiosClient) GetPoolsInfos(bech32PoolIds ...string) (map[string]*PoolI
 			pi := &PoolInfo{
 				Bech32:         string(p.PoolIDBech32),
 				Ticker:         *p.MetaJSON.Ticker,
-				ActiveStake:    uint32(p.ActiveStake.Shift(-6).IntPart()),
-				LiveStake:      uint32(p.LiveStake.Shift(-6).IntPart()),
+				ActiveStake:    utils.Lovelace(p.ActiveStake.IntPart()),
+				LiveStake:      utils.Lovelace(p.LiveStake.IntPart()),
 				LiveDelegators: uint32(p.LiveDelegators),
 				VrfKeyHash:     string(p.VrfKeyHash),
 				IsRetired:      (p.RetiringEpoch != nil && currentEpoch > *p.RetiringEpoch) || p.PoolStatus == "retired",
@@ -166,31 +247,35 @@ func (kc *KoiosClient) GetPoolsInfos(bech32PoolIds ...string) (map[string]*PoolI
 		}
 
 		if IsResponseComplete(pools.Response) {
-			break
+			return nil
 		}
 	}
-	return res, err
 }
 
 type AccountInfo struct {
 	Bech32        string
 	DelegatedPool string
 	Status        string
-	TotalAda      uint32
+	TotalBalance  utils.Lovelace
 }
 
 func (kc *KoiosClient) GetStakeAddressesInfos(stakeAddrs ...string) (map[string]*AccountInfo, error) {
 	if len(stakeAddrs) == 0 {
 		return nil, nil
 	}
-	var err error
 	res := make(map[string]*AccountInfo)
 	saddrs := make([]koios.Address, 0, len(stakeAddrs))
 
 	for _, saddr := range stakeAddrs {
 		saddrs = append(saddrs, koios.Address(saddr))
 	}
+	err := inChunks(kc.ctx, accountsChunkSizer, saddrs, func(chunk []koios.Address) error {
+		return kc.getStakeAddressesInfos(chunk, res)
+	})
+	return res, err
+}
 
+func (kc *KoiosClient) getStakeAddressesInfos(saddrs []koios.Address, res map[string]*AccountInfo) error {
 	page := uint(1)
 	opts_ := kc.k.NewRequestOptions()
 
@@ -202,17 +287,16 @@ func (kc *KoiosClient) GetStakeAddressesInfos(stakeAddrs ...string) (map[string]
 		page = page + 1
 		var (
 			infos *koios.AccountsInfoResponse
-			ierr  error
+			err   error
 		)
 
 		if useKoiosCachedInfo {
-			infos, ierr = kc.k.GetAccountInfoCached(kc.ctx, saddrs, opts)
+			infos, err = kc.k.GetAccountInfoCached(kc.ctx, saddrs, opts)
 		} else {
-			infos, ierr = kc.k.GetAccountInfo(kc.ctx, saddrs, opts)
+			infos, err = kc.k.GetAccountInfo(kc.ctx, saddrs, opts)
 		}
-		if ierr != nil || len(infos.Data) == 0 {
-			err = ierr
-			break
+		if err != nil || len(infos.Data) == 0 {
+			return err
 		}
 
 		for _, i := range infos.Data {
@@ -227,20 +311,19 @@ func (kc *KoiosClient) GetStakeAddressesInfos(stakeAddrs ...string) (map[string]
 				Bech32:        string(i.StakeAddress),
 				DelegatedPool: dp,
 				Status:        i.Status,
-				TotalAda:      uint32(i.TotalBalance.Shift(-6).IntPart()),
+				TotalBalance:  utils.Lovelace(i.TotalBalance.IntPart()),
 			}
 
 			res[ai.Bech32] = ai
 		}
 
 		if IsResponseComplete(infos.Response) {
-			break
+			return nil
 		}
 	}
-	return res, err
 }
 
-func (kc *KoiosClient) GetStakeAddressInfo(stakeAddr string) (delegatedPool string, totalAda uint32, err error) {
+func (kc *KoiosClient) GetStakeAddressInfo(stakeAddr string) (delegatedPool string, totalBalance utils.Lovelace, err error) {
 	var (
 		infos *koios.AccountsInfoResponse
 		info  koios.AccountInfo
@@ -261,7 +344,7 @@ func (kc *KoiosClient) GetStakeAddressInfo(stakeAddr string) (delegatedPool stri
 		if info.DelegatedPool != nil && *info.DelegatedPool != "" {
 			delegatedPool = string(*info.DelegatedPool)
 		}
-		totalAda = uint32(info.TotalBalance.Shift(-6).IntPart())
+		totalBalance = utils.Lovelace(info.TotalBalance.IntPart())
 	}
 	return
 }
@@ -0,0 +1,271 @@
+package koiosutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// states of the circuit breaker
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitOpenErr is returned without contacting koios while the circuit breaker is open
+var CircuitOpenErr = errors.New("koios circuit breaker is open")
+
+// EndpointStats are the counters of the requests to a koios endpoint, Retries are the attempts after the first one
+// and RateLimited the responses with status 429
+type EndpointStats struct {
+	Endpoint      string        `json:"endpoint"`
+	Requests      uint64        `json:"requests"`
+	Retries       uint64        `json:"retries"`
+	Failures      uint64        `json:"failures"`
+	RateLimited   uint64        `json:"rate_limited"`
+	LastStatus    int           `json:"last_status"`
+	LastError     string        `json:"last_error,omitempty"`
+	LastRequestAt time.Time     `json:"last_request_at"`
+	AvgLatency    time.Duration `json:"avg_latency"`
+
+	totalLatency time.Duration
+}
+
+// Stats is the status of the resilience layer and of the response cache shared by all the koios clients
+type Stats struct {
+	Circuit             string          `json:"circuit"`
+	CircuitOpenedAt     time.Time       `json:"circuit_opened_at"`
+	ConsecutiveFailures uint32          `json:"consecutive_failures"`
+	RateLimit           float64         `json:"rate_limit"`
+	Authenticated       bool            `json:"authenticated"`
+	Tier                string          `json:"tier,omitempty"`
+	PoolsChunkSize      int             `json:"pools_chunk_size"`
+	AccountsChunkSize   int             `json:"accounts_chunk_size"`
+	Endpoints           []EndpointStats `json:"endpoints"`
+	Cache               []CacheStats    `json:"cache"`
+}
+
+// resilientTransport rate limits the requests to koios, retries the ones failed by 429/5xx or by the network
+// with a jittered exponential backoff and stops sending them for a while after too many consecutive failures
+type resilientTransport struct {
+	next    http.RoundTripper
+	limiter *rate.Limiter
+
+	maxRetries       int
+	backoffBase      time.Duration
+	backoffMax       time.Duration
+	breakerThreshold uint32
+	breakerCooldown  time.Duration
+
+	mu                  sync.Mutex
+	consecutiveFailures uint32
+	openedAt            time.Time
+	probing             bool
+	endpoints           map[string]*EndpointStats
+}
+
+func newResilientTransport(next http.RoundTripper) *resilientTransport {
+	return &resilientTransport{
+		next:             next,
+		limiter:          rate.NewLimiter(rate.Limit(rateLimit), rateBurst),
+		maxRetries:       maxRetries,
+		backoffBase:      retryBackoff,
+		backoffMax:       retryBackoffMax,
+		breakerThreshold: uint32(breakerThreshold),
+		breakerCooldown:  breakerCooldown,
+		endpoints:        make(map[string]*EndpointStats),
+	}
+}
+
+// retryable tells if the attempt is worth repeating, 4xx but 429 are not
+func retryable(rsp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode >= http.StatusInternalServerError
+}
+
+// backoff is a full jitter exponential delay, a Retry-After header of a 429 is the minimum
+func (t *resilientTransport) backoff(attempt int, rsp *http.Response) time.Duration {
+	d := t.backoffBase << attempt
+	if d <= 0 || d > t.backoffMax {
+		d = t.backoffMax
+	}
+	d = time.Duration(rand.Int63n(int64(d) + 1))
+	if rsp != nil && rsp.StatusCode == http.StatusTooManyRequests {
+		if secs, err := strconv.Atoi(rsp.Header.Get("Retry-After")); err == nil {
+			if ra := time.Duration(secs) * time.Second; ra > d {
+				d = ra
+			}
+		}
+	}
+	return d
+}
+
+// allow tells if a request can be sent, after the cooldown a single probe is let through the open circuit.
+// The probe has to be released once its outcome is recorded
+func (t *resilientTransport) allow() (ok, probe bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.openedAt.IsZero() {
+		return true, false
+	}
+	if t.probing || time.Since(t.openedAt) < t.breakerCooldown {
+		return false, false
+	}
+	t.probing = true
+	return true, true
+}
+
+func (t *resilientTransport) releaseProbe() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.probing = false
+}
+
+func (t *resilientTransport) record(endpoint string, rsp *http.Response, err error, retries int, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	es, ok := t.endpoints[endpoint]
+	if !ok {
+		es = &EndpointStats{Endpoint: endpoint}
+		t.endpoints[endpoint] = es
+	}
+	es.Requests++
+	es.Retries += uint64(retries)
+	es.LastRequestAt = time.Now()
+	es.totalLatency += latency
+	es.AvgLatency = es.totalLatency / time.Duration(es.Requests)
+	es.LastError = ""
+	if rsp != nil {
+		es.LastStatus = rsp.StatusCode
+	}
+	// a canceled request says nothing about koios, a timed out one is a failure
+	canceled := errors.Is(err, context.Canceled)
+	failed := !canceled && (errors.Is(err, context.DeadlineExceeded) || retryable(rsp, err))
+	if err != nil {
+		es.LastStatus = 0
+		es.LastError = err.Error()
+	}
+	if failed {
+		es.Failures++
+	}
+
+	if canceled {
+		return
+	}
+	if !failed {
+		t.consecutiveFailures = 0
+		t.openedAt = time.Time{}
+		return
+	}
+	t.consecutiveFailures++
+	if t.breakerThreshold > 0 && t.consecutiveFailures >= t.breakerThreshold {
+		// also a failed probe restarts the cooldown
+		t.openedAt = time.Now()
+	}
+}
+
+func (t *resilientTransport) countRateLimited(endpoint string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if es, ok := t.endpoints[endpoint]; ok {
+		es.RateLimited++
+	} else {
+		t.endpoints[endpoint] = &EndpointStats{Endpoint: endpoint, RateLimited: 1}
+	}
+}
+
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := path.Base(req.URL.Path)
+	ok, probe := t.allow()
+	if !ok {
+		return nil, CircuitOpenErr
+	}
+	if probe {
+		defer t.releaseProbe()
+	}
+
+	// the koios client streams the bodies from a pipe, they are kept to be sent again
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	var (
+		rsp     *http.Response
+		err     error
+		attempt int
+		start   = time.Now()
+	)
+	for ; ; attempt++ {
+		if err := t.limiter.Wait(req.Context()); err != nil {
+			// not sent, only the context can fail it
+			return nil, err
+		}
+		r := req.Clone(req.Context())
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+		}
+		rsp, err = t.next.RoundTrip(r)
+		if err == nil && rsp.StatusCode == http.StatusTooManyRequests {
+			t.countRateLimited(endpoint)
+		}
+		if attempt >= t.maxRetries || !retryable(rsp, err) {
+			break
+		}
+		d := t.backoff(attempt, rsp)
+		if rsp != nil {
+			io.Copy(io.Discard, rsp.Body)
+			rsp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			t.record(endpoint, nil, req.Context().Err(), attempt, time.Since(start))
+			return nil, req.Context().Err()
+		case <-time.After(d):
+		}
+	}
+	t.record(endpoint, rsp, err, attempt, time.Since(start))
+	return rsp, err
+}
+
+func (t *resilientTransport) stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := Stats{
+		Circuit:             CircuitClosed,
+		CircuitOpenedAt:     t.openedAt,
+		ConsecutiveFailures: t.consecutiveFailures,
+		RateLimit:           float64(t.limiter.Limit()),
+		Endpoints:           make([]EndpointStats, 0, len(t.endpoints)),
+	}
+	if !t.openedAt.IsZero() {
+		s.Circuit = CircuitOpen
+		if t.probing || time.Since(t.openedAt) >= t.breakerCooldown {
+			s.Circuit = CircuitHalfOpen
+		}
+	}
+	for _, es := range t.endpoints {
+		s.Endpoints = append(s.Endpoints, *es)
+	}
+	sort.Slice(s.Endpoints, func(i, j int) bool { return s.Endpoints[i].Endpoint < s.Endpoints[j].Endpoint })
+	return s
+}
@@ -0,0 +1,235 @@
+package koiosutils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func statusRoundTrip(status *int, calls *int) roundTripFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		*calls++
+		w := httptest.NewRecorder()
+		w.WriteHeader(*status)
+		return w.Result(), nil
+	}
+}
+
+func newTestResilientTransport(next http.RoundTripper) *resilientTransport {
+	return &resilientTransport{
+		next:             next,
+		limiter:          rate.NewLimiter(rate.Inf, 1),
+		backoffBase:      time.Millisecond,
+		backoffMax:       time.Millisecond,
+		breakerThreshold: 2,
+		breakerCooldown:  time.Hour,
+		endpoints:        make(map[string]*EndpointStats),
+	}
+}
+
+func sendTestRequest(t *testing.T, rt http.RoundTripper, ctx context.Context) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://koios.example/api/v1/tip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt.RoundTrip(req)
+}
+
+// endCooldown moves the opening of the circuit in the past, so the next request is the probe
+func endCooldown(rt *resilientTransport) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.openedAt = time.Now().Add(-2 * rt.breakerCooldown)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	status, calls := http.StatusInternalServerError, 0
+	rt := newTestResilientTransport(statusRoundTrip(&status, &calls))
+
+	for i := 0; i < 2; i++ {
+		if _, err := sendTestRequest(t, rt, context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := rt.stats(); s.Circuit != CircuitOpen || s.ConsecutiveFailures != 2 {
+		t.Fatalf("expected an open circuit after 2 failures, got %s with %d failures", s.Circuit, s.ConsecutiveFailures)
+	}
+	if _, err := sendTestRequest(t, rt, context.Background()); !errors.Is(err, CircuitOpenErr) {
+		t.Fatalf("expected %v, got %v", CircuitOpenErr, err)
+	}
+	if calls != 2 {
+		t.Fatalf("koios contacted with an open circuit, %d calls", calls)
+	}
+
+	// a failed probe opens the circuit again
+	endCooldown(rt)
+	if s := rt.stats(); s.Circuit != CircuitHalfOpen {
+		t.Fatalf("expected an half-open circuit after the cooldown, got %s", s.Circuit)
+	}
+	if _, err := sendTestRequest(t, rt, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s := rt.stats(); s.Circuit != CircuitOpen || calls != 3 {
+		t.Fatalf("expected an open circuit after a failed probe, got %s with %d calls", s.Circuit, calls)
+	}
+
+	// a successful probe closes it
+	endCooldown(rt)
+	status = http.StatusOK
+	if _, err := sendTestRequest(t, rt, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s := rt.stats(); s.Circuit != CircuitClosed || s.ConsecutiveFailures != 0 || !s.CircuitOpenedAt.IsZero() {
+		t.Fatalf("expected a closed circuit after a successful probe, got %+v", s)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	rt := newTestResilientTransport(nil)
+	rt.openedAt = time.Now()
+	if ok, _ := rt.allow(); ok {
+		t.Fatalf("request allowed during the cooldown")
+	}
+
+	endCooldown(rt)
+	if ok, probe := rt.allow(); !ok || !probe {
+		t.Fatalf("expected a probe after the cooldown, got ok=%v probe=%v", ok, probe)
+	}
+	if ok, _ := rt.allow(); ok {
+		t.Fatalf("a second request allowed while probing")
+	}
+	rt.releaseProbe()
+	if ok, probe := rt.allow(); !ok || !probe {
+		t.Fatalf("expected a new probe once released, got ok=%v probe=%v", ok, probe)
+	}
+}
+
+func TestCircuitBreakerCanceledProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	rt := newTestResilientTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		// canceled while waiting for the retry
+		cancel()
+		w := httptest.NewRecorder()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return w.Result(), nil
+	}))
+	rt.maxRetries = 3
+	rt.backoffBase, rt.backoffMax = time.Hour, time.Hour
+	rt.openedAt = time.Now()
+	rt.consecutiveFailures = rt.breakerThreshold
+	endCooldown(rt)
+
+	if _, err := sendTestRequest(t, rt, ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	// neither a success nor stuck probing: the next request is a new probe
+	if s := rt.stats(); s.Circuit != CircuitHalfOpen || s.ConsecutiveFailures != rt.breakerThreshold {
+		t.Fatalf("expected the circuit still half-open, got %+v", s)
+	}
+	if ok, probe := rt.allow(); !ok || !probe {
+		t.Fatalf("expected a new probe after a canceled one, got ok=%v probe=%v", ok, probe)
+	}
+}
+
+func TestCircuitBreakerCanceledRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rt := newTestResilientTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, req.Context().Err()
+	}))
+	rt.consecutiveFailures = 1
+
+	sendTestRequest(t, rt, ctx)
+	if s := rt.stats(); s.ConsecutiveFailures != 1 {
+		t.Fatalf("a canceled request changed the consecutive failures to %d", s.ConsecutiveFailures)
+	}
+}
+
+func TestChunkSizer(t *testing.T) {
+	cs := newChunkSizer(100)
+	if !cs.shrink(100) || cs.Size() != 50 {
+		t.Fatalf("expected 50 after a failed chunk of 100, got %d", cs.Size())
+	}
+	// a failure of a larger chunk sent before the shrink does not grow it
+	if !cs.shrink(80) || cs.Size() != 40 {
+		t.Fatalf("expected 40, got %d", cs.Size())
+	}
+	if cs.shrink(1) {
+		t.Fatalf("a single item can not be shrunk")
+	}
+	for _, want := range []int{45, 50, 56} {
+		if cs.grow(); cs.Size() != want {
+			t.Fatalf("expected %d after growing, got %d", want, cs.Size())
+		}
+	}
+	for i := 0; i < 20; i++ {
+		cs.grow()
+	}
+	if cs.Size() != 100 {
+		t.Fatalf("expected the size back to the maximum, got %d", cs.Size())
+	}
+	if newChunkSizer(0).Size() != 1 {
+		t.Fatalf("expected a minimum size of 1")
+	}
+}
+
+func TestInChunks(t *testing.T) {
+	items := make([]int, 10)
+	for i := range items {
+		items[i] = i
+	}
+
+	// the chunks larger than 3 items fail
+	cs := newChunkSizer(8)
+	var got []int
+	err := inChunks(context.Background(), cs, items, func(chunk []int) error {
+		if len(chunk) > 3 {
+			return errors.New("too large")
+		}
+		got = append(got, chunk...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(items) {
+		t.Fatalf("expected all the items once, got %v", got)
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("items out of order %v", got)
+		}
+	}
+
+	// a single item failing is returned
+	failErr := errors.New("bad item")
+	if err := inChunks(context.Background(), newChunkSizer(4), items, func(chunk []int) error {
+		for _, v := range chunk {
+			if v == 5 {
+				return failErr
+			}
+		}
+		return nil
+	}); !errors.Is(err, failErr) {
+		t.Fatalf("expected %v, got %v", failErr, err)
+	}
+
+	// the open circuit is returned without shrinking
+	cs = newChunkSizer(4)
+	if err := inChunks(context.Background(), cs, items, func([]int) error { return CircuitOpenErr }); !errors.Is(err, CircuitOpenErr) || cs.Size() != 4 {
+		t.Fatalf("expected %v and the size unchanged, got %v and %d", CircuitOpenErr, err, cs.Size())
+	}
+}
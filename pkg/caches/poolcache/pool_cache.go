@@ -2,19 +2,16 @@ package poolcache
 
 import (
 	"bytes"
-	"context"
 	"encoding"
-	"encoding/gob"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
+	"sort"
 	"sync"
-	"sync/atomic"
 	"time"
-	"unsafe"
 
 	// koios "github.com/cardano-community/koios-go-client"
+	"github.com/safanaj/go-f2lb/pkg/caches/cache"
+	"github.com/safanaj/go-f2lb/pkg/caches/cachestore"
+	"github.com/safanaj/go-f2lb/pkg/caches/chainprovider"
 	ku "github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
 	"github.com/safanaj/go-f2lb/pkg/logging"
 	"github.com/safanaj/go-f2lb/pkg/utils"
@@ -23,6 +20,13 @@ import (
 const (
 	DefaultRefreshIntervalSeconds = time.Duration(10 * time.Minute)
 	poolIdPrefix                  = "pool1"
+
+	storeFileName = "poolcache.store"
+	// the file of the gob encoder, the stakes are in ADA
+	legacyStoreFileName = "poolcache.gob"
+	// 1: the lines of the legacy gob file, in ADA
+	// 2: quoted strings with retirement, margin and last update time
+	storeSchemaVersion = 2
 )
 
 func isTickerOrPoolIdBech32_a_PoolId(s string) bool {
@@ -32,6 +36,8 @@ func isTickerOrPoolIdBech32_a_PoolId(s string) bool {
 type (
 	PoolCache interface {
 		Add(string)
+		// AddNow is Add without waiting for a batch, for the pools needed on demand
+		AddNow(string)
 		AddMany([]any)
 		Del(string)
 		DelMany([]string)
@@ -43,12 +49,12 @@ type (
 		Ready() bool
 		WaitReady(time.Duration) bool
 		IsRunning() bool
+		Stats() cache.Stats
 		Start()
 		Stop()
 		WithOptions(
-			kc *ku.KoiosClient,
+			cp chainprovider.ChainProvider,
 			workers uint32,
-			cacheSyncers uint32,
 			refreshInterval time.Duration,
 			workersInterval time.Duration,
 			poolInfosToGet uint32,
@@ -64,14 +70,15 @@ type (
 		IdBech32() string
 		IdHex() string
 		VrfKeyHash() string
-		ActiveStake() uint32
-		LiveStake() uint32
+		ActiveStake() utils.Lovelace
+		LiveStake() utils.Lovelace
 		LiveDelegators() uint32
 		BlockHeight() uint32
 		SetBlockHeight(uint32)
 		IsRetired() bool
 		Relays() []ku.Relay
 		Margin() float32
+		LastUpdated() time.Time
 	}
 
 	MinimalPoolInfo struct {
@@ -84,14 +91,15 @@ type poolInfo struct {
 	ticker         string
 	bech32         string
 	hex            string
-	activeStake    uint32
-	liveStake      uint32
+	activeStake    utils.Lovelace
+	liveStake      utils.Lovelace
 	liveDelegators uint32
 	vrfKeyHash     string
 	blockHeight    uint32
 	isRetired      bool
 	relays         []ku.Relay
 	margin         float32
+	lastUpdated    time.Time
 }
 
 var (
@@ -99,807 +107,325 @@ var (
 	_ encoding.BinaryUnmarshaler = (*poolInfo)(nil)
 )
 
-func (pi *poolInfo) Ticker() string          { return pi.ticker }
-func (pi *poolInfo) IdBech32() string        { return pi.bech32 }
-func (pi *poolInfo) IdHex() string           { return pi.hex }
-func (pi *poolInfo) VrfKeyHash() string      { return pi.vrfKeyHash }
-func (pi *poolInfo) ActiveStake() uint32     { return pi.activeStake }
-func (pi *poolInfo) LiveStake() uint32       { return pi.liveStake }
-func (pi *poolInfo) LiveDelegators() uint32  { return pi.liveDelegators }
-func (pi *poolInfo) BlockHeight() uint32     { return pi.blockHeight }
-func (pi *poolInfo) SetBlockHeight(h uint32) { pi.blockHeight = h }
-func (pi *poolInfo) IsRetired() bool         { return pi.isRetired }
-func (pi *poolInfo) Relays() []ku.Relay      { return pi.relays }
-func (pi *poolInfo) Margin() float32         { return pi.margin }
-
+func (pi *poolInfo) Ticker() string              { return pi.ticker }
+func (pi *poolInfo) IdBech32() string            { return pi.bech32 }
+func (pi *poolInfo) IdHex() string               { return pi.hex }
+func (pi *poolInfo) VrfKeyHash() string          { return pi.vrfKeyHash }
+func (pi *poolInfo) ActiveStake() utils.Lovelace { return pi.activeStake }
+func (pi *poolInfo) LiveStake() utils.Lovelace   { return pi.liveStake }
+func (pi *poolInfo) LiveDelegators() uint32      { return pi.liveDelegators }
+func (pi *poolInfo) BlockHeight() uint32         { return pi.blockHeight }
+func (pi *poolInfo) SetBlockHeight(h uint32)     { pi.blockHeight = h }
+func (pi *poolInfo) IsRetired() bool             { return pi.isRetired }
+func (pi *poolInfo) Relays() []ku.Relay          { return pi.relays }
+func (pi *poolInfo) Margin() float32             { return pi.margin }
+func (pi *poolInfo) LastUpdated() time.Time      { return pi.lastUpdated }
+func (pi *poolInfo) SetLastUpdated(t time.Time)  { pi.lastUpdated = t }
+
+// the strings are quoted as they can be empty, the relays and the block height are not stored
 func (pi *poolInfo) MarshalBinary() (data []byte, err error) {
 	var buf bytes.Buffer
-	_, err = fmt.Fprintln(&buf, pi.ticker, pi.bech32, pi.hex, pi.activeStake, pi.liveStake, pi.liveDelegators, pi.vrfKeyHash)
+	_, err = fmt.Fprintf(&buf, "%q %q %q %d %d %d %q %t %g %d\n", pi.ticker, pi.bech32, pi.hex,
+		uint64(pi.activeStake), uint64(pi.liveStake), pi.liveDelegators, pi.vrfKeyHash, pi.isRetired, pi.margin,
+		pi.lastUpdated.Unix())
 	return buf.Bytes(), err
 }
 
 func (pi *poolInfo) UnmarshalBinary(data []byte) error {
-	buf := bytes.NewBuffer(data)
-	_, err := fmt.Fscanln(buf, &pi.ticker, &pi.bech32, &pi.hex, &pi.activeStake, &pi.liveStake, &pi.liveDelegators, &pi.vrfKeyHash)
-	return err
-}
-
-type poolCache struct {
-	logging.Logger
-
-	refreshInterval    time.Duration
-	refresherTick      *time.Ticker
-	refresherFinished  chan any
-	refresherCtxCancel func()
-
-	resetCountsMu sync.RWMutex
-	addeditems    uint32
-	nitems        uint32
-	cache         *sync.Map
-	// keys are bech32 ids
-	cache2 *sync.Map
-
-	cachesStoreDir string
-
-	kc *ku.KoiosClient
-
-	running bool
-
-	cacheSyncers  uint32
-	cacheSyncerWg sync.WaitGroup
-	infoCh        chan any
-
-	workers   uint32
-	workersWg sync.WaitGroup
-	workersCh chan any
-
-	workersCtxCancel func()
-	workersInterval  time.Duration
-	poolInfosToGet   uint32
-
-	readyWaiterCh chan any
-
-	missingFinished        chan any
-	missingCtxCancel       func()
-	missingCh              chan any
-	missingMu              sync.RWMutex
-	missingTickersFromList map[string]any
-}
-
-var (
-	_ PoolCache      = (*poolCache)(nil)
-	_ gob.GobEncoder = (*poolCache)(nil)
-	_ gob.GobDecoder = (*poolCache)(nil)
-)
-
-func (pc *poolCache) cacheSyncer() {
-	maybeNotifyWaiter := func(pc *poolCache) {
-		chp := unsafe.Pointer(&pc.readyWaiterCh)
-		ch := *((*chan any)(atomic.LoadPointer(&chp)))
-		if ch != nil {
-			select {
-			case <-ch:
-			default:
-				if pc.Ready() {
-					select {
-					case <-ch:
-					default:
-						close(ch)
-					}
-				}
-			}
-		}
+	var lastUpdated int64
+	_, err := fmt.Sscanf(string(bytes.TrimSpace(data)), "%q %q %q %d %d %d %q %t %g %d", &pi.ticker, &pi.bech32, &pi.hex,
+		&pi.activeStake, &pi.liveStake, &pi.liveDelegators, &pi.vrfKeyHash, &pi.isRetired, &pi.margin, &lastUpdated)
+	if err != nil {
+		return err
 	}
-
-	defer pc.cacheSyncerWg.Done()
-	for ai := range pc.infoCh {
-		pc.V(3).Info("cache syncer", "got", ai)
-		pc.resetCountsMu.RLock()
-		switch v := ai.(type) {
-		case string:
-			// delete from cache
-			if old, ok := pc.cache.LoadAndDelete(v); ok {
-				atomic.AddUint32(&pc.nitems, ^uint32(0))
-				pc.V(2).Info("cache syncer deleted", "item", v, "now len", pc.Len(),
-					"addeditems", pc.addeditems, "nitems", pc.nitems)
-				pc.cache2.Delete(old.(*poolInfo).bech32)
-			}
-			maybeNotifyWaiter(pc)
-		case *poolInfo:
-			// add to cache
-			if old, loaded := pc.cache.LoadOrStore(v.ticker, v); loaded {
-				// check for overridable fields, better an old value than a zero value
-				if v.activeStake == 0 {
-					v.activeStake = old.(*poolInfo).activeStake
-				}
-				if v.liveStake == 0 {
-					v.liveStake = old.(*poolInfo).liveStake
-				}
-				if v.liveDelegators == 0 {
-					v.liveDelegators = old.(*poolInfo).liveDelegators
-				}
-				if v.vrfKeyHash == "" {
-					v.vrfKeyHash = old.(*poolInfo).vrfKeyHash
-				}
-				if v.margin == 0 {
-					v.margin = old.(*poolInfo).margin
-				}
-				if v.relays == nil {
-					v.relays = old.(*poolInfo).relays
-				}
-				pc.cache.Store(v.ticker, v)
-				pc.cache2.Store(v.bech32, v)
-			} else {
-				atomic.AddUint32(&pc.nitems, uint32(1))
-				pc.cache2.Store(v.bech32, v)
-				if pc.nitems > pc.addeditems {
-					atomic.StoreUint32(&pc.addeditems, pc.nitems)
-				}
-				pc.V(2).Info("cache syncer added", "item", v.ticker, "now len", pc.Len(),
-					"addeditems", pc.addeditems, "nitems", pc.nitems)
-			}
-			maybeNotifyWaiter(pc)
-		}
-		pc.resetCountsMu.RUnlock()
+	if lastUpdated > 0 {
+		pi.lastUpdated = time.Unix(lastUpdated, 0)
 	}
+	return nil
 }
 
-func (c *poolCache) GobEncode() ([]byte, error) {
-	if c.running {
-		return nil, fmt.Errorf("Impossible GOB encode a running pool cache")
-	}
-	if c.cache == nil {
-		return nil, fmt.Errorf("Impossible GOB encode an uninitialized pool cache")
-	}
-	var buf bytes.Buffer
-	var err error
-	c.cache.Range(func(k, v any) bool {
-		if dat, e := v.(encoding.BinaryMarshaler).MarshalBinary(); e != nil {
-			err = e
-			return false
-		} else {
-			if _, e := buf.Write(dat); e != nil {
-				err = e
-				return false
+// migrateStoreV1 converts the lines of the legacy gob file, the stakes are in ADA.
+// The malformed lines are skipped, the pools are fetched again
+func migrateStoreV1(logger logging.Logger) cachestore.Migration {
+	return func(dat []byte) ([]byte, error) {
+		var buf bytes.Buffer
+		for _, line := range bytes.Split(dat, []byte{'\n'}) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
 			}
-			return true
-		}
-	})
-	return buf.Bytes(), err
-}
-
-func (c *poolCache) GobDecode(dat []byte) error {
-	if c.running {
-		return fmt.Errorf("Impossible GOB decode a running pool cache")
-	}
-	if c.cache == nil {
-		return fmt.Errorf("Impossible GOB decode an uninitialized pool cache")
-	}
-	buf := bytes.NewBuffer(dat)
-	nitems := 0
-	defer func() {
-		atomic.StoreUint32(&c.addeditems, uint32(nitems))
-		atomic.StoreUint32(&c.nitems, uint32(nitems))
-	}()
-	for {
-		if elt, err := buf.ReadBytes(byte('\n')); err == nil || err == io.EOF {
+			var activeStake, liveStake uint64
 			pi := &poolInfo{}
-			if err := pi.UnmarshalBinary(elt); err != nil {
-				return err
-			}
-			c.cache.Store(pi.ticker, pi)
-			nitems++
-			if pi.bech32 != "" {
-				c.cache2.Store(pi.bech32, pi)
+			if _, err := fmt.Sscanln(string(line), &pi.ticker, &pi.bech32, &pi.hex, &activeStake, &liveStake,
+				&pi.liveDelegators, &pi.vrfKeyHash); err != nil {
+				// the vrf key hash is empty when the pool info was not fetched yet
+				pi = &poolInfo{}
+				if _, err := fmt.Sscanln(string(line), &pi.ticker, &pi.bech32, &pi.hex, &activeStake, &liveStake,
+					&pi.liveDelegators); err != nil {
+					logger.Error(err, "skipping malformed legacy store line", "line", string(line))
+					continue
+				}
 			}
-			if err == io.EOF {
-				return err
+			pi.activeStake, pi.liveStake = utils.AdaToLovelace(activeStake), utils.AdaToLovelace(liveStake)
+			if dat, err := pi.MarshalBinary(); err != nil {
+				return nil, err
+			} else {
+				buf.Write(dat)
 			}
-		} else {
-			return err
 		}
+		return buf.Bytes(), nil
 	}
 }
 
-func (c *poolCache) poolListOrPoolInfosGetter(end context.Context) {
-	d := time.Duration(c.workersInterval)
-	t := time.NewTimer(d)
-
-	defer c.workersWg.Done()
-
-	go func() {
-		round := 1
-		ntickers := 0
-		tickers := make(map[string]any)
-		npools := 0
-		poolids := make(map[string]any)
-
-		getAndReset := func(f func() error, resetTarget *map[string]any) {
-			err := f()
-			if err != nil {
-				c.Error(err, "poolCache.getAndReset")
-			}
-			// reset everything
-			round = 0
-			if resetTarget == &poolids {
-				npools = 0
-				poolids = make(map[string]any)
-			}
-			if resetTarget == &tickers {
-				ntickers = 0
-				tickers = make(map[string]any)
-			}
-		}
-
-		getAndResetTickers := func() {
-			getAndReset(func() error {
-				tickers_l := []string{}
-				for t := range tickers {
-					tickers_l = append(tickers_l, t)
-				}
-				if len(tickers_l) == 0 {
-					return nil
-				}
-				t2p, err, _ := c.kc.GetTickerToPoolIdMapFor(tickers_l...)
-				if err != nil {
-					return err
-				}
-				if len(t2p) < len(tickers) {
-					for t := range tickers {
-						if _, ok := t2p[t]; !ok {
-							c.missingCh <- t
-						}
-					}
-				}
-				for t, p := range t2p {
-					c.V(4).Info("GetTickerToPoolIdMapFor: Forwarding poolInfo", "ticker", t, "bech32", p, "hex", utils.Bech32ToHexOrDie(p))
-					pi := &poolInfo{
-						ticker: t,
-						bech32: p,
-						hex:    utils.Bech32ToHexOrDie(p),
-					}
-					c.infoCh <- pi
-					c.workersCh <- pi
-				}
-				return nil
-			}, &tickers)
-		}
-
-		getAndResetPoolInfos := func() {
-			getAndReset(func() error {
-				pids_l := []string{}
-				t2p := make(map[string]string)
-				for pid, pi := range poolids {
-					pids_l = append(pids_l, pid)
-					if pi.(*poolInfo).ticker != "" {
-						t2p[pi.(*poolInfo).ticker] = pid
-					}
-				}
-				if len(pids_l) == 0 {
-					return nil
-				}
-				// do a GetPoolInfos ....
-				c.V(3).Info("GetPoolsInfos: Processing t2p", "len", len(t2p), "poolids len", len(pids_l))
-				p2i, err := c.kc.GetPoolsInfos(pids_l...)
-				if err != nil {
-					return err
-				}
-				c.V(3).Info("GetPoolsInfos: Got p2i", "len", len(p2i), "poolids len", len(pids_l))
-				for p, i := range p2i {
-					c.V(4).Info("GetPoolsInfos (p2i): Forwarding poolInfo", "ticker", i.Ticker, "bech32", p, "hex", utils.Bech32ToHexOrDie(p))
-					pi := &poolInfo{
-						ticker:         i.Ticker,
-						bech32:         p,
-						hex:            utils.Bech32ToHexOrDie(p),
-						activeStake:    i.ActiveStake,
-						liveStake:      i.LiveStake,
-						liveDelegators: i.LiveDelegators,
-						vrfKeyHash:     i.VrfKeyHash,
-						isRetired:      i.IsRetired,
-						relays:         i.Relays,
-						margin:         i.Margin,
-					}
-					c.missingCh <- string(append([]rune{'-'}, []rune(i.Ticker)...))
-					delete(t2p, i.Ticker)
-					c.infoCh <- pi
-				}
-				if len(t2p) > 0 {
-					for t, p := range t2p {
-						c.V(4).Info("GetPoolsInfos (t2p): Forwarding poolInfo", "ticker", t, "bech32", p, "hex", utils.Bech32ToHexOrDie(p))
-						c.missingCh <- string(append([]rune{'-'}, []rune(t)...))
-						c.infoCh <- &poolInfo{
-							ticker: t,
-							bech32: p,
-							hex:    utils.Bech32ToHexOrDie(p),
-						}
-					}
-				}
-				return nil
-			}, &poolids)
-		}
-
-		for {
-			select {
-			case <-end.Done():
-				return
-			case <-t.C:
-				// collect strings
-				if (round > 0 && (len(tickers) == ntickers || len(poolids) == npools)) ||
-					(len(poolids) > int(c.poolInfosToGet) || len(tickers) > int(c.poolInfosToGet)) {
-					if len(tickers) == ntickers || len(tickers) > int(c.poolInfosToGet) {
-						getAndResetTickers()
-					}
-					if len(poolids) == npools || len(poolids) > int(c.poolInfosToGet) {
-						getAndResetPoolInfos()
-					}
-
-				} else {
-					if round == 0 && (len(tickers) == ntickers || len(poolids) == npools) {
-						round++
-					}
-					npools = len(poolids)
-					ntickers = len(tickers)
-				}
-
-				t.Reset(d)
-			case xI, more := <-c.workersCh:
-				if !more {
-					return
-				}
-				c.V(4).Info("worker", "got", xI)
-
-				// in this channel we can recieve a TICKER (string) or poolIdBech32 (string) or a poolInfo{}
-				// in case of TICKER string we need just to list pools in an optimized way to get the Ids of them,
-				// in case of poolInfo or poolIdBech32 (string) we should populate it with additional info other the just the ids
-
-				switch v := xI.(type) {
-				case string:
-					if isTickerOrPoolIdBech32_a_PoolId(v) {
-						if pi, ok := poolids[v]; !ok {
-							if pi_, ok := c.cache2.Load(v); ok {
-								pi = pi_
-							} else {
-								pi = &poolInfo{
-									bech32: v,
-									hex:    utils.Bech32ToHexOrDie(v),
-								}
-							}
-							poolids[v] = pi
-						}
-						if len(poolids) > int(c.poolInfosToGet) {
-							getAndResetPoolInfos()
-						}
-						break
-					}
-					tickers[v] = struct{}{}
-					if len(tickers) > int(c.poolInfosToGet) {
-						getAndResetTickers()
-					}
-				case *poolInfo:
-					c.infoCh <- v
-					poolids[v.bech32] = v
-					if len(poolids) > int(c.poolInfosToGet) {
-						getAndResetPoolInfos()
-					}
-				}
-			}
-		}
-	}()
+func decodePoolInfo(data []byte) (*poolInfo, error) {
+	pi := &poolInfo{}
+	if err := pi.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return pi, nil
 }
 
-func (pc *poolCache) manageMissingTickers(end context.Context) {
-	defer close(pc.missingFinished)
-	for {
-		select {
-		case <-end.Done():
-			return
-		case vI, stillOpen := <-pc.missingCh:
-			if !stillOpen {
-				return
-			}
-			switch v := vI.(type) {
-			case string:
-				if len(v) == 0 {
-					continue
-				}
-				if []rune(v)[0] == '-' {
-					pc.missingMu.Lock()
-					pc.V(5).Info("MissingTickers: deleting", "ticker", v, "len", len(pc.missingTickersFromList))
-					if _, ok := pc.missingTickersFromList[string([]rune(v)[1:])]; ok {
-						delete(pc.missingTickersFromList, string([]rune(v)[1:]))
-						atomic.AddUint32(&pc.addeditems, uint32(1))
-						pc.V(4).Info("MissingTickers: increased addeditems for missing one",
-							"ticker", string([]rune(v)[1:]),
-							"addeditems", pc.addeditems, "nitems", pc.nitems)
-						pc.V(5).Info("MissingTickers: deleted", "len", len(pc.missingTickersFromList))
-					}
-					pc.missingMu.Unlock()
-				} else {
-					pc.missingMu.Lock()
-					pc.V(5).Info("MissingTickers: adding", "ticker", v, "len", len(pc.missingTickersFromList))
-					if _, ok := pc.missingTickersFromList[v]; !ok {
-						pc.missingTickersFromList[v] = struct{}{}
-						atomic.AddUint32(&pc.addeditems, ^uint32(0))
-						pc.V(4).Info("MissingTickers: decreased addeditems for missing one",
-							"ticker", v, "addeditems", pc.addeditems, "nitems", pc.nitems)
-						pc.V(5).Info("MissingTickers: added", "ticker", v, "len", len(pc.missingTickersFromList))
-					}
-					pc.missingMu.Unlock()
-				}
-			}
-		}
+// the pools are cached by ticker, the ones without a ticker by bech32 id
+func poolInfoKey(pi *poolInfo) string {
+	if pi.ticker == "" {
+		return pi.bech32
 	}
+	return pi.ticker
 }
 
-func (pc *poolCache) GetMissingPoolInfos() []string {
-	missing := []string{}
-	pc.missingMu.RLock()
-	defer pc.missingMu.RUnlock()
-	for t := range pc.missingTickersFromList {
-		missing = append(missing, t)
+func poolInfoAliases(pi *poolInfo) []string {
+	if pi.bech32 == "" {
+		return nil
 	}
-	return missing
+	return []string{pi.bech32}
 }
 
-func (pc *poolCache) FillMissingPoolInfos(p2t map[string]string) {
-	if !pc.running {
-		return
+// mergePoolInfo keeps the old values of the fields not fetched, better an old value than a zero value
+func mergePoolInfo(old, pi *poolInfo) *poolInfo {
+	if pi.activeStake == 0 {
+		pi.activeStake = old.activeStake
 	}
-
-	for p, t := range p2t {
-		pc.workersCh <- &poolInfo{ticker: t, bech32: p, hex: utils.Bech32ToHexOrDie(p)}
+	if pi.liveStake == 0 {
+		pi.liveStake = old.liveStake
 	}
-}
-
-func (pc *poolCache) addMany(saddrs []any) {
-	if !pc.running {
-		return
+	if pi.liveDelegators == 0 {
+		pi.liveDelegators = old.liveDelegators
 	}
-	pc.resetCountsMu.RLock()
-	defer pc.resetCountsMu.RUnlock()
-	for _, saddrI := range saddrs {
-		switch v := saddrI.(type) {
-		case string:
-			tcache := pc.cache
-			if isTickerOrPoolIdBech32_a_PoolId(v) {
-				tcache = pc.cache2
-			}
-			if _, ok := tcache.Load(v); !ok {
-				atomic.AddUint32(&pc.addeditems, uint32(1))
-			}
-			pc.workersCh <- v
-		case *MinimalPoolInfo:
-			_, ok := pc.cache.Load(v.Ticker)
-			_, ok2 := pc.cache2.Load(v.Bech32)
-			if !ok && !ok2 {
-				atomic.AddUint32(&pc.addeditems, uint32(1))
-			}
-			pc.workersCh <- &poolInfo{
-				ticker: v.Ticker,
-				bech32: v.Bech32,
-				hex:    utils.Bech32ToHexOrDie(v.Bech32),
-			}
-		}
+	if pi.vrfKeyHash == "" {
+		pi.vrfKeyHash = old.vrfKeyHash
 	}
-}
-
-func (pc *poolCache) Add(saddr string) {
-	pc.addMany([]any{saddr})
-}
-
-func (pc *poolCache) AddMany(saddrs []any) {
-	pc.addMany(saddrs)
-}
-
-func (pc *poolCache) delMany(saddrs []string) {
-	if !pc.running {
-		return
+	if pi.margin == 0 {
+		pi.margin = old.margin
 	}
-	for _, saddr := range saddrs {
-		if _, ok := pc.cache.Load(saddr); ok && pc.addeditems > 0 {
-			atomic.AddUint32(&pc.addeditems, ^uint32(0))
-		}
-		pc.infoCh <- saddr
+	if pi.relays == nil {
+		pi.relays = old.relays
 	}
+	return pi
 }
 
-func (pc *poolCache) Del(saddr string) {
-	pc.delMany([]string{saddr})
-}
+type poolCache struct {
+	*cache.Cache[string, *poolInfo]
 
-func (pc *poolCache) DelMany(saddrs []string) {
-	pc.delMany(saddrs)
+	cp    chainprovider.ChainProvider
+	store *cachestore.Store
+	// the pool ids of the tickers from the sheets and the hints, to avoid the tickers lookup
+	poolIds *sync.Map
 }
 
-func (pc *poolCache) Get(tickerOrbech32 string) (PoolInfo, bool) {
-	if isTickerOrPoolIdBech32_a_PoolId(tickerOrbech32) {
-		pi, ok := pc.cache2.Load(tickerOrbech32)
-		if !ok {
-			return (*poolInfo)(nil), false
-		}
-		return pi.(*poolInfo), true
+var _ PoolCache = (*poolCache)(nil)
+
+func (pc *poolCache) poolIdOf(ticker string) string {
+	if p, ok := pc.poolIds.Load(ticker); ok {
+		return p.(string)
 	}
-	pi, ok := pc.cache.Load(tickerOrbech32)
-	if !ok {
-		return (*poolInfo)(nil), false
+	if pi, ok := pc.Cache.Get(ticker); ok {
+		return pi.bech32
 	}
-	return pi.(*poolInfo), true
+	return ""
 }
 
-func (pc *poolCache) IsTickerMissingFromKoiosPoolList(t string) bool {
-	pc.missingMu.RLock()
-	defer pc.missingMu.RUnlock()
-	_, ok := pc.missingTickersFromList[t]
-	return !ok
-}
-
-func (pc *poolCache) Len() uint32 { return pc.nitems }
-func (pc *poolCache) Pending() uint32 {
-	if pc.addeditems >= pc.nitems {
-		return pc.addeditems - pc.nitems
-	} else {
-		return uint32(len(pc.missingTickersFromList))
+// fetch gets the infos of tickers and pool ids, the ids of the tickers not known are looked up before.
+// The tickers not found by the lookup are missing from the koios pools list
+func (pc *poolCache) fetch(keys []string) (map[string]*poolInfo, error) {
+	// pool id to the keys it was requested with
+	p2k := make(map[string][]string)
+	tickers := []string{}
+	for _, k := range keys {
+		if isTickerOrPoolIdBech32_a_PoolId(k) {
+			p2k[k] = append(p2k[k], k)
+		} else if p := pc.poolIdOf(k); p != "" {
+			p2k[p] = append(p2k[p], k)
+		} else {
+			tickers = append(tickers, k)
+		}
 	}
-}
-func (pc *poolCache) AddedItems() uint32 { return pc.addeditems }
-func (pc *poolCache) IsRunning() bool    { return pc.running }
-func (pc *poolCache) ResetCounts() {
-	pc.resetCountsMu.Lock()
-	defer pc.resetCountsMu.Unlock()
-	items := 0
-	pc.cache.Range(func(_, _ any) bool {
-		items++
-		return true
-	})
-	atomic.SwapUint32(&pc.addeditems, uint32(items))
-	atomic.SwapUint32(&pc.nitems, uint32(items))
-}
 
-func (pc *poolCache) Ready() bool {
-	pc.V(5).Info("PoolCache.Status",
-		"ready", pc.nitems == pc.addeditems, "nitems", pc.nitems, "addeditems", pc.addeditems, "pending", pc.Pending())
-	return pc.nitems == pc.addeditems
-}
-func (pc *poolCache) WaitReady(d time.Duration) bool {
-	if !pc.running {
-		return false
-	}
-	if pc.Ready() {
-		return true
+	if len(tickers) > 0 {
+		pc.V(3).Info("GetTickerToPoolIdMapFor: Processing tickers", "len", len(tickers))
+		t2p, err := pc.cp.GetTickerToPoolIdMapFor(tickers...)
+		if err != nil {
+			return nil, err
+		}
+		pc.V(3).Info("GetTickerToPoolIdMapFor: Got t2p", "len", len(t2p), "tickers len", len(tickers))
+		for t, p := range t2p {
+			p2k[p] = append(p2k[p], t)
+		}
 	}
 
-	pc.readyWaiterCh = make(chan any)
-	select {
-	case <-pc.readyWaiterCh:
-	case <-time.After(d):
-		chp := unsafe.Pointer(&pc.readyWaiterCh)
-		ch := *((*chan any)(atomic.SwapPointer(&chp, nil)))
-		close(ch)
+	pids := make([]string, 0, len(p2k))
+	for p := range p2k {
+		pids = append(pids, p)
 	}
-	return pc.Ready()
-}
-
-func (pc *poolCache) Refresh() {
-	if !pc.running {
-		return
+	if len(pids) == 0 {
+		return nil, nil
 	}
-	pc.cache.Range(func(_, v any) bool {
-		pc.workersCh <- v.(*poolInfo)
-		return true
-	})
-}
-
-func (c *poolCache) maybeLoadFromDisk() {
-	if c.running || c.cache == nil || c.cachesStoreDir == "" {
-		return
+	pc.V(3).Info("GetPoolsInfos: Processing pool ids", "len", len(pids))
+	p2i, err := pc.cp.GetPoolsInfos(pids...)
+	if err != nil {
+		return nil, err
 	}
+	pc.V(3).Info("GetPoolsInfos: Got p2i", "len", len(p2i), "poolids len", len(pids))
 
-	fi, err := os.Stat(c.cachesStoreDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(c.cachesStoreDir, 0700); err != nil {
-				return
+	infos := make(map[string]*poolInfo, len(keys))
+	for p, ks := range p2k {
+		pi := &poolInfo{bech32: p, hex: utils.Bech32ToHexOrDie(p)}
+		for _, k := range ks {
+			if !isTickerOrPoolIdBech32_a_PoolId(k) {
+				pi.ticker = k
 			}
-		} else {
-			return
 		}
-	} else {
-		if !(fi.Mode().IsDir() && ((fi.Mode().Perm() & 0700) == 0700)) {
-			return
+		if i, ok := p2i[p]; ok {
+			if i.Ticker != "" {
+				pi.ticker = i.Ticker
+			}
+			pi.activeStake = i.ActiveStake
+			pi.liveStake = i.LiveStake
+			pi.liveDelegators = i.LiveDelegators
+			pi.vrfKeyHash = i.VrfKeyHash
+			pi.isRetired = i.IsRetired
+			pi.relays = i.Relays
+			pi.margin = i.Margin
+		} else if pi.ticker == "" {
+			// neither the ticker nor the info of the pool id are known
+			continue
+		}
+		pc.V(4).Info("GetPoolsInfos (p2i): Forwarding poolInfo", "ticker", pi.ticker, "bech32", p, "hex", pi.hex)
+		for _, k := range ks {
+			infos[k] = pi
 		}
 	}
+	return infos, nil
+}
 
-	fn := filepath.Join(c.cachesStoreDir, "poolcache.gob")
-	fi, err = os.Stat(fn)
-	if err != nil {
-		c.Error(err, "file stat failed")
-		return
-	}
-	if !(fi.Mode().IsRegular() && ((fi.Mode().Perm() & 0600) == 0600)) {
-		return
-	}
+func (pc *poolCache) GetMissingPoolInfos() []string {
+	missing := pc.Missing()
+	sort.Strings(missing)
+	return missing
+}
 
-	if f, err := os.OpenFile(fn, os.O_RDONLY, 0400); err != nil {
-		c.Error(err, "OpenFile failed")
-		return
-	} else {
-		if err := gob.NewDecoder(f).Decode(c); err != nil && err != io.EOF {
-			c.Error(err, "Gob decode failed")
-		}
-		f.Close()
-	}
-	c.V(2).Info("maybeLoadFromDisk", "addeditems", c.addeditems, "nitems", c.nitems)
-	return
+func (pc *poolCache) IsTickerMissingFromKoiosPoolList(t string) bool {
+	return !pc.IsMissing(t)
 }
 
-func (c *poolCache) maybeStoreToDisk() {
-	if c.running || c.cache == nil || c.cachesStoreDir == "" {
+func (pc *poolCache) FillMissingPoolInfos(p2t map[string]string) {
+	if !pc.IsRunning() {
 		return
 	}
-	if c.Len() == 0 {
-		return
+	tickers := make([]string, 0, len(p2t))
+	for p, t := range p2t {
+		pc.poolIds.Store(t, p)
+		tickers = append(tickers, t)
 	}
+	pc.Cache.Add(tickers...)
+}
 
-	fi, err := os.Stat(c.cachesStoreDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(c.cachesStoreDir, 0700); err != nil {
-				c.Error(err, "creating dir failed")
-				return
+func (pc *poolCache) AddMany(saddrs []any) {
+	keys := make([]string, 0, len(saddrs))
+	for _, saddrI := range saddrs {
+		switch v := saddrI.(type) {
+		case string:
+			keys = append(keys, v)
+		case *MinimalPoolInfo:
+			if v.Ticker == "" {
+				keys = append(keys, v.Bech32)
+				break
 			}
-		} else {
-			c.Error(err, "getting dir stat")
-			return
-		}
-	} else {
-		if !(fi.Mode().IsDir() && ((fi.Mode().Perm() & 0700) == 0700)) {
-			c.Error(err, "is not a dir or bad perm")
-			return
-		}
-	}
-
-	fn := filepath.Join(c.cachesStoreDir, "poolcache.gob")
-	if f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE, 0600); err != nil {
-		c.Error(err, "opening/creating file failed")
-		return
-	} else {
-		if err := gob.NewEncoder(f).Encode(c); err != nil {
-			c.Error(err, "Gob encode failed")
+			if v.Bech32 != "" {
+				pc.poolIds.Store(v.Ticker, v.Bech32)
+			}
+			keys = append(keys, v.Ticker)
 		}
-		f.Close()
 	}
+	pc.Cache.Add(keys...)
 }
 
-func (pc *poolCache) Start() {
-	if pc.running {
-		return
-	}
-	pc.refresherFinished = make(chan any)
-	pc.infoCh = make(chan any)
-
-	pc.cacheSyncerWg.Add(int(pc.cacheSyncers))
-	for i := 0; i < int(pc.cacheSyncers); i++ {
-		go pc.cacheSyncer()
-	}
+func (pc *poolCache) Add(saddr string)        { pc.Cache.Add(saddr) }
+func (pc *poolCache) AddNow(saddr string)     { pc.Cache.AddNow(saddr) }
+func (pc *poolCache) Del(saddr string)        { pc.Cache.Del(saddr) }
+func (pc *poolCache) DelMany(saddrs []string) { pc.Cache.Del(saddrs...) }
 
-	ctx, ctxCancel := context.WithCancel(pc.kc.GetContext())
-	pc.workersCtxCancel = ctxCancel
-	pc.workersCh = make(chan any)
-	pc.workersWg.Add(int(pc.workers))
-	for i := 0; i < int(pc.workers); i++ {
-		go pc.poolListOrPoolInfosGetter(ctx)
+func (pc *poolCache) Get(tickerOrbech32 string) (PoolInfo, bool) {
+	pi, ok := pc.Cache.Get(tickerOrbech32)
+	if !ok {
+		return (*poolInfo)(nil), false
 	}
-
-	pc.missingFinished = make(chan any)
-	ctx, ctxCancel = context.WithCancel(pc.kc.GetContext())
-	pc.missingCtxCancel = ctxCancel
-	pc.missingCh = make(chan any)
-	go pc.manageMissingTickers(ctx)
-
-	pc.running = true
-
-	ctx, ctxCancel = context.WithCancel(pc.kc.GetContext())
-	pc.refresherCtxCancel = ctxCancel
-	pc.refresherTick = time.NewTicker(pc.refreshInterval)
-	go func(end context.Context) {
-		defer close(pc.refresherFinished)
-		for {
-			pc.V(3).Info("Refresh PoolCache", "is ready", pc.Ready())
-			select {
-			case <-end.Done():
-				return
-			case <-pc.refresherTick.C:
-				pc.Refresh()
-			}
-		}
-	}(ctx)
+	return pi, true
 }
 
-func (pc *poolCache) Stop() {
-	if !pc.running {
-		return
+func (pc *poolCache) options(
+	workers uint32,
+	refreshInterval time.Duration,
+	workersInterval time.Duration,
+	poolInfosToGet uint32,
+) cache.Options[string, *poolInfo] {
+	return cache.Options[string, *poolInfo]{
+		Context:         pc.cp.GetContext(),
+		Fetch:           pc.fetch,
+		KeyOf:           poolInfoKey,
+		Aliases:         poolInfoAliases,
+		Merge:           mergePoolInfo,
+		Workers:         workers,
+		BatchSize:       poolInfosToGet,
+		WorkersInterval: workersInterval,
+		RefreshInterval: refreshInterval,
+		Store:           pc.store,
+		Decode:          decodePoolInfo,
 	}
-
-	pc.running = false
-
-	pc.V(2).Info("Stopping PoolCache")
-
-	pc.refresherTick.Stop()
-	pc.refresherCtxCancel()
-	<-pc.refresherFinished
-	pc.V(2).Info("PoolCache refresher stopped")
-
-	pc.workersCtxCancel()
-	pc.workersWg.Wait()
-	close(pc.workersCh)
-	pc.V(2).Info("PoolCache workers stopped")
-
-	pc.missingCtxCancel()
-	<-pc.missingFinished
-	pc.V(2).Info("PoolCache missing manager stopped")
-
-	close(pc.infoCh)
-	pc.cacheSyncerWg.Wait()
-	pc.V(2).Info("PoolCache cache syncer stopped")
-	pc.V(2).Info("PoolCache stopped")
-
-	pc.maybeStoreToDisk()
 }
 
 func (pc *poolCache) WithOptions(
-	kc *ku.KoiosClient,
+	cp chainprovider.ChainProvider,
 	workers uint32,
-	cacheSyncers uint32,
 	refreshInterval time.Duration,
 	workersInterval time.Duration,
 	poolInfosToGet uint32,
 ) (PoolCache, error) {
-	if pc.running {
+	if pc.IsRunning() {
 		return nil, fmt.Errorf("PoolCache is running")
 	}
-	newPoolCache := &poolCache{
-		kc:              kc,
-		workers:         workers,
-		cacheSyncers:    cacheSyncers,
-		workersInterval: workersInterval,
-		poolInfosToGet:  poolInfosToGet,
-		refreshInterval: refreshInterval,
-	}
-	if pc.cache == nil {
-		newPoolCache.cache = new(sync.Map)
-	} else {
-		newPoolCache.cache = pc.cache
-	}
-
-	if pc.cache2 == nil {
-		newPoolCache.cache2 = new(sync.Map)
-	} else {
-		newPoolCache.cache2 = pc.cache2
-	}
-
-	if pc.missingTickersFromList == nil {
-		newPoolCache.missingTickersFromList = make(map[string]any)
-	} else {
-		newPoolCache.missingTickersFromList = pc.missingTickersFromList
+	newPoolCache := &poolCache{cp: cp, store: pc.store, poolIds: pc.poolIds}
+	c, err := pc.Cache.WithOptions(newPoolCache.options(workers, refreshInterval, workersInterval, poolInfosToGet))
+	if err != nil {
+		return nil, err
 	}
-
-	newPoolCache.Logger = pc.Logger
-	newPoolCache.cachesStoreDir = pc.cachesStoreDir
-	newPoolCache.maybeLoadFromDisk()
+	newPoolCache.Cache = c
 	return newPoolCache, nil
 }
 
 func New(
-	kc *ku.KoiosClient,
+	cp chainprovider.ChainProvider,
 	workers uint32,
-	cacheSyncers uint32,
 	refreshInterval time.Duration,
 	workersInterval time.Duration,
 	poolInfosToGet uint32,
 	logger logging.Logger,
 	cachesStoreDir string,
 ) PoolCache {
-	pc, _ := (&poolCache{Logger: logger, cachesStoreDir: cachesStoreDir}).WithOptions(
-		kc, workers, cacheSyncers, refreshInterval, workersInterval, poolInfosToGet)
+	pc := &poolCache{cp: cp, poolIds: new(sync.Map)}
+	if cachesStoreDir != "" {
+		pc.store = cachestore.New(logger.WithName("store"), cachesStoreDir, storeFileName, storeSchemaVersion).
+			WithMigration(1, migrateStoreV1(logger)).
+			WithLegacyGobFile(legacyStoreFileName, 1)
+	}
+	pc.Cache = cache.New(logger, pc.options(workers, refreshInterval, workersInterval, poolInfosToGet))
 	return pc
 }
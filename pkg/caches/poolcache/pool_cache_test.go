@@ -0,0 +1,42 @@
+package poolcache
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+func TestMigrateStoreV1(t *testing.T) {
+	// the lines of the gob encoder: the stakes in ADA, the vrf key hash is empty when not fetched yet
+	legacy := "AAA pool1aaa aa 1000000 2000000 10 vrfaaa\n" +
+		"BBB pool1bbb bb 5 6 1 \n" +
+		"CCC pool1ccc\n"
+	dat, err := migrateStoreV1(logr.Discard())([]byte(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(dat), []byte{'\n'})
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 pools, the malformed line skipped, got %q", dat)
+	}
+	for i, want := range []struct {
+		ticker, vrf            string
+		activeStake, liveStake utils.Lovelace
+		liveDelegators         uint32
+	}{
+		{"AAA", "vrfaaa", utils.AdaToLovelace(1000000), utils.AdaToLovelace(2000000), 10},
+		{"BBB", "", utils.AdaToLovelace(5), utils.AdaToLovelace(6), 1},
+	} {
+		pi, err := decodePoolInfo(lines[i])
+		if err != nil {
+			t.Fatalf("line %q: %v", lines[i], err)
+		}
+		if pi.ticker != want.ticker || pi.vrfKeyHash != want.vrf || pi.activeStake != want.activeStake ||
+			pi.liveStake != want.liveStake || pi.liveDelegators != want.liveDelegators {
+			t.Errorf("got %+v, want %+v", pi, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package eventbus
+
+import (
+	"cmp"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/safanaj/go-f2lb/pkg/logging"
+)
+
+// DefaultBufferSize is the buffer of a subscriber when the given size is not positive
+const DefaultBufferSize = 16
+
+// Topic is a named stream of events, the type of the payload is checked at compile time
+type Topic[T any] struct{ name string }
+
+func NewTopic[T any](name string) Topic[T] { return Topic[T]{name: name} }
+func (t Topic[T]) String() string          { return t.name }
+
+// topics with the same name and different payload types are different topics
+type topicKey struct {
+	name string
+	typ  reflect.Type
+}
+
+func (t Topic[T]) key() topicKey { return topicKey{name: t.name, typ: reflect.TypeFor[T]()} }
+
+type subscriber struct {
+	name  string
+	topic topicKey
+	size  int
+	// send does not block, it returns false if the buffer is full
+	send      func(any) bool
+	queued    func() int
+	close     func()
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// Bus delivers the published events to the subscribers of the topic, a slow subscriber does not block
+// the publisher: when its buffer is full the event is dropped and counted
+type Bus struct {
+	logging.Logger
+	mu   sync.RWMutex
+	subs map[topicKey][]*subscriber
+}
+
+func New(logger logging.Logger) *Bus {
+	return &Bus{Logger: logger, subs: make(map[topicKey][]*subscriber)}
+}
+
+// Subscription receives the events of a topic on C until it is closed
+type Subscription[T any] struct {
+	C    <-chan T
+	bus  *Bus
+	sub  *subscriber
+	once sync.Once
+}
+
+// Subscribe registers a subscriber with a buffer of size events, the name is used in logs and stats
+func Subscribe[T any](b *Bus, t Topic[T], name string, size int) *Subscription[T] {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	ch := make(chan T, size)
+	sub := &subscriber{
+		name:  name,
+		topic: t.key(),
+		size:  size,
+		send: func(v any) bool {
+			select {
+			case ch <- v.(T):
+				return true
+			default:
+				return false
+			}
+		},
+		queued: func() int { return len(ch) },
+		close:  func() { close(ch) },
+	}
+	b.mu.Lock()
+	b.subs[sub.topic] = append(b.subs[sub.topic], sub)
+	b.mu.Unlock()
+	return &Subscription[T]{C: ch, bus: b, sub: sub}
+}
+
+// Close removes the subscriber from the bus and closes C
+func (s *Subscription[T]) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		s.bus.subs[s.sub.topic] = slices.DeleteFunc(s.bus.subs[s.sub.topic],
+			func(sub *subscriber) bool { return sub == s.sub })
+		s.bus.mu.Unlock()
+		s.sub.close()
+	})
+}
+
+// Publish delivers the payload to all the subscribers of the topic without blocking,
+// publishing on a nil bus is a no-op
+func Publish[T any](b *Bus, t Topic[T], payload T) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subs[t.key()] {
+		if sub.send(payload) {
+			sub.delivered.Add(1)
+			continue
+		}
+		if dropped := sub.dropped.Add(1); dropped == 1 || dropped%100 == 0 {
+			b.V(2).Info("Event bus subscriber is not keeping up, event dropped",
+				"topic", t.name, "subscriber", sub.name, "dropped", dropped)
+		}
+	}
+}
+
+// SubscriberStats are the delivery counters of a subscriber
+type SubscriberStats struct {
+	Topic      string `json:"topic"`
+	Subscriber string `json:"subscriber"`
+	BufferSize int    `json:"buffer_size"`
+	Queued     int    `json:"queued"`
+	Delivered  uint64 `json:"delivered"`
+	Dropped    uint64 `json:"dropped"`
+}
+
+// Stats returns the counters of all the subscribers, sorted by topic
+func (b *Bus) Stats() []SubscriberStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	stats := []SubscriberStats{}
+	for topic, subs := range b.subs {
+		for _, sub := range subs {
+			stats = append(stats, SubscriberStats{
+				Topic:      topic.name,
+				Subscriber: sub.name,
+				BufferSize: sub.size,
+				Queued:     sub.queued(),
+				Delivered:  sub.delivered.Load(),
+				Dropped:    sub.dropped.Load(),
+			})
+		}
+	}
+	slices.SortStableFunc(stats, func(a, b SubscriberStats) int { return cmp.Compare(a.Topic, b.Topic) })
+	return stats
+}
@@ -0,0 +1,54 @@
+package eventbus
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	b := New(logr.Discard())
+	topic := NewTopic[string]("test")
+	sub := Subscribe(b, topic, "sub", 1)
+
+	Publish(b, topic, "first")
+	Publish(b, topic, "dropped")
+	if got := <-sub.C; got != "first" {
+		t.Fatalf("got %q, want %q", got, "first")
+	}
+	stats := b.Stats()
+	if len(stats) != 1 || stats[0].Topic != "test" || stats[0].Delivered != 1 || stats[0].Dropped != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Fatalf("the subscription is not closed")
+	}
+	Publish(b, topic, "after close")
+	Publish[string](nil, topic, "nil bus")
+}
+
+func TestSameNameDifferentType(t *testing.T) {
+	b := New(logr.Discard())
+	strSub := Subscribe(b, NewTopic[string]("test"), "string", 1)
+	intSub := Subscribe(b, NewTopic[int]("test"), "int", 1)
+
+	Publish(b, NewTopic[int]("test"), 42)
+	if got := <-intSub.C; got != 42 {
+		t.Fatalf("got %d, want 42", got)
+	}
+	select {
+	case v := <-strSub.C:
+		t.Fatalf("the string subscriber got %q", v)
+	default:
+	}
+
+	Publish(b, NewTopic[string]("test"), "value")
+	if got := <-strSub.C; got != "value" {
+		t.Fatalf("got %q, want %q", got, "value")
+	}
+	if stats := b.Stats(); len(stats) != 2 {
+		t.Fatalf("expected 2 subscribers, got %+v", stats)
+	}
+}
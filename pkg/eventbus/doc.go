@@ -0,0 +1,3 @@
+// Package eventbus is an in-process typed publish/subscribe bus, used to propagate the changes between the controller,
+// the API layers, the pinger and the payer
+package eventbus
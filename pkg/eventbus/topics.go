@@ -0,0 +1,20 @@
+package eventbus
+
+import "time"
+
+// topics used across packages that cannot import each other, the other ones
+// are declared in the package that owns the payload
+var (
+	// the caches got all the infos for the first time, the payload is when
+	CacheReady = NewTopic[time.Time]("cache-ready")
+	// a session has been verified by a signature, the payload is the session uuid
+	SessionVerified = NewTopic[string]("session-verified")
+	// a member reported the tip of its pool, the controllers republish the state with it
+	TipReported = NewTopic[TipReport]("tip-reported")
+)
+
+type TipReport struct {
+	Ticker      string `json:"ticker"`
+	PoolId      string `json:"pool_id"`
+	BlockHeight uint32 `json:"block_height"`
+}
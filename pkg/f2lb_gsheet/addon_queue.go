@@ -4,9 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -18,15 +15,16 @@ const addonQueueSheet = "AddonQ"
 
 // the first 2 rows are headers
 const addonQueueRange = "A2:N"
+const addonQueueHeaderRange = "A1:N1"
 
 type AddonQueueRec struct {
 	// columns
-	DiscordName  string `json:"discord_name" yaml:"discord_name"` // A
-	QPP          uint16 `json:"qpp" yaml:"qpp"`                   // B
-	Ticker       string `json:"ticker" yaml:"ticker"`             // C
-	AD           uint16 `json:"ada_declared" yaml:"ada_declared"` // D
-	AdaDelegated uint16 `json:"ada_delegated" yaml:"ada_delegated"`
-	EG           uint16 `json:"epoch_granted" yaaml:"epoch_granted"` // E
+	DiscordName  string         `json:"discord_name" yaml:"discord_name"` // A
+	QPP          uint16         `json:"qpp" yaml:"qpp"`                   // B
+	Ticker       string         `json:"ticker" yaml:"ticker"`             // C
+	AD           utils.Lovelace `json:"-" yaml:"-"`                       // D, declared in ADA, kept in lovelace
+	AdaDelegated utils.Lovelace `json:"-" yaml:"-"`
+	EG           uint16         `json:"epoch_granted" yaaml:"epoch_granted"` // E
 	// computed column I
 	StakeKeys  []string `json:"stake_keys" yaml:"stake_keys"`
 	StakeAddrs []string `json:"stake_addresses" yaml:"stake_addresses"`
@@ -41,17 +39,30 @@ type AddonQueueRec struct {
 	PoolIdBech32          string
 	discordID             string // M
 	initialAdaDeclaration string // N
+
+	// first row of the record in the sheet, used to write back computed columns
+	sheetRow int
+}
+
+func (r *AddonQueueRec) toMarshalable() any {
+	type rec AddonQueueRec
+	return struct {
+		rec        `yaml:",inline"`
+		recAmounts `yaml:",inline"`
+	}{rec(*r), newRecAmounts(r.AD, r.AdaDelegated)}
 }
 
 func (r *AddonQueueRec) MarshalJSON() ([]byte, error) {
-	return json.Marshal(*r)
+	return json.Marshal(r.toMarshalable())
 }
 
 func (r *AddonQueueRec) MarshalYAML() (any, error) {
-	return *r, nil
+	return r.toMarshalable(), nil
 }
 
 type AddonQueue struct {
+	sheetParsing
+
 	mu      sync.RWMutex
 	ordered []string
 
@@ -85,9 +96,9 @@ func (aq *AddonQueue) MarshalYAML() (any, error) {
 	return aq.toMarshalable(), nil
 }
 
-func NewAddonQueue(f2lb *F2LB) (*AddonQueue, error) {
+func NewAddonQueue(src SheetSource) (*AddonQueue, error) {
 	aq := &AddonQueue{}
-	err := aq.Refresh(f2lb, nil)
+	err := aq.Refresh(src, nil)
 	var cwarn *CacheWarn
 	if err != nil && !errors.As(err, &cwarn) {
 		return nil, err
@@ -95,8 +106,8 @@ func NewAddonQueue(f2lb *F2LB) (*AddonQueue, error) {
 	return aq, err
 }
 
-func NewAddonQueueOrDie(f2lb *F2LB) *AddonQueue {
-	aq, err := NewAddonQueue(f2lb)
+func NewAddonQueueOrDie(src SheetSource) *AddonQueue {
+	aq, err := NewAddonQueue(src)
 	if aq == nil {
 		utils.CheckErr(err)
 	}
@@ -104,34 +115,56 @@ func NewAddonQueueOrDie(f2lb *F2LB) *AddonQueue {
 }
 
 func (aq *AddonQueue) GetRange() string {
-	return fmt.Sprintf("%s!%s", addonQueueSheet, addonQueueRange)
+	return fmt.Sprintf("%s!%s", aq.nameOr(addonQueueSheet), addonQueueRange)
+}
+
+// the first row is the header
+func (aq *AddonQueue) GetHeaderRange() string {
+	return fmt.Sprintf("%s!%s", aq.nameOr(addonQueueSheet), addonQueueHeaderRange)
 }
 
-func (aq *AddonQueue) Refresh(f2lb *F2LB, vr *ValueRange) error {
+// SetHeader resolves the columns from the header rows, a nil header means the schema columns
+func (aq *AddonQueue) SetHeader(header *ValueRange) { aq.setHeader(addonQueueSheet, header) }
+
+func (aq *AddonQueue) Refresh(src SheetSource, vr *ValueRange) error {
 	var aqRec *AddonQueueRec
 	if vr == nil {
-		res, err := f2lb.Spreadsheets.Values.BatchGet(
-			f2lbSpreadSheetID).MajorDimension("ROWS").Ranges(
-			aq.GetRange()).Do()
+		vrs, err := src.BatchGet(aq.GetRange(), aq.GetHeaderRange())
 		if err != nil {
 			return err
 		}
-		vr = res.ValueRanges[0]
+		vr = vrs[0]
+		aq.SetHeader(vrs[1])
 	}
 
+	cm := aq.getColumns(addonQueueSheet)
+	firstRow := firstRowOfRange(vr.Range, aq.GetRange())
+	var rowErrs []RowError
+
 	orderedTickers := make([]string, 0, len(vr.Values))
+	sheetRows := make(map[string]int)
 	records := make([]*AddonQueueRec, 0, len(vr.Values))
 
-	for _, v := range vr.Values {
-		if len(v) < 9 {
+	for i, v := range vr.Values {
+		p := &rowParser{cm: cm, row: v, rowNum: firstRow + i}
+		p.ticker = p.str(fieldTicker)
+		if !p.has(fieldTicker, fieldStakeAddresses) {
+			// rows without a ticker are not entries of the queue
+			if p.ticker != "" {
+				rowErrs = append(rowErrs, p.errs...)
+			}
 			continue
 		}
+		ticker := p.ticker // C
 
-		qppVal, _ := strconv.ParseUint(v[1].(string), 10, 16)
-		adVal, _ := strconv.ParseUint(v[3].(string), 10, 16)
-		egVal, _ := strconv.ParseUint(v[4].(string), 10, 16)
+		// compute for column I
+		stakeAddrs, stakeKeys := p.stakeAddresses(fieldStakeAddresses)
+		if len(stakeAddrs) == 0 {
+			p.addError(fieldStakeAddresses, p.str(fieldStakeAddresses), "no valid stake address, row skipped")
+			rowErrs = append(rowErrs, p.errs...)
+			continue
+		}
 
-		ticker := v[2].(string) // C
 		orderedTickers = append(orderedTickers, ticker)
 
 		aqRec = (*AddonQueueRec)(nil)
@@ -142,76 +175,32 @@ func (aq *AddonQueue) Refresh(f2lb *F2LB, vr *ValueRange) error {
 		}
 		if aqRec == nil {
 			aqRec = &AddonQueueRec{
-				DiscordName: v[0].(string),  // A
-				QPP:         uint16(qppVal), // B
-				Ticker:      ticker,         // C
-				AD:          uint16(adVal),  // D
-				EG:          uint16(egVal),  // E
-			}
-		}
-
-		if len(v) > 5 {
-			aqRec.delegStatus = v[5].(string) // F
-		}
-		if len(v) > 6 {
-			aqRec.addonQCurrPos = v[6].(string) // G
-		}
-		if len(v) > 7 {
-			aqRec.addonQStatus = v[7].(string) // H
-		}
-		if len(v) > 9 {
-			aqRec.missedEpochs = v[9].(string) // J
-		}
-		if len(v) > 10 {
-			aqRec.addedToGoogleGroup = v[10].(string) // K
-		}
-		if len(v) > 11 {
-			aqRec.PoolIdHex = v[11].(string) // L
-		}
-		if len(v) > 12 {
-			aqRec.discordID = v[12].(string) // M
-		}
-		if len(v) > 13 {
-			aqRec.initialAdaDeclaration = v[13].(string) // N
-		}
-
-		if strings.HasPrefix(aqRec.PoolIdHex, "pool") {
-			aqRec.PoolIdBech32 = aqRec.PoolIdHex
-			aqRec.PoolIdHex = ""
-			if hex, err := utils.Bech32ToHex(aqRec.PoolIdBech32); err == nil {
-				aqRec.PoolIdHex = hex
-			}
-		} else {
-			if bech32, err := utils.HexToBech32("pool", aqRec.PoolIdHex); err == nil {
-				aqRec.PoolIdBech32 = bech32
+				DiscordName: p.str(fieldDiscordName),                                 // A
+				QPP:         p.uint16(fieldQPP),                                      // B
+				Ticker:      ticker,                                                  // C
+				AD:          utils.AdaToLovelace(uint64(p.uint32(fieldAdaDeclared))), // D
+				EG:          p.uint16(fieldEpochGranted),                             // E
 			}
 		}
 
-		// compute for column I
-		for _, val := range regexp.MustCompile("[[:space:]]").Split(v[8].(string), -1) {
-			if val == "" || len(val) < 6 {
-				continue
-			}
-			if val[:5] == "stake" {
-				kh, err := utils.StakeAddressToStakeKeyHash(val)
-				if err != nil {
-					fmt.Println(fmt.Errorf("AddonQueue parsing: Invalid bech32 stake address for ticker: %s", ticker))
-					continue
-				}
-				aqRec.StakeAddrs = append(aqRec.StakeAddrs, val)
-				aqRec.StakeKeys = append(aqRec.StakeKeys, kh)
-			} else {
-				addr, err := utils.StakeKeyHashToStakeAddress(val)
-				if err != nil {
-					fmt.Println(fmt.Errorf("AddonQueue parsing: Invalid hex stake address for ticker: %s", ticker))
-					continue
-				}
-				aqRec.StakeKeys = append(aqRec.StakeKeys, val)
-				aqRec.StakeAddrs = append(aqRec.StakeAddrs, addr)
-			}
+		aqRec.delegStatus = p.str(fieldDelegStatus)                     // F
+		aqRec.addonQCurrPos = p.str(fieldQueuePosition)                 // G
+		aqRec.addonQStatus = p.str(fieldAddonQueueStatus)               // H
+		aqRec.missedEpochs = p.str(fieldMissedEpochs)                   // J
+		aqRec.addedToGoogleGroup = p.str(fieldAddedToGoogleGroup)       // K
+		aqRec.PoolIdHex, aqRec.PoolIdBech32 = p.poolId(fieldPoolId)     // L
+		aqRec.discordID = p.str(fieldDiscordId)                         // M
+		aqRec.initialAdaDeclaration = p.str(fieldInitialAdaDeclaration) // N
+
+		if _, ok := sheetRows[ticker]; !ok {
+			sheetRows[ticker] = p.rowNum
+			aqRec.sheetRow = p.rowNum
 		}
+		aqRec.StakeAddrs = append(aqRec.StakeAddrs, stakeAddrs...)
+		aqRec.StakeKeys = append(aqRec.StakeKeys, stakeKeys...)
 
 		records = append(records, aqRec)
+		rowErrs = append(rowErrs, p.errs...)
 	}
 
 	// now lock and prepare caches
@@ -222,7 +211,10 @@ func (aq *AddonQueue) Refresh(f2lb *F2LB, vr *ValueRange) error {
 	// below code to be deprecated, NOT REALLY, some information as EG from the addonQ should be kept
 	aq.records = records
 
-	aq.served = records[0]
+	aq.served = nil
+	if len(records) > 0 {
+		aq.served = records[0]
+	}
 	aq.refreshedInEpoch = utils.CurrentEpoch()
 
 	warn := &CacheWarn{}
@@ -252,7 +244,8 @@ func (aq *AddonQueue) Refresh(f2lb *F2LB, vr *ValueRange) error {
 			}
 		}
 	}
-	aq.refreshTime = time.Now()
+	aq.refreshTime = utils.Now()
+	aq.setDataQuality(cm, rowErrs, warn.warnings)
 	if len(warn.warnings) > 0 {
 		return warn
 	}
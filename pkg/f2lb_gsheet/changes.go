@@ -0,0 +1,221 @@
+package f2lb_gsheet
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/eventbus"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// ChangeKind is the type of a change detected between two refreshes
+type ChangeKind string
+
+const (
+	MemberAdded             ChangeKind = "member_added"
+	MemberRemoved           ChangeKind = "member_removed"
+	QueuePositionChanged    ChangeKind = "queue_position_changed"
+	TickerChanged           ChangeKind = "ticker_changed"
+	PoolIdChanged           ChangeKind = "pool_id_changed"
+	AdaDeclaredChanged      ChangeKind = "ada_declared_changed"
+	ServedPoolChanged       ChangeKind = "served_pool_changed"
+	DelegCycleTopChanged    ChangeKind = "deleg_cycle_top_changed"
+	DelegCycleActiveChanged ChangeKind = "deleg_cycle_active_changed"
+)
+
+// queues names used in the change events
+const (
+	MainQueueName  = "main"
+	AddonQueueName = "addon"
+)
+
+// ChangeEvent describes a single change, Old and New are the values before and after the refresh
+type ChangeEvent struct {
+	Kind         ChangeKind `json:"kind"`
+	Queue        string     `json:"queue,omitempty"`
+	Ticker       string     `json:"ticker,omitempty"`
+	StakeAddress string     `json:"stake_address,omitempty"`
+	Old          string     `json:"old,omitempty"`
+	New          string     `json:"new,omitempty"`
+}
+
+func (e ChangeEvent) String() string {
+	return fmt.Sprintf("%s queue=%s ticker=%s old=%q new=%q", e.Kind, e.Queue, e.Ticker, e.Old, e.New)
+}
+
+// RefreshDiff is published after every refresh with the changes from the previous one,
+// the first refresh has no events as there is nothing to compare with.
+type RefreshDiff struct {
+	// generation of the state published with this diff, see State
+	Generation uint64 `json:"generation"`
+
+	Reason string        `json:"reason"`
+	Time   time.Time     `json:"time"`
+	Epoch  utils.Epoch   `json:"epoch"`
+	Events []ChangeEvent `json:"events"`
+}
+
+func (d *RefreshDiff) IsEmpty() bool { return d == nil || len(d.Events) == 0 }
+
+// topics published on the event bus of the controller
+var (
+	// every notified refresh, also without changes
+	RefreshCompletedTopic = eventbus.NewTopic[*RefreshDiff]("refresh-completed")
+	// each change of a refresh related to a member
+	MemberChangedTopic = eventbus.NewTopic[ChangeEvent]("member-changed")
+)
+
+type memberState struct {
+	ticker   string
+	poolId   string
+	position int
+	ad       uint32
+}
+
+type queueState struct {
+	served string
+	// main stake addresses in queue order
+	order   []string
+	members map[string]memberState
+}
+
+type refreshState struct {
+	mainQueue  queueState
+	addonQueue queueState
+
+	delegTop      string
+	delegActive   string
+	delegAddonTop string
+}
+
+func (c *controller) takeRefreshState() *refreshState {
+	s := &refreshState{
+		mainQueue:     queueState{members: make(map[string]memberState)},
+		addonQueue:    queueState{members: make(map[string]memberState)},
+		delegTop:      c.delegCycle.topTicker,
+		delegActive:   c.delegCycle.activeTicker,
+		delegAddonTop: c.delegCycle.aqTopTicker,
+	}
+	if served := c.mainQueue.GetServed(); served != nil {
+		s.mainQueue.served = served.Ticker
+	}
+	for i, r := range c.mainQueue.GetRecords() {
+		if len(r.StakeAddrs) == 0 {
+			continue
+		}
+		if _, ok := s.mainQueue.members[r.StakeAddrs[0]]; !ok {
+			s.mainQueue.order = append(s.mainQueue.order, r.StakeAddrs[0])
+		}
+		s.mainQueue.members[r.StakeAddrs[0]] = memberState{ticker: r.Ticker, poolId: r.PoolIdBech32, position: i, ad: r.AD.AdaUint32()}
+	}
+	if served := c.addonQueue.GetServed(); served != nil {
+		s.addonQueue.served = served.Ticker
+	}
+	for i, r := range c.addonQueue.GetRecords() {
+		if len(r.StakeAddrs) == 0 {
+			continue
+		}
+		if _, ok := s.addonQueue.members[r.StakeAddrs[0]]; !ok {
+			s.addonQueue.order = append(s.addonQueue.order, r.StakeAddrs[0])
+		}
+		s.addonQueue.members[r.StakeAddrs[0]] = memberState{ticker: r.Ticker, poolId: r.PoolIdBech32, position: i, ad: r.AD.AdaUint32()}
+	}
+	return s
+}
+
+func diffQueueState(queue string, prev, curr queueState) []ChangeEvent {
+	events := []ChangeEvent{}
+	if prev.served != curr.served {
+		events = append(events, ChangeEvent{Kind: ServedPoolChanged, Queue: queue, Ticker: curr.served, Old: prev.served, New: curr.served})
+	}
+	for _, saddr := range prev.order {
+		if _, ok := curr.members[saddr]; !ok {
+			m := prev.members[saddr]
+			events = append(events, ChangeEvent{Kind: MemberRemoved, Queue: queue, Ticker: m.ticker, StakeAddress: saddr,
+				Old: strconv.Itoa(m.position)})
+		}
+	}
+	for _, saddr := range curr.order {
+		m := curr.members[saddr]
+		pm, ok := prev.members[saddr]
+		if !ok {
+			events = append(events, ChangeEvent{Kind: MemberAdded, Queue: queue, Ticker: m.ticker, StakeAddress: saddr,
+				New: strconv.Itoa(m.position)})
+			continue
+		}
+		if pm.ticker != m.ticker {
+			events = append(events, ChangeEvent{Kind: TickerChanged, Queue: queue, Ticker: m.ticker, StakeAddress: saddr,
+				Old: pm.ticker, New: m.ticker})
+		}
+		if m.poolId == "" {
+			// the records are rebuilt by the sheet refresh without the pool ids found by the caches,
+			// unknown is not a change: the previous one is kept for the next diff
+			m.poolId = pm.poolId
+			curr.members[saddr] = m
+		}
+		if pm.poolId != m.poolId && pm.poolId != "" {
+			events = append(events, ChangeEvent{Kind: PoolIdChanged, Queue: queue, Ticker: m.ticker, StakeAddress: saddr,
+				Old: pm.poolId, New: m.poolId})
+		}
+		if pm.position != m.position {
+			events = append(events, ChangeEvent{Kind: QueuePositionChanged, Queue: queue, Ticker: m.ticker, StakeAddress: saddr,
+				Old: strconv.Itoa(pm.position), New: strconv.Itoa(m.position)})
+		}
+		if pm.ad != m.ad {
+			events = append(events, ChangeEvent{Kind: AdaDeclaredChanged, Queue: queue, Ticker: m.ticker, StakeAddress: saddr,
+				Old: strconv.Itoa(int(pm.ad)), New: strconv.Itoa(int(m.ad))})
+		}
+	}
+	return events
+}
+
+func diffRefreshState(prev, curr *refreshState) []ChangeEvent {
+	events := diffQueueState(MainQueueName, prev.mainQueue, curr.mainQueue)
+	events = append(events, diffQueueState(AddonQueueName, prev.addonQueue, curr.addonQueue)...)
+	if prev.delegTop != curr.delegTop {
+		events = append(events, ChangeEvent{Kind: DelegCycleTopChanged, Queue: MainQueueName, Ticker: curr.delegTop,
+			Old: prev.delegTop, New: curr.delegTop})
+	}
+	if prev.delegAddonTop != curr.delegAddonTop {
+		events = append(events, ChangeEvent{Kind: DelegCycleTopChanged, Queue: AddonQueueName, Ticker: curr.delegAddonTop,
+			Old: prev.delegAddonTop, New: curr.delegAddonTop})
+	}
+	if prev.delegActive != curr.delegActive {
+		events = append(events, ChangeEvent{Kind: DelegCycleActiveChanged, Ticker: curr.delegActive,
+			Old: prev.delegActive, New: curr.delegActive})
+	}
+	return events
+}
+
+// notifyRefresh publishes a new state, computes the changes since the last notification,
+// logs them and publishes them on the event bus
+func (c *controller) notifyRefresh(reason string) {
+	c.diffMu.Lock()
+	state := c.publishState()
+	curr := c.takeRefreshState()
+	diff := &RefreshDiff{Generation: state.Generation, Reason: reason, Time: utils.Now(), Epoch: utils.CurrentEpoch(), Events: []ChangeEvent{}}
+	if c.lastRefreshState != nil {
+		diff.Events = diffRefreshState(c.lastRefreshState, curr)
+	}
+	c.lastRefreshState = curr
+	c.lastRefreshDiff = diff
+	c.diffMu.Unlock()
+
+	for _, e := range diff.Events {
+		c.V(2).Info("Controller detected change", "reason", reason, "kind", e.Kind, "queue", e.Queue,
+			"ticker", e.Ticker, "old", e.Old, "new", e.New)
+		if e.Ticker != "" {
+			eventbus.Publish(c.bus, MemberChangedTopic, e)
+		}
+	}
+
+	// the control services send a message to the clients via websocket just to refetch the state
+	eventbus.Publish(c.bus, RefreshCompletedTopic, diff)
+}
+
+func (c *controller) GetLastRefreshDiff() *RefreshDiff {
+	c.diffMu.Lock()
+	defer c.diffMu.Unlock()
+	return c.lastRefreshDiff
+}
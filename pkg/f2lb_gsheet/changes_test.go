@@ -0,0 +1,75 @@
+package f2lb_gsheet
+
+import "testing"
+
+func newTestRefreshState(poolIds ...string) *refreshState {
+	s := &refreshState{
+		mainQueue:  queueState{members: make(map[string]memberState)},
+		addonQueue: queueState{members: make(map[string]memberState)},
+	}
+	for i, pid := range poolIds {
+		saddr := "stake1" + string(rune('a'+i))
+		s.mainQueue.order = append(s.mainQueue.order, saddr)
+		s.mainQueue.members[saddr] = memberState{ticker: "T" + string(rune('A'+i)), poolId: pid, position: i, ad: 1000}
+	}
+	if len(poolIds) > 0 {
+		s.mainQueue.served = "TA"
+	}
+	return s
+}
+
+func TestDiffRefreshStatePoolIdsRebuiltBySheetRefresh(t *testing.T) {
+	// caches ready, sheet refresh rebuilding the records without the pool ids, caches ready again
+	ready := newTestRefreshState("pool1a", "pool1b")
+	rebuilt := newTestRefreshState("", "")
+	readyAgain := newTestRefreshState("pool1a", "pool1b")
+
+	if events := diffRefreshState(ready, rebuilt); len(events) != 0 {
+		t.Fatalf("sheet refresh: expected no events, got %+v", events)
+	}
+	if pid := rebuilt.mainQueue.members["stake1a"].poolId; pid != "pool1a" {
+		t.Fatalf("sheet refresh: expected the previous pool id to be kept, got %q", pid)
+	}
+	if events := diffRefreshState(rebuilt, readyAgain); len(events) != 0 {
+		t.Fatalf("caches ready: expected no events, got %+v", events)
+	}
+}
+
+func TestDiffRefreshStatePoolIdFound(t *testing.T) {
+	// the first refresh does not know the pool ids yet
+	if events := diffRefreshState(newTestRefreshState("", ""), newTestRefreshState("pool1a", "")); len(events) != 0 {
+		t.Fatalf("expected no events, got %+v", events)
+	}
+}
+
+func TestDiffRefreshStatePoolIdChanged(t *testing.T) {
+	events := diffRefreshState(newTestRefreshState("pool1a", "pool1b"), newTestRefreshState("pool1a", "pool1c"))
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %+v", events)
+	}
+	e := events[0]
+	if e.Kind != PoolIdChanged || e.Queue != MainQueueName || e.Ticker != "TB" || e.Old != "pool1b" || e.New != "pool1c" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+}
+
+func TestDiffRefreshStateQueueChanges(t *testing.T) {
+	prev := newTestRefreshState("pool1a", "pool1b")
+	curr := newTestRefreshState("pool1b")
+	curr.mainQueue.order = []string{"stake1b"}
+	curr.mainQueue.members = map[string]memberState{"stake1b": {ticker: "TB", poolId: "pool1b", position: 0, ad: 2000}}
+	curr.mainQueue.served = "TB"
+
+	kinds := make(map[ChangeKind]int)
+	for _, e := range diffRefreshState(prev, curr) {
+		kinds[e.Kind]++
+	}
+	for _, k := range []ChangeKind{ServedPoolChanged, MemberRemoved, QueuePositionChanged, AdaDeclaredChanged} {
+		if kinds[k] != 1 {
+			t.Errorf("expected 1 %s event, got %d", k, kinds[k])
+		}
+	}
+	if kinds[PoolIdChanged] != 0 {
+		t.Errorf("unexpected %s events: %d", PoolIdChanged, kinds[PoolIdChanged])
+	}
+}
@@ -0,0 +1,170 @@
+package f2lb_gsheet
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/safanaj/go-f2lb/pkg/eventbus"
+	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
+	"github.com/safanaj/go-f2lb/pkg/logging"
+	"github.com/safanaj/go-f2lb/pkg/scheduler"
+)
+
+var communitiesConfigPath string
+
+// directory under the caches store path where each community has its own one
+const communitiesDirName = "communities"
+
+var communityNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// CommunitySheets are the names of the sheets in the spreadsheet of a community, the empty ones are the defaults
+type CommunitySheets struct {
+	MainQueue       string `json:"main_queue" yaml:"main_queue"`
+	AddonQueue      string `json:"addon_queue" yaml:"addon_queue"`
+	Supporters      string `json:"supporters" yaml:"supporters"`
+	DelegationCycle string `json:"delegation_cycle" yaml:"delegation_cycle"`
+}
+
+// Community is another alliance served by the same process, with its own spreadsheet (or local sheets directory).
+// The koios client, the account and pool caches and the pinger are shared with the main controller.
+type Community struct {
+	// used in the routes as /c/<name>/ and as directory name in the caches store path
+	Name           string          `json:"name" yaml:"name"`
+	SpreadsheetID  string          `json:"spreadsheet_id" yaml:"spreadsheet_id"`
+	SheetSourceDir string          `json:"sheet_source_dir" yaml:"sheet_source_dir"`
+	Sheets         CommunitySheets `json:"sheets" yaml:"sheets"`
+	PoolsHintsPath string          `json:"pools_hints_path" yaml:"pools_hints_path"`
+	// tickers of the pools whose owners are admin of the community
+	AdminPools []string `json:"admin_pools" yaml:"admin_pools"`
+	// requests for these hosts are routed to the community without the path prefix
+	Hosts []string `json:"hosts" yaml:"hosts"`
+}
+
+type communitiesConfig struct {
+	Communities []*Community `json:"communities" yaml:"communities"`
+}
+
+func (cm *Community) validate() error {
+	if !communityNameRe.MatchString(cm.Name) {
+		return fmt.Errorf("invalid community name %q: only lowercase letters, digits and dashes are allowed", cm.Name)
+	}
+	if cm.SpreadsheetID == "" && cm.SheetSourceDir == "" {
+		return fmt.Errorf("community %s: one of spreadsheet_id or sheet_source_dir is required", cm.Name)
+	}
+	return nil
+}
+
+// LoadCommunities reads the communities from a YAML (or JSON) file like:
+//
+//	communities:
+//	  - name: other
+//	    spreadsheet_id: 1AbC...
+//	    sheets: {main_queue: MQ, addon_queue: AQ}
+//	    admin_pools: [TICKR]
+//	    hosts: [other.example.org]
+func LoadCommunities(path string) ([]*Community, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := communitiesConfig{}
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	names := make(map[string]struct{})
+	hosts := make(map[string]string)
+	for _, cm := range conf.Communities {
+		if err := cm.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if _, ok := names[cm.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicated community %s", path, cm.Name)
+		}
+		names[cm.Name] = struct{}{}
+		for _, h := range cm.Hosts {
+			if other, ok := hosts[h]; ok {
+				return nil, fmt.Errorf("%s: host %s used by communities %s and %s", path, h, other, cm.Name)
+			}
+			hosts[h] = cm.Name
+		}
+	}
+	return conf.Communities, nil
+}
+
+// GetCommunities returns the communities configured by flag, none when the flag is not set
+func GetCommunities() ([]*Community, error) {
+	if communitiesConfigPath == "" {
+		return nil, nil
+	}
+	return LoadCommunities(communitiesConfigPath)
+}
+
+func (cm *Community) newSheetSource(ctx context.Context) (SheetSource, error) {
+	if cm.SheetSourceDir != "" {
+		return NewLocalSheetSource(cm.SheetSourceDir)
+	}
+	return NewF2LBForSpreadsheet(ctx, cm.SpreadsheetID)
+}
+
+// storeDir is where the history and the sheets snapshot of the community are kept
+func (cm *Community) storeDir() string {
+	if cachesStoreDirPath == "" {
+		return ""
+	}
+	return filepath.Join(cachesStoreDirPath, communitiesDirName, cm.Name)
+}
+
+// NewCommunityController returns a controller for the community sharing the koios client,
+// the caches and the pinger of the parent, that has to be a controller returned by NewController.
+// The shared parts are started and stopped only by the parent.
+func NewCommunityController(ctx context.Context, logger logging.Logger, cm *Community, parent Controller) (Controller, error) {
+	pc, ok := parent.(*controller)
+	if !ok {
+		return nil, fmt.Errorf("community %s: unsupported parent controller %T", cm.Name, parent)
+	}
+	if err := cm.validate(); err != nil {
+		return nil, err
+	}
+	storeDir := cm.storeDir()
+	if storeDir != "" {
+		if err := os.MkdirAll(storeDir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	logger = logger.WithName(cm.Name)
+	src, err := cm.newSheetSource(ctx)
+	if err != nil {
+		logger.Error(err, "Controller sheet source not available, will retry", "community", cm.Name)
+		src = &lazySheetSource{newSource: func() (SheetSource, error) { return cm.newSheetSource(ctx) }}
+	}
+
+	// the context is canceled on Stop, so the watch of the pools hints ends with the controller
+	cctx, cctxCancel := context.WithCancel(ctx)
+	return &controller{
+		Logger:          logger,
+		ctx:             cctx,
+		source:          src,
+		refreshInterval: defaultRefreshInterval,
+		kc:              pc.kc,
+		cp:              pc.cp,
+		ctxCancel:       cctxCancel,
+		accountCache:    pc.accountCache,
+		poolCache:       pc.poolCache,
+		stakePoolSet:    f2lb_members.NewSet(pc.accountCache, pc.poolCache),
+		bus:             eventbus.New(logger.WithName("eventbus")),
+		scheduler:       scheduler.New(logger.WithName("scheduler")),
+		mainQueue:       &MainQueue{sheetParsing: sheetParsing{sheetName: cm.Sheets.MainQueue}},
+		addonQueue:      &AddonQueue{sheetParsing: sheetParsing{sheetName: cm.Sheets.AddonQueue}},
+		supporters:      &Supporters{sheetParsing: sheetParsing{sheetName: cm.Sheets.Supporters}},
+		delegCycle:      &DelegationCycle{sheetParsing: sheetParsing{sheetName: cm.Sheets.DelegationCycle}},
+		history:         newHistoryIn(storeDir, logger),
+		poolsHints:      NewPoolsHints(cm.PoolsHintsPath, logger.WithName("poolshints")),
+		parent:          pc,
+		storeDir:        storeDir,
+	}, nil
+}
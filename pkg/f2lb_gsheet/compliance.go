@@ -0,0 +1,230 @@
+package f2lb_gsheet
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+const complianceFileName = "compliance.json"
+
+// a finding seen in this number of epochs is escalated to critical
+var complianceEscalationEpochs = 3
+
+// the epochs kept in the history of a finding
+const complianceHistoryEpochs = 10
+
+type ComplianceRule string
+
+const (
+	// the member stake is not delegated to the active pool
+	RuleNotDelegatedToActive ComplianceRule = "not_delegated_to_active"
+	// the member holds, across all its stake addresses, less than the ADA declared in the sheet
+	RuleAdaBelowDeclared ComplianceRule = "ada_below_declared"
+	// the member stake is delegated elsewhere while the member pool is the active one
+	RuleDelegatedElsewhereOnOwnTurn ComplianceRule = "delegated_elsewhere_on_own_turn"
+)
+
+type ComplianceSeverity string
+
+const (
+	SeverityWarning  ComplianceSeverity = "warning"
+	SeverityCritical ComplianceSeverity = "critical"
+)
+
+// ComplianceFinding is a rule violated by a member, Epochs are the last epochs in which it was seen
+type ComplianceFinding struct {
+	Rule      ComplianceRule     `json:"rule"`
+	Severity  ComplianceSeverity `json:"severity"`
+	Ticker    string             `json:"ticker"`
+	StakeAddr string             `json:"stake_address"`
+	Expected  string             `json:"expected"`
+	Actual    string             `json:"actual"`
+	Message   string             `json:"message"`
+
+	FirstSeen time.Time     `json:"first_seen"`
+	LastSeen  time.Time     `json:"last_seen"`
+	Epochs    []utils.Epoch `json:"epochs"`
+}
+
+func (f *ComplianceFinding) key() string { return string(f.Rule) + "/" + f.StakeAddr }
+
+// ComplianceReport are the findings of the last check, sorted by severity and ticker
+type ComplianceReport struct {
+	CheckedAt time.Time           `json:"checked_at"`
+	Epoch     utils.Epoch         `json:"epoch"`
+	Findings  []ComplianceFinding `json:"findings"`
+}
+
+func (c *controller) compliancePath() string {
+	if c.storeDir == "" {
+		return ""
+	}
+	return filepath.Join(c.storeDir, complianceFileName)
+}
+
+func loadComplianceReport(path string) (*ComplianceReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &ComplianceReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return report, nil
+}
+
+func saveComplianceReport(path string, report *ComplianceReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data)
+}
+
+// evaluateCompliance applies the rules to the members of the main queue, the findings have no history yet
+func (c *controller) evaluateCompliance() []ComplianceFinding {
+	activeTicker := c.delegCycle.activeTicker
+	activePool := ""
+	if pi, ok := c.poolCache.Get(activeTicker); ok {
+		activePool = pi.IdBech32()
+	}
+	findings := []ComplianceFinding{}
+	seen := make(map[string]bool)
+	for _, r := range c.mainQueue.GetRecords() {
+		if len(r.StakeAddrs) == 0 || seen[r.StakeAddrs[0]] {
+			continue
+		}
+		saddr := r.StakeAddrs[0]
+		seen[saddr] = true
+		ai, ok := c.accountCache.Get(saddr)
+		if !ok {
+			continue
+		}
+
+		delegatedTo := ai.DelegatedPool()
+		if pi, ok := c.poolCache.Get(delegatedTo); ok && pi.Ticker() != "" {
+			delegatedTo = pi.Ticker()
+		}
+		if activePool != "" && ai.DelegatedPool() != activePool {
+			f := ComplianceFinding{
+				Rule: RuleNotDelegatedToActive, Severity: SeverityWarning, Ticker: r.Ticker, StakeAddr: saddr,
+				Expected: activeTicker, Actual: delegatedTo,
+				Message: fmt.Sprintf("%s is delegated to %q instead of the active pool %s", r.Ticker, delegatedTo, activeTicker),
+			}
+			if r.Ticker == activeTicker {
+				f.Rule, f.Severity = RuleDelegatedElsewhereOnOwnTurn, SeverityCritical
+				f.Message = fmt.Sprintf("%s is delegated to %q during its own turn", r.Ticker, delegatedTo)
+			}
+			findings = append(findings, f)
+		}
+
+		// the declared ADA can be spread across all the stake addresses of the member
+		amount := ai.AdaAmount()
+		for _, other := range r.StakeAddrs[1:] {
+			if oai, ok := c.accountCache.Get(other); ok {
+				amount += oai.AdaAmount()
+			}
+		}
+		if declared := r.AD; amount < declared {
+			findings = append(findings, ComplianceFinding{
+				Rule: RuleAdaBelowDeclared, Severity: SeverityWarning, Ticker: r.Ticker, StakeAddr: saddr,
+				Expected: declared.String(), Actual: amount.String(),
+				Message: fmt.Sprintf("%s holds %s but declared %s", r.Ticker, amount.AdaString(), declared.AdaString()),
+			})
+		}
+	}
+	return findings
+}
+
+// mergeComplianceHistory carries the history of the previous findings on the current ones,
+// the findings no more violated are dropped
+func mergeComplianceHistory(prev *ComplianceReport, findings []ComplianceFinding, epoch utils.Epoch, now time.Time) []ComplianceFinding {
+	prevByKey := make(map[string]ComplianceFinding)
+	if prev != nil {
+		for _, f := range prev.Findings {
+			prevByKey[f.key()] = f
+		}
+	}
+	for i := range findings {
+		f := &findings[i]
+		f.FirstSeen, f.Epochs = now, []utils.Epoch{epoch}
+		if pf, ok := prevByKey[f.key()]; ok {
+			f.FirstSeen = pf.FirstSeen
+			f.Epochs = pf.Epochs
+			if !slices.Contains(f.Epochs, epoch) {
+				f.Epochs = append(f.Epochs, epoch)
+			}
+			if len(f.Epochs) > complianceHistoryEpochs {
+				f.Epochs = f.Epochs[len(f.Epochs)-complianceHistoryEpochs:]
+			}
+		}
+		f.LastSeen = now
+		if len(f.Epochs) >= complianceEscalationEpochs {
+			f.Severity = SeverityCritical
+		}
+	}
+	slices.SortFunc(findings, func(a, b ComplianceFinding) int {
+		if a.Severity != b.Severity {
+			// critical first
+			return strings.Compare(string(a.Severity), string(b.Severity))
+		}
+		if a.Ticker != b.Ticker {
+			return strings.Compare(a.Ticker, b.Ticker)
+		}
+		return strings.Compare(string(a.Rule), string(b.Rule))
+	})
+	return findings
+}
+
+// checkCompliance runs the rules when the caches are ready, the report is stored with the findings history
+func (c *controller) checkCompliance() {
+	if !c.IsReady() {
+		return
+	}
+	now := utils.Now()
+	epoch := utils.CurrentEpoch()
+	findings := c.evaluateCompliance()
+
+	c.complianceMu.Lock()
+	defer c.complianceMu.Unlock()
+	if c.complianceReport == nil {
+		if path := c.compliancePath(); path != "" {
+			if report, err := loadComplianceReport(path); err == nil {
+				c.complianceReport = report
+			} else if !os.IsNotExist(err) {
+				c.Error(err, "Controller loading compliance report", "path", path)
+			}
+		}
+	}
+	report := &ComplianceReport{
+		CheckedAt: now,
+		Epoch:     epoch,
+		Findings:  mergeComplianceHistory(c.complianceReport, findings, epoch, now),
+	}
+	c.complianceReport = report
+	c.V(2).Info("Controller checked members compliance", "findings", len(report.Findings))
+
+	if path := c.compliancePath(); path != "" {
+		if err := saveComplianceReport(path, report); err != nil {
+			c.Error(err, "Controller saving compliance report", "path", path)
+		}
+	}
+}
+
+// GetComplianceReport returns the report of the last check, an empty one if the check did not run yet
+func (c *controller) GetComplianceReport() *ComplianceReport {
+	c.complianceMu.RLock()
+	defer c.complianceMu.RUnlock()
+	if c.complianceReport == nil {
+		return &ComplianceReport{Findings: []ComplianceFinding{}}
+	}
+	return c.complianceReport
+}
@@ -4,21 +4,22 @@ import (
 	"context"
 	"fmt"
 	"runtime"
-	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"google.golang.org/api/sheets/v4"
 
-	koios "github.com/cardano-community/koios-go-client/v4"
 	"github.com/safanaj/go-f2lb/pkg/caches/accountcache"
 	"github.com/safanaj/go-f2lb/pkg/caches/blockfrostutils"
+	"github.com/safanaj/go-f2lb/pkg/caches/chainprovider"
 	"github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
 	"github.com/safanaj/go-f2lb/pkg/caches/poolcache"
+	"github.com/safanaj/go-f2lb/pkg/eventbus"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
 	"github.com/safanaj/go-f2lb/pkg/logging"
 	"github.com/safanaj/go-f2lb/pkg/pinger"
+	"github.com/safanaj/go-f2lb/pkg/scheduler"
 	"github.com/safanaj/go-f2lb/pkg/utils"
 )
 
@@ -33,26 +34,30 @@ var (
 	cachesStoreDirPath = ""
 	// account cache flags
 	acWorkers           = 10
-	acCacheSyncers      = 5
 	acAccountInfosToGet = 50
 	acRefreshInterval   = time.Duration(30 * time.Minute)
 	acWorkersInterval   = time.Duration(1 * time.Minute)
 
 	// pool cache flags
 	pcWorkers         = 10
-	pcCacheSyncers    = 5
 	pcPoolInfosToGet  = 50
 	pcRefreshInterval = time.Duration(30 * time.Minute)
 	pcWorkersInterval = time.Duration(1 * time.Minute)
 
 	// koios tip refresh interal
 	koiosTipRefreshInterval = time.Duration(3 * time.Minute)
+
+	// how often the caches are checked for updates to republish the state
+	stateCachesCheckInterval = time.Duration(1 * time.Minute)
 )
 
 type Controller interface {
+	// Refresh skips the parsing when the sheets were not modified, it is the scheduled one
 	Refresh() error
+	// ForceRefresh parses the sheets even if not modified, i.e. when requested by an admin
+	ForceRefresh() error
 
-	GetF2LB() *F2LB
+	GetSheetSource() SheetSource
 
 	GetMainQueue() *MainQueue
 	GetMainQueueRecords() []*MainQueueRec
@@ -66,11 +71,27 @@ type Controller interface {
 	GetSupportersRecords() []*Supporter
 
 	GetDelegationCycle() *DelegationCycle
-
-	SetRefresherChannel(chan<- string) error
-	SetRefresherChannelV2(chan<- string) error
+	GetDelegationForecast() *DelegationForecast
+	SimulateDeclaration(WhatIfDeclaration) (*WhatIfResult, error)
+
+	GetDataQualityReport() *DataQualityReport
+
+	GetPoolsHints() []PoolHint
+	GetPoolsHintsErrors() []string
+	SetPoolHint(ticker, poolId string) error
+	DeletePoolHint(ticker string) error
+	GetPendingSheetChanges() []CellChange
+	GetComplianceReport() *ComplianceReport
+
+	GetEventBus() *eventbus.Bus
+	GetScheduler() *scheduler.Scheduler
+	GetLastRefreshDiff() *RefreshDiff
+	GetState() *State
+	GetHistory() *History
 	SetRefresherInterval(time.Duration) error
+	RequestRefresh(ranges ...string)
 	GetLastRefreshTime() time.Time
+	GetSheetsStatus() SheetsStatus
 	IsRunning() bool
 	Start() error
 	Stop() error
@@ -80,6 +101,7 @@ type Controller interface {
 	GetPoolCache() poolcache.PoolCache
 
 	GetKoiosClient() *koiosutils.KoiosClient
+	GetChainProvider() chainprovider.ChainProvider
 
 	GetTopOfQueues(int, int) (int, int, error)
 	GetValuesInBatch() ([]*ValueRange, error)
@@ -98,9 +120,11 @@ type controller struct {
 	logging.Logger
 
 	ctx       context.Context
-	f2lb      *F2LB
+	source    SheetSource
 	kc        *koiosutils.KoiosClient
 	ctxCancel context.CancelFunc
+	// the chain data is got from here, koios is still used directly for its specific queries
+	cp chainprovider.ChainProvider
 
 	accountCache accountcache.AccountCache
 	poolCache    poolcache.PoolCache
@@ -113,45 +137,108 @@ type controller struct {
 	delegCycle *DelegationCycle
 
 	refreshInterval time.Duration
-	tick            *time.Ticker
-	refresherCh     chan<- string
-	refresherV2Ch   chan<- string
+	// runs the refreshes and the koios tip cacher, see registerJobs
+	scheduler       *scheduler.Scheduler
 	mu              sync.RWMutex
 	isRefreshing    bool
 	lastRefreshTime time.Time
 
+	// changes are published here, the API layers and the pinger subscribe to it
+	bus *eventbus.Bus
+
+	diffMu           sync.Mutex
+	lastRefreshState *refreshState
+	lastRefreshDiff  *RefreshDiff
+
+	// published on every notified refresh, see State
+	state           atomic.Pointer[State]
+	stateGeneration uint64
+	// the last fetch of the caches seen by republishStateOnCachesUpdate
+	cachesFetchedAt time.Time
+
+	history    *History
+	poolsHints *PoolsHints
+
+	writerMu            sync.Mutex
+	pendingSheetChanges []CellChange
+
+	complianceMu     sync.RWMutex
+	complianceReport *ComplianceReport
+
+	sheetsStatusMu   sync.RWMutex
+	sheetsStatus     SheetsStatus
+	sheetsRetryDelay time.Duration
+	sheetsRetryTimer *time.Timer
+
+	// what was parsed by the last refresh, used to skip the redundant ones
+	parsedSheetsRevision string
+	parsedSheetsHash     string
+	parsedSheetsEpoch    utils.Epoch
+
+	refreshReqMu     sync.Mutex
+	refreshReqTimer  *time.Timer
+	refreshReqFull   bool
+	refreshReqRanges []string
+
 	koiosTipBlockHeightCached int
 	koiosTipSlotCached        int
 
 	pinger pinger.Pinger
+
+	// the controller owning the koios client, the caches and the pinger shared with this one,
+	// nil when this controller is the owner
+	parent *controller
+	// directory for the history and the sheets snapshot, it is specific to the community
+	storeDir string
 }
 
 var _ Controller = &controller{}
 
 func NewController(ctx context.Context, logger logging.Logger) Controller {
+	if sheetSchemaPath != "" {
+		utils.CheckErr(LoadSheetSchemas(sheetSchemaPath))
+	}
+	src, err := NewSheetSource(ctx)
+	if err != nil {
+		// start anyway, the last good snapshot will be served until the source is available
+		logger.Error(err, "Controller sheet source not available, will retry")
+		src = &lazySheetSource{newSource: func() (SheetSource, error) { return NewSheetSource(ctx) }}
+	}
+	return NewControllerWithSheetSource(ctx, logger, src)
+}
+
+func NewControllerWithSheetSource(ctx context.Context, logger logging.Logger, src SheetSource) Controller {
 	cctx, cctxCancel := context.WithCancel(ctx)
 	kc := koiosutils.New(cctx)
 	bfc := blockfrostutils.New(cctx)
-	ac := accountcache.New(kc, bfc, uint32(acWorkers), uint32(acCacheSyncers),
+	cp, err := chainprovider.New(cctx, kc, bfc, logger.WithName("chainprovider"))
+	utils.CheckErr(err)
+	ac := accountcache.New(cp, uint32(acWorkers),
 		acRefreshInterval, acWorkersInterval, uint32(acAccountInfosToGet),
 		logger.WithName("accountcache"), cachesStoreDirPath)
-	pc := poolcache.New(kc, uint32(pcWorkers), uint32(pcCacheSyncers),
+	pc := poolcache.New(cp, uint32(pcWorkers),
 		pcRefreshInterval, pcWorkersInterval, uint32(pcPoolInfosToGet),
 		logger.WithName("poolcache"), cachesStoreDirPath)
 	return &controller{
 		Logger:          logger,
 		ctx:             ctx,
-		f2lb:            NewF2LB(cctx),
+		source:          src,
 		refreshInterval: defaultRefreshInterval,
 		kc:              kc,
+		cp:              cp,
 		ctxCancel:       cctxCancel,
 		accountCache:    ac,
 		poolCache:       pc,
 		stakePoolSet:    f2lb_members.NewSet(ac, pc),
+		bus:             eventbus.New(logger.WithName("eventbus")),
+		scheduler:       scheduler.New(logger.WithName("scheduler")),
 		mainQueue:       &MainQueue{},
 		addonQueue:      &AddonQueue{},
 		supporters:      &Supporters{},
 		delegCycle:      &DelegationCycle{},
+		history:         newHistoryIn(cachesStoreDirPath, logger),
+		poolsHints:      NewPoolsHints(poolsHintsPath, logger.WithName("poolshints")),
+		storeDir:        cachesStoreDirPath,
 	}
 }
 
@@ -163,24 +250,17 @@ const (
 	supportersVRI
 	delegCycleVRI
 
+	// header rows, used to map the columns
+	mainQueueHeaderVRI
+	addonQueueHeaderVRI
+	supportersHeaderVRI
+	delegCycleHeaderVRI
+
 	valueRangeIdxMax
 )
 
-func (c *controller) SetRefresherChannel(ch chan<- string) error {
-	if c.IsRunning() {
-		return IsRunningErr
-	}
-	c.refresherCh = ch
-	return nil
-}
-
-func (c *controller) SetRefresherChannelV2(ch chan<- string) error {
-	if c.IsRunning() {
-		return IsRunningErr
-	}
-	c.refresherV2Ch = ch
-	return nil
-}
+func (c *controller) GetEventBus() *eventbus.Bus         { return c.bus }
+func (c *controller) GetScheduler() *scheduler.Scheduler { return c.scheduler }
 
 func (c *controller) SetRefresherInterval(d time.Duration) error {
 	if c.IsRunning() {
@@ -199,15 +279,12 @@ func (c *controller) getValuesInBatch() ([]*ValueRange, error) {
 	ranges[addonQueueVRI] = c.addonQueue.GetRange()
 	ranges[supportersVRI] = c.supporters.GetRange()
 	ranges[delegCycleVRI] = c.delegCycle.GetRange()
+	ranges[mainQueueHeaderVRI] = c.mainQueue.GetHeaderRange()
+	ranges[addonQueueHeaderVRI] = c.addonQueue.GetHeaderRange()
+	ranges[supportersHeaderVRI] = c.supporters.GetHeaderRange()
+	ranges[delegCycleHeaderVRI] = c.delegCycle.GetHeaderRange()
 
-	res, err := c.f2lb.Spreadsheets.Values.BatchGet(
-		f2lbSpreadSheetID).MajorDimension("ROWS").Ranges(
-		ranges...).Do()
-
-	if err != nil {
-		return nil, err
-	}
-	return res.ValueRanges, nil
+	return c.source.BatchGet(ranges...)
 }
 
 func (c *controller) GetTopOfQueues(mainQueueValuesN, addonQueueValuesN int) (int, int, error) {
@@ -223,65 +300,38 @@ func (c *controller) getTopOfQueues(mainQueueValuesN, addonQueueValuesN int) (in
 	ranges[mainQueueVRI] = string(append(runesMQ[:len(runesMQ)-1], []rune(fmt.Sprintf("G%d", mainQueueValuesN+10))...))
 	ranges[addonQueueVRI] = string(append(runesAQ[:len(runesAQ)-1], []rune(fmt.Sprintf("B%d", addonQueueValuesN+10))...))
 
-	res, err := c.f2lb.Spreadsheets.Get(f2lbSpreadSheetID).Ranges(ranges...).IncludeGridData(true).Do()
+	idxs, err := c.source.GetTopRows(
+		TopRowRange{Range: ranges[mainQueueVRI], ValueColumn: 6},
+		TopRowRange{Range: ranges[addonQueueVRI], ValueColumn: 1})
 	if err != nil {
 		return -1, -1, err
 	}
-
-	idxs := []int{-1, -1}
-	for sheetIdx, sheet := range res.Sheets {
-		for i, rowData := range sheet.Data[0].RowData {
-			if len(rowData.Values) == 0 {
-				continue
-			}
-			if sheetIdx == int(mainQueueVRI) {
-				if rowData.Values[0].EffectiveFormat.BackgroundColorStyle.ThemeColor == "" && rowData.Values[6].FormattedValue == "" {
-					continue
-				}
-			} else {
-				if rowData.Values[0].EffectiveFormat.BackgroundColorStyle.ThemeColor == "" && rowData.Values[1].FormattedValue == "" {
-					continue
-				}
-			}
-			idxs[sheetIdx] = i
-			break
-		}
-	}
-	return idxs[0], idxs[1], err
+	return idxs[0], idxs[1], nil
 }
 
 func (c *controller) getTopOfDelegCycle(delegCycleValuesN int) (int, error) {
 	ranges := make([]string, 1)
 	runesDC := []rune(c.delegCycle.GetRange())
 	ranges[0] = string(append(runesDC[:len(runesDC)-1], []rune(fmt.Sprintf("A%d", delegCycleValuesN+10))...))
-	res, err := c.f2lb.Spreadsheets.Get(f2lbSpreadSheetID).Ranges(ranges...).IncludeGridData(true).Do()
+	idxs, err := c.source.GetTopRows(TopRowRange{Range: ranges[0], ValueColumn: -1})
 	if err != nil {
 		return -1, err
 	}
-	idx := -1
-	for _, sheet := range res.Sheets {
-		for i, rowData := range sheet.Data[0].RowData {
-			if len(rowData.Values) == 0 {
-				continue
-			}
-			if rowData.Values[0].EffectiveFormat.BackgroundColorStyle.ThemeColor == "" {
-				continue
-			}
-			idx = i
-			break
-		}
-	}
-	return idx, err
+	return idxs[0], nil
 }
 
-func (c *controller) getTopOfDelegCycleFromValues(vr *ValueRange) (int, error) {
+// getTopOfDelegCycleFromValues looks for the row of the current epoch, the columns are resolved with the header
+func (c *controller) getTopOfDelegCycleFromValues(vr, header *ValueRange) (int, error) {
 	if vr == nil || len(vr.Values) < 2 {
 		return -1, fmt.Errorf("getTopOfDelegCycleFromValues invalid ValueRange")
 	}
+	cm := newColumnMapping(delegationCycleSheet, header)
 	currentEpochAsUint64 := uint64(utils.CurrentEpoch())
 	for idx, v := range vr.Values {
-		eVal, err := strconv.ParseUint(v[0].(string), 10, 32)
-		if err != nil || eVal < currentEpochAsUint64 {
+		// the unparsable epochs are just skipped, the data quality is reported by the delegation cycle refresh
+		p := &rowParser{cm: cm, row: v}
+		eVal := p.uint(fieldEpoch, 32)
+		if eVal < currentEpochAsUint64 {
 			continue
 		}
 		if eVal > currentEpochAsUint64 {
@@ -296,14 +346,22 @@ func (c *controller) getTopOfDelegCycleFromValues(vr *ValueRange) (int, error) {
 	return -1, fmt.Errorf("getTopOfDelegCycleFromValues invalid ValueRange or unable to find current epoch")
 }
 
-func getOnlyTickers(rows [][]any) []string {
+func getOnlyTickers(cm *columnMapping, rows [][]any) []string {
 	tickers := []string{}
 	for _, v := range rows {
-		tickers = append(tickers, v[2].(string))
+		p := &rowParser{cm: cm, row: v}
+		if t := p.str(fieldTicker); t != "" {
+			tickers = append(tickers, t)
+		}
 	}
 	return tickers
 }
 
+func (c *controller) ForceRefresh() error {
+	c.invalidateParsedSheets()
+	return c.Refresh()
+}
+
 func (c *controller) Refresh() error {
 	var wg sync.WaitGroup
 
@@ -320,38 +378,71 @@ func (c *controller) Refresh() error {
 	c.mu.Lock()
 	defer func() {
 		c.isRefreshing = false
-		c.lastRefreshTime = time.Now().UTC()
+		c.lastRefreshTime = utils.Now().UTC()
 		c.mu.Unlock()
 		c.V(2).Info("Controller refresh done", "in", time.Since(startRefreshAt).String())
 	}()
 	c.isRefreshing = true
 
-	res, err := c.getValuesInBatch()
-	if err != nil {
-		return err
+	revision := c.sheetsRevision()
+	if c.isSheetsRevisionParsed(revision) {
+		c.setSheetsFresh(utils.Now())
+		c.V(2).Info("Controller refresh skipped, spreadsheet not modified", "revision", revision)
+		// the caches could be ready since the last parse
+		c.checkCompliance()
+		return nil
 	}
 
-	// for MainQueue and AddonQueue sheets get the top of the queues,
-	// basically the first row with a specific format/color
-	// with that indexes we can pass the correct subset of values to the Queues objects
-	mainQueueTopIdx, addonQueueTopIdx, err := c.getTopOfQueues(
-		len(res[mainQueueVRI].Values),
-		len(res[addonQueueVRI].Values))
+	snap, err := c.fetchSheetsSnapshot()
 	if err != nil {
-		return err
+		c.setSheetsStale(err)
+		c.scheduleRefreshRetry()
+		if c.GetSheetsStatus().HasData() {
+			// keep serving the data we already have
+			return err
+		}
+		var lerr error
+		if snap, lerr = loadSheetsSnapshot(c.sheetsSnapshotPath()); lerr != nil {
+			c.Error(lerr, "Controller unable to load last good sheets snapshot")
+			return err
+		}
+		c.Error(err, "Controller refresh failed, using last good sheets snapshot", "taken", snap.Time.Format(time.RFC850))
+		c.setSheetsFromSnapshot(snap.Time)
+		// the top of the delegation cycle depends on the current epoch
+		if idx, err := c.getTopOfDelegCycleFromValues(snap.Values[delegCycleVRI], snap.Values[delegCycleHeaderVRI]); err == nil {
+			snap.DelegCycleTopIdx = idx
+		}
+	} else {
+		if hash := snap.contentHash(); c.isSheetsContentParsed(hash) {
+			c.setSheetsFresh(snap.Time)
+			c.setSheetsParsed(revision, hash)
+			c.V(2).Info("Controller refresh skipped, spreadsheet content not changed", "in", time.Since(startRefreshAt).String())
+			c.checkCompliance()
+			return nil
+		}
+		if path := c.sheetsSnapshotPath(); path != "" {
+			if err := saveSheetsSnapshot(path, snap); err != nil {
+				c.Error(err, "Controller saving sheets snapshot", "path", path)
+			}
+		}
+		c.setSheetsFresh(snap.Time)
 	}
 
-	oldTickersValues := res[mainQueueVRI].Values[:mainQueueTopIdx]
-	res[mainQueueVRI].Values = res[mainQueueVRI].Values[mainQueueTopIdx:]
-	res[addonQueueVRI].Values = res[addonQueueVRI].Values[addonQueueTopIdx:]
+	contentHash := snap.contentHash()
+	res := snap.Values
+	oldTickersValueRange := subValueRange(res[mainQueueVRI], 0, snap.MainQueueTopIdx)
+	res[mainQueueVRI] = subValueRange(res[mainQueueVRI], snap.MainQueueTopIdx, len(res[mainQueueVRI].Values))
+	res[addonQueueVRI] = subValueRange(res[addonQueueVRI], snap.AddonQueueTopIdx, len(res[addonQueueVRI].Values))
+	res[delegCycleVRI] = subValueRange(res[delegCycleVRI], snap.DelegCycleTopIdx, len(res[delegCycleVRI].Values))
 
-	if idx, err := c.getTopOfDelegCycleFromValues(res[delegCycleVRI]); err == nil {
-		res[delegCycleVRI].Values = res[delegCycleVRI].Values[idx:]
-	} else if idx, err := c.getTopOfDelegCycle(len(res[delegCycleVRI].Values)); err == nil {
-		res[delegCycleVRI].Values = res[delegCycleVRI].Values[idx:]
-	} else {
-		return err
-	}
+	// this is a kind of hard refresh
+	c.mainQueue.ResetCaches()
+	c.addonQueue.ResetCaches()
+
+	c.mainQueue.SetHeader(res[mainQueueHeaderVRI])
+	c.addonQueue.SetHeader(res[addonQueueHeaderVRI])
+	c.supporters.SetHeader(res[supportersHeaderVRI])
+	c.delegCycle.SetHeader(res[delegCycleHeaderVRI])
 
 	c.V(2).Info("Controller refresh got data from spreadsheet", "in", time.Since(startRefreshAt).String())
 
@@ -359,12 +450,13 @@ func (c *controller) Refresh() error {
 	var oldMainQueue *MainQueue
 	{
 		oldMainQueue = &MainQueue{}
-		if err := oldMainQueue.Refresh(c.f2lb, &ValueRange{Values: oldTickersValues}); err != nil {
+		oldMainQueue.SetHeader(res[mainQueueHeaderVRI])
+		if err := oldMainQueue.Refresh(c.source, oldTickersValueRange); err != nil {
 			c.Error(err, "oldMainQueue.Refresh failed")
 		}
 	}
 
-	hintsMapping := getHintsMapping(poolsHintsPath)
+	hintsMapping := c.poolsHints.Mapping()
 
 	wg.Add(4)
 	// wg.Add(1)
@@ -379,7 +471,7 @@ func (c *controller) Refresh() error {
 	// wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := c.mainQueue.Refresh(c.f2lb, res[mainQueueVRI]); err != nil {
+		if err := c.mainQueue.Refresh(c.source, res[mainQueueVRI]); err != nil {
 			c.Error(err, "mainQueue.Refresh failed")
 		}
 
@@ -393,7 +485,7 @@ func (c *controller) Refresh() error {
 		tickers_with_pid_m := make(map[string]any)
 
 		for _, r := range append(c.mainQueue.GetRecords(), oldMainQueue.GetRecords()...) {
-			// should we add all the addresses?
+			// only the main address is waited for, the others are for the totals of the member
 			saddrs_m[r.StakeAddrs[0]] = struct{}{}
 
 			// c.V(2).Info("check for hints mapping", "ticker", r.Ticker)
@@ -408,14 +500,22 @@ func (c *controller) Refresh() error {
 						continue
 					}
 					// try to use hints mapping here as early speculation
-					r.PoolIdBech32 = pid
-					if hex, err := utils.Bech32ToHex(r.PoolIdBech32); err == nil {
-						r.PoolIdHex = hex
+					setHintedPoolId := func(rec *MainQueueRec) {
+						rec.PoolIdBech32 = pid
+						if hex, err := utils.Bech32ToHex(rec.PoolIdBech32); err == nil {
+							rec.PoolIdHex = hex
+						}
+					}
+					if c.mainQueue.GetByTicker(r.Ticker) == r {
+						r = c.mainQueue.UpdateRecord(r.Ticker, setHintedPoolId)
+					} else {
+						// the old main queue is not published
+						setHintedPoolId(r)
 					}
 				}
 			}
 
-			saddrs = append(saddrs, r.StakeAddrs[0])
+			saddrs = append(saddrs, r.StakeAddrs...)
 
 			if r.PoolIdBech32 == "" {
 				tickers_m[r.Ticker] = struct{}{}
@@ -454,29 +554,25 @@ func (c *controller) Refresh() error {
 			{
 				c.V(2).Info("Controller refresh got pools info data from koios", "in", time.Since(startRefreshAt).String())
 				for _, r := range c.mainQueue.GetRecords() {
-
-					if pi, ok := c.poolCache.Get(r.Ticker); ok {
+					pi, piOk := c.poolCache.Get(r.Ticker)
+					if piOk {
 						delete(tickers_m, r.Ticker)
-						r.PoolIdBech32 = pi.IdBech32()
-						r.PoolIdHex = pi.IdHex()
 					}
-
-					if actual, loaded := c.mainQueue.cacheByPoolIdBech32.LoadOrStore(r.PoolIdBech32, r); loaded && actual != r {
-						c.mainQueue.cacheByPoolIdBech32.Store(r.PoolIdBech32, r)
-					}
-					if actual, loaded := c.mainQueue.cacheByPoolIdHex.LoadOrStore(r.PoolIdHex, r); loaded && actual != r {
-						c.mainQueue.cacheByPoolIdHex.Store(r.PoolIdHex, r)
-					}
-
-					if ai, ok := c.accountCache.Get(r.StakeAddrs[0]); ok {
+					ai, aiOk := c.accountCache.Get(r.StakeAddrs[0])
+					if aiOk {
 						delete(saddrs_m, r.StakeAddrs[0])
-						r.AdaDelegated = uint16(ai.AdaAmount())
-						if poolInQueue := c.mainQueue.GetByPoolId(ai.DelegatedPool()); poolInQueue != nil {
-							r.DelegatedPool = poolInQueue.Ticker
-						} else {
-							r.DelegatedPool = ai.DelegatedPool()
-						}
 					}
+					// the pool ids are needed to resolve the delegated pools of the next records
+					c.mainQueue.UpdateRecord(r.Ticker, func(rec *MainQueueRec) {
+						if piOk {
+							rec.PoolIdBech32 = pi.IdBech32()
+							rec.PoolIdHex = pi.IdHex()
+						}
+						if aiOk {
+							rec.AdaDelegated = ai.AdaAmount()
+							rec.DelegatedPool = c.delegatedPoolTicker(ai.DelegatedPool())
+						}
+					})
 				}
 
 				if len(tickers_m) > 0 && !c.poolCache.Ready() {
@@ -505,15 +601,12 @@ func (c *controller) Refresh() error {
 			}
 
 			// send a message to the clients via websocket just to refetch the state that is not updated with details
-			if c.refresherCh != nil {
-				c.V(2).Info("Controller sending refresh message to all the clients via websocket", "in", time.Since(startRefreshAt).String())
-				c.refresherCh <- "ALL"
-			}
-
-			if c.refresherV2Ch != nil {
-				c.V(2).Info("Controller sending refresh message to all the clients via websocket", "in", time.Since(startRefreshAt).String())
-				c.refresherV2Ch <- "ALL"
-			}
+			c.V(2).Info("Controller sending refresh message to all the clients via websocket", "in", time.Since(startRefreshAt).String())
+			// the status columns of this refresh were not written while the caches were not ready
+			c.writeSheetChanges()
+			c.checkCompliance()
+			c.notifyRefresh("caches ready")
+			eventbus.Publish(c.bus, eventbus.CacheReady, utils.Now())
 
 		}()
 
@@ -523,7 +616,7 @@ func (c *controller) Refresh() error {
 	// wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := c.addonQueue.Refresh(c.f2lb, res[addonQueueVRI]); err != nil {
+		if err := c.addonQueue.Refresh(c.source, res[addonQueueVRI]); err != nil {
 			c.Error(err, "addonQueue.Refresh failed")
 		}
 		c.V(2).Info("Controller refresh filled addon Queue", "in", time.Since(startRefreshAt).String())
@@ -532,7 +625,7 @@ func (c *controller) Refresh() error {
 	// wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := c.supporters.Refresh(c.f2lb, res[supportersVRI]); err != nil {
+		if err := c.supporters.Refresh(c.source, res[supportersVRI]); err != nil {
 			c.Error(err, "supporters.Refresh failed")
 		}
 		c.V(2).Info("Controller refresh filled supporters", "in", time.Since(startRefreshAt).String())
@@ -609,8 +702,8 @@ func (c *controller) Refresh() error {
 				}
 				c.stakePoolSet.SetWithValuesFromMainQueue(vals)
 				c.V(5).Info("Added missing from MainQ SP", "vals", vals, "rec", r)
-				// lets add also the stake addrs to the cache, should we add all the addresses?
-				c.accountCache.Add(r.StakeAddrs[0])
+				// lets add also the stake addrs to the cache
+				c.accountCache.AddMany(r.StakeAddrs)
 				c.poolCache.AddMany([]any{&poolcache.MinimalPoolInfo{Ticker: r.Ticker, Bech32: r.PoolIdBech32}})
 			} else {
 				// this is in mainQ, lets update EG from addonQ
@@ -633,19 +726,19 @@ func (c *controller) Refresh() error {
 					Ticker: c.delegCycle.activeTicker,
 				}
 				c.stakePoolSet.SetWithValuesFromMainQueue(vals)
-				c.poolCache.Add(c.delegCycle.activeTicker)
+				c.poolCache.AddNow(c.delegCycle.activeTicker)
 			}
 		}
 
 		c.V(2).Info("Controller refresh stake pool set filled", "in", time.Since(startRefreshAt).String())
 	}
 
-	if c.refresherCh != nil {
-		c.refresherCh <- "ALL"
-	}
-	if c.refresherV2Ch != nil {
-		c.refresherV2Ch <- "ALL"
-	}
+	c.checkQueueRules()
+	c.setSheetsParsed(revision, contentHash)
+	c.saveHistory()
+	c.writeSheetChanges()
+	c.checkCompliance()
+	c.notifyRefresh("sheet refresh")
 	c.V(2).Info("Controller caches are ready?",
 		"account", c.accountCache.Ready(),
 		"pool", c.poolCache.Ready(),
@@ -653,7 +746,7 @@ func (c *controller) Refresh() error {
 	return nil
 }
 
-func (c *controller) GetF2LB() *F2LB { return c.f2lb }
+func (c *controller) GetSheetSource() SheetSource { return c.source }
 
 func (c *controller) GetMainQueue() *MainQueue             { return c.mainQueue }
 func (c *controller) GetMainQueueRecords() []*MainQueueRec { return c.mainQueue.GetRecords() }
@@ -668,67 +761,130 @@ func (c *controller) GetSupportersRecords() []*Supporter { return c.supporters.G
 
 func (c *controller) GetDelegationCycle() *DelegationCycle { return c.delegCycle }
 
+func (c *controller) GetDataQualityReport() *DataQualityReport {
+	return &DataQualityReport{
+		GeneratedAt: c.GetLastRefreshTime(),
+		Sheets: []SheetDataQuality{
+			c.mainQueue.GetDataQuality(),
+			c.addonQueue.GetDataQuality(),
+			c.supporters.GetDataQuality(),
+			c.delegCycle.GetDataQuality(),
+		},
+	}
+}
+
 func (c *controller) GetStakePoolSet() f2lb_members.StakePoolSet { return c.stakePoolSet }
 func (c *controller) GetAccountCache() accountcache.AccountCache { return c.accountCache }
 func (c *controller) GetPoolCache() poolcache.PoolCache          { return c.poolCache }
 
-func (c *controller) GetKoiosClient() *koiosutils.KoiosClient { return c.kc }
+func (c *controller) GetKoiosClient() *koiosutils.KoiosClient       { return c.kc }
+func (c *controller) GetChainProvider() chainprovider.ChainProvider { return c.cp }
 
 func (c *controller) GetLastRefreshTime() time.Time { return c.lastRefreshTime }
 
-func (c *controller) GetKoiosTipBlockHeight() int { return c.koiosTipBlockHeightCached }
-func (c *controller) GetKoiosTipSlot() int        { return c.koiosTipSlotCached }
+func (c *controller) GetKoiosTipBlockHeight() int {
+	if c.parent != nil {
+		return c.parent.GetKoiosTipBlockHeight()
+	}
+	return c.koiosTipBlockHeightCached
+}
+func (c *controller) GetKoiosTipSlot() int {
+	if c.parent != nil {
+		return c.parent.GetKoiosTipSlot()
+	}
+	return c.koiosTipSlotCached
+}
 
-func (c *controller) GetPinger() pinger.Pinger      { return c.pinger }
+func (c *controller) GetPinger() pinger.Pinger {
+	if c.parent != nil {
+		return c.parent.GetPinger()
+	}
+	return c.pinger
+}
 func (c *controller) SetPinger(p pinger.Pinger)     { c.pinger = p }
 func (c *controller) GetContext() context.Context   { return c.ctx }
 func (c *controller) GetCachesStoreDirPath() string { return cachesStoreDirPath }
 
-func (c *controller) IsRunning() bool { return c.tick != nil }
-func (c *controller) Start() error {
-	c.tick = time.NewTicker(c.refreshInterval)
-	c.accountCache.Start()
-	c.poolCache.Start()
-	if err := c.Refresh(); err != nil {
+func (c *controller) IsRunning() bool { return c.scheduler.IsRunning() }
+
+// names of the jobs registered by the controller
+const (
+	RefreshJobName           = "refresh"
+	EpochStartRefreshJobName = "epoch-start-refresh"
+	KoiosTipJobName          = "koios-tip"
+	StateCachesCheckJobName  = "state-caches-check"
+)
+
+// registerJobs schedules the periodic refresh and also one at the epoch start, so the history and
+// the state are updated as soon as the served pools change
+func (c *controller) registerJobs() error {
+	refresh := func(context.Context) error { return c.Refresh() }
+	if err := c.scheduler.Register(RefreshJobName, scheduler.Every(c.refreshInterval), refresh); err != nil {
 		return err
 	}
-	go func() {
-		for range c.tick.C {
-			// this is a kind of hard refresh
-			c.mainQueue.ResetCaches()
-			c.addonQueue.ResetCaches()
-			if err := c.Refresh(); err != nil {
-				c.Error(err, "Controller Refresh failed")
-			}
-		}
-	}()
+	if err := c.scheduler.Register(EpochStartRefreshJobName, scheduler.AtEpochStart(), refresh); err != nil {
+		return err
+	}
+	// the caches are shared with the parent, every controller republishes its own state
+	if err := c.scheduler.Register(StateCachesCheckJobName, scheduler.Every(stateCachesCheckInterval),
+		func(context.Context) error { return c.republishStateOnCachesUpdate() }); err != nil {
+		return err
+	}
+	if c.parent != nil {
+		// the koios tip is cached by the parent
+		return nil
+	}
+	return c.scheduler.Register(KoiosTipJobName, scheduler.Every(koiosTipRefreshInterval), func(context.Context) error {
+		return c.refreshKoiosTip()
+	})
+}
 
-	// run a koios tip cacher, TODO: make a better/cleaner context cancel instead of ugly chaining
-	cctx, cctxCancel := context.WithCancel(c.ctx)
-	octxCancel := c.ctxCancel
-	c.ctxCancel = func() { cctxCancel(); octxCancel() }
-	go func() {
-		if _, block, slot, err := c.kc.GetTip(); err == nil {
-			c.koiosTipBlockHeightCached = block
-			c.koiosTipSlotCached = slot
-		} else {
-			c.Error(err, "[koios] GetTip failed")
+func (c *controller) refreshKoiosTip() error {
+	_, block, slot, err := c.cp.GetTip()
+	if err != nil {
+		c.Error(err, "GetTip failed")
+		return err
+	}
+	c.koiosTipBlockHeightCached = block
+	c.koiosTipSlotCached = slot
+	return nil
+}
+
+func (c *controller) Start() error {
+	if c.IsRunning() {
+		return IsRunningErr
+	}
+	// the jobs and the subscriptions are kept on Stop, they are registered only on the first Start
+	if len(c.scheduler.Jobs()) == 0 {
+		if err := c.registerJobs(); err != nil {
+			return err
 		}
-		t := time.NewTicker(koiosTipRefreshInterval)
-		for {
-			select {
-			case <-cctx.Done():
-				return
-			case <-t.C:
-				if _, block, slot, err := c.kc.GetTip(); err == nil {
-					c.koiosTipBlockHeightCached = block
-					c.koiosTipSlotCached = slot
-				} else {
-					c.Error(err, "[koios] GetTip failed")
-				}
-			}
+		c.subscribeTipReports()
+	}
+	c.scheduler.Start(c.ctx)
+	if c.parent == nil {
+		c.accountCache.Start()
+		c.poolCache.Start()
+		go c.refreshKoiosTip()
+	}
+	if err := c.Refresh(); err != nil {
+		// a retry is scheduled, meanwhile the last good snapshot is served if any
+		c.Error(err, "Controller first Refresh failed")
+	}
+
+	// reload the pools hints when the file is modified
+	go c.poolsHints.Watch(c.ctx, func() {
+		c.invalidateParsedSheets()
+		c.RequestRefresh()
+	})
+
+	if c.parent != nil {
+		// the caches, the koios tip and the pinger are run by the parent
+		if p := c.parent.GetPinger(); p != nil {
+			p.AddStakePoolSet(c.stakePoolSet)
 		}
-	}()
+		return nil
+	}
 
 	if c.pinger != nil && !c.pinger.IsRunning() {
 		c.pinger.Start(c.ctx)
@@ -738,12 +894,19 @@ func (c *controller) Start() error {
 
 func (c *controller) Stop() error {
 	c.V(2).Info("Stopping controller")
-	c.tick.Stop()
-	c.tick = nil
-	c.accountCache.Stop()
-	c.poolCache.Stop()
-	if c.pinger != nil {
-		c.pinger.Stop()
+	c.scheduler.Stop()
+	c.sheetsStatusMu.Lock()
+	if c.sheetsRetryTimer != nil {
+		c.sheetsRetryTimer.Stop()
+		c.sheetsRetryTimer = nil
+	}
+	c.sheetsStatusMu.Unlock()
+	if c.parent == nil {
+		c.accountCache.Stop()
+		c.poolCache.Stop()
+		if c.pinger != nil {
+			c.pinger.Stop()
+		}
 	}
 	c.ctxCancel()
 	c.V(2).Info("Controller stopped")
@@ -769,39 +932,44 @@ func (c *controller) getPoolInfos(tickers []string) {
 
 	startPoolInfoAt := time.Now()
 
-	t2p, err, _ := c.kc.GetTickerToPoolIdMapFor(tickers...)
+	t2p, err := c.cp.GetTickerToPoolIdMapFor(tickers...)
 	if err != nil {
-		c.Error(err, "[koios] Controller.getPoolInfos failed", "n tickers", len(tickers))
+		c.Error(err, "Controller.getPoolInfos failed", "n tickers", len(tickers))
 		return
 	}
 	for _, t := range tickers {
-		r := c.mainQueue.GetByTicker(t)
-		if pBech32, ok := t2p[r.Ticker]; ok {
-			if pBech32 != r.PoolIdBech32 && pBech32 != "" {
-				r.PoolIdBech32 = pBech32
-				r.PoolIdHex = utils.Bech32ToHexOrDie(pBech32)
-			}
-		} else {
-			if r.PoolIdHex != "" {
-				r.PoolIdBech32 = utils.HexToBech32OrDie("pool", r.PoolIdHex)
-			} else if r.PoolIdBech32 != "" && pBech32 != "" {
-				r.PoolIdHex = utils.Bech32ToHexOrDie(pBech32)
+		c.mainQueue.UpdateRecord(t, func(r *MainQueueRec) {
+			if pBech32, ok := t2p[r.Ticker]; ok {
+				if pBech32 != r.PoolIdBech32 && pBech32 != "" {
+					r.PoolIdBech32 = pBech32
+					r.PoolIdHex = utils.Bech32ToHexOrDie(pBech32)
+				}
+			} else {
+				if r.PoolIdHex != "" {
+					r.PoolIdBech32 = utils.HexToBech32OrDie("pool", r.PoolIdHex)
+				} else if r.PoolIdBech32 != "" && pBech32 != "" {
+					r.PoolIdHex = utils.Bech32ToHexOrDie(pBech32)
+				}
 			}
-		}
-	}
-	if c.refresherCh != nil {
-		c.refresherCh <- "ALL"
-	}
-	if c.refresherV2Ch != nil {
-		c.refresherV2Ch <- "ALL"
+		})
 	}
+	c.notifyRefresh("pool infos")
 	c.V(2).Info("Controller got pool infos", "in", time.Since(startPoolInfoAt).String())
 }
 
+// delegatedPoolTicker is the ticker of the delegated pool when it is in the main queue, the pool id otherwise
+func (c *controller) delegatedPoolTicker(poolId string) string {
+	if poolInQueue := c.mainQueue.GetByPoolId(poolId); poolInQueue != nil {
+		return poolInQueue.Ticker
+	}
+	return poolId
+}
+
 func (c *controller) getAccountInfos(saddrs []string) {
 	var stakeAddressInfoWaitGroup sync.WaitGroup
 	var stakeAddressInfoRunning uint32
 	var koiosErrors []error
+	var koiosErrorsMu sync.Mutex
 
 	if utils.IsContextDone(c.ctx) {
 		return
@@ -817,12 +985,12 @@ func (c *controller) getAccountInfos(saddrs []string) {
 
 	saddr2time := make(map[string]time.Time)
 	{
-		saddr2tx := make(map[string]koios.TxHash)
-		tx2saddr := make(map[koios.TxHash]string)
-		txs := []koios.TxHash{}
+		saddr2tx := make(map[string]string)
+		tx2saddr := make(map[string]string)
+		txs := []string{}
 		type txItem struct {
 			saddr string
-			tx    koios.TxHash
+			tx    string
 		}
 		txsCh := make(chan txItem)
 		go func() {
@@ -845,9 +1013,9 @@ func (c *controller) getAccountInfos(saddrs []string) {
 			atomic.AddUint32(&stakeAddressInfoRunning, uint32(1))
 			go func(r *MainQueueRec) {
 				defer stakeAddressInfoWaitGroup.Done()
-				tx, err := c.kc.GetLastDelegationTx(r.StakeAddrs[0])
+				tx, err := c.cp.GetLastDelegationTx(r.StakeAddrs[0])
 				if err != nil {
-					c.Error(err, "[koios] GetLastDelegationTx failed")
+					c.Error(err, "GetLastDelegationTx failed")
 					return
 				}
 				txsCh <- txItem{r.StakeAddrs[0], tx}
@@ -856,7 +1024,7 @@ func (c *controller) getAccountInfos(saddrs []string) {
 		stakeAddressInfoWaitGroup.Wait()
 		close(txsCh)
 
-		tx2time, err := c.kc.GetTxsTimes(txs)
+		tx2time, err := c.cp.GetTxsTimes(txs)
 		if err == nil {
 			for tx, t := range tx2time {
 				if saddr, ok := tx2saddr[tx]; ok {
@@ -864,7 +1032,7 @@ func (c *controller) getAccountInfos(saddrs []string) {
 				}
 			}
 		} else {
-			c.Error(err, "[koios] GetTxsTimes failed")
+			c.Error(err, "GetTxsTimes failed")
 		}
 	}
 
@@ -893,16 +1061,17 @@ func (c *controller) getAccountInfos(saddrs []string) {
 			// 	(r.DelegatedPool != "") {
 			// 	return
 			// }
-			delegatedPool, totalAda, err := c.kc.GetStakeAddressInfo(r.StakeAddrs[0])
+			delegatedPool, totalBalance, err := c.cp.GetStakeAddressInfo(r.StakeAddrs[0])
 			if err != nil {
+				koiosErrorsMu.Lock()
 				koiosErrors = append(koiosErrors, err)
+				koiosErrorsMu.Unlock()
 			} else {
-				r.AdaDelegated = uint16(totalAda)
-				if poolInQueue := c.mainQueue.GetByPoolId(delegatedPool); poolInQueue != nil {
-					r.DelegatedPool = poolInQueue.Ticker
-				} else {
-					r.DelegatedPool = delegatedPool
-				}
+				delegatedPool = c.delegatedPoolTicker(delegatedPool)
+				c.mainQueue.UpdateRecord(r.Ticker, func(rec *MainQueueRec) {
+					rec.AdaDelegated = totalBalance
+					rec.DelegatedPool = delegatedPool
+				})
 			}
 			atomic.AddUint32(&stakeAddressInfoRunning, ^uint32(0))
 		}(r)
@@ -913,11 +1082,6 @@ func (c *controller) getAccountInfos(saddrs []string) {
 			c.Error(e, "getting account info koios")
 		}
 	}
-	if c.refresherCh != nil {
-		c.refresherCh <- "ALL"
-	}
-	if c.refresherV2Ch != nil {
-		c.refresherV2Ch <- "ALL"
-	}
+	c.notifyRefresh("account infos")
 	c.V(2).Info("Controller got account infos", "in", time.Since(startAccountInfoAt).String())
 }
@@ -0,0 +1,97 @@
+package f2lb_gsheet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RowError is a validation error found parsing a row of a sheet
+type RowError struct {
+	Sheet   string `json:"sheet"`
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Ticker  string `json:"ticker,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("%s row %d column %s (%s): %s", e.Sheet, e.Row, e.Column, e.Ticker, e.Message)
+}
+
+// SheetDataQuality collects the issues found in a sheet during the last refresh
+type SheetDataQuality struct {
+	Sheet string `json:"sheet"`
+	// fields not found in the header rows, parsed from the schema default column
+	UnmappedFields []string   `json:"unmapped_fields,omitempty"`
+	RowErrors      []RowError `json:"row_errors,omitempty"`
+	Warnings       []string   `json:"warnings,omitempty"`
+	// values that differ from the ones computed by the queue rules
+	Discrepancies []RuleDiscrepancy `json:"discrepancies,omitempty"`
+}
+
+type DataQualityReport struct {
+	GeneratedAt time.Time          `json:"generated_at"`
+	Sheets      []SheetDataQuality `json:"sheets"`
+}
+
+// sheetParsing is embedded by the sheets to keep the column mapping
+// resolved from the header rows and the data quality of the last refresh
+type sheetParsing struct {
+	// name of the sheet in the spreadsheet, empty means the default one.
+	// The schema is anyway looked up by the default name.
+	sheetName string
+
+	pmu     sync.RWMutex
+	columns *columnMapping
+	quality SheetDataQuality
+}
+
+func (sp *sheetParsing) nameOr(defaultName string) string {
+	if sp.sheetName != "" {
+		return sp.sheetName
+	}
+	return defaultName
+}
+
+func (sp *sheetParsing) setHeader(sheet string, header *ValueRange) {
+	cm := newColumnMapping(sheet, header)
+	sp.pmu.Lock()
+	defer sp.pmu.Unlock()
+	sp.columns = cm
+}
+
+func (sp *sheetParsing) getColumns(sheet string) *columnMapping {
+	sp.pmu.RLock()
+	cm := sp.columns
+	sp.pmu.RUnlock()
+	if cm == nil {
+		cm = newColumnMapping(sheet, nil)
+	}
+	return cm
+}
+
+func (sp *sheetParsing) setDataQuality(cm *columnMapping, rowErrs []RowError, warnings []string) {
+	sp.pmu.Lock()
+	defer sp.pmu.Unlock()
+	sp.quality = SheetDataQuality{
+		Sheet:          cm.sheet,
+		UnmappedFields: cm.unmapped,
+		RowErrors:      rowErrs,
+		Warnings:       warnings,
+	}
+}
+
+func (sp *sheetParsing) setDiscrepancies(ds []RuleDiscrepancy) {
+	sp.pmu.Lock()
+	defer sp.pmu.Unlock()
+	sp.quality.Discrepancies = ds
+}
+
+func (sp *sheetParsing) GetDataQuality() SheetDataQuality {
+	sp.pmu.RLock()
+	defer sp.pmu.RUnlock()
+	return sp.quality
+}
@@ -2,7 +2,6 @@ package f2lb_gsheet
 
 import (
 	"fmt"
-	"strconv"
 
 	"github.com/safanaj/go-f2lb/pkg/logging"
 )
@@ -12,8 +11,11 @@ const delegationCycleSheet = "Delegation Cycle"
 
 // the first row is the header
 const delegationCycleRange = "A2:O"
+const delegationCycleHeaderRange = "A1:O1"
 
 type DelegationCycle struct {
+	sheetParsing
+
 	epoch              uint32
 	topTicker          string
 	topRemainingEpochs uint32
@@ -21,48 +23,81 @@ type DelegationCycle struct {
 
 	aqTopTicker          string
 	aqTopRemainingEpochs uint32
+
+	// top tickers of the main queue from the current epoch on, as reported in the sheet
+	topTickers []string
 }
 
 func (m *DelegationCycle) GetRange() string {
-	return fmt.Sprintf("%s!%s", delegationCycleSheet, delegationCycleRange)
+	return fmt.Sprintf("%s!%s", m.nameOr(delegationCycleSheet), delegationCycleRange)
 }
 
 func (m *DelegationCycle) GetActiveTicker() string { return m.activeTicker }
 func (m *DelegationCycle) GetTopTicker() string    { return m.topTicker }
 
+// the first row is the header
+func (m *DelegationCycle) GetHeaderRange() string {
+	return fmt.Sprintf("%s!%s", m.nameOr(delegationCycleSheet), delegationCycleHeaderRange)
+}
+
+// SetHeader resolves the columns from the header rows, a nil header means the schema columns
+func (m *DelegationCycle) SetHeader(header *ValueRange) { m.setHeader(delegationCycleSheet, header) }
+
 func (m *DelegationCycle) Refresh(vr *ValueRange) {
 	if vr == nil || len(vr.Values) < 2 {
 		return
 	}
 	log := logging.GetLogger()
-	first := vr.Values[0]
-	epochVal, _ := strconv.ParseUint(first[0].(string), 10, 32)
-	m.epoch = uint32(epochVal)
-	m.topTicker = first[4].(string)
-	m.activeTicker = first[6].(string)
-	remaining := uint32(1)
-	for _, v := range vr.Values[1:] {
-		if m.topTicker != v[4].(string) {
-			log.V(3).Info("DelegationCycle.Refresh", "top ticker", m.topTicker, "remaining", remaining, "found", v[4].(string))
+	cm := m.getColumns(delegationCycleSheet)
+	firstRow := firstRowOfRange(vr.Range, m.GetRange())
+	first := &rowParser{cm: cm, row: vr.Values[0], rowNum: firstRow}
+	if !first.has(fieldEpoch, fieldTopTicker, fieldActiveTicker) {
+		m.setDataQuality(cm, first.errs, nil)
+		return
+	}
+	m.epoch = uint32(first.uint(fieldEpoch, 32))
+	m.topTicker = first.str(fieldTopTicker)
+	m.activeTicker = first.str(fieldActiveTicker)
+	// process column O for the addon queue topTicker and remaining epochs
+	m.aqTopTicker = first.str(fieldAddonQueueTopTicker)
+
+	remaining, aqRemaining := uint32(1), uint32(1)
+	topDone, aqTopDone := false, m.aqTopTicker == ""
+	for i, v := range vr.Values[1:] {
+		p := &rowParser{cm: cm, row: v, rowNum: firstRow + 1 + i}
+		if !topDone {
+			if t := p.str(fieldTopTicker); m.topTicker != t {
+				log.V(3).Info("DelegationCycle.Refresh", "top ticker", m.topTicker, "remaining", remaining, "found", t)
+				topDone = true
+			} else {
+				remaining++
+			}
+		}
+		if !aqTopDone {
+			if t := p.str(fieldAddonQueueTopTicker); m.aqTopTicker != t {
+				log.V(3).Info("DelegationCycle.Refresh", "AQ top ticker", m.aqTopTicker, "AQ remaining", aqRemaining, "AQ found", t)
+				aqTopDone = true
+			} else {
+				aqRemaining++
+			}
+		}
+		if topDone && aqTopDone {
 			break
 		}
-		remaining++
 	}
 	m.topRemainingEpochs = remaining
 
-	if len(first) > 14 {
-		// process column O for the addon queue topTicker and remaining epochs
-		m.aqTopTicker = first[14].(string)
-		remaining := uint32(1)
-		for _, v := range vr.Values[1:] {
-			if m.aqTopTicker != v[14].(string) {
-				log.V(3).Info("DelegationCycle.Refresh", "AQ top ticker", m.aqTopTicker, "AQ remaining", remaining, "AQ found", v[14].(string))
-				break
-			}
-			remaining++
+	m.topTickers = []string{m.topTicker}
+	for i, v := range vr.Values[1:] {
+		p := &rowParser{cm: cm, row: v, rowNum: firstRow + 1 + i}
+		t := p.str(fieldTopTicker)
+		if t == "" {
+			break
 		}
-		m.aqTopRemainingEpochs = remaining
+		m.topTickers = append(m.topTickers, t)
 	}
-
-	return
+	if m.aqTopTicker != "" {
+		m.aqTopRemainingEpochs = aqRemaining
+	}
+	m.setDataQuality(cm, first.errs, nil)
 }
@@ -6,6 +6,28 @@ import (
 
 func AddFlags(fs *flag.FlagSet) {
 	fs.StringVar(&serviceAccountCredsJSONFileName, "service-account-credentials", serviceAccountCredsJSONFileName, "")
+	fs.StringVar(&localSheetSourceDir, "sheet-source-dir", localSheetSourceDir,
+		"Directory with CSV/JSON files (one per sheet) to use instead of the Google spreadsheet")
+	fs.StringVar(&sheetSchemaPath, "sheet-schema-path", sheetSchemaPath,
+		"YAML/JSON file overriding the header names and default columns of the sheets fields")
+	fs.BoolVar(&sheetWriterEnabled, "sheet-writer", sheetWriterEnabled,
+		"Write back the computed delegation status, queue position and missed epochs columns (requires read-write access to the spreadsheet)")
+	fs.BoolVar(&sheetWriterDryRun, "sheet-writer-dry-run", sheetWriterDryRun,
+		"Only compute and log the pending cell changes, without writing them")
+	fs.StringVar(&sheetWriterAuditLogPath, "sheet-writer-audit-log", sheetWriterAuditLogPath,
+		"File where every write to the sheet is appended as a JSON line")
+	fs.StringVar(&sheetWriterDelegatedStatus, "sheet-writer-delegated-status", sheetWriterDelegatedStatus,
+		"Value of the delegation status column for the members delegated to the active pool")
+	fs.StringVar(&sheetWriterNotDelegatedStatus, "sheet-writer-not-delegated-status", sheetWriterNotDelegatedStatus,
+		"Value of the delegation status column for the members not delegated to the active pool")
+
+	fs.StringVar(&communitiesConfigPath, "communities-config", communitiesConfigPath,
+		"YAML file with the other communities (spreadsheet, sheets names, admin pools and hosts) served by this process")
+
+	fs.IntVar(&queueRulesAdaPerEpoch, "queue-rules-ada-per-epoch", queueRulesAdaPerEpoch,
+		"ADA declared for each epoch granted, used to cross-check the sheet (0 disables the check of the epochs granted)")
+	fs.IntVar(&queueRulesMaxEpochsGranted, "queue-rules-max-epochs-granted", queueRulesMaxEpochsGranted,
+		"Maximum epochs granted to a member, 0 means no limit")
 
 	fs.DurationVar(&defaultRefreshInterval, "controller-refresh-interval", defaultRefreshInterval, "")
 
@@ -16,17 +38,21 @@ func AddFlags(fs *flag.FlagSet) {
 	acFlagSet.DurationVar(&acRefreshInterval, "account-refresh-interval", acRefreshInterval, "")
 	acFlagSet.DurationVar(&acWorkersInterval, "account-worker-interval", acWorkersInterval, "")
 	acFlagSet.IntVar(&acWorkers, "account-workers", acWorkers, "")
-	acFlagSet.IntVar(&acCacheSyncers, "account-cache-syncers", acCacheSyncers, "")
+	acFlagSet.Int("account-cache-syncers", 5, "")
+	acFlagSet.MarkDeprecated("account-cache-syncers", "the fetched account infos are stored by the workers")
 	acFlagSet.IntVar(&acAccountInfosToGet, "account-info-to-get-in-chunk", acAccountInfosToGet, "")
 	pcFlagSet := flag.NewFlagSet("pool cache", flag.ExitOnError)
 	pcFlagSet.DurationVar(&pcRefreshInterval, "pool-refresh-interval", pcRefreshInterval, "")
 	pcFlagSet.DurationVar(&pcWorkersInterval, "pool-worker-interval", pcWorkersInterval, "")
 	pcFlagSet.IntVar(&pcWorkers, "pool-workers", pcWorkers, "")
-	pcFlagSet.IntVar(&pcCacheSyncers, "pool-cache-syncers", pcCacheSyncers, "")
+	pcFlagSet.Int("pool-cache-syncers", 5, "")
+	pcFlagSet.MarkDeprecated("pool-cache-syncers", "the fetched pool infos are stored by the workers")
 	pcFlagSet.IntVar(&pcPoolInfosToGet, "pool-info-to-get-in-chunk", pcPoolInfosToGet, "")
 
 	koiosFlagSet := flag.NewFlagSet("koios cache", flag.ExitOnError)
 	koiosFlagSet.DurationVar(&koiosTipRefreshInterval, "koios-tip-refresh-interval", koiosTipRefreshInterval, "")
+	koiosFlagSet.DurationVar(&stateCachesCheckInterval, "state-caches-check-interval", stateCachesCheckInterval,
+		"How often the caches are checked for updates to republish the state")
 
 	fs.AddFlagSet(acFlagSet)
 	fs.AddFlagSet(pcFlagSet)
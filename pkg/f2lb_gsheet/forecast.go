@@ -0,0 +1,106 @@
+package f2lb_gsheet
+
+import (
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// ForecastEntry is the estimated delegation window of a queued pool, EndEpoch is inclusive
+type ForecastEntry struct {
+	Queue        string      `json:"queue"`
+	Position     int         `json:"position"`
+	Ticker       string      `json:"ticker"`
+	StakeAddr    string      `json:"stake_address"`
+	PoolIdBech32 string      `json:"pool_id_bech32"`
+	EpochGranted uint16      `json:"epoch_granted"`
+	StartEpoch   utils.Epoch `json:"start_epoch"`
+	EndEpoch     utils.Epoch `json:"end_epoch"`
+	StartTime    time.Time   `json:"start_time"`
+	EndTime      time.Time   `json:"end_time"`
+}
+
+// DelegationForecast is the estimated schedule of both queues starting from the current delegation cycle,
+// the top of each queue is granted only its remaining epochs.
+type DelegationForecast struct {
+	Epoch      utils.Epoch     `json:"epoch"`
+	MainQueue  []ForecastEntry `json:"main_queue"`
+	AddonQueue []ForecastEntry `json:"addon_queue"`
+}
+
+// ForTicker returns the entries of the ticker in both queues
+func (f *DelegationForecast) ForTicker(ticker string) []ForecastEntry {
+	entries := []ForecastEntry{}
+	for _, e := range f.MainQueue {
+		if e.Ticker == ticker {
+			entries = append(entries, e)
+		}
+	}
+	for _, e := range f.AddonQueue {
+		if e.Ticker == ticker {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+type forecastWalker struct {
+	queue string
+	next  utils.Epoch
+	top   uint32
+}
+
+// add appends the member to the walked queue, it is served at least one epoch as in QueueRules.Schedule
+func (w *forecastWalker) add(pos int, ticker, saddr, poolId string, eg uint16) ForecastEntry {
+	epochs := servedEpochs(uint32(eg))
+	if pos == 0 {
+		epochs = servedEpochs(w.top)
+	}
+	e := ForecastEntry{
+		Queue:        w.queue,
+		Position:     pos,
+		Ticker:       ticker,
+		StakeAddr:    saddr,
+		PoolIdBech32: poolId,
+		EpochGranted: eg,
+		StartEpoch:   w.next,
+		EndEpoch:     w.next + utils.Epoch(epochs) - 1,
+	}
+	e.StartTime = utils.EpochStartTime(e.StartEpoch)
+	e.EndTime = utils.EpochEndTime(e.EndEpoch)
+	w.next += utils.Epoch(epochs)
+	return e
+}
+
+// NewDelegationForecast walks the queues in order, the same way the starting epochs of the stake pool set are computed
+func NewDelegationForecast(mq *MainQueue, aq *AddonQueue, dc *DelegationCycle) *DelegationForecast {
+	epoch := utils.Epoch(dc.epoch)
+	if epoch == 0 {
+		epoch = utils.CurrentEpoch()
+	}
+	f := &DelegationForecast{Epoch: epoch, MainQueue: []ForecastEntry{}, AddonQueue: []ForecastEntry{}}
+
+	mw := &forecastWalker{queue: MainQueueName, next: epoch, top: dc.topRemainingEpochs}
+	for i, r := range mq.GetRecords() {
+		saddr := ""
+		if len(r.StakeAddrs) > 0 {
+			saddr = r.StakeAddrs[0]
+		}
+		f.MainQueue = append(f.MainQueue, mw.add(i, r.Ticker, saddr, r.PoolIdBech32, r.EG))
+	}
+
+	aw := &forecastWalker{queue: AddonQueueName, next: epoch, top: dc.aqTopRemainingEpochs}
+	pos := 0
+	for _, r := range aq.GetRecords() {
+		if len(r.StakeAddrs) == 0 {
+			continue
+		}
+		f.AddonQueue = append(f.AddonQueue, aw.add(pos, r.Ticker, r.StakeAddrs[0], r.PoolIdBech32, r.EG))
+		pos++
+	}
+	return f
+}
+
+func (c *controller) GetDelegationForecast() *DelegationForecast {
+	return NewDelegationForecast(c.mainQueue, c.addonQueue, c.delegCycle)
+}
@@ -0,0 +1,47 @@
+package f2lb_gsheet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+func TestDelegationForecastNotGranted(t *testing.T) {
+	src := newTestLocalSheetSource(t, map[string]string{
+		"MainQueue.csv": "Discord Name,QPP,Ticker,AD,EG,Delegation Status,MainQ Curr Pos,AddonQ Status,Stake Addresses\n,\n" +
+			"aaa,1,AAA,2000,2,,1,," + testStakeKeyA + "\n" +
+			"bbb,1,BBB,0,0,,2,," + testStakeKeyB + "\n" +
+			"ccc,1,CCC,1000,1,,3,," + strings.Repeat("c3", 28) + "\n",
+		"AddonQ.csv": "Discord Name,QPP,Ticker,AD,EG,Delegation Status,AddonQ Curr Pos,AddonQ Status,Stake Addresses\n",
+	})
+	mq, aq := &MainQueue{}, &AddonQueue{}
+	if err := mq.Refresh(src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := aq.Refresh(src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the member without epochs granted is served one epoch, as in the schedule of the queue rules
+	f := NewDelegationForecast(mq, aq, &DelegationCycle{epoch: 100, topRemainingEpochs: 2})
+	want := []struct {
+		ticker     string
+		start, end utils.Epoch
+	}{{"AAA", 100, 101}, {"BBB", 102, 102}, {"CCC", 103, 103}}
+	if len(f.MainQueue) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(f.MainQueue), len(want))
+	}
+	schedule := (QueueRules{}).Schedule([]string{"AAA", "BBB", "CCC"}, map[string]uint16{"AAA": 2, "CCC": 1}, 2, 4)
+	for i, w := range want {
+		e := f.MainQueue[i]
+		if e.Ticker != w.ticker || e.StartEpoch != w.start || e.EndEpoch != w.end {
+			t.Errorf("got %s %d-%d, want %s %d-%d", e.Ticker, e.StartEpoch, e.EndEpoch, w.ticker, w.start, w.end)
+		}
+		for epoch := e.StartEpoch; epoch <= e.EndEpoch; epoch++ {
+			if s := schedule[epoch-100]; s != e.Ticker {
+				t.Errorf("epoch %d: the forecast has %s, the schedule %s", epoch, e.Ticker, s)
+			}
+		}
+	}
+}
@@ -2,14 +2,13 @@ package f2lb_gsheet
 
 import (
 	"context"
-	"io/ioutil"
+	"fmt"
+	"os"
 
 	"golang.org/x/oauth2/google"
-	// "google.golang.org/api/drive/v3"
+	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
-
-	"github.com/safanaj/go-f2lb/pkg/utils"
 )
 
 var serviceAccountCredsJSONFileName string
@@ -17,22 +16,126 @@ var serviceAccountCredsJSONFileName string
 type F2LB struct {
 	*sheets.Service
 
-	// Drive   *drive.Service
-	ctx context.Context
-	// modTime string
+	// used only to get the modification time of the spreadsheet
+	Drive         *drive.Service
+	ctx           context.Context
+	spreadSheetID string
+}
+
+var (
+	_ SheetSource     = (*F2LB)(nil)
+	_ SheetWriter     = (*F2LB)(nil)
+	_ SheetRevisioner = (*F2LB)(nil)
+)
+
+func NewF2LB(ctx context.Context) (*F2LB, error) {
+	return NewF2LBForSpreadsheet(ctx, f2lbSpreadSheetID)
 }
 
-func NewF2LB(ctx context.Context) *F2LB {
-	creds, err := ioutil.ReadFile(serviceAccountCredsJSONFileName)
-	utils.CheckErr(err)
-	conf, err := google.JWTConfigFromJSON(creds, sheets.SpreadsheetsReadonlyScope)
-	utils.CheckErr(err)
-	svc, err := sheets.NewService(ctx, option.WithScopes(sheets.SpreadsheetsReadonlyScope),
+// NewF2LBForSpreadsheet is like NewF2LB but for another spreadsheet with the same layout, i.e. of another community
+func NewF2LBForSpreadsheet(ctx context.Context, spreadSheetID string) (*F2LB, error) {
+	creds, err := os.ReadFile(serviceAccountCredsJSONFileName)
+	if err != nil {
+		return nil, fmt.Errorf("reading service account credentials: %w", err)
+	}
+	// the read-write scope is requested only when the writer is enabled
+	scope := sheets.SpreadsheetsReadonlyScope
+	if sheetWriterEnabled {
+		scope = sheets.SpreadsheetsScope
+	}
+	conf, err := google.JWTConfigFromJSON(creds, scope, drive.DriveMetadataReadonlyScope)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := sheets.NewService(ctx, option.WithScopes(scope),
+		option.WithTokenSource(conf.TokenSource(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	drv, err := drive.NewService(ctx, option.WithScopes(drive.DriveMetadataReadonlyScope),
 		option.WithTokenSource(conf.TokenSource(ctx)))
-	utils.CheckErr(err)
-	// drv, err := drive.NewService(ctx, option.WithScopes(drive.DriveReadonlyScope),
-	// 	option.WithTokenSource(conf.TokenSource(ctx)))
-	// utils.CheckErr(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &F2LB{Service: svc, Drive: drv, ctx: ctx, spreadSheetID: spreadSheetID}, nil
+}
+
+// Revision uses the version and the modification time of the spreadsheet file,
+// the service account needs to see the file metadata in Drive
+func (f2lb *F2LB) Revision() (string, error) {
+	file, err := f2lb.Drive.Files.Get(f2lb.spreadSheetID).Fields("version", "modifiedTime").Context(f2lb.ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d@%s", file.Version, file.ModifiedTime), nil
+}
+
+func (f2lb *F2LB) BatchGet(ranges ...string) ([]*ValueRange, error) {
+	res, err := f2lb.Spreadsheets.Values.BatchGet(
+		f2lb.spreadSheetID).MajorDimension("ROWS").Ranges(
+		ranges...).Context(f2lb.ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if len(res.ValueRanges) != len(ranges) {
+		return nil, fmt.Errorf("BatchGet: got %d value ranges, expected %d", len(res.ValueRanges), len(ranges))
+	}
+	return res.ValueRanges, nil
+}
+
+func (f2lb *F2LB) BatchUpdate(updates ...*ValueRange) error {
+	_, err := f2lb.Spreadsheets.Values.BatchUpdate(f2lb.spreadSheetID, &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: "USER_ENTERED",
+		Data:             updates,
+	}).Context(f2lb.ctx).Do()
+	return err
+}
+
+// the top row is the first one with a background color from the theme
+// or with a not empty value in the specified column
+func (f2lb *F2LB) GetTopRows(trrs ...TopRowRange) ([]int, error) {
+	ranges := make([]string, 0, len(trrs))
+	for _, trr := range trrs {
+		ranges = append(ranges, trr.Range)
+	}
+	res, err := f2lb.Spreadsheets.Get(f2lb.spreadSheetID).Ranges(ranges...).IncludeGridData(true).Context(f2lb.ctx).Do()
+	if err != nil {
+		return nil, err
+	}
 
-	return &F2LB{Service: svc, ctx: ctx /* , Drive: drv */}
+	idxs := make([]int, len(trrs))
+	for i := range idxs {
+		idxs[i] = -1
+	}
+	for sheetIdx, sheet := range res.Sheets {
+		// sheets are returned in the spreadsheet order, match them by title when possible
+		trrIdx := sheetIdx
+		if sheet.Properties != nil {
+			for i, trr := range trrs {
+				if sheetNameFromRange(trr.Range) == sheet.Properties.Title {
+					trrIdx = i
+					break
+				}
+			}
+		}
+		if trrIdx >= len(trrs) || len(sheet.Data) == 0 {
+			continue
+		}
+		col := trrs[trrIdx].ValueColumn
+		for i, rowData := range sheet.Data[0].RowData {
+			if len(rowData.Values) == 0 {
+				continue
+			}
+			ef := rowData.Values[0].EffectiveFormat
+			if ef == nil || ef.BackgroundColorStyle == nil || ef.BackgroundColorStyle.ThemeColor == "" {
+				if col < 0 || len(rowData.Values) <= col || rowData.Values[col].FormattedValue == "" {
+					continue
+				}
+			}
+			idxs[trrIdx] = i
+			break
+		}
+	}
+	return idxs, nil
 }
@@ -0,0 +1,291 @@
+package f2lb_gsheet
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/logging"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+const historyDirName = "history"
+
+// HistoryQueueEntry is a member in a queue as recorded in the history
+type HistoryQueueEntry struct {
+	Ticker       string `json:"ticker"`
+	PoolIdBech32 string `json:"pool_id_bech32,omitempty"`
+	StakeAddr    string `json:"stake_address,omitempty"`
+	AD           uint32 `json:"ada_declared"`
+	EG           uint16 `json:"epoch_granted"`
+	QPP          uint16 `json:"qpp"`
+	// delegated to the active pool, nil when it was not known
+	Delegated *bool `json:"delegated,omitempty"`
+}
+
+// EpochSnapshot is the state of the queues and the delegation cycle as seen during an epoch,
+// the last refresh in the epoch wins.
+type EpochSnapshot struct {
+	Epoch utils.Epoch `json:"epoch"`
+	Time  time.Time   `json:"time"`
+
+	MainQueue        []HistoryQueueEntry `json:"main_queue"`
+	AddonQueue       []HistoryQueueEntry `json:"addon_queue"`
+	MainQueueServed  string              `json:"main_queue_served"`
+	AddonQueueServed string              `json:"addon_queue_served"`
+
+	// delegation cycle as reported in the sheet
+	DelegCycleEpoch              uint32 `json:"deleg_cycle_epoch"`
+	ActiveTicker                 string `json:"active_ticker"`
+	TopTicker                    string `json:"top_ticker"`
+	TopRemainingEpochs           uint32 `json:"top_remaining_epochs"`
+	AddonQueueTopTicker          string `json:"addon_queue_top_ticker"`
+	AddonQueueTopRemainingEpochs uint32 `json:"addon_queue_top_remaining_epochs"`
+}
+
+// ServedEpoch tells how a ticker was served in an epoch
+type ServedEpoch struct {
+	Epoch            utils.Epoch `json:"epoch"`
+	Active           bool        `json:"active"`
+	MainQueueServed  bool        `json:"main_queue_served"`
+	AddonQueueServed bool        `json:"addon_queue_served"`
+}
+
+type servedInEpoch struct {
+	active, mainQueueServed, addonQueueServed string
+	// tickers with a known delegation, true when delegated to the active pool
+	delegated map[string]bool
+}
+
+// History stores one snapshot per epoch as JSON files in a directory
+type History struct {
+	dir string
+
+	mu     sync.RWMutex
+	served map[utils.Epoch]servedInEpoch
+}
+
+func NewHistory(dir string) (*History, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	h := &History{dir: dir, served: make(map[utils.Epoch]servedInEpoch)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		epoch, ok := epochFromHistoryFileName(e.Name())
+		if !ok {
+			continue
+		}
+		snap, err := h.read(epoch)
+		if err != nil {
+			return nil, err
+		}
+		h.index(snap)
+	}
+	return h, nil
+}
+
+func historyFileName(epoch utils.Epoch) string { return fmt.Sprintf("epoch-%d.json", epoch) }
+
+func epochFromHistoryFileName(name string) (utils.Epoch, bool) {
+	if !strings.HasPrefix(name, "epoch-") || !strings.HasSuffix(name, ".json") {
+		return 0, false
+	}
+	e, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "epoch-"), ".json"), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return utils.Epoch(e), true
+}
+
+func (h *History) index(snap *EpochSnapshot) {
+	delegated := make(map[string]bool)
+	for _, q := range [][]HistoryQueueEntry{snap.MainQueue, snap.AddonQueue} {
+		for _, e := range q {
+			if e.Delegated != nil {
+				delegated[e.Ticker] = *e.Delegated
+			}
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.served[snap.Epoch] = servedInEpoch{
+		active:           snap.ActiveTicker,
+		mainQueueServed:  snap.MainQueueServed,
+		addonQueueServed: snap.AddonQueueServed,
+		delegated:        delegated,
+	}
+}
+
+func (h *History) read(epoch utils.Epoch) (*EpochSnapshot, error) {
+	data, err := os.ReadFile(filepath.Join(h.dir, historyFileName(epoch)))
+	if err != nil {
+		return nil, err
+	}
+	snap := &EpochSnapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("parsing history for epoch %d: %w", epoch, err)
+	}
+	return snap, nil
+}
+
+// Save writes the snapshot replacing the one for the same epoch
+func (h *History) Save(snap *EpochSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(h.dir, historyFileName(snap.Epoch)), data); err != nil {
+		return err
+	}
+	h.index(snap)
+	return nil
+}
+
+// Epochs returns the epochs with a snapshot, sorted
+func (h *History) Epochs() []utils.Epoch {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	epochs := make([]utils.Epoch, 0, len(h.served))
+	for e := range h.served {
+		epochs = append(epochs, e)
+	}
+	slices.Sort(epochs)
+	return epochs
+}
+
+// AsOfEpoch returns the snapshot of the epoch or the latest one before it
+func (h *History) AsOfEpoch(epoch utils.Epoch) (*EpochSnapshot, error) {
+	epochs := h.Epochs()
+	idx, found := slices.BinarySearch(epochs, epoch)
+	if !found {
+		if idx == 0 {
+			return nil, fmt.Errorf("No history for epoch %d", epoch)
+		}
+		idx--
+	}
+	return h.read(epochs[idx])
+}
+
+// ServedEpochs returns the epochs in which the ticker was the active one or the served one in a queue
+func (h *History) ServedEpochs(ticker string) []ServedEpoch {
+	served := []ServedEpoch{}
+	for _, e := range h.Epochs() {
+		h.mu.RLock()
+		s := h.served[e]
+		h.mu.RUnlock()
+		se := ServedEpoch{
+			Epoch:            e,
+			Active:           s.active == ticker,
+			MainQueueServed:  s.mainQueueServed == ticker,
+			AddonQueueServed: s.addonQueueServed == ticker,
+		}
+		if se.Active || se.MainQueueServed || se.AddonQueueServed {
+			served = append(served, se)
+		}
+	}
+	return served
+}
+
+// MissedEpochs counts the epochs before the given one in which the ticker was in a queue and not delegated
+// to the active pool, ok is false when no delegation of the ticker was recorded
+func (h *History) MissedEpochs(ticker string, before utils.Epoch) (missed uint32, ok bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for e, s := range h.served {
+		if e >= before {
+			continue
+		}
+		delegated, known := s.delegated[ticker]
+		if !known {
+			continue
+		}
+		ok = true
+		if !delegated {
+			missed++
+		}
+	}
+	return missed, ok
+}
+
+func (c *controller) historyQueueEntry(ticker, poolIdBech32 string, ad utils.Lovelace, eg, qpp uint16, stakeAddrs []string) HistoryQueueEntry {
+	e := HistoryQueueEntry{Ticker: ticker, PoolIdBech32: poolIdBech32, AD: ad.AdaUint32(), EG: eg, QPP: qpp}
+	if len(stakeAddrs) > 0 {
+		e.StakeAddr = stakeAddrs[0]
+	}
+	if delegated, known := c.delegatedToActive(stakeAddrs); known {
+		e.Delegated = &delegated
+	}
+	return e
+}
+
+func (c *controller) takeEpochSnapshot() *EpochSnapshot {
+	snap := &EpochSnapshot{
+		Epoch:                        utils.CurrentEpoch(),
+		Time:                         utils.Now(),
+		MainQueue:                    []HistoryQueueEntry{},
+		AddonQueue:                   []HistoryQueueEntry{},
+		DelegCycleEpoch:              c.delegCycle.epoch,
+		ActiveTicker:                 c.delegCycle.activeTicker,
+		TopTicker:                    c.delegCycle.topTicker,
+		TopRemainingEpochs:           c.delegCycle.topRemainingEpochs,
+		AddonQueueTopTicker:          c.delegCycle.aqTopTicker,
+		AddonQueueTopRemainingEpochs: c.delegCycle.aqTopRemainingEpochs,
+	}
+	for _, r := range c.mainQueue.GetRecords() {
+		snap.MainQueue = append(snap.MainQueue,
+			c.historyQueueEntry(r.Ticker, r.PoolIdBech32, r.AD, r.EG, r.QPP, r.StakeAddrs))
+	}
+	for _, r := range c.addonQueue.GetRecords() {
+		snap.AddonQueue = append(snap.AddonQueue,
+			c.historyQueueEntry(r.Ticker, r.PoolIdBech32, r.AD, r.EG, r.QPP, r.StakeAddrs))
+	}
+	if served := c.mainQueue.GetServed(); served != nil {
+		snap.MainQueueServed = served.Ticker
+	}
+	if served := c.addonQueue.GetServed(); served != nil {
+		snap.AddonQueueServed = served.Ticker
+	}
+	return snap
+}
+
+// saveHistory stores the current state as the snapshot of the current epoch, unless the data is degraded
+func (c *controller) saveHistory() {
+	if c.history == nil {
+		return
+	}
+	if c.GetSheetsStatus().IsDegraded() {
+		c.V(3).Info("Controller not saving history, the sheets data is degraded")
+		return
+	}
+	snap := c.takeEpochSnapshot()
+	if err := c.history.Save(snap); err != nil {
+		c.Error(err, "Controller saving history", "epoch", snap.Epoch)
+		return
+	}
+	c.V(3).Info("Controller saved history", "epoch", snap.Epoch)
+}
+
+// newHistoryIn returns nil when the directory is not set or the history can not be loaded
+func newHistoryIn(storeDir string, logger logging.Logger) *History {
+	if storeDir == "" {
+		return nil
+	}
+	h, err := NewHistory(filepath.Join(storeDir, historyDirName))
+	if err != nil {
+		logger.Error(err, "Controller history disabled", "path", storeDir)
+		return nil
+	}
+	return h
+}
+
+func (c *controller) GetHistory() *History { return c.history }
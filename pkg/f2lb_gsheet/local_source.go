@@ -0,0 +1,273 @@
+package f2lb_gsheet
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// localSheetSource reads the ranges from a directory containing one file per sheet,
+// named as the sheet (i.e. "MainQueue.csv" or "Delegation Cycle.json").
+//
+// Files contain the whole sheet, starting from the cell A1, header rows included.
+// JSON files contain either the rows as an array of arrays,
+// or an object like {"top": 42, "values": [[...], ...]} where top is the sheet row number
+// of the top row (the highlighted one in the spreadsheet). When top is not specified
+// the first row of the range is considered the top.
+type localSheetSource struct {
+	dir string
+}
+
+type localSheet struct {
+	Top    int     `json:"top"`
+	Values [][]any `json:"values"`
+
+	// file and format it was read from, to write it back
+	fileName   string
+	withObject bool
+}
+
+var (
+	_ SheetSource     = (*localSheetSource)(nil)
+	_ SheetWriter     = (*localSheetSource)(nil)
+	_ SheetRevisioner = (*localSheetSource)(nil)
+)
+
+func NewLocalSheetSource(dir string) (SheetSource, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &localSheetSource{dir: dir}, nil
+}
+
+func (s *localSheetSource) readSheet(name string) (*localSheet, error) {
+	fn := filepath.Join(s.dir, name+".json")
+	if data, err := os.ReadFile(fn); err == nil {
+		sheet := &localSheet{fileName: fn}
+		if err := json.Unmarshal(data, &sheet.Values); err != nil {
+			if err := json.Unmarshal(data, sheet); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", fn, err)
+			}
+			sheet.withObject = true
+		}
+		return sheet, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	fn = filepath.Join(s.dir, name+".csv")
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", fn, err)
+	}
+	sheet := &localSheet{Values: make([][]any, 0, len(records)), fileName: fn}
+	for _, rec := range records {
+		row := make([]any, 0, len(rec))
+		for _, v := range rec {
+			row = append(row, v)
+		}
+		sheet.Values = append(sheet.Values, row)
+	}
+	return sheet, nil
+}
+
+// sliceValues returns the rows and columns of the range, like the Google API does
+// trailing empty cells and rows are dropped
+func sliceValues(values [][]any, ar a1Range) [][]any {
+	from, to := ar.startRow-1, len(values)
+	if ar.endRow > 0 && ar.endRow < to {
+		to = ar.endRow
+	}
+	if from >= to {
+		return [][]any{}
+	}
+	rows := make([][]any, 0, to-from)
+	for _, row := range values[from:to] {
+		end := len(row)
+		if ar.endCol >= 0 && ar.endCol+1 < end {
+			end = ar.endCol + 1
+		}
+		if ar.startCol >= end {
+			rows = append(rows, []any{})
+			continue
+		}
+		for end > ar.startCol && (row[end-1] == nil || row[end-1] == "") {
+			end--
+		}
+		rows = append(rows, row[ar.startCol:end])
+	}
+	for len(rows) > 0 && len(rows[len(rows)-1]) == 0 {
+		rows = rows[:len(rows)-1]
+	}
+	return rows
+}
+
+// the parsers expect strings as cell values, like the Google API returns
+func stringifyValues(values [][]any) [][]any {
+	for _, row := range values {
+		for i, v := range row {
+			switch vv := v.(type) {
+			case string:
+			case nil:
+				row[i] = ""
+			default:
+				row[i] = fmt.Sprint(vv)
+			}
+		}
+	}
+	return values
+}
+
+func (s *localSheetSource) BatchGet(ranges ...string) ([]*ValueRange, error) {
+	vrs := make([]*ValueRange, 0, len(ranges))
+	for _, r := range ranges {
+		ar, err := parseA1Range(r)
+		if err != nil {
+			return nil, err
+		}
+		sheet, err := s.readSheet(ar.sheet)
+		if err != nil {
+			return nil, err
+		}
+		vrs = append(vrs, &ValueRange{Range: r, MajorDimension: "ROWS", Values: stringifyValues(sliceValues(sheet.Values, ar))})
+	}
+	return vrs, nil
+}
+
+func (s *localSheetSource) GetTopRows(trrs ...TopRowRange) ([]int, error) {
+	idxs := make([]int, 0, len(trrs))
+	for _, trr := range trrs {
+		ar, err := parseA1Range(trr.Range)
+		if err != nil {
+			return nil, err
+		}
+		sheet, err := s.readSheet(ar.sheet)
+		if err != nil {
+			return nil, err
+		}
+		idx := 0
+		if sheet.Top > 0 {
+			idx = sheet.Top - ar.startRow
+			if idx < 0 || (ar.endRow > 0 && sheet.Top > ar.endRow) {
+				idx = -1
+			}
+		}
+		idxs = append(idxs, idx)
+	}
+	return idxs, nil
+}
+
+func (s *localSheetSource) writeSheet(sheet *localSheet) error {
+	var data []byte
+	if filepath.Ext(sheet.fileName) == ".json" {
+		var err error
+		if sheet.withObject {
+			data, err = json.MarshalIndent(sheet, "", "  ")
+		} else {
+			data, err = json.MarshalIndent(sheet.Values, "", "  ")
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		sb := strings.Builder{}
+		w := csv.NewWriter(&sb)
+		for _, row := range stringifyValues(sheet.Values) {
+			rec := make([]string, 0, len(row))
+			for _, v := range row {
+				rec = append(rec, v.(string))
+			}
+			if len(rec) == 0 {
+				// an empty line is skipped by the reader, shifting the rows below
+				rec = []string{"", ""}
+			}
+			if err := w.Write(rec); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		data = []byte(sb.String())
+	}
+
+	return utils.WriteFileAtomic(sheet.fileName, data)
+}
+
+// BatchUpdate sets the cells of the ranges and writes back the files, growing the sheets when needed
+func (s *localSheetSource) BatchUpdate(updates ...*ValueRange) error {
+	sheets := make(map[string]*localSheet)
+	order := []string{}
+	for _, vr := range updates {
+		ar, err := parseA1Range(vr.Range)
+		if err != nil {
+			return err
+		}
+		sheet, ok := sheets[ar.sheet]
+		if !ok {
+			if sheet, err = s.readSheet(ar.sheet); err != nil {
+				return err
+			}
+			sheets[ar.sheet] = sheet
+			order = append(order, ar.sheet)
+		}
+		for i, row := range vr.Values {
+			r := ar.startRow - 1 + i
+			for len(sheet.Values) <= r {
+				sheet.Values = append(sheet.Values, []any{})
+			}
+			for j, v := range row {
+				c := ar.startCol + j
+				for len(sheet.Values[r]) <= c {
+					sheet.Values[r] = append(sheet.Values[r], "")
+				}
+				sheet.Values[r][c] = v
+			}
+		}
+	}
+	for _, name := range order {
+		if err := s.writeSheet(sheets[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revision is based on the name, size and modification time of the files in the directory
+func (s *localSheetSource) Revision() (string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s:%d:%d\n", fi.Name(), fi.Size(), fi.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
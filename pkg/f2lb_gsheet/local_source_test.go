@@ -0,0 +1,148 @@
+package f2lb_gsheet
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestLocalSheetSource(t *testing.T, files map[string]string) *localSheetSource {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := NewLocalSheetSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src.(*localSheetSource)
+}
+
+const (
+	testMainQueueCSV = "h1,h2,h3\nh1,h2,h3\na3,b3,c3,,\na4,b4\n,,\n"
+	// the numbers are returned as strings, like the Google API does
+	testAddonQueueJSON = `{"top": 4, "values": [["h1", "h2"], ["a2", 2], ["a3", null, ""], ["a4", 4.5]]}`
+)
+
+func TestLocalSheetSourceBatchGet(t *testing.T) {
+	src := newTestLocalSheetSource(t, map[string]string{
+		"MainQueue.csv": testMainQueueCSV,
+		"AddonQ.json":   testAddonQueueJSON,
+	})
+
+	vrs, err := src.BatchGet("MainQueue!A3:C", "MainQueue!B1:B1", "AddonQ!A2:B", "'AddonQ'!B3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range [][][]any{
+		// trailing empty cells and rows are dropped
+		{{"a3", "b3", "c3"}, {"a4", "b4"}},
+		{{"h2"}},
+		{{"a2", "2"}, {"a3"}, {"a4", "4.5"}},
+		{{}, {"4.5"}},
+	} {
+		if !reflect.DeepEqual(vrs[i].Values, want) {
+			t.Errorf("range %s: got %v, want %v", vrs[i].Range, vrs[i].Values, want)
+		}
+	}
+
+	if _, err := src.BatchGet("Supporters!A1:B"); err == nil {
+		t.Errorf("expected an error for a missing sheet")
+	}
+}
+
+func TestLocalSheetSourceGetTopRows(t *testing.T) {
+	src := newTestLocalSheetSource(t, map[string]string{
+		"MainQueue.csv": testMainQueueCSV,
+		"AddonQ.json":   testAddonQueueJSON,
+	})
+
+	idxs, err := src.GetTopRows(
+		TopRowRange{Range: "MainQueue!A3:O", ValueColumn: -1},
+		TopRowRange{Range: "AddonQ!A2:N", ValueColumn: -1},
+		TopRowRange{Range: "AddonQ!A5:N", ValueColumn: -1},
+		TopRowRange{Range: "AddonQ!A2:N3", ValueColumn: -1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a top the first row of the range is the top, a top outside of the range is not found
+	if want := []int{0, 2, -1, -1}; !reflect.DeepEqual(idxs, want) {
+		t.Errorf("got %v, want %v", idxs, want)
+	}
+}
+
+func TestLocalSheetSourceBatchUpdate(t *testing.T) {
+	src := newTestLocalSheetSource(t, map[string]string{
+		"MainQueue.csv": testMainQueueCSV,
+		"AddonQ.json":   testAddonQueueJSON,
+	})
+
+	err := src.BatchUpdate(
+		&ValueRange{Range: "'MainQueue'!B4", Values: [][]any{{"B4"}}},
+		// beyond the end of the sheet, it grows
+		&ValueRange{Range: "MainQueue!E7", Values: [][]any{{"E7"}}},
+		&ValueRange{Range: "AddonQ!C3:D3", Values: [][]any{{"C3", "D3"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vrs, err := src.BatchGet("MainQueue!A4:E", "AddonQ!A3:D3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]any{{"a4", "B4"}, {}, {}, {"", "", "", "", "E7"}}; !reflect.DeepEqual(vrs[0].Values, want) {
+		t.Errorf("main queue: got %v, want %v", vrs[0].Values, want)
+	}
+	if want := [][]any{{"a3", "", "C3", "D3"}}; !reflect.DeepEqual(vrs[1].Values, want) {
+		t.Errorf("addon queue: got %v, want %v", vrs[1].Values, want)
+	}
+
+	// the json file is written back in the same format, keeping the top
+	idxs, err := src.GetTopRows(TopRowRange{Range: "AddonQ!A2:N", ValueColumn: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idxs[0] != 2 {
+		t.Errorf("top row lost by the update, got %d", idxs[0])
+	}
+	if _, err := os.Stat(filepath.Join(src.dir, "AddonQ.csv")); !os.IsNotExist(err) {
+		t.Errorf("the json sheet was written in another file: %v", err)
+	}
+}
+
+func TestLocalSheetSourceRevision(t *testing.T) {
+	src := newTestLocalSheetSource(t, map[string]string{"MainQueue.csv": testMainQueueCSV})
+
+	rev, err := src.Revision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := src.Revision(); again != rev {
+		t.Errorf("revision changed without modifications: %s != %s", again, rev)
+	}
+
+	if err := src.BatchUpdate(&ValueRange{Range: "MainQueue!A3", Values: [][]any{{"a3 modified"}}}); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := src.Revision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated == rev {
+		t.Errorf("revision not changed by the update")
+	}
+
+	// the temporary files are not part of the revision
+	if err := os.WriteFile(filepath.Join(src.dir, ".sheet-1.tmp"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := src.Revision(); again != updated {
+		t.Errorf("revision changed by a hidden file")
+	}
+}
@@ -4,8 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -18,15 +17,16 @@ const mainQueueSheet = "MainQueue"
 
 // the first 2 rows are headers
 const mainQueueRange = "A3:O"
+const mainQueueHeaderRange = "A1:O2"
 
 type MainQueueRec struct {
 	// columns
-	DiscordName  string `json:"discord_name" yaml:"discord_name"`    // A
-	QPP          uint16 `json:"qpp" yaml:"qpp"`                      // B
-	Ticker       string `json:"ticker" yaml:"ticker"`                // C
-	AD           uint16 `json:"ada_declared" yaml:"ada_declared"`    // D
-	AdaDelegated uint16 `json:"ada_delegated" yaml:"ada_delegated"`  // D
-	EG           uint16 `json:"epoch_granted" yaaml:"epoch_granted"` // E
+	DiscordName  string         `json:"discord_name" yaml:"discord_name"`    // A
+	QPP          uint16         `json:"qpp" yaml:"qpp"`                      // B
+	Ticker       string         `json:"ticker" yaml:"ticker"`                // C
+	AD           utils.Lovelace `json:"-" yaml:"-"`                          // D, declared in ADA, kept in lovelace
+	AdaDelegated utils.Lovelace `json:"-" yaml:"-"`                          // D
+	EG           uint16         `json:"epoch_granted" yaaml:"epoch_granted"` // E
 	// computed column I
 	StakeKeys     []string `json:"stake_keys" yaml:"stake_keys"`
 	StakeAddrs    []string `json:"stake_addresses" yaml:"stake_addresses"`
@@ -46,20 +46,50 @@ type MainQueueRec struct {
 	discordID             string // M
 	initialAdaDeclaration string // N
 
+	// first row of the record in the sheet, used to write back computed columns
+	sheetRow int
+
 	// computed/discovered last delegation tx time
 	// lastDelegationTxTime time.Time
 	stakeAddressStatus string
 }
 
+// recAmounts are the amounts of a queue record, serialized in ADA as they always were and also in lovelace
+type recAmounts struct {
+	AdaDeclared          uint32 `json:"ada_declared" yaml:"ada_declared"`
+	AdaDeclaredLovelace  uint64 `json:"ada_declared_lovelace" yaml:"ada_declared_lovelace"`
+	AdaDelegated         uint32 `json:"ada_delegated" yaml:"ada_delegated"`
+	AdaDelegatedLovelace uint64 `json:"ada_delegated_lovelace" yaml:"ada_delegated_lovelace"`
+}
+
+func newRecAmounts(declared, delegated utils.Lovelace) recAmounts {
+	return recAmounts{
+		AdaDeclared:          declared.AdaUint32(),
+		AdaDeclaredLovelace:  uint64(declared),
+		AdaDelegated:         delegated.AdaUint32(),
+		AdaDelegatedLovelace: uint64(delegated),
+	}
+}
+
+func (r *MainQueueRec) toMarshalable() any {
+	type rec MainQueueRec
+	return struct {
+		rec        `yaml:",inline"`
+		recAmounts `yaml:",inline"`
+	}{rec(*r), newRecAmounts(r.AD, r.AdaDelegated)}
+}
+
 func (r *MainQueueRec) MarshalJSON() ([]byte, error) {
-	return json.Marshal(*r)
+	return json.Marshal(r.toMarshalable())
 }
 
 func (r *MainQueueRec) MarshalYAML() (any, error) {
-	return *r, nil
+	return r.toMarshalable(), nil
 }
 
 type MainQueue struct {
+	sheetParsing
+
 	mu      sync.RWMutex
 	ordered []string
 
@@ -94,9 +124,9 @@ func (mq *MainQueue) MarshalYAML() (any, error) {
 	return mq.toMarshalable(), nil
 }
 
-func NewMainQueue(f2lb *F2LB) (*MainQueue, error) {
+func NewMainQueue(src SheetSource) (*MainQueue, error) {
 	mq := &MainQueue{}
-	err := mq.Refresh(f2lb, nil)
+	err := mq.Refresh(src, nil)
 	var cwarn *CacheWarn
 	if err != nil && !errors.As(err, &cwarn) {
 		return nil, err
@@ -104,8 +134,8 @@ func NewMainQueue(f2lb *F2LB) (*MainQueue, error) {
 	return mq, err
 }
 
-func NewMainQueueOrDie(f2lb *F2LB) *MainQueue {
-	mq, err := NewMainQueue(f2lb)
+func NewMainQueueOrDie(src SheetSource) *MainQueue {
+	mq, err := NewMainQueue(src)
 	if mq == nil {
 		utils.CheckErr(err)
 	}
@@ -113,32 +143,55 @@ func NewMainQueueOrDie(f2lb *F2LB) *MainQueue {
 }
 
 func (mq *MainQueue) GetRange() string {
-	return fmt.Sprintf("%s!%s", mainQueueSheet, mainQueueRange)
+	return fmt.Sprintf("%s!%s", mq.nameOr(mainQueueSheet), mainQueueRange)
 }
 
-func (mq *MainQueue) Refresh(f2lb *F2LB, vr *ValueRange) error {
+// the first 2 rows are the header
+func (mq *MainQueue) GetHeaderRange() string {
+	return fmt.Sprintf("%s!%s", mq.nameOr(mainQueueSheet), mainQueueHeaderRange)
+}
+
+// SetHeader resolves the columns from the header rows, a nil header means the schema columns
+func (mq *MainQueue) SetHeader(header *ValueRange) { mq.setHeader(mainQueueSheet, header) }
+
+func (mq *MainQueue) Refresh(src SheetSource, vr *ValueRange) error {
 	var totalAdaInQueue uint64
 	var mqRec *MainQueueRec
 	if vr == nil {
-		res, err := f2lb.Spreadsheets.Values.BatchGet(
-			f2lbSpreadSheetID).MajorDimension("ROWS").Ranges(
-			mq.GetRange()).Do()
+		vrs, err := src.BatchGet(mq.GetRange(), mq.GetHeaderRange())
 		if err != nil {
 			return err
 		}
-		vr = res.ValueRanges[0]
+		vr = vrs[0]
+		mq.SetHeader(vrs[1])
 	}
 
+	cm := mq.getColumns(mainQueueSheet)
+	firstRow := firstRowOfRange(vr.Range, mq.GetRange())
+	var rowErrs []RowError
+
 	orderedTickers := make([]string, 0, len(vr.Values))
+	sheetRows := make(map[string]int)
 	records := make([]*MainQueueRec, 0, len(vr.Values))
 
-	for _, v := range vr.Values {
-		qppVal, _ := strconv.ParseUint(v[1].(string), 10, 16)
-		adVal, _ := strconv.ParseUint(v[3].(string), 10, 16)
-		egVal, _ := strconv.ParseUint(v[4].(string), 10, 16)
-		currPosVal, _ := strconv.ParseUint(v[6].(string), 10, 16)
+	for i, v := range vr.Values {
+		p := &rowParser{cm: cm, row: v, rowNum: firstRow + i}
+		p.ticker = p.str(fieldTicker)
+		if !p.has(fieldTicker, fieldStakeAddresses) {
+			rowErrs = append(rowErrs, p.errs...)
+			continue
+		}
+		ticker := p.ticker // C
+
+		// compute for column I
+		stakeAddrs, stakeKeys := p.stakeAddresses(fieldStakeAddresses)
+		if len(stakeAddrs) == 0 {
+			p.addError(fieldStakeAddresses, p.str(fieldStakeAddresses), "no valid stake address, row skipped")
+			rowErrs = append(rowErrs, p.errs...)
+			continue
+		}
 
-		ticker := v[2].(string) // D
+		adVal := p.uint32(fieldAdaDeclared)
 		orderedTickers = append(orderedTickers, ticker)
 		mqRec = (*MainQueueRec)(nil)
 		if mqRecI, ok := mq.cacheByTicker.Load(ticker); ok {
@@ -148,62 +201,32 @@ func (mq *MainQueue) Refresh(f2lb *F2LB, vr *ValueRange) error {
 		}
 		if mqRec == nil {
 			mqRec = &MainQueueRec{
-				DiscordName:           v[0].(string),      // A
-				QPP:                   uint16(qppVal),     // B
-				Ticker:                ticker,             // C
-				AD:                    uint16(adVal),      // D
-				EG:                    uint16(egVal),      // E
-				delegStatus:           v[5].(string),      // F
-				mainQCurrPos:          uint16(currPosVal), // G
-				addonQStatus:          v[7].(string),      // H
-				missedEpochs:          v[9].(string),      // J
-				addedToGoogleGroup:    v[10].(string),     // K
-				PoolIdHex:             v[11].(string),     // L
-				discordID:             v[12].(string),     // M
-				initialAdaDeclaration: v[13].(string),     // N
-			}
-
-		}
-
-		if strings.HasPrefix(mqRec.PoolIdHex, "pool") {
-			mqRec.PoolIdBech32 = mqRec.PoolIdHex
-			mqRec.PoolIdHex = ""
-			if hex, err := utils.Bech32ToHex(mqRec.PoolIdBech32); err == nil {
-				mqRec.PoolIdHex = hex
-			}
-		} else {
-			if bech32, err := utils.HexToBech32("pool", mqRec.PoolIdHex); err == nil {
-				mqRec.PoolIdBech32 = bech32
+				DiscordName:           p.str(fieldDiscordName),            // A
+				QPP:                   p.uint16(fieldQPP),                 // B
+				Ticker:                ticker,                             // C
+				AD:                    utils.AdaToLovelace(uint64(adVal)), // D
+				EG:                    p.uint16(fieldEpochGranted),        // E
+				delegStatus:           p.str(fieldDelegStatus),            // F
+				mainQCurrPos:          p.uint16(fieldQueuePosition),       // G
+				addonQStatus:          p.str(fieldAddonQueueStatus),       // H
+				missedEpochs:          p.str(fieldMissedEpochs),           // J
+				addedToGoogleGroup:    p.str(fieldAddedToGoogleGroup),     // K
+				discordID:             p.str(fieldDiscordId),              // M
+				initialAdaDeclaration: p.str(fieldInitialAdaDeclaration),  // N
 			}
+			mqRec.PoolIdHex, mqRec.PoolIdBech32 = p.poolId(fieldPoolId) // L
 		}
 
-		// compute for column I
-		for _, val := range regexp.MustCompile("[[:space:]]").Split(v[8].(string), -1) {
-			if val == "" || len(val) < 6 {
-				continue
-			}
-			if val[:5] == "stake" {
-				kh, err := utils.StakeAddressToStakeKeyHash(val)
-				if err != nil {
-					fmt.Println(fmt.Errorf("MainQueue parsing: Invalid bech32 stake address for ticker: %s", ticker))
-					continue
-				}
-				mqRec.StakeAddrs = append(mqRec.StakeAddrs, val)
-				mqRec.StakeKeys = append(mqRec.StakeKeys, kh)
-			} else {
-				addr, err := utils.StakeKeyHashToStakeAddress(val)
-				if err != nil {
-					fmt.Println(fmt.Errorf("MainQueue parsing: Invalid hex stake address for ticker: %s", ticker))
-					continue
-				}
-				mqRec.StakeKeys = append(mqRec.StakeKeys, val)
-				mqRec.StakeAddrs = append(mqRec.StakeAddrs, addr)
-			}
+		if _, ok := sheetRows[ticker]; !ok {
+			sheetRows[ticker] = p.rowNum
+			mqRec.sheetRow = p.rowNum
 		}
+		mqRec.StakeAddrs = append(mqRec.StakeAddrs, stakeAddrs...)
+		mqRec.StakeKeys = append(mqRec.StakeKeys, stakeKeys...)
 
-		totalAdaInQueue = totalAdaInQueue + adVal
+		totalAdaInQueue = totalAdaInQueue + uint64(adVal)
 		records = append(records, mqRec)
-
+		rowErrs = append(rowErrs, p.errs...)
 	}
 
 	// now lock and prepare caches
@@ -213,7 +236,10 @@ func (mq *MainQueue) Refresh(f2lb *F2LB, vr *ValueRange) error {
 
 	// below code to be deprecated
 	mq.records = records
-	mq.served = records[0]
+	mq.served = nil
+	if len(records) > 0 {
+		mq.served = records[0]
+	}
 	mq.refreshedInEpoch = utils.CurrentEpoch()
 	mq.totalAdaInQueue = totalAdaInQueue
 
@@ -254,7 +280,8 @@ func (mq *MainQueue) Refresh(f2lb *F2LB, vr *ValueRange) error {
 			}
 		}
 	}
-	mq.refreshTime = time.Now()
+	mq.refreshTime = utils.Now()
+	mq.setDataQuality(cm, rowErrs, warn.warnings)
 	if len(warn.warnings) > 0 {
 		return warn
 	}
@@ -325,6 +352,46 @@ func (mq *MainQueue) GetByPoolId(poolId string) *MainQueueRec {
 	return mq.getBy(poolId, mq.cacheByPoolIdHex)
 }
 
+// UpdateRecord replaces the record of the ticker with an updated copy and returns it, nil if unknown.
+// The records handed out are never modified, so they can be read without locks
+func (mq *MainQueue) UpdateRecord(ticker string, update func(*MainQueueRec)) *MainQueueRec {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	old := mq.getBy(ticker, mq.cacheByTicker)
+	if old == nil {
+		return nil
+	}
+	rec := *old
+	rec.StakeKeys, rec.StakeAddrs = slices.Clone(old.StakeKeys), slices.Clone(old.StakeAddrs)
+	update(&rec)
+
+	if i := slices.Index(mq.records, old); i >= 0 {
+		mq.records[i] = &rec
+	}
+	if mq.served == old {
+		mq.served = &rec
+	}
+	mq.cacheByTicker.Store(ticker, &rec)
+	for _, skey := range rec.StakeKeys {
+		mq.cacheByStakeKey.Store(skey, &rec)
+	}
+	for _, saddr := range rec.StakeAddrs {
+		mq.cacheByStakeAddr.Store(saddr, &rec)
+	}
+	for id, cache := range map[string]*sync.Map{old.PoolIdBech32: &mq.cacheByPoolIdBech32, old.PoolIdHex: &mq.cacheByPoolIdHex} {
+		if id != "" {
+			cache.CompareAndDelete(id, old)
+		}
+	}
+	if rec.PoolIdBech32 != "" {
+		mq.cacheByPoolIdBech32.Store(rec.PoolIdBech32, &rec)
+	}
+	if rec.PoolIdHex != "" {
+		mq.cacheByPoolIdHex.Store(rec.PoolIdHex, &rec)
+	}
+	return &rec
+}
+
 func (mq *MainQueue) GetRefreshedInEpoch() utils.Epoch {
 	mq.mu.RLock()
 	defer mq.mu.RUnlock()
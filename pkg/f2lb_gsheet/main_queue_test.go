@@ -0,0 +1,48 @@
+package f2lb_gsheet
+
+import "testing"
+
+func TestMainQueueUpdateRecord(t *testing.T) {
+	src := newTestLocalSheetSource(t, testSheetWriterFiles)
+	mq := &MainQueue{}
+	if err := mq.Refresh(src, nil); err != nil {
+		t.Fatal(err)
+	}
+	old := mq.GetByTicker("AAA")
+	saddr := old.StakeAddrs[0]
+
+	rec := mq.UpdateRecord("AAA", func(r *MainQueueRec) {
+		r.PoolIdBech32, r.PoolIdHex, r.AdaDelegated = "pool1aaa", "aa", 5
+	})
+	if rec == nil || rec == old {
+		t.Fatalf("expected a new record, got %p (old %p)", rec, old)
+	}
+	if old.PoolIdBech32 != "" || old.AdaDelegated != 0 {
+		t.Fatalf("the record handed out was modified: %+v", old)
+	}
+	for name, got := range map[string]*MainQueueRec{
+		"ticker":        mq.GetByTicker("AAA"),
+		"stake address": mq.GetByStakeAddr(saddr),
+		"pool id":       mq.GetByPoolId("pool1aaa"),
+		"pool id hex":   mq.GetByPoolId("aa"),
+		"records":       mq.GetRecords()[0],
+		"served":        mq.GetServed(),
+	} {
+		if got != rec {
+			t.Errorf("by %s: got %p, want the updated record %p", name, got, rec)
+		}
+	}
+
+	// the previous pool ids do not point to the member anymore
+	mq.UpdateRecord("AAA", func(r *MainQueueRec) { r.PoolIdBech32, r.PoolIdHex = "pool1bbb", "bb" })
+	if r := mq.GetByPoolId("pool1aaa"); r != nil {
+		t.Errorf("stale pool id still resolved to %+v", r)
+	}
+	if r := mq.GetByPoolId("pool1bbb"); r == nil || r.Ticker != "AAA" {
+		t.Errorf("new pool id not resolved, got %+v", r)
+	}
+
+	if mq.UpdateRecord("ZZZ", func(*MainQueueRec) { t.Error("update called for an unknown ticker") }) != nil {
+		t.Errorf("expected nil for an unknown ticker")
+	}
+}
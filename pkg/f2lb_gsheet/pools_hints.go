@@ -1,28 +1,283 @@
 package f2lb_gsheet
 
 import (
+	"context"
 	"encoding/csv"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/logging"
+	"github.com/safanaj/go-f2lb/pkg/utils"
 )
 
-func getHintsMapping(path string) map[string]string {
-	if path == "" {
+// the hints file is checked for changes with this interval
+var poolsHintsWatchInterval = 30 * time.Second
+
+// retiredPoolHint as pool id marks the ticker as retired, so it is ignored
+const retiredPoolHint = "retired"
+
+// PoolHint maps a ticker missing from koios to a pool id,
+// Conflict reports a disagreement with the koios data and it is filled only when listing
+type PoolHint struct {
+	Ticker       string `json:"ticker"`
+	PoolIdBech32 string `json:"pool_id_bech32"`
+	Conflict     string `json:"conflict,omitempty"`
+}
+
+func validatePoolHint(ticker, poolId string) error {
+	if ticker == "" {
+		return fmt.Errorf("empty ticker")
+	}
+	if poolId == retiredPoolHint {
 		return nil
 	}
+	if !strings.HasPrefix(poolId, "pool1") {
+		return fmt.Errorf("invalid pool id %q for %s: not a bech32 pool id", poolId, ticker)
+	}
+	if _, err := utils.Bech32ToHex(poolId); err != nil {
+		return fmt.Errorf("invalid pool id %q for %s: %w", poolId, ticker, err)
+	}
+	return nil
+}
+
+// PoolsHints is the store of the hints, backed by a CSV file (ticker,pool_id) that is reloaded when modified
+type PoolsHints struct {
+	logging.Logger
+	path string
+
+	mu      sync.RWMutex
+	hints   map[string]string
+	modTime time.Time
+	errs    []string
+}
+
+func NewPoolsHints(path string, logger logging.Logger) *PoolsHints {
+	h := &PoolsHints{Logger: logger, path: path, hints: make(map[string]string)}
+	if _, err := h.reloadIfChanged(); err != nil {
+		h.Error(err, "Loading pools hints", "path", path)
+	}
+	return h
+}
+
+func readPoolsHintsFile(path string) (map[string]string, []string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return nil
+		return nil, nil, err
 	}
-	records, err := csv.NewReader(f).ReadAll()
-	if err != nil || len(records) == 0 {
-		return nil
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
 	}
-	if records[0][0] == "ticker" {
+	if len(records) > 0 && len(records[0]) > 0 && records[0][0] == "ticker" {
 		records = records[1:]
 	}
-	mapping := make(map[string]string)
-	for _, r := range records {
-		mapping[r[0]] = r[1]
+	hints := make(map[string]string)
+	errs := []string{}
+	for i, r := range records {
+		if len(r) < 2 {
+			errs = append(errs, fmt.Sprintf("line %d: expected ticker and pool id", i+1))
+			continue
+		}
+		ticker, poolId := strings.TrimSpace(r[0]), strings.TrimSpace(r[1])
+		if err := validatePoolHint(ticker, poolId); err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %s", i+1, err))
+			continue
+		}
+		hints[ticker] = poolId
+	}
+	return hints, errs, nil
+}
+
+// reloadIfChanged reads the file if its modification time changed since the last load
+func (h *PoolsHints) reloadIfChanged() (bool, error) {
+	if h.path == "" {
+		return false, nil
+	}
+	fi, err := os.Stat(h.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	h.mu.RLock()
+	unchanged := fi.ModTime().Equal(h.modTime)
+	h.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	hints, errs, err := readPoolsHintsFile(h.path)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range errs {
+		h.Info("Invalid pool hint ignored", "path", h.path, "error", e)
+	}
+	h.mu.Lock()
+	h.hints, h.errs, h.modTime = hints, errs, fi.ModTime()
+	h.mu.Unlock()
+	return true, nil
+}
+
+// Watch polls the file until the context is done, onChange is called after every reload
+func (h *PoolsHints) Watch(ctx context.Context, onChange func()) {
+	if h.path == "" {
+		return
+	}
+	t := time.NewTicker(poolsHintsWatchInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			changed, err := h.reloadIfChanged()
+			if err != nil {
+				h.Error(err, "Reloading pools hints", "path", h.path)
+				continue
+			}
+			if changed {
+				h.V(2).Info("Pools hints reloaded", "path", h.path, "hints", len(h.Mapping()))
+				onChange()
+			}
+		}
+	}
+}
+
+// Mapping returns a copy of the hints, ticker to pool id
+func (h *PoolsHints) Mapping() map[string]string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	mapping := make(map[string]string, len(h.hints))
+	for k, v := range h.hints {
+		mapping[k] = v
 	}
 	return mapping
 }
+
+// Errors returns the invalid lines found by the last load of the file
+func (h *PoolsHints) Errors() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.errs
+}
+
+func (h *PoolsHints) List() []PoolHint {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	hints := make([]PoolHint, 0, len(h.hints))
+	for t, pid := range h.hints {
+		hints = append(hints, PoolHint{Ticker: t, PoolIdBech32: pid})
+	}
+	slices.SortFunc(hints, func(a, b PoolHint) int { return strings.Compare(a.Ticker, b.Ticker) })
+	return hints
+}
+
+// write stores the hints in the file, the caller holds the lock
+func (h *PoolsHints) write() error {
+	if h.path == "" {
+		return nil
+	}
+	tickers := make([]string, 0, len(h.hints))
+	for t := range h.hints {
+		tickers = append(tickers, t)
+	}
+	slices.Sort(tickers)
+
+	sb := strings.Builder{}
+	w := csv.NewWriter(&sb)
+	w.Write([]string{"ticker", "pool_id"})
+	for _, t := range tickers {
+		w.Write([]string{t, h.hints[t]})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	if err := utils.WriteFileAtomic(h.path, []byte(sb.String())); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(h.path); err == nil {
+		h.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// Set adds or replaces the hint for the ticker, use "retired" as pool id to ignore the ticker
+func (h *PoolsHints) Set(ticker, poolId string) error {
+	if err := validatePoolHint(ticker, poolId); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old, existed := h.hints[ticker]
+	h.hints[ticker] = poolId
+	if err := h.write(); err != nil {
+		if existed {
+			h.hints[ticker] = old
+		} else {
+			delete(h.hints, ticker)
+		}
+		return err
+	}
+	return nil
+}
+
+func (h *PoolsHints) Delete(ticker string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old, existed := h.hints[ticker]
+	if !existed {
+		return fmt.Errorf("No hint for %s", ticker)
+	}
+	delete(h.hints, ticker)
+	if err := h.write(); err != nil {
+		h.hints[ticker] = old
+		return err
+	}
+	return nil
+}
+
+// GetPoolsHints returns the hints with the conflicts with the koios data
+func (c *controller) GetPoolsHints() []PoolHint {
+	hints := c.poolsHints.List()
+	for i, hint := range hints {
+		if hint.PoolIdBech32 == retiredPoolHint {
+			continue
+		}
+		if pi, ok := c.poolCache.Get(hint.Ticker); ok && pi.IdBech32() != "" && pi.IdBech32() != hint.PoolIdBech32 {
+			hints[i].Conflict = fmt.Sprintf("koios maps %s to %s", hint.Ticker, pi.IdBech32())
+		} else if pi, ok := c.poolCache.Get(hint.PoolIdBech32); ok && pi.Ticker() != "" && pi.Ticker() != hint.Ticker {
+			hints[i].Conflict = fmt.Sprintf("koios ticker of %s is %s", hint.PoolIdBech32, pi.Ticker())
+		}
+	}
+	return hints
+}
+
+func (c *controller) GetPoolsHintsErrors() []string { return c.poolsHints.Errors() }
+
+func (c *controller) SetPoolHint(ticker, poolId string) error {
+	if err := c.poolsHints.Set(ticker, poolId); err != nil {
+		return err
+	}
+	c.invalidateParsedSheets()
+	c.RequestRefresh()
+	return nil
+}
+
+func (c *controller) DeletePoolHint(ticker string) error {
+	if err := c.poolsHints.Delete(ticker); err != nil {
+		return err
+	}
+	c.invalidateParsedSheets()
+	c.RequestRefresh()
+	return nil
+}
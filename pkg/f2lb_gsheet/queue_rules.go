@@ -0,0 +1,225 @@
+package f2lb_gsheet
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// parameters of the queue rules, see QueueRules.
+// The default of an epoch every 1000 ADA declared, and at least one, is meant to match the EG formula of the
+// F2LB MainQueue sheet (EG = max(1, floor(AD / 1000))). The formula lives in the spreadsheet and not here,
+// so a community using a different one has to set --queue-rules-ada-per-epoch, or 0 to disable the check.
+var (
+	queueRulesAdaPerEpoch      = 1000
+	queueRulesMaxEpochsGranted = 0
+)
+
+// QueueRules are the F2LB rules behind the columns computed by the sheet formulas:
+// a member is granted an epoch every AdaPerEpoch declared (at least one, at most MaxEpochsGranted if set),
+// the queue positions start from 1 at the top of the queue and the member on top is served for its granted epochs,
+// then it is rotated to the bottom of the queue.
+// QPP is not checked as its formula depends on data that is not in the sheets.
+type QueueRules struct {
+	AdaPerEpoch      uint32
+	MaxEpochsGranted uint16
+}
+
+func defaultQueueRules() QueueRules {
+	return QueueRules{AdaPerEpoch: uint32(queueRulesAdaPerEpoch), MaxEpochsGranted: uint16(queueRulesMaxEpochsGranted)}
+}
+
+// EpochsGranted returns the epochs granted for the ADA declared
+func (qr QueueRules) EpochsGranted(adaDeclared uint32) uint16 {
+	if qr.AdaPerEpoch == 0 {
+		return 0
+	}
+	eg := adaDeclared / qr.AdaPerEpoch
+	if eg == 0 {
+		eg = 1
+	}
+	if eg > math.MaxUint16 {
+		eg = math.MaxUint16
+	}
+	if qr.MaxEpochsGranted > 0 && eg > uint32(qr.MaxEpochsGranted) {
+		eg = uint32(qr.MaxEpochsGranted)
+	}
+	return uint16(eg)
+}
+
+// servedEpochs is how long a member is served for its granted or remaining epochs, at least one epoch:
+// the schedule, the forecast and the what-if simulation all follow this rule
+func servedEpochs(epochs uint32) uint32 {
+	if epochs == 0 {
+		return 1
+	}
+	return epochs
+}
+
+// Rotate returns the queue after the member on top was served, the top is moved to the bottom
+func (qr QueueRules) Rotate(tickers []string) []string {
+	if len(tickers) < 2 {
+		return tickers
+	}
+	return append(append([]string{}, tickers[1:]...), tickers[0])
+}
+
+// Schedule returns the top ticker for the next epochs: the member on top is served for the remaining epochs,
+// the others for their granted ones (at least one, see servedEpochs), and each served member is rotated
+// to the bottom of the queue
+func (qr QueueRules) Schedule(tickers []string, granted map[string]uint16, topRemaining uint32, epochs int) []string {
+	schedule := make([]string, 0, epochs)
+	if len(tickers) == 0 {
+		return schedule
+	}
+	queue := tickers
+	served := int(servedEpochs(topRemaining))
+	for len(schedule) < epochs {
+		for i := 0; i < served && len(schedule) < epochs; i++ {
+			schedule = append(schedule, queue[0])
+		}
+		queue = qr.Rotate(queue)
+		served = int(servedEpochs(uint32(granted[queue[0]])))
+	}
+	return schedule
+}
+
+// RuleDiscrepancy is a column value in the sheet that differs from the one computed by the queue rules
+type RuleDiscrepancy struct {
+	Sheet       string `json:"sheet"`
+	Row         int    `json:"row,omitempty"`
+	Field       string `json:"field"`
+	Ticker      string `json:"ticker"`
+	SheetValue  string `json:"sheet_value"`
+	Computed    string `json:"computed"`
+	Explanation string `json:"explanation"`
+}
+
+func (d RuleDiscrepancy) String() string {
+	return fmt.Sprintf("%s row %d %s (%s): sheet has %q, computed %q: %s",
+		d.Sheet, d.Row, d.Field, d.Ticker, d.SheetValue, d.Computed, d.Explanation)
+}
+
+func (qr QueueRules) checkMainQueue(sheet string, records []*MainQueueRec, dc *DelegationCycle) []RuleDiscrepancy {
+	ds := []RuleDiscrepancy{}
+	seen := make(map[*MainQueueRec]bool)
+	pos := 0
+	for _, r := range records {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		pos++
+		if eg := qr.EpochsGranted(r.AD.AdaUint32()); eg > 0 && eg != r.EG {
+			ds = append(ds, RuleDiscrepancy{
+				Sheet: sheet, Row: r.sheetRow, Field: fieldEpochGranted, Ticker: r.Ticker,
+				SheetValue: strconv.Itoa(int(r.EG)), Computed: strconv.Itoa(int(eg)),
+				Explanation: fmt.Sprintf("%s declared grant %d epochs, one every %d ADA", r.AD.AdaString(), eg, qr.AdaPerEpoch),
+			})
+		}
+		if r.mainQCurrPos != 0 && int(r.mainQCurrPos) != pos {
+			ds = append(ds, RuleDiscrepancy{
+				Sheet: sheet, Row: r.sheetRow, Field: fieldQueuePosition, Ticker: r.Ticker,
+				SheetValue: strconv.Itoa(int(r.mainQCurrPos)), Computed: strconv.Itoa(pos),
+				Explanation: "positions are counted from 1 at the top of the queue",
+			})
+		}
+	}
+	if len(records) > 0 && dc.topTicker != "" {
+		ds = append(ds, qr.checkTop(sheet, records[0].Ticker, records[0].sheetRow, records[0].EG, dc.topTicker, dc.topRemainingEpochs)...)
+	}
+	return ds
+}
+
+// checkAddonQueue does not check the eligibility of the members, the ones not in the main queue are served too
+func (qr QueueRules) checkAddonQueue(sheet string, records []*AddonQueueRec, dc *DelegationCycle) []RuleDiscrepancy {
+	ds := []RuleDiscrepancy{}
+	seen := make(map[*AddonQueueRec]bool)
+	pos := 0
+	for _, r := range records {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		pos++
+		if r.addonQCurrPos != "" && r.addonQCurrPos != strconv.Itoa(pos) {
+			ds = append(ds, RuleDiscrepancy{
+				Sheet: sheet, Row: r.sheetRow, Field: fieldQueuePosition, Ticker: r.Ticker,
+				SheetValue: r.addonQCurrPos, Computed: strconv.Itoa(pos),
+				Explanation: "positions are counted from 1 at the top of the queue",
+			})
+		}
+	}
+	if len(records) > 0 && dc.aqTopTicker != "" {
+		ds = append(ds, qr.checkTop(sheet, records[0].Ticker, records[0].sheetRow, records[0].EG, dc.aqTopTicker, dc.aqTopRemainingEpochs)...)
+	}
+	return ds
+}
+
+// checkDelegationCycle compares the top tickers of the next epochs with the schedule from the main queue,
+// only the first difference is reported as the following ones are a consequence of it
+func (qr QueueRules) checkDelegationCycle(sheet string, records []*MainQueueRec, dc *DelegationCycle) []RuleDiscrepancy {
+	ds := []RuleDiscrepancy{}
+	if len(records) == 0 || len(dc.topTickers) == 0 {
+		return ds
+	}
+	tickers := []string{}
+	granted := make(map[string]uint16)
+	for _, r := range records {
+		if _, ok := granted[r.Ticker]; ok {
+			continue
+		}
+		tickers = append(tickers, r.Ticker)
+		if granted[r.Ticker] = qr.EpochsGranted(r.AD.AdaUint32()); granted[r.Ticker] == 0 {
+			granted[r.Ticker] = r.EG
+		}
+	}
+	for i, t := range qr.Schedule(tickers, granted, dc.topRemainingEpochs, len(dc.topTickers)) {
+		if dc.topTickers[i] != t {
+			ds = append(ds, RuleDiscrepancy{
+				Sheet: sheet, Field: fieldTopTicker, Ticker: dc.topTickers[i], SheetValue: dc.topTickers[i], Computed: t,
+				Explanation: fmt.Sprintf("in epoch %d the top should be %s, rotating the main queue on the granted epochs", dc.epoch+uint32(i), t),
+			})
+			break
+		}
+	}
+	return ds
+}
+
+// checkTop compares the top of the queue with the delegation cycle, the member on top is the served one
+// and it can not have more remaining epochs than the granted ones
+func (qr QueueRules) checkTop(sheet, ticker string, row int, eg uint16, dcTop string, dcRemaining uint32) []RuleDiscrepancy {
+	ds := []RuleDiscrepancy{}
+	if ticker != dcTop {
+		ds = append(ds, RuleDiscrepancy{
+			Sheet: sheet, Row: row, Field: fieldTicker, Ticker: ticker, SheetValue: ticker, Computed: dcTop,
+			Explanation: fmt.Sprintf("the top of the queue should be the member served in the delegation cycle (%s)", dcTop),
+		})
+	} else if eg > 0 && dcRemaining > uint32(eg) {
+		ds = append(ds, RuleDiscrepancy{
+			Sheet: sheet, Row: row, Field: fieldEpochGranted, Ticker: ticker,
+			SheetValue: strconv.Itoa(int(eg)), Computed: strconv.Itoa(int(dcRemaining)),
+			Explanation: "the member on top has more remaining epochs in the delegation cycle than the granted ones",
+		})
+	}
+	return ds
+}
+
+// checkQueueRules compares the queues with the rules, the discrepancies are reported in the data quality of the sheets
+func (c *controller) checkQueueRules() {
+	qr := defaultQueueRules()
+	mqRecords := c.mainQueue.GetRecords()
+	mqDs := qr.checkMainQueue(c.mainQueue.nameOr(mainQueueSheet), mqRecords, c.delegCycle)
+	aqDs := qr.checkAddonQueue(c.addonQueue.nameOr(addonQueueSheet), c.addonQueue.GetRecords(), c.delegCycle)
+	dcDs := qr.checkDelegationCycle(c.delegCycle.nameOr(delegationCycleSheet), mqRecords, c.delegCycle)
+	c.mainQueue.setDiscrepancies(mqDs)
+	c.addonQueue.setDiscrepancies(aqDs)
+	c.delegCycle.setDiscrepancies(dcDs)
+	for _, d := range append(append(mqDs, aqDs...), dcDs...) {
+		c.V(3).Info("Controller queue rules discrepancy", "sheet", d.Sheet, "row", d.Row, "field", d.Field,
+			"ticker", d.Ticker, "sheet value", d.SheetValue, "computed", d.Computed, "explanation", d.Explanation)
+	}
+	if n := len(mqDs) + len(aqDs) + len(dcDs); n > 0 {
+		c.V(2).Info("Controller found queue rules discrepancies", "main", len(mqDs), "addon", len(aqDs), "delegation cycle", len(dcDs))
+	}
+}
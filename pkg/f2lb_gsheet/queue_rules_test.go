@@ -0,0 +1,73 @@
+package f2lb_gsheet
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestQueueRulesEpochsGranted(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		qr   QueueRules
+		ad   uint32
+		want uint16
+	}{
+		{"disabled", QueueRules{}, 5000, 0},
+		{"an epoch every 1000 ADA", QueueRules{AdaPerEpoch: 1000}, 3000, 3},
+		{"rounded down", QueueRules{AdaPerEpoch: 1000}, 3999, 3},
+		{"at least one", QueueRules{AdaPerEpoch: 1000}, 999, 1},
+		{"nothing declared", QueueRules{AdaPerEpoch: 1000}, 0, 1},
+		{"capped", QueueRules{AdaPerEpoch: 1000, MaxEpochsGranted: 5}, 10000, 5},
+		{"under the cap", QueueRules{AdaPerEpoch: 1000, MaxEpochsGranted: 5}, 2000, 2},
+		{"max uint16", QueueRules{AdaPerEpoch: 1}, math.MaxUint32, math.MaxUint16},
+	} {
+		if got := tc.qr.EpochsGranted(tc.ad); got != tc.want {
+			t.Errorf("%s: EpochsGranted(%d) = %d, want %d", tc.name, tc.ad, got, tc.want)
+		}
+	}
+}
+
+func TestQueueRulesRotate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		tickers []string
+		want    []string
+	}{
+		{"empty", []string{}, []string{}},
+		{"single", []string{"A"}, []string{"A"}},
+		{"top to the bottom", []string{"A", "B", "C"}, []string{"B", "C", "A"}},
+	} {
+		tickers := append([]string{}, tc.tickers...)
+		if got := (QueueRules{}).Rotate(tickers); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+		if !reflect.DeepEqual(tickers, tc.tickers) {
+			t.Errorf("%s: the queue was modified %v", tc.name, tickers)
+		}
+	}
+}
+
+func TestQueueRulesSchedule(t *testing.T) {
+	granted := map[string]uint16{"A": 2, "B": 1, "C": 3}
+	for _, tc := range []struct {
+		name         string
+		tickers      []string
+		granted      map[string]uint16
+		topRemaining uint32
+		epochs       int
+		want         []string
+	}{
+		{"empty queue", nil, granted, 1, 3, []string{}},
+		{"no epochs", []string{"A", "B"}, granted, 1, 0, []string{}},
+		{"top remaining then granted", []string{"A", "B", "C"}, granted, 1, 7, []string{"A", "B", "C", "C", "C", "A", "A"}},
+		{"top served for the remaining only", []string{"C", "A"}, granted, 2, 5, []string{"C", "C", "A", "A", "C"}},
+		{"single member", []string{"A"}, granted, 1, 3, []string{"A", "A", "A"}},
+		{"not granted served once", []string{"A", "D", "B"}, granted, 1, 4, []string{"A", "D", "B", "A"}},
+		{"no remaining served once", []string{"C", "B"}, granted, 0, 3, []string{"C", "B", "C"}},
+	} {
+		if got := (QueueRules{}).Schedule(tc.tickers, tc.granted, tc.topRemaining, tc.epochs); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
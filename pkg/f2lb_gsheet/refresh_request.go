@@ -0,0 +1,55 @@
+package f2lb_gsheet
+
+import (
+	"time"
+)
+
+// requested refreshes (i.e. from the webhook) are delayed by this debounce, so a burst of edits triggers a single refresh
+var refreshRequestDebounce = 15 * time.Second
+
+// RequestRefresh schedules a refresh after the debounce delay, the requests received in the meantime are coalesced.
+// The ranges are the touched ones, when all of them are in the supporters sheet only that sheet is refreshed,
+// no ranges means a full refresh.
+func (c *controller) RequestRefresh(ranges ...string) {
+	c.refreshReqMu.Lock()
+	defer c.refreshReqMu.Unlock()
+	if len(ranges) == 0 {
+		c.refreshReqFull = true
+	}
+	c.refreshReqRanges = append(c.refreshReqRanges, ranges...)
+	if c.refreshReqTimer != nil {
+		c.refreshReqTimer.Reset(refreshRequestDebounce)
+		return
+	}
+	c.refreshReqTimer = time.AfterFunc(refreshRequestDebounce, c.runRequestedRefresh)
+}
+
+func (c *controller) runRequestedRefresh() {
+	c.refreshReqMu.Lock()
+	full, ranges := c.refreshReqFull, c.refreshReqRanges
+	c.refreshReqFull, c.refreshReqRanges, c.refreshReqTimer = false, nil, nil
+	c.refreshReqMu.Unlock()
+
+	onlySupporters := !full && len(ranges) > 0
+	for _, r := range ranges {
+		if sheetNameFromRange(r) != c.supporters.nameOr(supportersSheet) {
+			onlySupporters = false
+			break
+		}
+	}
+
+	if onlySupporters {
+		c.V(2).Info("Controller requested refresh of supporters", "ranges", ranges)
+		if err := c.supporters.Refresh(c.source, nil); err != nil {
+			c.Error(err, "supporters.Refresh failed")
+			return
+		}
+		c.notifyRefresh("supporters refresh")
+		return
+	}
+
+	c.V(2).Info("Controller requested refresh", "ranges", ranges)
+	if err := c.Refresh(); err != nil {
+		c.Error(err, "Controller requested Refresh failed")
+	}
+}
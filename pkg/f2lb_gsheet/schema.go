@@ -0,0 +1,328 @@
+package f2lb_gsheet
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+var sheetSchemaPath string
+
+// fields of the sheets, used as keys in the schema
+const (
+	fieldDiscordName           = "discord_name"
+	fieldQPP                   = "qpp"
+	fieldTicker                = "ticker"
+	fieldAdaDeclared           = "ada_declared"
+	fieldEpochGranted          = "epoch_granted"
+	fieldDelegStatus           = "deleg_status"
+	fieldQueuePosition         = "queue_position"
+	fieldAddonQueueStatus      = "addon_queue_status"
+	fieldStakeAddresses        = "stake_addresses"
+	fieldMissedEpochs          = "missed_epochs"
+	fieldAddedToGoogleGroup    = "added_to_google_group"
+	fieldPoolId                = "pool_id"
+	fieldDiscordId             = "discord_id"
+	fieldInitialAdaDeclaration = "initial_ada_declaration"
+
+	fieldEpoch               = "epoch"
+	fieldTopTicker           = "top_ticker"
+	fieldActiveTicker        = "active_ticker"
+	fieldAddonQueueTopTicker = "addon_queue_top_ticker"
+)
+
+// ColumnSchema tells how to find the column of a field:
+// Headers are the names looked up in the header rows (comparison ignores case, spaces and punctuation),
+// Column is the column letter used when none of the headers is found.
+type ColumnSchema struct {
+	Headers []string `json:"headers" yaml:"headers"`
+	Column  string   `json:"column" yaml:"column"`
+}
+
+// SheetSchema maps the fields to their columns
+type SheetSchema map[string]ColumnSchema
+
+var (
+	sheetSchemasMu sync.RWMutex
+	sheetSchemas   = map[string]SheetSchema{
+		mainQueueSheet: {
+			fieldDiscordName:           {Headers: []string{"Discord Name", "Discord"}, Column: "A"},
+			fieldQPP:                   {Headers: []string{"QPP"}, Column: "B"},
+			fieldTicker:                {Headers: []string{"Ticker"}, Column: "C"},
+			fieldAdaDeclared:           {Headers: []string{"AD", "Ada Declared", "ADA Declared"}, Column: "D"},
+			fieldEpochGranted:          {Headers: []string{"EG", "Epochs Granted", "Epoch Granted"}, Column: "E"},
+			fieldDelegStatus:           {Headers: []string{"Delegation Status", "Deleg Status"}, Column: "F"},
+			fieldQueuePosition:         {Headers: []string{"MainQ Curr Pos", "Current Position", "Position"}, Column: "G"},
+			fieldAddonQueueStatus:      {Headers: []string{"AddonQ Status", "Addon Queue Status"}, Column: "H"},
+			fieldStakeAddresses:        {Headers: []string{"Stake Addresses", "Stake Keys", "Stake Address"}, Column: "I"},
+			fieldMissedEpochs:          {Headers: []string{"Missed Epochs"}, Column: "J"},
+			fieldAddedToGoogleGroup:    {Headers: []string{"Added to Google Group", "Google Group"}, Column: "K"},
+			fieldPoolId:                {Headers: []string{"Pool ID", "Pool Id Hex", "Pool Hex ID"}, Column: "L"},
+			fieldDiscordId:             {Headers: []string{"Discord ID"}, Column: "M"},
+			fieldInitialAdaDeclaration: {Headers: []string{"Initial Ada Declaration", "Initial AD"}, Column: "N"},
+		},
+		addonQueueSheet: {
+			fieldDiscordName:           {Headers: []string{"Discord Name", "Discord"}, Column: "A"},
+			fieldQPP:                   {Headers: []string{"QPP"}, Column: "B"},
+			fieldTicker:                {Headers: []string{"Ticker"}, Column: "C"},
+			fieldAdaDeclared:           {Headers: []string{"AD", "Ada Declared", "ADA Declared"}, Column: "D"},
+			fieldEpochGranted:          {Headers: []string{"EG", "Epochs Granted", "Epoch Granted"}, Column: "E"},
+			fieldDelegStatus:           {Headers: []string{"Delegation Status", "Deleg Status"}, Column: "F"},
+			fieldQueuePosition:         {Headers: []string{"AddonQ Curr Pos", "Current Position", "Position"}, Column: "G"},
+			fieldAddonQueueStatus:      {Headers: []string{"AddonQ Status", "Addon Queue Status"}, Column: "H"},
+			fieldStakeAddresses:        {Headers: []string{"Stake Addresses", "Stake Keys", "Stake Address"}, Column: "I"},
+			fieldMissedEpochs:          {Headers: []string{"Missed Epochs"}, Column: "J"},
+			fieldAddedToGoogleGroup:    {Headers: []string{"Added to Google Group", "Google Group"}, Column: "K"},
+			fieldPoolId:                {Headers: []string{"Pool ID", "Pool Id Hex", "Pool Hex ID"}, Column: "L"},
+			fieldDiscordId:             {Headers: []string{"Discord ID"}, Column: "M"},
+			fieldInitialAdaDeclaration: {Headers: []string{"Initial Ada Declaration", "Initial AD"}, Column: "N"},
+		},
+		supportersSheet: {
+			fieldDiscordName:    {Headers: []string{"Discord Name", "Discord"}, Column: "A"},
+			fieldTicker:         {Headers: []string{"Ticker"}, Column: "C"},
+			fieldStakeAddresses: {Headers: []string{"Stake Addresses", "Stake Keys", "Stake Address"}, Column: "I"},
+			fieldDiscordId:      {Headers: []string{"Discord ID"}, Column: "M"},
+		},
+		delegationCycleSheet: {
+			fieldEpoch:               {Headers: []string{"Epoch"}, Column: "A"},
+			fieldTopTicker:           {Headers: []string{"Top Ticker", "Top of Queue"}, Column: "E"},
+			fieldActiveTicker:        {Headers: []string{"Active Ticker", "Active Pool"}, Column: "G"},
+			fieldAddonQueueTopTicker: {Headers: []string{"AddonQ Top Ticker", "Top of AddonQ"}, Column: "O"},
+		},
+	}
+)
+
+// LoadSheetSchemas reads a YAML (or JSON) file keyed by sheet name and field,
+// the columns specified there override the default ones.
+func LoadSheetSchemas(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	schemas := make(map[string]SheetSchema)
+	if err := yaml.Unmarshal(data, &schemas); err != nil {
+		return fmt.Errorf("parsing sheet schema %s: %w", path, err)
+	}
+	sheetSchemasMu.Lock()
+	defer sheetSchemasMu.Unlock()
+	for sheet, schema := range schemas {
+		if _, ok := sheetSchemas[sheet]; !ok {
+			return fmt.Errorf("Unknown sheet %q in sheet schema %s", sheet, path)
+		}
+		for field, cs := range schema {
+			if _, ok := sheetSchemas[sheet][field]; !ok {
+				return fmt.Errorf("Unknown field %q for sheet %q in sheet schema %s", field, sheet, path)
+			}
+			if cs.Column == "" {
+				cs.Column = sheetSchemas[sheet][field].Column
+			}
+			sheetSchemas[sheet][field] = cs
+		}
+	}
+	return nil
+}
+
+func getSheetSchema(sheet string) SheetSchema {
+	sheetSchemasMu.RLock()
+	defer sheetSchemasMu.RUnlock()
+	return sheetSchemas[sheet]
+}
+
+func normalizeHeader(h string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, h)
+}
+
+// columnMapping is the resolved position of the fields in the rows of a sheet
+type columnMapping struct {
+	sheet string
+	cols  map[string]int
+	// fields not found in the header rows, for them the schema column is used
+	unmapped []string
+}
+
+// newColumnMapping resolves the fields using the header rows, header and data ranges are expected
+// to start from the same column. A nil header means use the schema columns.
+func newColumnMapping(sheet string, header *ValueRange) *columnMapping {
+	schema := getSheetSchema(sheet)
+	cm := &columnMapping{sheet: sheet, cols: make(map[string]int, len(schema))}
+	taken := make(map[int]bool)
+
+	fields := make([]string, 0, len(schema))
+	for field := range schema {
+		fields = append(fields, field)
+	}
+	// resolve fields in column order, so duplicated headers go to the field expected first
+	sortFieldsByColumn(fields, schema)
+
+	if header != nil {
+		for _, field := range fields {
+			names := make(map[string]bool, len(schema[field].Headers))
+			for _, h := range schema[field].Headers {
+				names[normalizeHeader(h)] = true
+			}
+		search:
+			for _, row := range header.Values {
+				for i, cell := range row {
+					if s, ok := cell.(string); ok && !taken[i] && names[normalizeHeader(s)] {
+						taken[i] = true
+						cm.cols[field] = i
+						break search
+					}
+				}
+			}
+		}
+	}
+	for _, field := range fields {
+		if _, ok := cm.cols[field]; ok {
+			continue
+		}
+		cm.cols[field], _, _ = parseA1Cell(schema[field].Column)
+		if header != nil {
+			cm.unmapped = append(cm.unmapped, field)
+		}
+	}
+	return cm
+}
+
+func sortFieldsByColumn(fields []string, schema SheetSchema) {
+	slices.SortFunc(fields, func(a, b string) int {
+		ca, _, _ := parseA1Cell(schema[a].Column)
+		cb, _, _ := parseA1Cell(schema[b].Column)
+		if ca != cb {
+			return ca - cb
+		}
+		return strings.Compare(a, b)
+	})
+}
+
+func (cm *columnMapping) column(field string) string {
+	if idx, ok := cm.cols[field]; ok && idx >= 0 {
+		return columnLetter(idx)
+	}
+	return ""
+}
+
+// rowParser reads the fields of a row collecting the validation errors
+type rowParser struct {
+	cm     *columnMapping
+	row    []any
+	rowNum int
+	ticker string
+	errs   []RowError
+}
+
+func (p *rowParser) addError(field, value, format string, args ...any) {
+	p.errs = append(p.errs, RowError{
+		Sheet:   p.cm.sheet,
+		Row:     p.rowNum,
+		Column:  p.cm.column(field),
+		Field:   field,
+		Ticker:  p.ticker,
+		Value:   value,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// has reports if the row is long enough to contain the fields, reporting a short row otherwise
+func (p *rowParser) has(fields ...string) bool {
+	for _, field := range fields {
+		if idx, ok := p.cm.cols[field]; !ok || idx >= len(p.row) {
+			p.addError(field, "", "short row: %d cells, missing column for %s", len(p.row), field)
+			return false
+		}
+	}
+	return true
+}
+
+func (p *rowParser) str(field string) string {
+	idx, ok := p.cm.cols[field]
+	if !ok || idx < 0 || idx >= len(p.row) {
+		return ""
+	}
+	switch v := p.row[idx].(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (p *rowParser) uint(field string, bitSize int) uint64 {
+	s := strings.TrimSpace(p.str(field))
+	if s == "" {
+		return 0
+	}
+	v, err := strconv.ParseUint(s, 10, bitSize)
+	if err != nil {
+		p.addError(field, s, "unparsable number")
+		return 0
+	}
+	return v
+}
+
+func (p *rowParser) uint16(field string) uint16 { return uint16(p.uint(field, 16)) }
+func (p *rowParser) uint32(field string) uint32 { return uint32(p.uint(field, 32)) }
+
+// stakeAddresses splits the cell on spaces, values can be bech32 stake addresses or hex stake key hashes
+func (p *rowParser) stakeAddresses(field string) (saddrs, skeys []string) {
+	for _, val := range regexp.MustCompile("[[:space:]]").Split(p.str(field), -1) {
+		if val == "" {
+			continue
+		}
+		if strings.HasPrefix(val, "stake") {
+			kh, err := utils.StakeAddressToStakeKeyHash(val)
+			if err != nil {
+				p.addError(field, val, "invalid bech32 stake address")
+				continue
+			}
+			saddrs = append(saddrs, val)
+			skeys = append(skeys, kh)
+		} else {
+			addr, err := utils.StakeKeyHashToStakeAddress(val)
+			if err != nil {
+				p.addError(field, val, "invalid hex stake address")
+				continue
+			}
+			skeys = append(skeys, val)
+			saddrs = append(saddrs, addr)
+		}
+	}
+	return
+}
+
+// poolId reads a pool id that can be in hex or bech32 format and returns both,
+// an empty cell is not an error: the pool id is usually found by ticker
+func (p *rowParser) poolId(field string) (hex, bech32 string) {
+	val := strings.TrimSpace(p.str(field))
+	if val == "" {
+		return
+	}
+	var err error
+	if strings.HasPrefix(val, "pool") {
+		bech32 = val
+		hex, err = utils.Bech32ToHex(val)
+	} else {
+		hex = val
+		bech32, err = utils.HexToBech32("pool", val)
+	}
+	if err != nil {
+		p.addError(field, val, "invalid pool id: %v", err)
+	}
+	return
+}
@@ -0,0 +1,135 @@
+package f2lb_gsheet
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var localSheetSourceDir string
+
+// SheetSource is the provider of the spreadsheet ranges parsed by the queues,
+// the supporters and the delegation cycle.
+type SheetSource interface {
+	// BatchGet returns the values of the given ranges (A1 notation), in the same order.
+	BatchGet(ranges ...string) ([]*ValueRange, error)
+	// GetTopRows returns for each range the index of the first row marked as the top of the sheet,
+	// or -1 if no row is marked.
+	GetTopRows(ranges ...TopRowRange) ([]int, error)
+}
+
+// TopRowRange describes how to look for the top row in a range.
+type TopRowRange struct {
+	Range string
+	// when the row is not highlighted, a not empty value in this column marks it as top,
+	// use a negative value to rely only on the row highlighting
+	ValueColumn int
+}
+
+// SheetRevisioner is implemented by the sheet sources able to tell cheaply if the sheets changed,
+// the revision is an opaque string that changes on every modification.
+type SheetRevisioner interface {
+	Revision() (string, error)
+}
+
+// NewSheetSource returns the local directory source if configured, otherwise the Google spreadsheet one.
+func NewSheetSource(ctx context.Context) (SheetSource, error) {
+	if localSheetSourceDir != "" {
+		return NewLocalSheetSource(localSheetSourceDir)
+	}
+	return NewF2LB(ctx)
+}
+
+func sheetNameFromRange(r string) string {
+	name, _, _ := strings.Cut(r, "!")
+	return strings.Trim(name, "'")
+}
+
+// a1Range is a parsed range in A1 notation like "MainQueue!A3:O",
+// columns are 0-based and rows are 1-based, a zero endRow or a negative endCol means unbounded
+type a1Range struct {
+	sheet    string
+	startCol int
+	startRow int
+	endCol   int
+	endRow   int
+}
+
+func parseA1Cell(cell string) (col, row int, err error) {
+	col = -1
+	i := 0
+	for ; i < len(cell); i++ {
+		ch := cell[i]
+		if ch >= 'a' && ch <= 'z' {
+			ch -= 'a' - 'A'
+		}
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = (col+1)*26 + int(ch-'A')
+	}
+	if i < len(cell) {
+		row, err = strconv.Atoi(cell[i:])
+		if err != nil {
+			return -1, 0, fmt.Errorf("invalid cell %q", cell)
+		}
+	}
+	return col, row, nil
+}
+
+func parseA1Range(r string) (a1Range, error) {
+	var err error
+	ar := a1Range{sheet: sheetNameFromRange(r), startRow: 1, endCol: -1}
+	_, cells, found := strings.Cut(r, "!")
+	if !found {
+		return ar, nil
+	}
+	start, end, _ := strings.Cut(cells, ":")
+	if ar.startCol, ar.startRow, err = parseA1Cell(start); err != nil {
+		return ar, err
+	}
+	if ar.startCol < 0 {
+		ar.startCol = 0
+	}
+	if ar.startRow == 0 {
+		ar.startRow = 1
+	}
+	if end != "" {
+		if ar.endCol, ar.endRow, err = parseA1Cell(end); err != nil {
+			return ar, err
+		}
+	}
+	return ar, nil
+}
+
+func columnLetter(idx int) string {
+	letters := ""
+	for idx += 1; idx > 0; idx = (idx - 1) / 26 {
+		letters = string(rune('A'+(idx-1)%26)) + letters
+	}
+	return letters
+}
+
+// firstRowOfRange returns the sheet row number of the first row in the range, or fallback
+func firstRowOfRange(r string, fallback string) int {
+	if ar, err := parseA1Range(r); err == nil && r != "" {
+		return ar.startRow
+	}
+	if ar, err := parseA1Range(fallback); err == nil {
+		return ar.startRow
+	}
+	return 1
+}
+
+// subValueRange returns the rows of vr from the given index, keeping the range in sync
+func subValueRange(vr *ValueRange, from, to int) *ValueRange {
+	sub := &ValueRange{MajorDimension: vr.MajorDimension, Values: vr.Values[from:to]}
+	if ar, err := parseA1Range(vr.Range); err == nil && vr.Range != "" {
+		sub.Range = fmt.Sprintf("'%s'!%s%d:%s", ar.sheet, columnLetter(ar.startCol), ar.startRow+from, columnLetter(ar.endCol))
+		if ar.endCol < 0 {
+			sub.Range = fmt.Sprintf("'%s'!%s%d", ar.sheet, columnLetter(ar.startCol), ar.startRow+from)
+		}
+	}
+	return sub
+}
@@ -0,0 +1,65 @@
+package f2lb_gsheet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseA1Range(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want a1Range
+	}{
+		{"MainQueue!A3:O", a1Range{sheet: "MainQueue", startCol: 0, startRow: 3, endCol: 14}},
+		{"'Delegation Cycle'!B2:D10", a1Range{sheet: "Delegation Cycle", startCol: 1, startRow: 2, endCol: 3, endRow: 10}},
+		{"AddonQ!AA10", a1Range{sheet: "AddonQ", startCol: 26, startRow: 10, endCol: -1}},
+		{"AddonQ!c", a1Range{sheet: "AddonQ", startCol: 2, startRow: 1, endCol: -1}},
+		{"Supporters", a1Range{sheet: "Supporters", startRow: 1, endCol: -1}},
+	} {
+		got, err := parseA1Range(tc.in)
+		if err != nil {
+			t.Errorf("parseA1Range(%q) failed: %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseA1Range(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+
+	for _, in := range []string{"MainQueue!A1:B2x", "MainQueue!1A"} {
+		if _, err := parseA1Range(in); err == nil {
+			t.Errorf("parseA1Range(%q) expected to fail", in)
+		}
+	}
+}
+
+func TestSubValueRange(t *testing.T) {
+	vr := &ValueRange{
+		Range:          "MainQueue!A3:O",
+		MajorDimension: "ROWS",
+		Values:         [][]any{{"r3"}, {"r4"}, {"r5"}, {"r6"}, {"r7"}},
+	}
+	sub := subValueRange(vr, 2, 5)
+	if sub.Range != "'MainQueue'!A5:O" {
+		t.Errorf("unexpected range %q", sub.Range)
+	}
+	if sub.MajorDimension != "ROWS" {
+		t.Errorf("unexpected major dimension %q", sub.MajorDimension)
+	}
+	if want := [][]any{{"r5"}, {"r6"}, {"r7"}}; !reflect.DeepEqual(sub.Values, want) {
+		t.Errorf("unexpected values %v, want %v", sub.Values, want)
+	}
+	if first := firstRowOfRange(sub.Range, "MainQueue!A3:O"); first != 5 {
+		t.Errorf("unexpected first row %d of the sub range", first)
+	}
+
+	sub = subValueRange(&ValueRange{Range: "'Delegation Cycle'!B2", Values: vr.Values}, 1, 3)
+	if sub.Range != "'Delegation Cycle'!B3" {
+		t.Errorf("unexpected range %q", sub.Range)
+	}
+
+	// a range not known stays unknown, the parsers use their own range
+	if sub = subValueRange(&ValueRange{Values: vr.Values}, 0, 2); sub.Range != "" || len(sub.Values) != 2 {
+		t.Errorf("unexpected sub range %+v", sub)
+	}
+}
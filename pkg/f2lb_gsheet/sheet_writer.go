@@ -0,0 +1,241 @@
+package f2lb_gsheet
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+var (
+	sheetWriterEnabled      bool
+	sheetWriterDryRun       bool
+	sheetWriterAuditLogPath string
+
+	// values written in the delegation status column, they have to match the ones used in the sheet
+	sheetWriterDelegatedStatus    = "Delegated"
+	sheetWriterNotDelegatedStatus = "Not Delegated"
+)
+
+// SheetWriter is implemented by the sheet sources that can be updated
+type SheetWriter interface {
+	BatchUpdate(updates ...*ValueRange) error
+}
+
+// CellChange is a computed value that differs from the one in the sheet
+type CellChange struct {
+	Sheet  string `json:"sheet"`
+	Cell   string `json:"cell"`
+	Field  string `json:"field"`
+	Ticker string `json:"ticker"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+func (cc CellChange) Range() string { return fmt.Sprintf("%s!%s", cc.Sheet, cc.Cell) }
+
+// sheetWriteAudit is a line of the audit log, one per write
+type sheetWriteAudit struct {
+	Time    time.Time    `json:"time"`
+	Changes []CellChange `json:"changes"`
+	Error   string       `json:"error,omitempty"`
+}
+
+func appendSheetWriteAudit(path string, audit sheetWriteAudit) error {
+	data, err := json.Marshal(audit)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// delegatedToActive uses the account cache, known is false when the delegation of the member is not in the cache
+func (c *controller) delegatedToActive(stakeAddrs []string) (delegated, known bool) {
+	if len(stakeAddrs) == 0 || c.accountCache == nil {
+		return false, false
+	}
+	ai, ok := c.accountCache.Get(stakeAddrs[0])
+	if !ok {
+		return false, false
+	}
+	dp := ai.DelegatedPool()
+	if dp == "" {
+		return false, true
+	}
+	if pi, ok := c.poolCache.Get(dp); ok {
+		dp = pi.Ticker()
+	}
+	return dp == c.delegCycle.activeTicker, true
+}
+
+// delegationStatusFor returns the value for the delegation status column, an empty string means unknown.
+// A member delegating to a pool other than the active one is not delegated.
+func (c *controller) delegationStatusFor(stakeAddrs []string) string {
+	delegated, known := c.delegatedToActive(stakeAddrs)
+	switch {
+	case !known:
+		return ""
+	case delegated:
+		return sheetWriterDelegatedStatus
+	default:
+		return sheetWriterNotDelegatedStatus
+	}
+}
+
+type statusRow struct {
+	ticker       string
+	sheetRow     int
+	stakeAddrs   []string
+	delegStatus  string
+	currPos      string
+	missedEpochs string
+}
+
+func (c *controller) statusChangesForSheet(sheet string, cm *columnMapping, rows []statusRow) []CellChange {
+	changes := []CellChange{}
+	delegStatusCol, hasDelegStatus := cm.cols[fieldDelegStatus]
+	currPosCol, hasCurrPos := cm.cols[fieldQueuePosition]
+	missedEpochsCol, hasMissedEpochs := cm.cols[fieldMissedEpochs]
+	hasMissedEpochs = hasMissedEpochs && c.history != nil
+	for i, r := range rows {
+		if r.sheetRow == 0 {
+			continue
+		}
+		if hasDelegStatus {
+			if status := c.delegationStatusFor(r.stakeAddrs); status != "" && status != r.delegStatus {
+				changes = append(changes, CellChange{
+					Sheet: sheet, Cell: fmt.Sprintf("%s%d", columnLetter(delegStatusCol), r.sheetRow),
+					Field: fieldDelegStatus, Ticker: r.ticker, Old: r.delegStatus, New: status,
+				})
+			}
+		}
+		// positions are 1-based, the top of the queue is 1
+		if pos := strconv.Itoa(i + 1); hasCurrPos && pos != r.currPos {
+			changes = append(changes, CellChange{
+				Sheet: sheet, Cell: fmt.Sprintf("%s%d", columnLetter(currPosCol), r.sheetRow),
+				Field: fieldQueuePosition, Ticker: r.ticker, Old: r.currPos, New: pos,
+			})
+		}
+		if hasMissedEpochs {
+			// only the completed epochs, the current one can still be delegated
+			if missed, ok := c.history.MissedEpochs(r.ticker, utils.CurrentEpoch()); ok {
+				if m := strconv.FormatUint(uint64(missed), 10); m != r.missedEpochs {
+					changes = append(changes, CellChange{
+						Sheet: sheet, Cell: fmt.Sprintf("%s%d", columnLetter(missedEpochsCol), r.sheetRow),
+						Field: fieldMissedEpochs, Ticker: r.ticker, Old: r.missedEpochs, New: m,
+					})
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// computeSheetChanges compares the status columns of the queues with the values computed from the caches,
+// the missed epochs are counted from the delegations recorded in the history.
+func (c *controller) computeSheetChanges() []CellChange {
+	seen := make(map[*MainQueueRec]bool)
+	mqRows := []statusRow{}
+	for _, r := range c.mainQueue.GetRecords() {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		currPos := ""
+		if r.mainQCurrPos > 0 {
+			currPos = strconv.Itoa(int(r.mainQCurrPos))
+		}
+		mqRows = append(mqRows, statusRow{ticker: r.Ticker, sheetRow: r.sheetRow, stakeAddrs: r.StakeAddrs,
+			delegStatus: r.delegStatus, currPos: currPos, missedEpochs: r.missedEpochs})
+	}
+
+	aqSeen := make(map[*AddonQueueRec]bool)
+	aqRows := []statusRow{}
+	for _, r := range c.addonQueue.GetRecords() {
+		if aqSeen[r] {
+			continue
+		}
+		aqSeen[r] = true
+		aqRows = append(aqRows, statusRow{ticker: r.Ticker, sheetRow: r.sheetRow, stakeAddrs: r.StakeAddrs,
+			delegStatus: r.delegStatus, currPos: r.addonQCurrPos, missedEpochs: r.missedEpochs})
+	}
+
+	changes := c.statusChangesForSheet(c.mainQueue.nameOr(mainQueueSheet), c.mainQueue.getColumns(mainQueueSheet), mqRows)
+	return append(changes, c.statusChangesForSheet(c.addonQueue.nameOr(addonQueueSheet),
+		c.addonQueue.getColumns(addonQueueSheet), aqRows)...)
+}
+
+// writeSheetChanges pushes the computed status columns to the sheet, in dry-run mode the changes are only kept as pending.
+// Nothing is written while the data is not from the last read of the sheets
+func (c *controller) writeSheetChanges() {
+	if !sheetWriterEnabled || !c.IsReady() {
+		return
+	}
+	// the status columns computed on old data would overwrite the newer ones in the sheet
+	if c.GetSheetsStatus().IsDegraded() {
+		c.V(3).Info("Controller not writing sheet changes, the sheets data is degraded")
+		return
+	}
+	changes := c.computeSheetChanges()
+	c.writerMu.Lock()
+	c.pendingSheetChanges = changes
+	c.writerMu.Unlock()
+	if len(changes) == 0 {
+		return
+	}
+
+	if sheetWriterDryRun {
+		for _, cc := range changes {
+			c.V(2).Info("Controller sheet change (dry-run)", "range", cc.Range(), "ticker", cc.Ticker,
+				"field", cc.Field, "old", cc.Old, "new", cc.New)
+		}
+		return
+	}
+
+	w, ok := c.source.(SheetWriter)
+	if !ok {
+		c.Error(fmt.Errorf("sheet source is not writable"), "Controller writing sheet changes")
+		return
+	}
+	updates := make([]*ValueRange, 0, len(changes))
+	for _, cc := range changes {
+		updates = append(updates, &ValueRange{Range: cc.Range(), MajorDimension: "ROWS", Values: [][]any{{cc.New}}})
+	}
+	err := w.BatchUpdate(updates...)
+	audit := sheetWriteAudit{Time: utils.Now(), Changes: changes}
+	if err != nil {
+		audit.Error = err.Error()
+		c.Error(err, "Controller writing sheet changes", "changes", len(changes))
+	} else {
+		c.writerMu.Lock()
+		c.pendingSheetChanges = []CellChange{}
+		c.writerMu.Unlock()
+		for _, cc := range changes {
+			c.Info("Controller wrote sheet change", "range", cc.Range(), "ticker", cc.Ticker,
+				"field", cc.Field, "old", cc.Old, "new", cc.New)
+		}
+	}
+	if sheetWriterAuditLogPath != "" {
+		if err := appendSheetWriteAudit(sheetWriterAuditLogPath, audit); err != nil {
+			c.Error(err, "Controller writing sheet audit log", "path", sheetWriterAuditLogPath)
+		}
+	}
+}
+
+// GetPendingSheetChanges returns the changes computed by the last run of the writer not yet written
+func (c *controller) GetPendingSheetChanges() []CellChange {
+	c.writerMu.Lock()
+	defer c.writerMu.Unlock()
+	return c.pendingSheetChanges
+}
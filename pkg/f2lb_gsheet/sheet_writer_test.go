@@ -0,0 +1,214 @@
+package f2lb_gsheet
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/safanaj/go-f2lb/pkg/caches/accountcache"
+	"github.com/safanaj/go-f2lb/pkg/caches/poolcache"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+type testAccountInfo struct {
+	accountcache.AccountInfo
+	delegatedPool string
+}
+
+func (ai testAccountInfo) DelegatedPool() string { return ai.delegatedPool }
+
+type testAccountCache struct {
+	accountcache.AccountCache
+	infos map[string]accountcache.AccountInfo
+}
+
+func (ac testAccountCache) Ready() bool { return true }
+func (ac testAccountCache) Get(saddr string) (accountcache.AccountInfo, bool) {
+	ai, ok := ac.infos[saddr]
+	return ai, ok
+}
+
+type testPoolInfo struct {
+	poolcache.PoolInfo
+	ticker string
+}
+
+func (pi testPoolInfo) Ticker() string { return pi.ticker }
+
+type testPoolCache struct {
+	poolcache.PoolCache
+	infos map[string]poolcache.PoolInfo
+}
+
+func (pc testPoolCache) Ready() bool { return true }
+func (pc testPoolCache) Get(key string) (poolcache.PoolInfo, bool) {
+	pi, ok := pc.infos[key]
+	return pi, ok
+}
+
+var (
+	testStakeKeyA = strings.Repeat("a1", 28)
+	testStakeKeyB = strings.Repeat("b2", 28)
+)
+
+// AAA is the active pool and delegated to it, BBB is delegated to another pool and its position is wrong
+var testSheetWriterFiles = map[string]string{
+	"MainQueue.csv": "Discord Name,QPP,Ticker,AD,EG,Delegation Status,MainQ Curr Pos,AddonQ Status,Stake Addresses\n,\n" +
+		"aaa,1,AAA,1000,1,Not Delegated,1,," + testStakeKeyA + "\n" +
+		"bbb,1,BBB,1000,1,Delegated,5,," + testStakeKeyB + "\n",
+	"AddonQ.csv": "Discord Name,QPP,Ticker,AD,EG,Delegation Status,AddonQ Curr Pos,AddonQ Status,Stake Addresses\n",
+}
+
+func newTestSheetWriterController(t *testing.T) (*controller, *localSheetSource) {
+	t.Helper()
+	return newTestSheetWriterControllerWith(t, testSheetWriterFiles)
+}
+
+func newTestSheetWriterControllerWith(t *testing.T, files map[string]string) (*controller, *localSheetSource) {
+	t.Helper()
+	src := newTestLocalSheetSource(t, files)
+	c := &controller{
+		Logger:     logr.Discard(),
+		source:     src,
+		mainQueue:  &MainQueue{},
+		addonQueue: &AddonQueue{},
+		delegCycle: &DelegationCycle{activeTicker: "AAA"},
+	}
+	if err := c.mainQueue.Refresh(src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.addonQueue.Refresh(src, nil); err != nil {
+		t.Fatal(err)
+	}
+	saddrA, saddrB := c.mainQueue.GetByTicker("AAA").StakeAddrs[0], c.mainQueue.GetByTicker("BBB").StakeAddrs[0]
+	c.accountCache = testAccountCache{infos: map[string]accountcache.AccountInfo{
+		saddrA: testAccountInfo{delegatedPool: "pool1aaa"},
+		saddrB: testAccountInfo{delegatedPool: "pool1ccc"},
+	}}
+	c.poolCache = testPoolCache{infos: map[string]poolcache.PoolInfo{
+		"pool1aaa": testPoolInfo{ticker: "AAA"},
+		"pool1ccc": testPoolInfo{ticker: "CCC"},
+	}}
+	return c, src
+}
+
+func setTestSheetWriter(t *testing.T, enabled, dryRun bool) {
+	t.Helper()
+	prevEnabled, prevDryRun := sheetWriterEnabled, sheetWriterDryRun
+	t.Cleanup(func() { sheetWriterEnabled, sheetWriterDryRun = prevEnabled, prevDryRun })
+	sheetWriterEnabled, sheetWriterDryRun = enabled, dryRun
+}
+
+var testSheetChanges = []CellChange{
+	{Sheet: "MainQueue", Cell: "F3", Field: fieldDelegStatus, Ticker: "AAA", Old: "Not Delegated", New: sheetWriterDelegatedStatus},
+	{Sheet: "MainQueue", Cell: "F4", Field: fieldDelegStatus, Ticker: "BBB", Old: "Delegated", New: sheetWriterNotDelegatedStatus},
+	{Sheet: "MainQueue", Cell: "G4", Field: fieldQueuePosition, Ticker: "BBB", Old: "5", New: "2"},
+}
+
+func TestComputeSheetChanges(t *testing.T) {
+	c, _ := newTestSheetWriterController(t)
+	if changes := c.computeSheetChanges(); !reflect.DeepEqual(changes, testSheetChanges) {
+		t.Fatalf("got changes %+v, want %+v", changes, testSheetChanges)
+	}
+}
+
+func TestComputeSheetChangesMissedEpochs(t *testing.T) {
+	c, _ := newTestSheetWriterControllerWith(t, map[string]string{
+		"MainQueue.csv": "Discord Name,QPP,Ticker,AD,EG,Delegation Status,MainQ Curr Pos,AddonQ Status,Stake Addresses,Missed Epochs\n,\n" +
+			"aaa,1,AAA,1000,1,Delegated,1,," + testStakeKeyA + ",1\n" +
+			"bbb,1,BBB,1000,1,Not Delegated,2,," + testStakeKeyB + ",3\n",
+		"AddonQ.csv": "Discord Name,QPP,Ticker,AD,EG,Delegation Status,AddonQ Curr Pos,AddonQ Status,Stake Addresses\n",
+	})
+	h, err := NewHistory(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.history = h
+
+	delegated, notDelegated := true, false
+	current := utils.CurrentEpoch()
+	for _, snap := range []*EpochSnapshot{
+		{Epoch: current - 3, MainQueue: []HistoryQueueEntry{{Ticker: "AAA", Delegated: &delegated}}},
+		{Epoch: current - 2, MainQueue: []HistoryQueueEntry{{Ticker: "AAA", Delegated: &notDelegated}, {Ticker: "BBB"}}},
+		{Epoch: current - 1, MainQueue: []HistoryQueueEntry{{Ticker: "AAA", Delegated: &notDelegated}}},
+		// the current epoch is not completed
+		{Epoch: current, MainQueue: []HistoryQueueEntry{{Ticker: "AAA", Delegated: &notDelegated}}},
+	} {
+		if err := h.Save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// BBB has no recorded delegation, so its missed epochs are left as they are
+	want := []CellChange{
+		{Sheet: "MainQueue", Cell: "J3", Field: fieldMissedEpochs, Ticker: "AAA", Old: "1", New: "2"},
+	}
+	if changes := c.computeSheetChanges(); !reflect.DeepEqual(changes, want) {
+		t.Fatalf("got changes %+v, want %+v", changes, want)
+	}
+}
+
+func TestWriteSheetChangesDryRun(t *testing.T) {
+	setTestSheetWriter(t, true, true)
+	c, src := newTestSheetWriterController(t)
+	before, err := os.ReadFile(filepath.Join(src.dir, "MainQueue.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.writeSheetChanges()
+
+	after, err := os.ReadFile(filepath.Join(src.dir, "MainQueue.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("the sheet was modified in dry-run mode:\n%s", after)
+	}
+	if pending := c.GetPendingSheetChanges(); !reflect.DeepEqual(pending, testSheetChanges) {
+		t.Errorf("got pending changes %+v, want %+v", pending, testSheetChanges)
+	}
+}
+
+func TestWriteSheetChanges(t *testing.T) {
+	setTestSheetWriter(t, true, false)
+	c, src := newTestSheetWriterController(t)
+
+	c.writeSheetChanges()
+
+	if pending := c.GetPendingSheetChanges(); len(pending) != 0 {
+		t.Errorf("unexpected pending changes %+v", pending)
+	}
+	vrs, err := src.BatchGet("MainQueue!F3:G4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]any{{sheetWriterDelegatedStatus, "1"}, {sheetWriterNotDelegatedStatus, "2"}}; !reflect.DeepEqual(vrs[0].Values, want) {
+		t.Errorf("got %v, want %v", vrs[0].Values, want)
+	}
+
+	// nothing is left to write once the sheet is parsed again
+	c.mainQueue.ResetCaches()
+	if err := c.mainQueue.Refresh(src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if changes := c.computeSheetChanges(); len(changes) != 0 {
+		t.Errorf("unexpected changes after the write %+v", changes)
+	}
+}
+
+func TestWriteSheetChangesDegraded(t *testing.T) {
+	setTestSheetWriter(t, true, false)
+	c, _ := newTestSheetWriterController(t)
+	c.setSheetsFromSnapshot(c.mainQueue.refreshTime)
+
+	c.writeSheetChanges()
+
+	if pending := c.GetPendingSheetChanges(); len(pending) != 0 {
+		t.Errorf("unexpected pending changes from snapshot data %+v", pending)
+	}
+}
@@ -0,0 +1,281 @@
+package f2lb_gsheet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+const sheetsSnapshotFileName = "sheets-snapshot.json"
+
+// bounds of the exponential backoff used to retry the refresh when the sheets are not reachable,
+// the delay is anyway capped to the refresh interval
+var (
+	sheetsRetryMinDelay = 10 * time.Second
+	sheetsRetryMaxDelay = 10 * time.Minute
+)
+
+// sheetsSnapshot is the last good fetch of the sheets, values are the raw ones got in batch
+// and the indexes are the top of the queues
+type sheetsSnapshot struct {
+	Time             time.Time     `json:"time"`
+	Values           []*ValueRange `json:"values"`
+	MainQueueTopIdx  int           `json:"main_queue_top_idx"`
+	AddonQueueTopIdx int           `json:"addon_queue_top_idx"`
+	DelegCycleTopIdx int           `json:"deleg_cycle_top_idx"`
+}
+
+// contentHash covers the values and the top indexes, so it changes when the delegation cycle moves to the next epoch
+func (snap *sheetsSnapshot) contentHash() string {
+	h := sha256.New()
+	if err := json.NewEncoder(h).Encode(snap.Values); err != nil {
+		return ""
+	}
+	fmt.Fprintf(h, "%d:%d:%d", snap.MainQueueTopIdx, snap.AddonQueueTopIdx, snap.DelegCycleTopIdx)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *controller) sheetsSnapshotPath() string {
+	if c.storeDir == "" {
+		return ""
+	}
+	return filepath.Join(c.storeDir, sheetsSnapshotFileName)
+}
+
+func saveSheetsSnapshot(path string, snap *sheetsSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data)
+}
+
+func loadSheetsSnapshot(path string) (*sheetsSnapshot, error) {
+	if path == "" {
+		return nil, fmt.Errorf("No sheets snapshot, caches store path is not set")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snap := &sheetsSnapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(snap.Values) != int(valueRangeIdxMax) {
+		return nil, fmt.Errorf("invalid sheets snapshot %s: got %d value ranges, expected %d", path, len(snap.Values), valueRangeIdxMax)
+	}
+	return snap, nil
+}
+
+// SheetsStatus tells how old is the data from the sheets, StaleSince is zero when the last refresh succeeded
+type SheetsStatus struct {
+	DataTime     time.Time `json:"data_time"`
+	StaleSince   time.Time `json:"stale_since"`
+	LastError    string    `json:"last_error,omitempty"`
+	FromSnapshot bool      `json:"from_snapshot"`
+}
+
+func (s SheetsStatus) IsStale() bool { return !s.StaleSince.IsZero() }
+func (s SheetsStatus) HasData() bool { return !s.DataTime.IsZero() }
+
+// IsDegraded tells the data is not from the last read of the sheets, it is old or from the snapshot
+func (s SheetsStatus) IsDegraded() bool { return s.FromSnapshot || s.IsStale() }
+func (s SheetsStatus) DataAge() time.Duration {
+	if s.DataTime.IsZero() {
+		return 0
+	}
+	return utils.Now().Sub(s.DataTime)
+}
+
+func (c *controller) GetSheetsStatus() SheetsStatus {
+	c.sheetsStatusMu.RLock()
+	defer c.sheetsStatusMu.RUnlock()
+	return c.sheetsStatus
+}
+
+func (c *controller) setSheetsFresh(dataTime time.Time) {
+	c.sheetsStatusMu.Lock()
+	c.sheetsStatus = SheetsStatus{DataTime: dataTime}
+	c.sheetsRetryDelay = 0
+	c.sheetsStatusMu.Unlock()
+}
+
+func (c *controller) setSheetsStale(err error) {
+	c.sheetsStatusMu.Lock()
+	defer c.sheetsStatusMu.Unlock()
+	if c.sheetsStatus.StaleSince.IsZero() {
+		c.sheetsStatus.StaleSince = utils.Now()
+	}
+	c.sheetsStatus.LastError = err.Error()
+}
+
+func (c *controller) setSheetsFromSnapshot(dataTime time.Time) {
+	c.sheetsStatusMu.Lock()
+	defer c.sheetsStatusMu.Unlock()
+	c.sheetsStatus.DataTime = dataTime
+	c.sheetsStatus.FromSnapshot = true
+}
+
+// scheduleRefreshRetry runs a refresh after a delay that doubles at each failure
+func (c *controller) scheduleRefreshRetry() {
+	c.sheetsStatusMu.Lock()
+	defer c.sheetsStatusMu.Unlock()
+	if c.sheetsRetryTimer != nil || !c.IsRunning() {
+		return
+	}
+	delay := c.sheetsRetryDelay * 2
+	if delay < sheetsRetryMinDelay {
+		delay = sheetsRetryMinDelay
+	}
+	if delay > sheetsRetryMaxDelay {
+		delay = sheetsRetryMaxDelay
+	}
+	if c.refreshInterval > 0 && delay > c.refreshInterval {
+		delay = c.refreshInterval
+	}
+	c.sheetsRetryDelay = delay
+	c.V(2).Info("Controller scheduling refresh retry", "in", delay.String())
+	c.sheetsRetryTimer = time.AfterFunc(delay, func() {
+		c.sheetsStatusMu.Lock()
+		c.sheetsRetryTimer = nil
+		c.sheetsStatusMu.Unlock()
+		if err := c.Refresh(); err != nil {
+			c.Error(err, "Controller Refresh retry failed")
+		}
+	})
+}
+
+// fetchSheetsSnapshot gets the values and the top of the queues from the sheet source
+func (c *controller) fetchSheetsSnapshot() (*sheetsSnapshot, error) {
+	snap := &sheetsSnapshot{Time: utils.Now()}
+	res, err := c.getValuesInBatch()
+	if err != nil {
+		return nil, err
+	}
+	snap.Values = res
+
+	// for MainQueue and AddonQueue sheets get the top of the queues,
+	// basically the first row with a specific format/color
+	// with that indexes we can pass the correct subset of values to the Queues objects
+	snap.MainQueueTopIdx, snap.AddonQueueTopIdx, err = c.getTopOfQueues(
+		len(res[mainQueueVRI].Values),
+		len(res[addonQueueVRI].Values))
+	if err != nil {
+		return nil, err
+	}
+
+	if idx, err := c.getTopOfDelegCycleFromValues(res[delegCycleVRI], res[delegCycleHeaderVRI]); err == nil {
+		snap.DelegCycleTopIdx = idx
+	} else if idx, err := c.getTopOfDelegCycle(len(res[delegCycleVRI].Values)); err == nil {
+		snap.DelegCycleTopIdx = idx
+	} else {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// sheetsRevision returns the revision of the source, empty when not supported or not available
+func (c *controller) sheetsRevision() string {
+	r, ok := c.source.(SheetRevisioner)
+	if !ok {
+		return ""
+	}
+	rev, err := r.Revision()
+	if err != nil {
+		c.V(3).Info("Controller unable to get sheets revision", "error", err)
+		return ""
+	}
+	return rev
+}
+
+// isSheetsRevisionParsed tells if the revision was already parsed in the current epoch
+func (c *controller) isSheetsRevisionParsed(rev string) bool {
+	c.sheetsStatusMu.RLock()
+	defer c.sheetsStatusMu.RUnlock()
+	return rev != "" && rev == c.parsedSheetsRevision && utils.CurrentEpoch() == c.parsedSheetsEpoch
+}
+
+func (c *controller) isSheetsContentParsed(hash string) bool {
+	c.sheetsStatusMu.RLock()
+	defer c.sheetsStatusMu.RUnlock()
+	return hash != "" && hash == c.parsedSheetsHash && utils.CurrentEpoch() == c.parsedSheetsEpoch
+}
+
+func (c *controller) setSheetsParsed(rev, hash string) {
+	c.sheetsStatusMu.Lock()
+	defer c.sheetsStatusMu.Unlock()
+	c.parsedSheetsRevision = rev
+	c.parsedSheetsHash = hash
+	c.parsedSheetsEpoch = utils.CurrentEpoch()
+}
+
+// invalidateParsedSheets forces the next refresh to parse the sheets, i.e. when the pools hints changed
+func (c *controller) invalidateParsedSheets() {
+	c.sheetsStatusMu.Lock()
+	defer c.sheetsStatusMu.Unlock()
+	c.parsedSheetsRevision = ""
+	c.parsedSheetsHash = ""
+}
+
+// lazySheetSource creates the actual source on first use, so the controller can start
+// (and serve the last good snapshot) also when the source can not be created yet, i.e. missing credentials
+type lazySheetSource struct {
+	newSource func() (SheetSource, error)
+
+	mu  sync.Mutex
+	src SheetSource
+}
+
+var (
+	_ SheetSource = (*lazySheetSource)(nil)
+	_ SheetWriter = (*lazySheetSource)(nil)
+)
+
+func (s *lazySheetSource) get() (SheetSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.src == nil {
+		src, err := s.newSource()
+		if err != nil {
+			return nil, err
+		}
+		s.src = src
+	}
+	return s.src, nil
+}
+
+func (s *lazySheetSource) BatchGet(ranges ...string) ([]*ValueRange, error) {
+	src, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return src.BatchGet(ranges...)
+}
+
+func (s *lazySheetSource) GetTopRows(trrs ...TopRowRange) ([]int, error) {
+	src, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return src.GetTopRows(trrs...)
+}
+
+func (s *lazySheetSource) BatchUpdate(updates ...*ValueRange) error {
+	src, err := s.get()
+	if err != nil {
+		return err
+	}
+	w, ok := src.(SheetWriter)
+	if !ok {
+		return fmt.Errorf("sheet source is not writable")
+	}
+	return w.BatchUpdate(updates...)
+}
@@ -0,0 +1,207 @@
+package f2lb_gsheet
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/eventbus"
+	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// DelegationCycleState are the values of the delegation cycle sheet
+type DelegationCycleState struct {
+	Epoch                uint32 `json:"epoch"`
+	TopTicker            string `json:"top_ticker"`
+	TopRemainingEpochs   uint32 `json:"top_remaining_epochs"`
+	ActiveTicker         string `json:"active_ticker"`
+	AqTopTicker          string `json:"addon_queue_top_ticker"`
+	AqTopRemainingEpochs uint32 `json:"addon_queue_top_remaining_epochs"`
+}
+
+// State is an immutable view of the controller data, a new one with a greater Generation is published
+// after every change so readers get a consistent view without locks. Nothing in it has to be modified.
+type State struct {
+	Generation uint64    `json:"generation"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	MainQueue         []string             `json:"main_queue"`
+	AddonQueue        []string             `json:"addon_queue"`
+	MainQueueRecords  []MainQueueRec       `json:"main_queue_records"`
+	AddonQueueRecords []AddonQueueRec      `json:"addon_queue_records"`
+	Supporters        []Supporter          `json:"supporters"`
+	DelegationCycle   DelegationCycleState `json:"delegation_cycle"`
+
+	// unordered, as StakePoolSet.StakePools
+	Members []f2lb_members.StakePoolView `json:"-"`
+	// by ticker and by stake addresses
+	members map[string]f2lb_members.StakePoolView
+}
+
+var emptyState = &State{members: map[string]f2lb_members.StakePoolView{}}
+
+// stateBootNonce tells apart the generations of different runs, the counter restarts with the process
+var stateBootNonce = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// ETag is a weak entity tag of the state, to be used for conditional responses
+func (s *State) ETag() string { return fmt.Sprintf(`W/"%s-%d"`, stateBootNonce, s.Generation) }
+
+// Member returns the member by ticker or stake address, nil if not found
+func (s *State) Member(key string) f2lb_members.StakePoolView { return s.members[key] }
+
+// MembersOf returns the members of the tickers in the same order, the unknown ones are skipped
+func (s *State) MembersOf(tickers []string) []f2lb_members.StakePoolView {
+	members := make([]f2lb_members.StakePoolView, 0, len(tickers))
+	for _, t := range tickers {
+		if m := s.members[t]; m != nil {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+// buildState copies the current data, the records are replaced by the writers and never modified (see MainQueue.UpdateRecord)
+func (c *controller) buildState(generation uint64) *State {
+	s := &State{
+		Generation: generation,
+		CreatedAt:  utils.Now(),
+		MainQueue:  c.mainQueue.GetOrdered(),
+		AddonQueue: c.addonQueue.GetOrdered(),
+		DelegationCycle: DelegationCycleState{
+			Epoch:                c.delegCycle.epoch,
+			TopTicker:            c.delegCycle.topTicker,
+			TopRemainingEpochs:   c.delegCycle.topRemainingEpochs,
+			ActiveTicker:         c.delegCycle.activeTicker,
+			AqTopTicker:          c.delegCycle.aqTopTicker,
+			AqTopRemainingEpochs: c.delegCycle.aqTopRemainingEpochs,
+		},
+		members: make(map[string]f2lb_members.StakePoolView),
+	}
+	for _, r := range c.mainQueue.GetRecords() {
+		rec := *r
+		rec.StakeKeys, rec.StakeAddrs = slices.Clone(r.StakeKeys), slices.Clone(r.StakeAddrs)
+		s.MainQueueRecords = append(s.MainQueueRecords, rec)
+	}
+	for _, r := range c.addonQueue.GetRecords() {
+		rec := *r
+		rec.StakeKeys, rec.StakeAddrs = slices.Clone(r.StakeKeys), slices.Clone(r.StakeAddrs)
+		s.AddonQueueRecords = append(s.AddonQueueRecords, rec)
+	}
+	for _, r := range c.supporters.GetRecords() {
+		rec := *r
+		rec.StakeKeys, rec.StakeAddrs = slices.Clone(r.StakeKeys), slices.Clone(r.StakeAddrs)
+		s.Supporters = append(s.Supporters, rec)
+	}
+	for _, sp := range c.stakePoolSet.StakePools() {
+		m := f2lb_members.Snapshot(sp)
+		s.Members = append(s.Members, m)
+		s.members[m.Ticker()] = m
+		for _, saddr := range m.StakeAddrs() {
+			s.members[saddr] = m
+		}
+	}
+	return s
+}
+
+// publishState replaces the state with a new generation
+func (c *controller) publishState() *State {
+	s := c.buildState(atomic.AddUint64(&c.stateGeneration, 1))
+	c.state.Store(s)
+	return s
+}
+
+// republishStateIfChanged publishes a new state when the members read from the caches differ from the
+// published ones, the stake, the delegations and the block heights are updated without a sheets refresh
+func (c *controller) republishStateIfChanged(reason string) bool {
+	// the refresh in progress publishes the state anyway
+	if !c.mu.TryRLock() {
+		return false
+	}
+	defer c.mu.RUnlock()
+	if reflect.DeepEqual(c.GetState().members, c.buildState(0).members) {
+		return false
+	}
+	c.notifyRefresh(reason)
+	return true
+}
+
+// republishStateOnCachesUpdate republishes the state if the caches fetched something since the last check
+func (c *controller) republishStateOnCachesUpdate() error {
+	fetchedAt := c.accountCache.Stats().LastFetchAt
+	if t := c.poolCache.Stats().LastFetchAt; t.After(fetchedAt) {
+		fetchedAt = t
+	}
+	if !fetchedAt.After(c.cachesFetchedAt) {
+		return nil
+	}
+	if c.republishStateIfChanged("caches updated") {
+		c.V(3).Info("Controller state republished, the caches were updated", "fetched at", fetchedAt)
+	}
+	c.cachesFetchedAt = fetchedAt
+	return nil
+}
+
+// subscribeTipReports republishes the state when a tip is reported. The pool cache is shared by the
+// communities, so the tips reported to a community are relayed to the parent bus where all the controllers listen
+func (c *controller) subscribeTipReports() {
+	bus := c.bus
+	if c.parent != nil {
+		bus = c.parent.bus
+		go func(sub *eventbus.Subscription[eventbus.TipReport]) {
+			defer sub.Close()
+			for {
+				select {
+				case <-c.ctx.Done():
+					return
+				case tr, ok := <-sub.C:
+					if !ok {
+						return
+					}
+					eventbus.Publish(bus, eventbus.TipReported, tr)
+				}
+			}
+		}(eventbus.Subscribe(c.bus, eventbus.TipReported, "f2lb_gsheet/relay", 0))
+	}
+	go c.republishStateOnTipReports(eventbus.Subscribe(bus, eventbus.TipReported, "f2lb_gsheet/state", 0))
+}
+
+// republishStateOnTipReports republishes the state with the block heights reported by the members,
+// the reports queued meanwhile are served by the same republish
+func (c *controller) republishStateOnTipReports(sub *eventbus.Subscription[eventbus.TipReport]) {
+	defer sub.Close()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case tr, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			for n := len(sub.C); n > 0; n-- {
+				<-sub.C
+			}
+			if c.republishStateIfChanged("tip reported") {
+				c.V(3).Info("Controller state republished, a tip was reported", "ticker", tr.Ticker, "block", tr.BlockHeight)
+			}
+		}
+	}
+}
+
+// GetState returns the last published state, an empty one before the first refresh
+func (c *controller) GetState() *State {
+	if s := c.state.Load(); s != nil {
+		return s
+	}
+	return emptyState
+}
+
+// StateGetter is the part of the Controller needed by the API layers to read the state
+type StateGetter interface {
+	GetState() *State
+}
+
+var _ StateGetter = (*controller)(nil)
@@ -0,0 +1,20 @@
+package f2lb_gsheet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStateETag(t *testing.T) {
+	s1, s2 := &State{Generation: 1}, &State{Generation: 2}
+	if s1.ETag() == s2.ETag() {
+		t.Fatalf("same ETag %s for different generations", s1.ETag())
+	}
+	if !strings.HasPrefix(s1.ETag(), `W/"`) {
+		t.Fatalf("expected a weak ETag, got %s", s1.ETag())
+	}
+	// the generations restart with the process, the ETags of a previous run do not match
+	if !strings.Contains(s1.ETag(), stateBootNonce) || s1.ETag() == `W/"1"` {
+		t.Fatalf("ETag %s without the boot nonce", s1.ETag())
+	}
+}
@@ -3,7 +3,6 @@ package f2lb_gsheet
 import (
 	"encoding/json"
 	"fmt"
-	"regexp"
 	"sync"
 
 	"github.com/safanaj/go-f2lb/pkg/utils"
@@ -14,6 +13,7 @@ const supportersSheet = "F2LB-Supporters"
 
 // the first row is the header
 const supportersRange = "A3:M"
+const supportersHeaderRange = "A1:M2"
 
 type Supporter struct {
 	DiscordName string `json:"discord_name" yaml:"discord_name"`         // A
@@ -29,21 +29,23 @@ func (s *Supporter) MarshalJSON() ([]byte, error) { return json.Marshal(*s) }
 func (s *Supporter) MarshalYAML() (any, error)    { return *s, nil }
 
 type Supporters struct {
+	sheetParsing
+
 	mu      sync.RWMutex
 	records []*Supporter
 }
 
-func NewSupporters(f2lb *F2LB) (*Supporters, error) {
+func NewSupporters(src SheetSource) (*Supporters, error) {
 	s := &Supporters{}
-	err := s.Refresh(f2lb, nil)
+	err := s.Refresh(src, nil)
 	if err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
-func NewSupportersOrDie(f2lb *F2LB) *Supporters {
-	s, err := NewSupporters(f2lb)
+func NewSupportersOrDie(src SheetSource) *Supporters {
+	s, err := NewSupporters(src)
 	utils.CheckErr(err)
 	return s
 }
@@ -57,51 +59,57 @@ func (s *Supporters) MarshalJSON() ([]byte, error) { return json.Marshal(s.toMar
 func (s *Supporters) MarshalYAML() (any, error)    { return s.toMarshalable(), nil }
 
 func (m *Supporters) GetRange() string {
-	return fmt.Sprintf("%s!%s", supportersSheet, supportersRange)
+	return fmt.Sprintf("%s!%s", m.nameOr(supportersSheet), supportersRange)
+}
+
+// the first 2 rows are the header
+func (m *Supporters) GetHeaderRange() string {
+	return fmt.Sprintf("%s!%s", m.nameOr(supportersSheet), supportersHeaderRange)
 }
 
-func (m *Supporters) Refresh(f2lb *F2LB, vr *ValueRange) error {
+// SetHeader resolves the columns from the header rows, a nil header means the schema columns
+func (m *Supporters) SetHeader(header *ValueRange) { m.setHeader(supportersSheet, header) }
+
+func (m *Supporters) Refresh(src SheetSource, vr *ValueRange) error {
 	if vr == nil {
-		res, err := f2lb.Spreadsheets.Values.BatchGet(
-			f2lbSpreadSheetID).MajorDimension("ROWS").Ranges(
-			m.GetRange()).Do()
+		vrs, err := src.BatchGet(m.GetRange(), m.GetHeaderRange())
 		if err != nil {
 			return err
 		}
-		vr = res.ValueRanges[0]
+		vr = vrs[0]
+		m.SetHeader(vrs[1])
 	}
+	cm := m.getColumns(supportersSheet)
+	firstRow := firstRowOfRange(vr.Range, m.GetRange())
+	var rowErrs []RowError
+
 	records := make([]*Supporter, 0, len(vr.Values))
-	for _, v := range vr.Values {
-		sRec := &Supporter{
-			DiscordName: v[0].(string),
-			Ticker:      v[2].(string),
+	for i, v := range vr.Values {
+		p := &rowParser{cm: cm, row: v, rowNum: firstRow + i}
+		p.ticker = p.str(fieldTicker)
+		if !p.has(fieldDiscordName, fieldStakeAddresses) {
+			rowErrs = append(rowErrs, p.errs...)
+			continue
 		}
-		if len(v) > 12 {
-			sRec.discordID = v[12].(string)
+		sRec := &Supporter{
+			DiscordName: p.str(fieldDiscordName),
+			Ticker:      p.ticker,
+			discordID:   p.str(fieldDiscordId),
 		}
 
 		if sRec.Ticker == "-" {
 			sRec.Ticker = ""
 		}
 
-		for _, val := range regexp.MustCompile("[[:space:]]").Split(v[8].(string), -1) {
-			if val == "" {
-				continue
-			}
-			if val[:5] == "stake" {
-				sRec.StakeAddrs = append(sRec.StakeAddrs, val)
-				sRec.StakeKeys = append(sRec.StakeKeys, utils.StakeAddressToStakeKeyHashOrDie(val))
-			} else {
-				sRec.StakeKeys = append(sRec.StakeKeys, val)
-				sRec.StakeAddrs = append(sRec.StakeAddrs, utils.StakeKeyHashToStakeAddressOrDie(val))
-			}
-		}
+		sRec.StakeAddrs, sRec.StakeKeys = p.stakeAddresses(fieldStakeAddresses)
 		records = append(records, sRec)
+		rowErrs = append(rowErrs, p.errs...)
 	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.records = records
+	m.setDataQuality(cm, rowErrs, nil)
 	return nil
 }
 
@@ -0,0 +1,125 @@
+package f2lb_gsheet
+
+import (
+	"fmt"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// the simulation gives up if the hypothetical member is not served within this number of epochs
+const whatIfMaxEpochs = 10000
+
+// placeholder ticker of the hypothetical member in the simulated queues
+const whatIfTicker = "?"
+
+// WhatIfDeclaration is a hypothetical member declaring AdaDeclared and joining the queues at JoinEpoch,
+// a zero JoinEpoch means the current one
+type WhatIfDeclaration struct {
+	AdaDeclared uint32      `json:"ada_declared"`
+	JoinEpoch   utils.Epoch `json:"join_epoch"`
+	Addon       bool        `json:"addon"`
+}
+
+// WhatIfResult is where the hypothetical member would land, positions are counted from 0 at the top of the queue
+// at the join epoch. AddonQueue is set only if the declaration asked for the addon queue.
+type WhatIfResult struct {
+	Declaration  WhatIfDeclaration `json:"declaration"`
+	EpochGranted uint16            `json:"epoch_granted"`
+	MainQueue    ForecastEntry     `json:"main_queue"`
+	AddonQueue   *ForecastEntry    `json:"addon_queue,omitempty"`
+}
+
+// whatIfQueue is a copy of the ordering of a queue with the epochs granted to each member
+type whatIfQueue struct {
+	name         string
+	tickers      []string
+	granted      map[string]uint16
+	topRemaining uint32
+}
+
+func (q *whatIfQueue) add(ticker string, eg uint16) {
+	if _, ok := q.granted[ticker]; ok {
+		return
+	}
+	q.tickers = append(q.tickers, ticker)
+	q.granted[ticker] = eg
+}
+
+// simulate walks the queue with the forecast from the given epoch, rotating the served members, and returns
+// the entry of the hypothetical member appended at the bottom at the join epoch: it is served once the member
+// serving at the join epoch and then each of the others are served again
+func (q *whatIfQueue) simulate(from, join utils.Epoch, eg uint16) (ForecastEntry, error) {
+	w := &forecastWalker{queue: q.name, next: from, top: q.topRemaining}
+	n := len(q.tickers)
+	if n == 0 {
+		// the hypothetical member is the top of the empty queue
+		w.next, w.top = join, uint32(eg)
+	}
+	left := -1
+	for i := 0; n > 0 && left != 0; i++ {
+		if w.next >= from+whatIfMaxEpochs {
+			return ForecastEntry{}, fmt.Errorf("%s: not served within %d epochs", q.name, whatIfMaxEpochs)
+		}
+		ticker := q.tickers[i%n]
+		e := w.add(i, ticker, "", "", q.granted[ticker])
+		if left > 0 {
+			left--
+		} else if left < 0 && e.EndEpoch >= join {
+			left = n - 1
+		}
+	}
+	e := w.add(n, whatIfTicker, "", "", eg)
+	e.Position = n
+	return e, nil
+}
+
+// SimulateDeclaration projects a hypothetical declaration on a copy of the current queues,
+// the epochs granted are computed by the queue rules and the live state is not touched
+func (c *controller) SimulateDeclaration(d WhatIfDeclaration) (*WhatIfResult, error) {
+	if d.AdaDeclared == 0 {
+		return nil, fmt.Errorf("ADA declared is required")
+	}
+	epoch := utils.Epoch(c.delegCycle.epoch)
+	if epoch == 0 {
+		epoch = utils.CurrentEpoch()
+	}
+	if d.JoinEpoch == 0 {
+		d.JoinEpoch = epoch
+	}
+	if d.JoinEpoch < epoch {
+		return nil, fmt.Errorf("join epoch %d is before the current one %d", d.JoinEpoch, epoch)
+	}
+
+	qr := defaultQueueRules()
+	eg := qr.EpochsGranted(d.AdaDeclared)
+	if eg == 0 {
+		// epochs granted check disabled, the minimum is granted
+		eg = 1
+	}
+	res := &WhatIfResult{Declaration: d, EpochGranted: eg}
+
+	mq := &whatIfQueue{name: MainQueueName, granted: make(map[string]uint16), topRemaining: c.delegCycle.topRemainingEpochs}
+	for _, r := range c.mainQueue.GetRecords() {
+		mq.add(r.Ticker, r.EG)
+	}
+	e, err := mq.simulate(epoch, d.JoinEpoch, eg)
+	if err != nil {
+		return nil, err
+	}
+	res.MainQueue = e
+
+	if d.Addon {
+		aq := &whatIfQueue{name: AddonQueueName, granted: make(map[string]uint16), topRemaining: c.delegCycle.aqTopRemainingEpochs}
+		for _, r := range c.addonQueue.GetRecords() {
+			if len(r.StakeAddrs) > 0 {
+				aq.add(r.Ticker, r.EG)
+			}
+		}
+		e, err := aq.simulate(epoch, d.JoinEpoch, eg)
+		if err != nil {
+			return nil, err
+		}
+		res.AddonQueue = &e
+	}
+	return res, nil
+}
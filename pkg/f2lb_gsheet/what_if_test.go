@@ -0,0 +1,42 @@
+package f2lb_gsheet
+
+import (
+	"testing"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+func TestWhatIfQueueSimulate(t *testing.T) {
+	newQueue := func(topRemaining uint32, tickers ...string) *whatIfQueue {
+		q := &whatIfQueue{name: MainQueueName, granted: make(map[string]uint16), topRemaining: topRemaining}
+		for _, ticker := range tickers {
+			q.add(ticker, map[string]uint16{"A": 1, "B": 2, "C": 0}[ticker])
+		}
+		return q
+	}
+	for _, tc := range []struct {
+		name       string
+		q          *whatIfQueue
+		join       utils.Epoch
+		eg         uint16
+		pos        int
+		start, end utils.Epoch
+	}{
+		{"empty queue", newQueue(0), 105, 2, 0, 105, 106},
+		{"join now", newQueue(1, "A", "B"), 100, 1, 2, 103, 103},
+		{"join after the rotation", newQueue(1, "A", "B"), 101, 1, 2, 104, 104},
+		{"join during the top epochs", newQueue(2, "B", "A"), 101, 3, 2, 103, 105},
+		{"not granted served once", newQueue(1, "A", "C"), 100, 1, 2, 102, 102},
+		{"single member", newQueue(1, "B"), 100, 1, 1, 101, 101},
+	} {
+		e, err := tc.q.simulate(100, tc.join, tc.eg)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if e.Ticker != whatIfTicker || e.Position != tc.pos || e.StartEpoch != tc.start || e.EndEpoch != tc.end {
+			t.Errorf("%s: got position %d epochs %d-%d, want position %d epochs %d-%d",
+				tc.name, e.Position, e.StartEpoch, e.EndEpoch, tc.pos, tc.start, tc.end)
+		}
+	}
+}
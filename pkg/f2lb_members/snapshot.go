@@ -0,0 +1,126 @@
+package f2lb_members
+
+import (
+	"slices"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// stakePoolSnapshot is a StakePoolView with the values taken once, also the ones from the caches
+type stakePoolSnapshot struct {
+	ticker                    string
+	discordName               string
+	adaDeclared               utils.Lovelace
+	adaDelegated              utils.Lovelace
+	epochGranted              uint16
+	mainCurrPos               uint16
+	epochGrantedOnAddonQ      uint16
+	stakeKeys                 []string
+	stakeAddrs                []string
+	qpp                       uint16
+	delegStatus               string
+	addonQStatus              string
+	missedEpochs              string
+	startingEpochOnMainQueue  uint16
+	startingEpochOnAddonQueue uint16
+	delegatedPool             string
+	adaAmount                 utils.Lovelace
+	status                    string
+	stakeAddressesInfo        []StakeAddressInfo
+	totalAdaDelegated         utils.Lovelace
+	delegationStatus          string
+	poolIdBech32              string
+	poolIdHex                 string
+	poolVrfKeyHash            string
+	activeStake               utils.Lovelace
+	liveStake                 utils.Lovelace
+	liveDelegators            uint32
+	blockHeight               uint32
+}
+
+var _ StakePoolView = (*stakePoolSnapshot)(nil)
+
+// Snapshot returns an immutable copy of the stake pool, to be read without locks while the set is refreshed
+func Snapshot(sp StakePoolView) StakePoolView {
+	return &stakePoolSnapshot{
+		ticker:                    sp.Ticker(),
+		discordName:               sp.DiscordName(),
+		adaDeclared:               sp.AdaDeclared(),
+		adaDelegated:              sp.AdaDelegated(),
+		epochGranted:              sp.EpochGranted(),
+		mainCurrPos:               sp.MainQueueCurrentPosision(),
+		epochGrantedOnAddonQ:      sp.EpochGrantedOnAddonQueue(),
+		stakeKeys:                 slices.Clone(sp.StakeKeys()),
+		stakeAddrs:                slices.Clone(sp.StakeAddrs()),
+		qpp:                       sp.QPP(),
+		delegStatus:               sp.DelegStatus(),
+		addonQStatus:              sp.AddonQStatus(),
+		missedEpochs:              sp.MissedEpochs(),
+		startingEpochOnMainQueue:  sp.StartingEpochOnMainQueue(),
+		startingEpochOnAddonQueue: sp.StartingEpochOnAddonQueue(),
+		delegatedPool:             sp.DelegatedPool(),
+		adaAmount:                 sp.AdaAmount(),
+		status:                    sp.Status(),
+		stakeAddressesInfo:        sp.StakeAddressesInfo(),
+		totalAdaDelegated:         sp.TotalAdaDelegated(),
+		delegationStatus:          sp.DelegationStatus(),
+		poolIdBech32:              sp.PoolIdBech32(),
+		poolIdHex:                 sp.PoolIdHex(),
+		poolVrfKeyHash:            sp.PoolVrfKeyHash(),
+		activeStake:               sp.ActiveStake(),
+		liveStake:                 sp.LiveStake(),
+		liveDelegators:            sp.LiveDelegators(),
+		blockHeight:               sp.BlockHeight(),
+	}
+}
+
+func (s *stakePoolSnapshot) Ticker() string                   { return s.ticker }
+func (s *stakePoolSnapshot) DiscordName() string              { return s.discordName }
+func (s *stakePoolSnapshot) AdaDeclared() utils.Lovelace      { return s.adaDeclared }
+func (s *stakePoolSnapshot) AdaDelegated() utils.Lovelace     { return s.adaDelegated }
+func (s *stakePoolSnapshot) EpochGranted() uint16             { return s.epochGranted }
+func (s *stakePoolSnapshot) MainQueueCurrentPosision() uint16 { return s.mainCurrPos }
+func (s *stakePoolSnapshot) EpochGrantedOnAddonQueue() uint16 { return s.epochGrantedOnAddonQ }
+func (s *stakePoolSnapshot) StakeKeys() []string              { return s.stakeKeys }
+func (s *stakePoolSnapshot) StakeAddrs() []string             { return s.stakeAddrs }
+func (s *stakePoolSnapshot) QPP() uint16                      { return s.qpp }
+func (s *stakePoolSnapshot) DelegStatus() string              { return s.delegStatus }
+func (s *stakePoolSnapshot) AddonQStatus() string             { return s.addonQStatus }
+func (s *stakePoolSnapshot) MissedEpochs() string             { return s.missedEpochs }
+
+func (s *stakePoolSnapshot) StartingEpochOnMainQueue() uint16 { return s.startingEpochOnMainQueue }
+func (s *stakePoolSnapshot) StartingTimeOnMainQueue() time.Time {
+	return utils.EpochStartTime(utils.Epoch(s.startingEpochOnMainQueue))
+}
+func (s *stakePoolSnapshot) StartingEpochOnAddonQueue() uint16 { return s.startingEpochOnAddonQueue }
+func (s *stakePoolSnapshot) StartingTimeOnAddonQueue() time.Time {
+	return utils.EpochStartTime(utils.Epoch(s.startingEpochOnAddonQueue))
+}
+
+func (s *stakePoolSnapshot) MainStakeAddress() string {
+	if len(s.stakeAddrs) == 0 {
+		return ""
+	}
+	return s.stakeAddrs[0]
+}
+func (s *stakePoolSnapshot) MainStakeKey() string {
+	if len(s.stakeKeys) == 0 {
+		return ""
+	}
+	return s.stakeKeys[0]
+}
+
+func (s *stakePoolSnapshot) DelegatedPool() string                  { return s.delegatedPool }
+func (s *stakePoolSnapshot) AdaAmount() utils.Lovelace              { return s.adaAmount }
+func (s *stakePoolSnapshot) Status() string                         { return s.status }
+func (s *stakePoolSnapshot) StakeAddressesInfo() []StakeAddressInfo { return s.stakeAddressesInfo }
+func (s *stakePoolSnapshot) TotalAdaDelegated() utils.Lovelace      { return s.totalAdaDelegated }
+func (s *stakePoolSnapshot) DelegationStatus() string               { return s.delegationStatus }
+func (s *stakePoolSnapshot) PoolIdBech32() string                   { return s.poolIdBech32 }
+func (s *stakePoolSnapshot) PoolIdHex() string                      { return s.poolIdHex }
+func (s *stakePoolSnapshot) PoolVrfKeyHash() string                 { return s.poolVrfKeyHash }
+func (s *stakePoolSnapshot) ActiveStake() utils.Lovelace            { return s.activeStake }
+func (s *stakePoolSnapshot) LiveStake() utils.Lovelace              { return s.liveStake }
+func (s *stakePoolSnapshot) LiveDelegators() uint32                 { return s.liveDelegators }
+func (s *stakePoolSnapshot) BlockHeight() uint32                    { return s.blockHeight }
@@ -0,0 +1,73 @@
+package f2lb_members
+
+import (
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// delegation status of a member looking at all its stake addresses
+const (
+	DelegationStatusUnknown = "unknown"
+	DelegationStatusSingle  = "single"
+	// the stake addresses are delegated to different pools
+	DelegationStatusSplit = "split delegation"
+)
+
+// StakeAddressInfo is the account info of one of the stake addresses of a member,
+// DelegatedPool is the ticker when the pool is known
+type StakeAddressInfo struct {
+	StakeAddress  string         `json:"stake_address"`
+	DelegatedPool string         `json:"delegated_pool"`
+	Amount        utils.Lovelace `json:"amount_lovelace"`
+	Status        string         `json:"status"`
+}
+
+func (sp *stakePool) StakeAddressesInfo() []StakeAddressInfo {
+	infos := make([]StakeAddressInfo, 0, len(sp.stakeAddrs))
+	for _, saddr := range sp.stakeAddrs {
+		info := StakeAddressInfo{StakeAddress: saddr, Status: "unknown"}
+		if ai, ok := sp.ac.Get(saddr); ok {
+			info.DelegatedPool = ai.DelegatedPool()
+			if pi, ok := sp.pc.Get(info.DelegatedPool); ok {
+				info.DelegatedPool = pi.Ticker()
+			}
+			info.Amount = ai.AdaAmount()
+			info.Status = ai.Status()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// TotalAdaDelegated is the sum of the amounts of the stake addresses delegated to the pool of the member
+func (sp *stakePool) TotalAdaDelegated() utils.Lovelace {
+	var total utils.Lovelace
+	for _, saddr := range sp.stakeAddrs {
+		ai, ok := sp.ac.Get(saddr)
+		if !ok || ai.DelegatedPool() == "" {
+			continue
+		}
+		if pi, ok := sp.pc.Get(ai.DelegatedPool()); ok && pi.Ticker() == sp.Ticker() {
+			total += ai.AdaAmount()
+		}
+	}
+	return total
+}
+
+// DelegationStatus tells if the known stake addresses are delegated to the same pool, the not delegated ones are ignored
+func (sp *stakePool) DelegationStatus() string {
+	pool := ""
+	for _, saddr := range sp.stakeAddrs {
+		ai, ok := sp.ac.Get(saddr)
+		if !ok || ai.DelegatedPool() == "" {
+			continue
+		}
+		if pool != "" && pool != ai.DelegatedPool() {
+			return DelegationStatusSplit
+		}
+		pool = ai.DelegatedPool()
+	}
+	if pool == "" {
+		return DelegationStatusUnknown
+	}
+	return DelegationStatusSingle
+}
@@ -31,7 +31,7 @@ type (
 		SetWithValues(
 			ticker string,
 			discordName string,
-			adaDeclared uint16,
+			adaDeclared utils.Lovelace,
 			epochGranted uint16,
 			mainCurrPos uint16,
 			stakeKeys []string,
@@ -49,16 +49,23 @@ type (
 		) StakePool
 	}
 	StakePool interface {
+		StakePoolView
+
+		SetEpochGrantedOnAddonQueue(uint16)
+		SetStartingEpochOnAddonQueue(uint16)
+	}
+
+	// StakePoolView are the read only methods of a StakePool, see Snapshot
+	StakePoolView interface {
 		Ticker() string
 		DiscordName() string
-		AdaDeclared() uint16
-		AdaDelegated() uint16
+		AdaDeclared() utils.Lovelace
+		AdaDelegated() utils.Lovelace
 		EpochGranted() uint16
 		// epochTraded  uint16
 		MainQueueCurrentPosision() uint16
 
 		EpochGrantedOnAddonQueue() uint16
-		SetEpochGrantedOnAddonQueue(uint16)
 
 		StakeKeys() []string
 		StakeAddrs() []string
@@ -76,22 +83,26 @@ type (
 		StartingEpochOnMainQueue() uint16
 		StartingTimeOnMainQueue() time.Time
 		StartingEpochOnAddonQueue() uint16
-		SetStartingEpochOnAddonQueue(uint16)
 		StartingTimeOnAddonQueue() time.Time
 
 		MainStakeAddress() string
 		MainStakeKey() string
 		DelegatedPool() string
-		AdaAmount() uint64
+		AdaAmount() utils.Lovelace
 		Status() string
+
+		// all the stake addresses of the member, the above are only about the main one
+		StakeAddressesInfo() []StakeAddressInfo
+		TotalAdaDelegated() utils.Lovelace
+		DelegationStatus() string
 		// LastDelegationTime() time.Time
 
 		PoolIdBech32() string
 		PoolIdHex() string
 		PoolVrfKeyHash() string
 
-		ActiveStake() uint32
-		LiveStake() uint32
+		ActiveStake() utils.Lovelace
+		LiveStake() utils.Lovelace
 		LiveDelegators() uint32
 
 		BlockHeight() uint32
@@ -111,7 +122,7 @@ type (
 		// fields from the main queue sheets
 		ticker       string
 		discordName  string
-		adaDeclared  uint16
+		adaDeclared  utils.Lovelace // as declared in the sheet
 		epochGranted uint16
 		epochTraded  uint16
 		mainCurrPos  uint16
@@ -158,7 +169,7 @@ func NewSet(ac accountcache.AccountCache, pc poolcache.PoolCache) StakePoolSet {
 func New(
 	ticker string,
 	discordName string,
-	adaDeclared uint16,
+	adaDeclared utils.Lovelace,
 	epochGranted uint16,
 	mainCurrPos uint16,
 
@@ -299,7 +310,7 @@ func (s *stakePoolSet) StakePools() []StakePool {
 func (s *stakePoolSet) SetWithValues(
 	ticker string,
 	discordName string,
-	adaDeclared uint16,
+	adaDeclared utils.Lovelace,
 	epochGranted uint16,
 	mainCurrPos uint16,
 	stakeKeys []string,
@@ -336,12 +347,12 @@ func (s *stakePoolSet) SetWithValues(
 	})
 }
 
-func (sp *stakePool) Ticker() string      { return sp.ticker }
-func (sp *stakePool) DiscordName() string { return sp.discordName }
-func (sp *stakePool) AdaDeclared() uint16 { return sp.adaDeclared }
-func (sp *stakePool) AdaDelegated() uint16 {
+func (sp *stakePool) Ticker() string              { return sp.ticker }
+func (sp *stakePool) DiscordName() string         { return sp.discordName }
+func (sp *stakePool) AdaDeclared() utils.Lovelace { return sp.adaDeclared }
+func (sp *stakePool) AdaDelegated() utils.Lovelace {
 	if ai, ok := sp.ac.Get(sp.MainStakeAddress()); ok {
-		return uint16(ai.AdaAmount())
+		return ai.AdaAmount()
 	}
 	return 0
 }
@@ -401,7 +412,7 @@ func (sp *stakePool) DelegatedPool() string {
 	}
 	return ""
 }
-func (sp *stakePool) AdaAmount() uint64 {
+func (sp *stakePool) AdaAmount() utils.Lovelace {
 	if ai, ok := sp.ac.Get(sp.MainStakeAddress()); ok {
 		return ai.AdaAmount()
 	}
@@ -442,13 +453,13 @@ func (sp *stakePool) PoolVrfKeyHash() string {
 	return ""
 }
 
-func (sp *stakePool) ActiveStake() uint32 {
+func (sp *stakePool) ActiveStake() utils.Lovelace {
 	if pi, ok := sp.pc.Get(sp.Ticker()); ok {
 		return pi.ActiveStake()
 	}
 	return 0
 }
-func (sp *stakePool) LiveStake() uint32 {
+func (sp *stakePool) LiveStake() utils.Lovelace {
 	if pi, ok := sp.pc.Get(sp.Ticker()); ok {
 		return pi.LiveStake()
 	}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 
 	"github.com/safanaj/go-f2lb/pkg/caches/poolcache"
+	"github.com/safanaj/go-f2lb/pkg/eventbus"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
+	"github.com/safanaj/go-f2lb/pkg/scheduler"
 )
 
 type (
@@ -13,11 +15,20 @@ type (
 		GetKoiosTipSlot() int
 		GetPoolCache() poolcache.PoolCache
 		GetStakePoolSet() f2lb_members.StakePoolSet
+		GetEventBus() *eventbus.Bus
+		GetScheduler() *scheduler.Scheduler
 
 		SetPinger(Pinger)
 		GetPinger() Pinger
 	}
 
+	// PoolHealth is published on PoolHealthChangedTopic when a pool goes up or down or its sync status changes
+	PoolHealth struct {
+		Ticker          string `json:"ticker"`
+		UpAndResponsive bool   `json:"up_and_responsive"`
+		InSync          string `json:"in_sync"`
+	}
+
 	PoolStats interface {
 		json.Marshaler
 		RelayStats() map[string]RelayStats
@@ -35,7 +46,11 @@ type (
 		CheckPool(f2lb_members.StakePool) PoolStats
 
 		SetController(MiniController)
+		// AddStakePoolSet adds a set of pools to check periodically, other than the controller one
+		AddStakePoolSet(f2lb_members.StakePoolSet)
 
 		DumpResults() any
 	}
 )
+
+var PoolHealthChangedTopic = eventbus.NewTopic[PoolHealth]("pool-health-changed")
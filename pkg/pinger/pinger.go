@@ -13,8 +13,10 @@ import (
 	"github.com/blinklabs-io/gouroboros/protocol/keepalive"
 
 	ku "github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
+	"github.com/safanaj/go-f2lb/pkg/eventbus"
 	"github.com/safanaj/go-f2lb/pkg/f2lb_members"
 	"github.com/safanaj/go-f2lb/pkg/logging"
+	"github.com/safanaj/go-f2lb/pkg/scheduler"
 	"github.com/safanaj/go-f2lb/pkg/utils"
 )
 
@@ -25,6 +27,8 @@ type (
 		ctx               context.Context
 		ctxDone           context.CancelFunc
 		ctrl              MiniController
+		setsMu            sync.RWMutex
+		extraSets         []f2lb_members.StakePoolSet
 		pings             int
 		pingInterval      time.Duration
 		responseThreshold time.Duration
@@ -35,6 +39,8 @@ type (
 
 		results map[string]PoolStats
 		ch      chan any
+		// the scheduled job asks the loop to check all the pools
+		checkAllCh chan struct{}
 
 		loopCh     chan struct{}
 		checkersWg sync.WaitGroup
@@ -91,14 +97,56 @@ func New(
 		connectTimeout: connectTimeout, keepaliveTimeout: keepaliveTimeout,
 		checkAlsoTip: checkAlsoTip,
 		results:      make(map[string]PoolStats),
+		checkAllCh:   make(chan struct{}, 1),
 	}
 }
 
+// CheckJobName is the job checking all the pools every checkInterval
+const CheckJobName = "pinger-check"
+
 func (p *pinger) SetController(ctrl MiniController) {
 	p.ctrl = ctrl
 	if ctrl.GetPinger() != p {
 		ctrl.SetPinger(p)
 	}
+	if err := ctrl.GetScheduler().Register(CheckJobName, scheduler.Every(p.checkInterval), func(context.Context) error {
+		if p.IsRunning() {
+			select {
+			case p.checkAllCh <- struct{}{}:
+			default:
+				// a check is already pending
+			}
+		}
+		return nil
+	}); err != nil {
+		p.Error(err, "Pinger scheduling the checks")
+	}
+}
+
+func (p *pinger) AddStakePoolSet(sps f2lb_members.StakePoolSet) {
+	p.setsMu.Lock()
+	defer p.setsMu.Unlock()
+	p.extraSets = append(p.extraSets, sps)
+}
+
+// poolIdsToCheck returns the pools of the controller and of the other sets, without duplicates
+func (p *pinger) poolIdsToCheck() []string {
+	p.setsMu.RLock()
+	sets := append([]f2lb_members.StakePoolSet{p.ctrl.GetStakePoolSet()}, p.extraSets...)
+	p.setsMu.RUnlock()
+	seen := make(map[string]struct{})
+	pids := []string{}
+	for _, sps := range sets {
+		for _, sp := range sps.StakePools() {
+			pid := sp.PoolIdBech32()
+			if _, ok := seen[pid]; ok {
+				continue
+			}
+			seen[pid] = struct{}{}
+			pids = append(pids, pid)
+		}
+	}
+	return pids
 }
 
 func (p *pinger) IsRunning() bool {
@@ -144,12 +192,8 @@ func (p *pinger) Start(pctx context.Context) error {
 		}()
 	}
 	p.loopCh = make(chan struct{})
-	go p.loop()
-
-	// start the first check on startup, delay it to try to get some sp from the set
-	// for _, sp := range p.ctrl.GetStakePoolSet().StakePools() {
-	// 	p.checkersCh <- sp.PoolIdBech32()
-	// }
+	// start the first check as soon as the caches are ready, so the set has the pool ids
+	go p.loop(eventbus.Subscribe(p.ctrl.GetEventBus(), eventbus.CacheReady, "pinger", 1))
 	return nil
 }
 
@@ -188,29 +232,42 @@ func (p *pinger) DumpResults() any {
 	return p.results
 }
 
-func (p *pinger) loop() {
-	tick := time.NewTicker(p.checkInterval)
+func (p *pinger) submitChecks() {
+	var li int
+	for i, pid := range p.poolIdsToCheck() {
+		p.checkersCh <- pid
+		li = i
+	}
+	p.V(4).Info("submitted ping checks", "pools", li)
+}
+
+func (p *pinger) publishHealthChange(ticker string, old, curr PoolStats) {
+	h := PoolHealth{Ticker: ticker, UpAndResponsive: curr.UpAndResponsive(), InSync: curr.InSync().String()}
+	if old != nil && old.UpAndResponsive() == h.UpAndResponsive && old.InSync().String() == h.InSync {
+		return
+	}
+	eventbus.Publish(p.ctrl.GetEventBus(), PoolHealthChangedTopic, h)
+}
+
+func (p *pinger) loop(cacheReady *eventbus.Subscription[time.Time]) {
 	ch := p.ch
+	cacheReadyCh := cacheReady.C
+	defer cacheReady.Close()
 	for {
 		select {
 		case <-p.ctx.Done():
-			tick.Stop()
 			close(p.loopCh)
 			return
-		case <-tick.C:
+		case <-cacheReadyCh:
+			// only the first one, then the scheduled job does it
+			cacheReadyCh = nil
+			go p.submitChecks()
+		case <-p.checkAllCh:
 			p.V(3).Info("current pool stats", "len", len(p.results))
-			go func() {
-				var li int
-				for i, sp := range p.ctrl.GetStakePoolSet().StakePools() {
-					p.checkersCh <- sp.PoolIdBech32()
-					li = i
-				}
-				p.V(4).Info("submitted ping checks", "pools", li)
-			}()
+			go p.submitChecks()
 
 		case msg, ok := <-ch:
 			if !ok {
-				tick.Stop()
 				return
 			}
 
@@ -228,6 +285,7 @@ func (p *pinger) loop() {
 				old, ok := p.results[v.name]
 				if !ok || v.result.errs != nil {
 					p.results[v.name] = v.result
+					p.publishHealthChange(v.name, old, v.result)
 					break
 				}
 				ps := &poolStats{stats: make(map[string]RelayStats)}
@@ -242,6 +300,7 @@ func (p *pinger) loop() {
 					ps.stats[t] = append(ps.stats[t], rs.first())
 				}
 				p.results[v.name] = ps
+				p.publishHealthChange(v.name, old, ps)
 
 			case getPoolStatsReq:
 				ps, ok := p.results[v.ticker]
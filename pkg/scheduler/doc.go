@@ -0,0 +1,3 @@
+// Package scheduler runs the periodic jobs, it is aware of the Cardano epochs so a job can run
+// at the epoch start or at a slot in the epoch other than at a fixed interval
+package scheduler
@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// NonceStabilitySlot is the slot in the epoch after which the nonce of the next epoch is known
+const NonceStabilitySlot utils.Slot = 302400
+
+// Schedule tells when a job has to run next
+type Schedule interface {
+	Next(after time.Time) time.Time
+	String() string
+}
+
+type slotInEpoch utils.Slot
+
+// AtSlotInEpoch runs the job every epoch when the slot in the epoch is reached
+func AtSlotInEpoch(slot utils.Slot) Schedule { return slotInEpoch(slot % utils.EpochLength) }
+
+// AtEpochStart runs the job at the first slot of every epoch, when the served pools change
+func AtEpochStart() Schedule { return slotInEpoch(0) }
+
+func (s slotInEpoch) Next(after time.Time) time.Time {
+	epoch := utils.TimeToEpoch(after)
+	next := utils.EpochStartTime(epoch).Add(time.Duration(s) * time.Second)
+	if !next.After(after) {
+		next = utils.EpochStartTime(epoch + 1).Add(time.Duration(s) * time.Second)
+	}
+	return next
+}
+
+func (s slotInEpoch) String() string {
+	if s == 0 {
+		return "at epoch start"
+	}
+	return fmt.Sprintf("at slot %d in epoch", s)
+}
+
+type every time.Duration
+
+// Every runs the job at a fixed interval from the previous run
+func Every(d time.Duration) Schedule { return every(d) }
+
+func (s every) Next(after time.Time) time.Time { return after.Add(time.Duration(s)) }
+func (s every) String() string                 { return fmt.Sprintf("every %s", time.Duration(s)) }
@@ -0,0 +1,227 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/safanaj/go-f2lb/pkg/logging"
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+// number of runs kept in the history of a job
+const jobHistorySize = 20
+
+var (
+	JobNotFoundErr       = errors.New("Job not found")
+	JobAlreadyRunningErr = errors.New("Job is already running")
+)
+
+type JobFunc func(context.Context) error
+
+// JobRun is a run of a job, Manual when triggered and not scheduled
+type JobRun struct {
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Epoch     utils.Epoch   `json:"epoch"`
+	Slot      utils.Slot    `json:"slot"`
+	Manual    bool          `json:"manual"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// JobInfo is the status of a job with the last runs, the most recent first
+type JobInfo struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	NextRun  time.Time `json:"next_run"`
+	Running  bool      `json:"running"`
+	History  []JobRun  `json:"history"`
+}
+
+type job struct {
+	name     string
+	schedule Schedule
+	fn       JobFunc
+
+	mu      sync.Mutex
+	nextRun time.Time
+	running bool
+	history []JobRun
+}
+
+func (j *job) info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := JobInfo{Name: j.name, Schedule: j.schedule.String(), NextRun: j.nextRun, Running: j.running,
+		History: slices.Clone(j.history)}
+	slices.Reverse(info.History)
+	return info
+}
+
+// Scheduler runs each registered job in its own goroutine, a run is skipped if the previous one is not done
+type Scheduler struct {
+	logging.Logger
+
+	mu      sync.RWMutex
+	jobs    []*job
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+func New(logger logging.Logger) *Scheduler {
+	return &Scheduler{Logger: logger}
+}
+
+// Register adds a job, if the scheduler is already started the job is scheduled immediately
+func (s *Scheduler) Register(name string, schedule Schedule, fn JobFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.ContainsFunc(s.jobs, func(j *job) bool { return j.name == name }) {
+		return fmt.Errorf("Job %s already registered", name)
+	}
+	j := &job{name: name, schedule: schedule, fn: fn}
+	s.jobs = append(s.jobs, j)
+	if s.ctx != nil {
+		s.running.Add(1)
+		go s.loop(s.ctx, j)
+	}
+	return nil
+}
+
+func (s *Scheduler) IsRunning() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ctx != nil
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx != nil {
+		return
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for _, j := range s.jobs {
+		s.running.Add(1)
+		go s.loop(s.ctx, j)
+	}
+}
+
+// Stop cancels the context of the running jobs and waits for the scheduling goroutines
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.ctx == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.cancel()
+	s.ctx, s.cancel = nil, nil
+	s.mu.Unlock()
+	s.running.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	defer s.running.Done()
+	next := j.schedule.Next(utils.Now())
+	for {
+		j.mu.Lock()
+		j.nextRun = next
+		j.mu.Unlock()
+		// the timer is on the system time, a simulated clock that is advanced wakes up the loop.
+		// The channel is taken before the timer, so an advance in between is not missed
+		changed := utils.ClockChanged()
+		timer := time.NewTimer(next.Sub(utils.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-changed:
+			timer.Stop()
+			if utils.Now().Before(next) {
+				continue
+			}
+		case <-timer.C:
+		}
+		if err := s.run(ctx, j, false); err != nil {
+			s.V(2).Info("Scheduler skipped job", "job", j.name, "reason", err.Error())
+		}
+		next = j.schedule.Next(utils.Now())
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, j *job, manual bool) error {
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		return JobAlreadyRunningErr
+	}
+	j.running = true
+	j.mu.Unlock()
+
+	start := utils.Now()
+	r := JobRun{StartedAt: start, Epoch: utils.TimeToEpoch(start), Slot: utils.TimeToSlot(start), Manual: manual}
+	s.V(3).Info("Scheduler running job", "job", j.name, "epoch", r.Epoch, "slot", r.Slot, "manual", manual)
+	err := j.fn(ctx)
+	r.Duration = utils.Now().Sub(start)
+	if err != nil {
+		r.Error = err.Error()
+		s.Error(err, "Scheduler job failed", "job", j.name)
+	}
+
+	j.mu.Lock()
+	j.running = false
+	j.history = append(j.history, r)
+	if len(j.history) > jobHistorySize {
+		j.history = slices.Delete(j.history, 0, len(j.history)-jobHistorySize)
+	}
+	j.mu.Unlock()
+	return nil
+}
+
+func (s *Scheduler) getJob(name string) *job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := slices.IndexFunc(s.jobs, func(j *job) bool { return j.name == name }); i >= 0 {
+		return s.jobs[i]
+	}
+	return nil
+}
+
+// Trigger runs the job now without waiting for it, the schedule is not changed
+func (s *Scheduler) Trigger(name string) (JobInfo, error) {
+	j := s.getJob(name)
+	if j == nil {
+		return JobInfo{}, JobNotFoundErr
+	}
+	s.mu.RLock()
+	ctx := s.ctx
+	s.mu.RUnlock()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	j.mu.Lock()
+	running := j.running
+	j.mu.Unlock()
+	if running {
+		return j.info(), JobAlreadyRunningErr
+	}
+	go s.run(ctx, j, true)
+	info := j.info()
+	info.Running = true
+	return info, nil
+}
+
+// Jobs returns the status of the jobs in the order they were registered
+func (s *Scheduler) Jobs() []JobInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := make([]JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		infos = append(infos, j.info())
+	}
+	return infos
+}
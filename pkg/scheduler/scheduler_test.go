@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/safanaj/go-f2lb/pkg/utils"
+)
+
+func setTestClock(t *testing.T, start time.Time) *utils.SimulatedClock {
+	t.Helper()
+	prev := utils.GetClock()
+	sc := utils.NewSimulatedClock(start)
+	utils.SetClock(sc)
+	t.Cleanup(func() { utils.SetClock(prev) })
+	return sc
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func jobInfo(s *Scheduler, name string) JobInfo {
+	for _, info := range s.Jobs() {
+		if info.Name == name {
+			return info
+		}
+	}
+	return JobInfo{}
+}
+
+func TestScheduleNext(t *testing.T) {
+	start := utils.EpochStartTime(400)
+	for _, tc := range []struct {
+		name     string
+		schedule Schedule
+		after    time.Time
+		want     time.Time
+	}{
+		{"epoch start", AtEpochStart(), start.Add(time.Hour), utils.EpochStartTime(401)},
+		{"at the epoch start", AtEpochStart(), start, utils.EpochStartTime(401)},
+		{"slot in epoch", AtSlotInEpoch(NonceStabilitySlot), start, start.Add(302400 * time.Second)},
+		{"slot in epoch passed", AtSlotInEpoch(NonceStabilitySlot), start.Add(302400 * time.Second),
+			utils.EpochStartTime(401).Add(302400 * time.Second)},
+		{"slot over the epoch length", AtSlotInEpoch(utils.EpochLength + 5), start, start.Add(5 * time.Second)},
+		{"every", Every(time.Hour), start, start.Add(time.Hour)},
+	} {
+		if got := tc.schedule.Next(tc.after); !got.Equal(tc.want) {
+			t.Errorf("%s (%s): got %s, want %s", tc.name, tc.schedule, got, tc.want)
+		}
+	}
+}
+
+func TestSchedulerSimulatedClock(t *testing.T) {
+	sc := setTestClock(t, utils.EpochStartTime(400).Add(time.Hour))
+	s := New(logr.Discard())
+	hourly, epochly := make(chan time.Time, 10), make(chan time.Time, 10)
+	if err := s.Register("hourly", Every(time.Hour), func(context.Context) error {
+		hourly <- utils.Now()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("epoch", AtEpochStart(), func(context.Context) error {
+		epochly <- utils.Now()
+		return errors.New("failed")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("hourly", Every(time.Hour), nil); err == nil {
+		t.Fatalf("a job registered twice")
+	}
+
+	s.Start(context.Background())
+	defer s.Stop()
+	waitFor(t, "the jobs scheduled", func() bool {
+		return !jobInfo(s, "hourly").NextRun.IsZero() && !jobInfo(s, "epoch").NextRun.IsZero()
+	})
+	if next := jobInfo(s, "epoch").NextRun; !next.Equal(utils.EpochStartTime(401)) {
+		t.Fatalf("epoch job scheduled at %s", next)
+	}
+
+	sc.Advance(time.Hour)
+	select {
+	case <-hourly:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("the hourly job did not run after advancing the clock")
+	}
+	select {
+	case <-epochly:
+		t.Fatalf("the epoch job run before the epoch start")
+	default:
+	}
+
+	sc.AdvanceToEpoch(401)
+	select {
+	case now := <-epochly:
+		if utils.TimeToEpoch(now) != 401 {
+			t.Fatalf("the epoch job run in epoch %d", utils.TimeToEpoch(now))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("the epoch job did not run at the epoch start")
+	}
+	waitFor(t, "the epoch job history", func() bool { return len(jobInfo(s, "epoch").History) == 1 })
+	if r := jobInfo(s, "epoch").History[0]; r.Epoch != 401 || r.Manual || r.Error != "failed" {
+		t.Fatalf("unexpected run %+v", r)
+	}
+}
+
+func TestSchedulerTrigger(t *testing.T) {
+	setTestClock(t, utils.EpochStartTime(400))
+	s := New(logr.Discard())
+	release := make(chan struct{})
+	if err := s.Register("job", Every(time.Hour), func(context.Context) error {
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Trigger("unknown"); !errors.Is(err, JobNotFoundErr) {
+		t.Fatalf("expected %v, got %v", JobNotFoundErr, err)
+	}
+	if info, err := s.Trigger("job"); err != nil || !info.Running {
+		t.Fatalf("expected the job running, got %+v, %v", info, err)
+	}
+	waitFor(t, "the job running", func() bool { return jobInfo(s, "job").Running })
+	if _, err := s.Trigger("job"); !errors.Is(err, JobAlreadyRunningErr) {
+		t.Fatalf("expected %v, got %v", JobAlreadyRunningErr, err)
+	}
+	close(release)
+	waitFor(t, "the job done", func() bool { return len(jobInfo(s, "job").History) == 1 })
+	if r := jobInfo(s, "job").History[0]; !r.Manual || r.Epoch != 400 {
+		t.Fatalf("unexpected run %+v", r)
+	}
+}
@@ -12,9 +12,11 @@ import (
 	"github.com/safanaj/cardano-go/bech32"
 	"github.com/safanaj/cardano-go/crypto"
 
-	"github.com/safanaj/go-f2lb/pkg/caches/koiosutils"
+	"github.com/safanaj/go-f2lb/pkg/caches/chainprovider"
 	"github.com/safanaj/go-f2lb/pkg/ccli"
+	"github.com/safanaj/go-f2lb/pkg/eventbus"
 	"github.com/safanaj/go-f2lb/pkg/logging"
+	"github.com/safanaj/go-f2lb/pkg/scheduler"
 	"github.com/safanaj/go-f2lb/pkg/utils"
 )
 
@@ -23,6 +25,23 @@ type txDone struct {
 	submitted bool
 }
 
+// states of the delegation txs in TxState
+const (
+	TxBuilt     = "built"
+	TxSubmitted = "submitted"
+	TxCanceled  = "canceled"
+)
+
+// TxState is published on TxStateTopic when a delegation tx is built, submitted or canceled,
+// the member is known only when the tx is built
+type TxState struct {
+	Hash   string `json:"hash"`
+	Member string `json:"member,omitempty"`
+	State  string `json:"state"`
+}
+
+var TxStateTopic = eventbus.NewTopic[TxState]("payer-tx-state")
+
 type DumpPayerData struct {
 	Metadata2Utxo map[string][]cardano.UTxO `json:"metadata2utxo"`
 	Utxos         []cardano.UTxO            `json:"utxos"`
@@ -39,7 +58,8 @@ type Payer struct {
 	logging.Logger
 	ctx context.Context
 	pp  *cardano.ProtocolParams
-	kc  *koiosutils.KoiosClient
+	cp  chainprovider.ChainProvider
+	bus *eventbus.Bus
 
 	axsk crypto.XPrvKey
 	addr cardano.Address
@@ -63,7 +83,9 @@ var (
 )
 
 func GetPayer() *Payer { return payer }
-func NewPayer(ctx context.Context, logger logging.Logger) (*Payer, error) {
+
+// NewPayer returns nil if the secrets file is not set, the chain data is got from the chain provider of the controller
+func NewPayer(ctx context.Context, logger logging.Logger, cp chainprovider.ChainProvider, bus *eventbus.Bus) (*Payer, error) {
 	if secretsFilePath == "" {
 		return nil, nil
 	}
@@ -116,7 +138,8 @@ func NewPayer(ctx context.Context, logger logging.Logger) (*Payer, error) {
 		Logger:          logger,
 		ctx:             ctx,
 		pp:              pp,
-		kc:              koiosutils.New(ctx),
+		cp:              cp,
+		bus:             bus,
 		axsk:            xprv,
 		addr:            addr,
 		refreshCh:       make(chan struct{}),
@@ -134,6 +157,24 @@ func NewPayer(ctx context.Context, logger logging.Logger) (*Payer, error) {
 
 func (p *Payer) GetAddress() cardano.Address { return p.addr }
 
+// name and interval of the periodic refresh of the UTxOs of the payer
+const (
+	RefreshJobName  = "payer-refresh"
+	refreshInterval = 30 * time.Minute
+)
+
+// RegisterJobs schedules the periodic refresh of the UTxOs, a run waits until the payer loop takes the request
+func (p *Payer) RegisterJobs(s *scheduler.Scheduler) error {
+	return s.Register(RefreshJobName, scheduler.Every(refreshInterval), func(ctx context.Context) error {
+		select {
+		case p.refreshCh <- struct{}{}:
+		case <-ctx.Done():
+		case <-p.ctx.Done():
+		}
+		return nil
+	})
+}
+
 func (p *Payer) refreshFilteredUTxOs() error {
 	utxos, err := getUTxOs(p.ctx, p.addr)
 	if err != nil {
@@ -145,7 +186,7 @@ func (p *Payer) refreshFilteredUTxOs() error {
 	for _, utxo := range utxos {
 		txHashes = append(txHashes, utxo.TxHash.String())
 	}
-	res, err := p.kc.GetTxsMetadata(txHashes)
+	res, err := p.cp.GetTxsMetadata(txHashes)
 	if err != nil {
 		p.Error(err, "GetTxsMetadata failed")
 		return err
@@ -254,17 +295,10 @@ func (p *Payer) Run() error {
 	if err := p.refreshFilteredUTxOs(); err != nil {
 		return err
 	}
-	tick := time.NewTicker(30 * time.Minute)
 	for {
 		select {
 		case <-p.ctx.Done():
 			return nil
-		case <-tick.C:
-			if err := p.refreshFilteredUTxOs(); err != nil {
-				return err
-			}
-			p.emptyRefreshChannel()
-
 		case _, more := <-p.refreshCh:
 			if !more {
 				return nil
@@ -281,6 +315,11 @@ func (p *Payer) Run() error {
 			}
 			if utxops, ok := p.pendingTxs[done.hash]; ok {
 				delete(p.pendingTxs, done.hash)
+				if done.submitted {
+					eventbus.Publish(p.bus, TxStateTopic, TxState{Hash: done.hash, State: TxSubmitted})
+				} else {
+					eventbus.Publish(p.bus, TxStateTopic, TxState{Hash: done.hash, State: TxCanceled})
+				}
 
 				if done.submitted {
 					var utxopps []***cardano.UTxO
@@ -405,6 +444,7 @@ func (p *Payer) Run() error {
 
 			if newTxHash, err := tx.Hash(); err == nil {
 				p.pendingTxs[newTxHash.String()] = []**cardano.UTxO{&utxo_}
+				eventbus.Publish(p.bus, TxStateTopic, TxState{Hash: newTxHash.String(), Member: delegReq.member, State: TxBuilt})
 			} else {
 				p.Error(err, "Tx hash failed")
 				close(delegReq.resCh)
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock is the source of the current time for the epoch and slot computations,
+// the simulated one allows to exercise epoch transitions without waiting days
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SimulatedClock runs as the system one but shifted, the shift can only grow when advanced
+type SimulatedClock struct {
+	mu      sync.RWMutex
+	offset  time.Duration
+	changed chan struct{}
+}
+
+func NewSimulatedClock(start time.Time) *SimulatedClock {
+	return &SimulatedClock{offset: time.Until(start), changed: make(chan struct{})}
+}
+
+func (c *SimulatedClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return time.Now().Add(c.offset)
+}
+
+// Advance moves the clock forward and wakes up who is waiting on Changed
+func (c *SimulatedClock) Advance(d time.Duration) (time.Time, error) {
+	if d < 0 {
+		return c.Now(), fmt.Errorf("the clock can not go back of %s", -d)
+	}
+	c.mu.Lock()
+	c.offset += d
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mu.Unlock()
+	return c.Now(), nil
+}
+
+// AdvanceToEpoch moves the clock forward to the start of the epoch
+func (c *SimulatedClock) AdvanceToEpoch(e Epoch) (time.Time, error) {
+	return c.Advance(EpochStartTime(e).Sub(c.Now()))
+}
+
+// Changed returns a channel closed on the next advance
+func (c *SimulatedClock) Changed() <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.changed
+}
+
+var clock Clock = systemClock{}
+
+// SetClock replaces the clock, it has to be called on startup before anything is using it
+func SetClock(c Clock) { clock = c }
+func GetClock() Clock  { return clock }
+func Now() time.Time   { return clock.Now() }
+
+// GetSimulatedClock returns the clock if it is a simulated one
+func GetSimulatedClock() (*SimulatedClock, bool) {
+	sc, ok := clock.(*SimulatedClock)
+	return sc, ok
+}
+
+// ClockChanged returns a channel closed when the clock is advanced, nil for the system clock
+func ClockChanged() <-chan struct{} {
+	if sc, ok := GetSimulatedClock(); ok {
+		return sc.Changed()
+	}
+	return nil
+}
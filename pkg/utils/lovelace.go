@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const LovelacePerAda = 1_000_000
+
+// Lovelace is an amount in the smallest unit of ADA, stakes and balances are kept in this type
+// and converted to ADA only to be shown
+type Lovelace uint64
+
+func AdaToLovelace(ada uint64) Lovelace { return Lovelace(ada * LovelacePerAda) }
+
+// Ada returns the whole ADA, the fraction is truncated
+func (l Lovelace) Ada() uint64 { return uint64(l) / LovelacePerAda }
+
+// AdaUint32 returns the whole ADA capped to the uint32 range, for the API fields that were in ADA
+func (l Lovelace) AdaUint32() uint32 {
+	if ada := l.Ada(); ada <= math.MaxUint32 {
+		return uint32(ada)
+	}
+	return math.MaxUint32
+}
+
+// String formats the amount in lovelace, like 1234567890
+func (l Lovelace) String() string { return strconv.FormatUint(uint64(l), 10) }
+
+// AdaString formats the amount in whole ADA with the unit, like 1234 ADA
+func (l Lovelace) AdaString() string { return fmt.Sprintf("%d ADA", l.Ada()) }
@@ -116,8 +116,8 @@ func TimeToAbsSlot(t time.Time) Slot {
 	return Slot(secondsSinceSystemStart)
 }
 
-func CurrentEpoch() Epoch      { return TimeToEpoch(time.Now()) }
-func CurrentSlotInEpoch() Slot { return TimeToSlot(time.Now()) }
+func CurrentEpoch() Epoch      { return TimeToEpoch(Now()) }
+func CurrentSlotInEpoch() Slot { return TimeToSlot(Now()) }
 func EpochStartTime(e Epoch) time.Time {
 	return time.Unix(systemStartUnixEpoch+(int64(e)*EpochLength), 0)
 }
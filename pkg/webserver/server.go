@@ -50,6 +50,8 @@ type Options struct {
 	CACertPath          string
 	CertPath            string
 	KeyPath             string
+	// wraps the root handler, i.e. to rewrite the requests before they reach the gin engine
+	HandlerWrapper func(http.Handler) http.Handler
 }
 
 func New(opts Options) WebServer {
@@ -58,8 +60,16 @@ func New(opts Options) WebServer {
 	httpSrv := &http.Server{Addr: opts.Addr}
 	quicSrv := &http3.Server{Addr: opts.Addr}
 
+	// the v2 apis can be served also under a community prefix
 	gzipOpts := gzip.WithExcludedPathsRegexs(
-		[]string{"^/v2\\..*/.*$", "^/api/v2/.*$"})
+		[]string{"^(/c/[^/]+)?/v2\\..*/.*$", "^(/c/[^/]+)?/api/v2/.*$"})
+
+	wrap := func(h http.Handler) http.Handler {
+		if opts.HandlerWrapper == nil {
+			return h
+		}
+		return opts.HandlerWrapper(h)
+	}
 
 	if opts.CertPath == "" || opts.KeyPath == "" {
 		rootHandler.ginHandler.UseH2C = true
@@ -96,30 +106,32 @@ func New(opts Options) WebServer {
 			SessionInHeaderAndCookieMiddleware(sm),
 			rootHandler.AsMiddleware(),
 			gzip.Gzip(gzip.DefaultCompression, gzipOpts))
+		handler := wrap(rootHandler.ginHandler)
 		if quicSrv.TLSConfig != nil {
 			httpSrv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				// quicSrv.SetQUICHeaders(w.Header())
-				rootHandler.ginHandler.ServeHTTP(w, r)
+				handler.ServeHTTP(w, r)
 			})
-			quicSrv.Handler = rootHandler.ginHandler
+			quicSrv.Handler = handler
 		} else {
-			httpSrv.Handler = rootHandler.ginHandler
+			httpSrv.Handler = handler
 		}
 		return &webServer{srv: httpSrv, quicSrv: quicSrv, rh: rootHandler, grpcAddr: opts.GrpcAddr}
 	} else {
 		rootHandler.ginHandler.Use(gzip.Gzip(gzip.DefaultCompression, gzipOpts))
+		handler := wrap(rootHandler)
 		if quicSrv.TLSConfig != nil {
 			httpSrv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				// quicSrv.SetQUICHeaders(w.Header())
-				rootHandler.ServeHTTP(w, r)
+				handler.ServeHTTP(w, r)
 			})
-			quicSrv.Handler = rootHandler
+			quicSrv.Handler = handler
 		} else {
-			httpSrv.Handler = rootHandler
+			httpSrv.Handler = handler
 		}
 		if rootHandler.ginHandler.UseH2C {
 			// we are not running with TLS
-			httpSrv.Handler = h2c.NewHandler(rootHandler, &http2.Server{})
+			httpSrv.Handler = h2c.NewHandler(handler, &http2.Server{})
 		}
 		return &webServer{srv: httpSrv, quicSrv: quicSrv, rh: rootHandler, grpcAddr: opts.GrpcAddr}
 	}
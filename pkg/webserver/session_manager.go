@@ -12,16 +12,23 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/safanaj/go-f2lb/pkg/scheduler"
+	"github.com/safanaj/go-f2lb/pkg/utils"
 )
 
 const (
 	sessionExpiresIn           = 1 * time.Hour
 	sessionsFolderLeafPathName = "sessions"
+	sessionsExpiryInterval     = 30 * time.Minute
+
+	SessionsExpiryJobName = "sessions-expiry"
 )
 
 type (
 	SessionManager interface {
 		Start(context.Context)
+		RegisterJobs(*scheduler.Scheduler) error
 		Session() (string, SessionData)
 		Delete(string)
 		DeleteByContext(context.Context)
@@ -82,7 +89,7 @@ func (s *sessionManager) dumpSessions() {
 	}
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	now := time.Now()
+	now := utils.Now()
 	for sid, sd := range s.sessions {
 		fn := fp.Join(s.path, fmt.Sprintf("%s.json", sid))
 		data, err := json.Marshal(sd)
@@ -132,45 +139,47 @@ func (s *sessionManager) Done() <-chan struct{} { return s.done }
 func (s *sessionManager) Start(rtx context.Context) {
 	s.loadSessions()
 	go func() {
-		tick := time.NewTicker(30 * time.Minute)
-		defer tick.Stop()
-		for {
-			select {
-			case <-rtx.Done():
-				s.dumpSessions()
-				s.lock.Lock()
-				defer s.lock.Unlock()
-				s.sessions = make(map[string]*SessionData)
-				close(s.done)
-				return
+		<-rtx.Done()
+		s.dumpSessions()
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.sessions = make(map[string]*SessionData)
+		close(s.done)
+	}()
+}
 
-			case <-tick.C:
-				expired := []string{}
-				now := time.Now().Add(2 * time.Minute)
-				s.lock.RLock()
-				for sid, sd := range s.sessions {
-					if now.After(sd.Expires) {
-						expired = append(expired, sid)
-					}
-				}
-				s.lock.RUnlock()
-				if len(expired) > 0 {
-					s.lock.Lock()
-					for _, sid := range expired {
-						delete(s.sessions, sid)
-					}
-					s.lock.Unlock()
-				}
-			}
+// RegisterJobs schedules the removal of the expired sessions
+func (s *sessionManager) RegisterJobs(sched *scheduler.Scheduler) error {
+	return sched.Register(SessionsExpiryJobName, scheduler.Every(sessionsExpiryInterval), func(context.Context) error {
+		s.expireSessions()
+		return nil
+	})
+}
+
+func (s *sessionManager) expireSessions() {
+	expired := []string{}
+	now := utils.Now().Add(2 * time.Minute)
+	s.lock.RLock()
+	for sid, sd := range s.sessions {
+		if now.After(sd.Expires) {
+			expired = append(expired, sid)
 		}
-	}()
+	}
+	s.lock.RUnlock()
+	if len(expired) > 0 {
+		s.lock.Lock()
+		for _, sid := range expired {
+			delete(s.sessions, sid)
+		}
+		s.lock.Unlock()
+	}
 }
 
 func (s *sessionManager) Session() (string, SessionData) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	sid := fmt.Sprintf("%s", uuid.New())
-	sd := &SessionData{Expires: time.Now().Add(sessionExpiresIn)}
+	sd := &SessionData{Expires: utils.Now().Add(sessionExpiresIn)}
 	s.sessions[sid] = sd
 	return sid, *sd
 }
@@ -216,7 +225,7 @@ func (s *sessionManager) UpdateExpirationByContext(ctx context.Context) {
 }
 
 func (s *sessionManager) UpdateExpiration(id string) {
-	at := time.Now().Add(sessionExpiresIn)
+	at := utils.Now().Add(sessionExpiresIn)
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	sd, ok := s.sessions[id]